@@ -0,0 +1,213 @@
+package aclient_badger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jpfluger/alibs-slim/autils"
+)
+
+const (
+	BADGER_GC_DISCARD_RATIO   = 0.5
+	BADGER_BACKUP_FILE_PREFIX = "badger-backup-"
+	BADGER_BACKUP_FILE_EXT    = ".bak"
+	BADGER_LOAD_MAX_PENDING   = 256
+)
+
+// RunValueLogGC rewrites value log files until badger reports nothing left
+// to collect and returns how many files were rewritten. Badger never does
+// this on its own, so call it periodically (see BadgerMaintenance).
+func (cn *AClientBadger) RunValueLogGC(discardRatio float64) (int, error) {
+	db := cn.DB()
+	if db == nil {
+		return 0, fmt.Errorf("no badger db has been created")
+	}
+	if discardRatio <= 0 || discardRatio >= 1 {
+		discardRatio = BADGER_GC_DISCARD_RATIO
+	}
+	count := 0
+	for {
+		err := db.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("value log gc failed; %v", err)
+		}
+		count++
+	}
+}
+
+// Backup streams an online backup of entries newer than since to w and
+// returns the version to pass as since for the next incremental backup.
+func (cn *AClientBadger) Backup(w io.Writer, since uint64) (uint64, error) {
+	db := cn.DB()
+	if db == nil {
+		return 0, fmt.Errorf("no badger db has been created")
+	}
+	next, err := db.Backup(w, since)
+	if err != nil {
+		return 0, fmt.Errorf("badger backup failed; %v", err)
+	}
+	return next, nil
+}
+
+// BackupToDir writes a full backup to a timestamped file in dir and returns its path.
+func (cn *AClientBadger) BackupToDir(dir string) (string, error) {
+	dir, err := autils.CleanDirWithMkdirOption(dir, "", true)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, BADGER_BACKUP_FILE_PREFIX+time.Now().UTC().Format("20060102T150405.000000000Z")+BADGER_BACKUP_FILE_EXT)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file; %v", err)
+	}
+	if _, err = cn.Backup(f, 0); err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("failed to close backup file; %v", err)
+	}
+	return path, nil
+}
+
+// Restore loads a backup created by Backup into the open db. Existing keys
+// are overwritten by the backup; other keys are kept.
+func (cn *AClientBadger) Restore(r io.Reader) error {
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no badger db has been created")
+	}
+	if err := db.Load(r, BADGER_LOAD_MAX_PENDING); err != nil {
+		return fmt.Errorf("badger restore failed; %v", err)
+	}
+	return nil
+}
+
+// RestoreFromFile loads the backup file at path.
+func (cn *AClientBadger) RestoreFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file; %v", err)
+	}
+	defer f.Close()
+	return cn.Restore(f)
+}
+
+// ListBackups returns the backup files in dir, oldest first.
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, BADGER_BACKUP_FILE_PREFIX) && strings.HasSuffix(name, BADGER_BACKUP_FILE_EXT) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	// Names embed a sortable UTC timestamp.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// pruneBackups removes all but the newest keep backups in dir.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	paths, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > keep {
+		if err = os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// BadgerMaintenance schedules value-log GC and backups for a client.
+// A zero interval disables that job.
+type BadgerMaintenance struct {
+	GCInterval     time.Duration
+	GCDiscardRatio float64
+
+	BackupInterval time.Duration
+	BackupDir      string
+	BackupKeep     int // Number of backups to retain; zero keeps all.
+
+	// OnGC is called after every GC run with the number of rewritten files.
+	OnGC func(rewrites int, err error)
+
+	// OnBackup is called after every backup with the file path.
+	OnBackup func(path string, err error)
+}
+
+// Run executes the scheduled jobs until ctx is done.
+func (m *BadgerMaintenance) Run(ctx context.Context, cn *AClientBadger) {
+	if cn == nil {
+		return
+	}
+	var gcTick, backupTick <-chan time.Time
+	if m.GCInterval > 0 {
+		ticker := time.NewTicker(m.GCInterval)
+		defer ticker.Stop()
+		gcTick = ticker.C
+	}
+	if m.BackupInterval > 0 && strings.TrimSpace(m.BackupDir) != "" {
+		ticker := time.NewTicker(m.BackupInterval)
+		defer ticker.Stop()
+		backupTick = ticker.C
+	}
+	if gcTick == nil && backupTick == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gcTick:
+			m.RunGC(cn)
+		case <-backupTick:
+			m.RunBackup(cn)
+		}
+	}
+}
+
+// RunGC runs value-log GC once and calls OnGC.
+func (m *BadgerMaintenance) RunGC(cn *AClientBadger) (int, error) {
+	n, err := cn.RunValueLogGC(m.GCDiscardRatio)
+	if m.OnGC != nil {
+		m.OnGC(n, err)
+	}
+	return n, err
+}
+
+// RunBackup writes one backup, prunes old ones and calls OnBackup.
+func (m *BadgerMaintenance) RunBackup(cn *AClientBadger) (string, error) {
+	path, err := cn.BackupToDir(m.BackupDir)
+	if err == nil {
+		if errPrune := pruneBackups(m.BackupDir, m.BackupKeep); errPrune != nil {
+			err = fmt.Errorf("backup written but pruning failed; %v", errPrune)
+		}
+	}
+	if m.OnBackup != nil {
+		m.OnBackup(path, err)
+	}
+	return path, err
+}
@@ -0,0 +1,73 @@
+package aclient_badger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAClientBadger_BackupRestore(t *testing.T) {
+	src := newTestBadgerClient(t)
+	repo := NewRepo[testRepoUser](src.DB(), "users", nil)
+	_, err := repo.Put("bob", testRepoUser{Name: "Bob"}, 0)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	m := &BadgerMaintenance{BackupDir: dir, BackupKeep: 2}
+	for i := 0; i < 3; i++ {
+		_, err = m.RunBackup(src)
+		require.NoError(t, err)
+	}
+	paths, err := ListBackups(dir)
+	require.NoError(t, err)
+	require.Len(t, paths, 2, "older backups are pruned")
+
+	dst := newTestBadgerClient(t)
+	require.NoError(t, dst.RestoreFromFile(paths[len(paths)-1]))
+	item, err := NewRepo[testRepoUser](dst.DB(), "users", nil).Get("bob")
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", item.Value.Name)
+	assert.Equal(t, uint64(1), item.Version)
+}
+
+func TestBadgerMaintenance_Run(t *testing.T) {
+	cn := newTestBadgerClient(t)
+
+	gcRuns := make(chan error, 8)
+	backups := make(chan string, 8)
+	m := &BadgerMaintenance{
+		GCInterval:     10 * time.Millisecond,
+		BackupInterval: 15 * time.Millisecond,
+		BackupDir:      t.TempDir(),
+		OnGC:           func(n int, err error) { gcRuns <- err },
+		OnBackup: func(path string, err error) {
+			if err == nil {
+				backups <- path
+			}
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, cn)
+		close(done)
+	}()
+
+	select {
+	case err := <-gcRuns:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("gc hook not called")
+	}
+	select {
+	case path := <-backups:
+		assert.FileExists(t, path)
+	case <-time.After(2 * time.Second):
+		t.Fatal("backup hook not called")
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,317 @@
+package aclient_badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	ErrRepoNotFound        = errors.New("repo key not found")
+	ErrRepoVersionConflict = errors.New("repo version conflict")
+)
+
+// REPO_KEY_SEPARATOR joins the repo prefix and the item key.
+const REPO_KEY_SEPARATOR = ":"
+
+// RepoCodec encodes values stored by Repo.
+type RepoCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error)   { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(b []byte, v interface{}) error { return json.Unmarshal(b, v) }
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// RepoItem is a value read from a Repo. Version starts at 1 and increases
+// on every write; pass it to Update or DeleteVersion for optimistic
+// concurrency. ExpiresAt is zero when the item has no TTL.
+type RepoItem[T any] struct {
+	Key       string
+	Value     T
+	Version   uint64
+	ExpiresAt time.Time
+}
+
+// RepoPage is one page of a prefix listing. Next is the cursor for the
+// following page and is empty on the last page.
+type RepoPage[T any] struct {
+	Items []*RepoItem[T]
+	Next  string
+}
+
+// Repo is a typed key-value repository on a badger db. All keys are stored
+// under Prefix so several repos can share one db. Stored values carry an
+// 8-byte version header followed by the encoded value.
+type Repo[T any] struct {
+	Prefix string
+	Codec  RepoCodec
+
+	db *badger.DB
+}
+
+// NewRepo creates a Repo. A nil codec defaults to JSONCodec.
+func NewRepo[T any](db *badger.DB, prefix string, codec RepoCodec) *Repo[T] {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &Repo[T]{
+		Prefix: strings.TrimSpace(prefix),
+		Codec:  codec,
+		db:     db,
+	}
+}
+
+// NewRepoFromClient creates a Repo on the client's db.
+func NewRepoFromClient[T any](cn *AClientBadger, prefix string, codec RepoCodec) *Repo[T] {
+	return NewRepo[T](cn.DB(), prefix, codec)
+}
+
+func (r *Repo[T]) fullKey(key string) []byte {
+	return []byte(r.Prefix + REPO_KEY_SEPARATOR + key)
+}
+
+func (r *Repo[T]) namespace() []byte {
+	return []byte(r.Prefix + REPO_KEY_SEPARATOR)
+}
+
+func (r *Repo[T]) check(key string) error {
+	if r.db == nil {
+		return fmt.Errorf("no badger db has been created")
+	}
+	if r.Prefix == "" {
+		return fmt.Errorf("repo prefix is empty")
+	}
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("key is empty")
+	}
+	return nil
+}
+
+// Get returns the item or ErrRepoNotFound.
+func (r *Repo[T]) Get(key string) (*RepoItem[T], error) {
+	if err := r.check(key); err != nil {
+		return nil, err
+	}
+	var out *RepoItem[T]
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(r.fullKey(key))
+		if err == badger.ErrKeyNotFound {
+			return ErrRepoNotFound
+		}
+		if err != nil {
+			return err
+		}
+		out, err = r.decodeItem(key, item)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Put writes the value regardless of the current version and returns the new version.
+func (r *Repo[T]) Put(key string, value T, ttl time.Duration) (uint64, error) {
+	return r.write(key, value, ttl, nil)
+}
+
+// Insert writes the value only if the key does not exist.
+func (r *Repo[T]) Insert(key string, value T, ttl time.Duration) (uint64, error) {
+	expected := uint64(0)
+	return r.write(key, value, ttl, &expected)
+}
+
+// Update writes the value only if the stored version equals expectedVersion.
+func (r *Repo[T]) Update(key string, value T, expectedVersion uint64, ttl time.Duration) (uint64, error) {
+	return r.write(key, value, ttl, &expectedVersion)
+}
+
+// write stores value. If expected is set, the current version (0 if missing)
+// must match. Concurrent writers that race past the check are caught by
+// badger's transaction conflict detection.
+func (r *Repo[T]) write(key string, value T, ttl time.Duration, expected *uint64) (uint64, error) {
+	if err := r.check(key); err != nil {
+		return 0, err
+	}
+	b, err := r.Codec.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode value; %v", err)
+	}
+
+	var version uint64
+	err = r.db.Update(func(txn *badger.Txn) error {
+		current, err := r.currentVersion(txn, key)
+		if err != nil {
+			return err
+		}
+		if expected != nil && *expected != current {
+			return fmt.Errorf("%w; key=%s, expected=%d, current=%d", ErrRepoVersionConflict, key, *expected, current)
+		}
+		version = current + 1
+		entry := badger.NewEntry(r.fullKey(key), encodeRepoValue(version, b))
+		if ttl > 0 {
+			entry = entry.WithTTL(ttl)
+		}
+		return txn.SetEntry(entry)
+	})
+	if err == badger.ErrConflict {
+		return 0, fmt.Errorf("%w; key=%s; %v", ErrRepoVersionConflict, key, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Delete removes the key. Missing keys are not an error.
+func (r *Repo[T]) Delete(key string) error {
+	if err := r.check(key); err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(r.fullKey(key))
+	})
+}
+
+// DeleteVersion removes the key only if the stored version equals expectedVersion.
+func (r *Repo[T]) DeleteVersion(key string, expectedVersion uint64) error {
+	if err := r.check(key); err != nil {
+		return err
+	}
+	err := r.db.Update(func(txn *badger.Txn) error {
+		current, err := r.currentVersion(txn, key)
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			return ErrRepoNotFound
+		}
+		if current != expectedVersion {
+			return fmt.Errorf("%w; key=%s, expected=%d, current=%d", ErrRepoVersionConflict, key, expectedVersion, current)
+		}
+		return txn.Delete(r.fullKey(key))
+	})
+	if err == badger.ErrConflict {
+		return fmt.Errorf("%w; key=%s; %v", ErrRepoVersionConflict, key, err)
+	}
+	return err
+}
+
+// List returns up to limit items whose key starts with subPrefix, in key
+// order, beginning after the cursor. Pass the returned Next as cursor to
+// continue. A limit of zero or less returns everything.
+func (r *Repo[T]) List(subPrefix string, cursor string, limit int) (*RepoPage[T], error) {
+	if r.db == nil {
+		return nil, fmt.Errorf("no badger db has been created")
+	}
+	page := &RepoPage[T]{Items: []*RepoItem[T]{}}
+	ns := r.namespace()
+	prefix := append(append([]byte{}, ns...), subPrefix...)
+
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		start := prefix
+		if cursor != "" {
+			start = r.fullKey(cursor)
+		}
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := string(item.Key()[len(ns):])
+			if cursor != "" && key == cursor {
+				continue
+			}
+			if limit > 0 && len(page.Items) == limit {
+				page.Next = page.Items[len(page.Items)-1].Key
+				return nil
+			}
+			ri, err := r.decodeItem(key, item)
+			if err != nil {
+				return err
+			}
+			page.Items = append(page.Items, ri)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// currentVersion returns the stored version of key or 0 if missing.
+func (r *Repo[T]) currentVersion(txn *badger.Txn, key string) (uint64, error) {
+	item, err := txn.Get(r.fullKey(key))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var version uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) < 8 {
+			return fmt.Errorf("value of key '%s' is missing the version header", key)
+		}
+		version = binary.BigEndian.Uint64(val[:8])
+		return nil
+	})
+	return version, err
+}
+
+func (r *Repo[T]) decodeItem(key string, item *badger.Item) (*RepoItem[T], error) {
+	out := &RepoItem[T]{Key: key}
+	if exp := item.ExpiresAt(); exp > 0 {
+		out.ExpiresAt = time.Unix(int64(exp), 0).UTC()
+	}
+	err := item.Value(func(val []byte) error {
+		if len(val) < 8 {
+			return fmt.Errorf("value of key '%s' is missing the version header", key)
+		}
+		out.Version = binary.BigEndian.Uint64(val[:8])
+		if err := r.Codec.Unmarshal(val[8:], &out.Value); err != nil {
+			return fmt.Errorf("failed to decode value of key '%s'; %v", key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func encodeRepoValue(version uint64, b []byte) []byte {
+	out := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(out[:8], version)
+	copy(out[8:], b)
+	return out
+}
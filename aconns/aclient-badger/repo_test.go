@@ -0,0 +1,123 @@
+package aclient_badger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRepoUser struct {
+	Name  string
+	Email string
+}
+
+func newTestBadgerClient(t *testing.T) *AClientBadger {
+	cn := &AClientBadger{
+		ADBAdapterBase: aconns.ADBAdapterBase{
+			Adapter: aconns.Adapter{
+				Type: ADAPTERTYPE_BADGER,
+				Name: aconns.AdapterName("test_badger"),
+			},
+			Database: t.TempDir(),
+		},
+	}
+	ok, _, err := cn.Test()
+	require.NoError(t, err)
+	require.True(t, ok)
+	t.Cleanup(func() { cn.CloseConnection() })
+	return cn
+}
+
+func TestRepo_CRUDAndVersions(t *testing.T) {
+	for _, codec := range []RepoCodec{JSONCodec{}, GobCodec{}} {
+		cn := newTestBadgerClient(t)
+		repo := NewRepoFromClient[testRepoUser](cn, "users", codec)
+
+		_, err := repo.Get("bob")
+		assert.True(t, errors.Is(err, ErrRepoNotFound))
+
+		v, err := repo.Insert("bob", testRepoUser{Name: "Bob"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), v)
+
+		_, err = repo.Insert("bob", testRepoUser{Name: "Bob2"}, 0)
+		assert.True(t, errors.Is(err, ErrRepoVersionConflict))
+
+		item, err := repo.Get("bob")
+		require.NoError(t, err)
+		assert.Equal(t, "Bob", item.Value.Name)
+		assert.Equal(t, uint64(1), item.Version)
+		assert.True(t, item.ExpiresAt.IsZero())
+
+		v, err = repo.Update("bob", testRepoUser{Name: "Bob", Email: "bob@example.com"}, item.Version, 0)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), v)
+
+		// A stale writer loses.
+		_, err = repo.Update("bob", testRepoUser{Name: "stale"}, item.Version, 0)
+		assert.True(t, errors.Is(err, ErrRepoVersionConflict))
+
+		assert.True(t, errors.Is(repo.DeleteVersion("bob", 1), ErrRepoVersionConflict))
+		require.NoError(t, repo.DeleteVersion("bob", 2))
+		_, err = repo.Get("bob")
+		assert.True(t, errors.Is(err, ErrRepoNotFound))
+	}
+}
+
+func TestRepo_PrefixIsolationAndTTL(t *testing.T) {
+	cn := newTestBadgerClient(t)
+	users := NewRepo[testRepoUser](cn.DB(), "users", nil)
+	admins := NewRepo[testRepoUser](cn.DB(), "admins", nil)
+
+	_, err := users.Put("a", testRepoUser{Name: "user-a"}, 0)
+	require.NoError(t, err)
+	_, err = admins.Put("a", testRepoUser{Name: "admin-a"}, time.Hour)
+	require.NoError(t, err)
+
+	item, err := users.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "user-a", item.Value.Name)
+
+	item, err = admins.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "admin-a", item.Value.Name)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), item.ExpiresAt, time.Minute)
+
+	_, err = users.Put("short", testRepoUser{Name: "gone"}, time.Second)
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+	_, err = users.Get("short")
+	assert.True(t, errors.Is(err, ErrRepoNotFound))
+}
+
+func TestRepo_ListPagination(t *testing.T) {
+	cn := newTestBadgerClient(t)
+	repo := NewRepo[testRepoUser](cn.DB(), "users", nil)
+	other := NewRepo[testRepoUser](cn.DB(), "users2", nil)
+	for _, key := range []string{"org1:a", "org1:b", "org1:c", "org2:a"} {
+		_, err := repo.Put(key, testRepoUser{Name: key}, 0)
+		require.NoError(t, err)
+	}
+	_, err := other.Put("org1:z", testRepoUser{Name: "other"}, 0)
+	require.NoError(t, err)
+
+	page, err := repo.List("org1:", "", 2)
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "org1:a", page.Items[0].Key)
+	assert.Equal(t, "org1:b", page.Next)
+
+	page, err = repo.List("org1:", page.Next, 2)
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "org1:c", page.Items[0].Key)
+	assert.Empty(t, page.Next)
+
+	page, err = repo.List("", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 4, "other prefixes are not listed")
+}
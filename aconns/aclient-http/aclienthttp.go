@@ -27,6 +27,9 @@ type AClientHTTP struct {
 	Name aconns.AdapterName `json:"name,omitempty"`
 	Url  anetwork.NetURL    `json:"url,omitempty"`
 
+	// Signer signs requests whose HOB has no Signer.
+	Signer IRequestSigner `json:"-"`
+
 	health aconns.HealthCheck
 
 	mu sync.RWMutex
@@ -105,7 +108,7 @@ func (a *AClientHTTP) GetWithOptions(hob *HOB) ([]byte, string, error) {
 		return nil, "", err
 	}
 
-	return DoHTTPGet(fullURL, hob)
+	return DoHTTPGet(fullURL, a.withSigner(hob))
 }
 
 // Post performs a POST request with the given payload and returns the response body and content type.
@@ -132,7 +135,17 @@ func (a *AClientHTTP) PostWithOptions(hob *HOB) ([]byte, string, error) {
 		return nil, "", err
 	}
 
-	return DoHTTPPost(fullURL, hob)
+	return DoHTTPPost(fullURL, a.withSigner(hob))
+}
+
+// withSigner returns a copy of hob with the client Signer when hob has none.
+func (a *AClientHTTP) withSigner(hob *HOB) *HOB {
+	if hob == nil || hob.Signer != nil || a.Signer == nil {
+		return hob
+	}
+	signed := *hob
+	signed.Signer = a.Signer
+	return &signed
 }
 
 // GetJSON performs a GET request and parses the response as JSON into the provided interface.
@@ -1,5 +1,12 @@
 package aclient_http
 
+import "net/http"
+
+// IRequestSigner signs an outgoing request (eg *acrypt.HTTPSignatureSigner or RoboSigner).
+type IRequestSigner interface {
+	Sign(req *http.Request) error
+}
+
 // HOB (HTTP Object) encapsulates all options for HTTP requests.
 type HOB struct {
 	Path              string
@@ -8,6 +15,7 @@ type HOB struct {
 	ContentType       string
 	ExpectedType      string
 	ConnectionTimeout int
+	Signer            IRequestSigner // Optional; signs the request before it is sent.
 }
 
 // NewHOBGet creates a new HOB for GET requests.
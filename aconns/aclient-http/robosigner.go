@@ -0,0 +1,39 @@
+package aclient_http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/anode"
+)
+
+// RoboSigner signs requests with the current key of a RoboCredential using
+// HTTP Message Signatures (RFC 9421). The key is read on each request, so a
+// rotated key is used as soon as the credential is updated.
+type RoboSigner struct {
+	Credential     *anode.RoboCredential
+	MasterPassword string
+	Expires        time.Duration // Adds an expires parameter when > 0.
+}
+
+// NewRoboSigner creates a signer for rc.
+func NewRoboSigner(rc *anode.RoboCredential, masterPassword string) (*RoboSigner, error) {
+	if rc == nil {
+		return nil, fmt.Errorf("robo credential is nil")
+	}
+	return &RoboSigner{Credential: rc, MasterPassword: masterPassword}, nil
+}
+
+// Sign sets the Content-Digest, Signature-Input and Signature headers of req.
+func (rs *RoboSigner) Sign(req *http.Request) error {
+	if rs == nil || rs.Credential == nil {
+		return fmt.Errorf("robo credential is nil")
+	}
+	signer, err := rs.Credential.NewHTTPSignatureSigner(rs.MasterPassword)
+	if err != nil {
+		return err
+	}
+	signer.Expires = rs.Expires
+	return signer.Sign(req)
+}
@@ -0,0 +1,59 @@
+package aclient_http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/amidware"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoboSigner(t *testing.T) {
+	rc := &anode.RoboCredential{}
+	require.NoError(t, rc.GenerateKeyPair("secure-password", 0))
+
+	e := echo.New()
+	e.HEAD("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	api := e.Group("/api", amidware.NewHTTPSignature(rc))
+	api.GET("/status", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"keyId": amidware.HTTPSignatureKeyId(c)})
+	})
+	api.POST("/jobs", func(c echo.Context) error {
+		payload := map[string]string{}
+		if err := c.Bind(&payload); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, map[string]string{"keyId": amidware.HTTPSignatureKeyId(c), "name": payload["name"]})
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	client, err := NewAClientHTTP(server.URL)
+	require.NoError(t, err)
+
+	// Unsigned requests are rejected.
+	body, _, err := client.Get(NewHOBGet("/api/status"))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Unauthorized")
+
+	result := map[string]string{}
+
+	client.Signer, err = NewRoboSigner(rc, "secure-password")
+	require.NoError(t, err)
+	require.NoError(t, client.GetJSON(NewHOBWithJSON("/api/status", nil), &result))
+	assert.Equal(t, rc.KeyId, result["keyId"])
+
+	require.NoError(t, client.PostJSON(NewHOBWithJSON("/api/jobs", map[string]string{"name": "nightly"}), &result))
+	assert.Equal(t, "nightly", result["name"])
+
+	// After rotation the signer uses the new key, which the server accepts.
+	require.NoError(t, rc.RotateKeysWithOverlap("secure-password", 0))
+	require.NoError(t, client.PostJSON(NewHOBWithJSON("/api/jobs", map[string]string{"name": "rotated"}), &result))
+	assert.Equal(t, rc.KeyId, result["keyId"])
+}
@@ -24,7 +24,16 @@ func DoHTTPGet(fullURL string, hob *HOB) ([]byte, string, error) {
 
 	client := &http.Client{Timeout: time.Duration(hob.ConnectionTimeout) * time.Second}
 
-	resp, err := client.Get(fullURL)
+	req, err := http.NewRequest(http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if hob.Signer != nil {
+		if err = hob.Signer.Sign(req); err != nil {
+			return nil, "", fmt.Errorf("failed to sign request; %v", err)
+		}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, "", err
 	}
@@ -79,7 +88,17 @@ func DoHTTPPost(fullURL string, hob *HOB) ([]byte, string, error) {
 	}
 
 	client := &http.Client{Timeout: time.Duration(hob.ConnectionTimeout) * time.Second}
-	resp, err := client.Post(fullURL, hob.ContentType, bytes.NewBuffer(data))
+	req, err := http.NewRequest(http.MethodPost, fullURL, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", hob.ContentType)
+	if hob.Signer != nil {
+		if err = hob.Signer.Sign(req); err != nil {
+			return nil, "", fmt.Errorf("failed to sign request; %v", err)
+		}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, "", err
 	}
@@ -99,3 +99,17 @@ func NewRedisStoreSCS() *redisstore.RedisStore {
 	}
 	return store
 }
+
+// SwapREDIS installs an empty global connection map and returns a function
+// that restores the previous one. Intended for tests via aconnstest.SwapGlobals.
+func SwapREDIS() (restore func()) {
+	muCM.Lock()
+	defer muCM.Unlock()
+	prev := connMap
+	connMap = &connMapGlobal{Map: NewMapRedis()}
+	return func() {
+		muCM.Lock()
+		defer muCM.Unlock()
+		connMap = prev
+	}
+}
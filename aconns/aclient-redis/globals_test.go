@@ -129,3 +129,18 @@ func TestNewRedisStoreSCS(t *testing.T) {
 	store := NewRedisStoreSCS()
 	assert.NotNil(t, store)
 }
+
+func TestSwapREDIS(t *testing.T) {
+	name := aconns.AdapterName("swap_redis")
+	client := &AClientRedis{
+		ADBAdapterBase: aconns.ADBAdapterBase{Adapter: aconns.Adapter{Name: name}},
+		pool:           &redis.Pool{},
+	}
+	assert.NoError(t, REDIS().Set(client))
+	defer REDIS().Remove(name)
+
+	restore := SwapREDIS()
+	assert.Nil(t, REDIS().Get(name))
+	restore()
+	assert.Equal(t, client, REDIS().Get(name))
+}
@@ -0,0 +1,279 @@
+package aclient_redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("redis lock not acquired")
+	ErrLockNotHeld     = errors.New("redis lock not held")
+)
+
+const (
+	REDIS_LOCK_DEFAULT_TTL    = 30 * time.Second
+	REDIS_LOCK_RETRY_INTERVAL = 100 * time.Millisecond
+)
+
+// KEYS[1]=lock key, KEYS[2]=fence counter; ARGV[1]=token, ARGV[2]=ttl ms.
+// Returns the new fencing token or 0 if the lock is held by someone else.
+var scriptLockAcquire = redis.NewScript(2, `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0`)
+
+// KEYS[1]=lock key; ARGV[1]=token, ARGV[2]=ttl ms.
+var scriptLockRefresh = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+
+// KEYS[1]=lock key; ARGV[1]=token.
+var scriptLockRelease = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// RedisLock is a single-instance redis lock. Each successful acquisition
+// receives a fencing token that increases monotonically per key. Pass the
+// token to the protected resource and have it reject writes carrying a
+// lower token than it has already seen; this keeps a holder that paused
+// past its TTL from clobbering the next holder's work.
+type RedisLock struct {
+	Key           string
+	TTL           time.Duration
+	RetryInterval time.Duration
+
+	pool  *redis.Pool
+	token string
+	fence int64
+	lost  chan struct{}
+	stop  chan struct{}
+
+	mu sync.Mutex
+}
+
+// NewRedisLock creates a lock on key. It is not acquired yet.
+func NewRedisLock(pool *redis.Pool, key string, ttl time.Duration) *RedisLock {
+	if ttl <= 0 {
+		ttl = REDIS_LOCK_DEFAULT_TTL
+	}
+	return &RedisLock{
+		Key:           strings.TrimSpace(key),
+		TTL:           ttl,
+		RetryInterval: REDIS_LOCK_RETRY_INTERVAL,
+		pool:          pool,
+	}
+}
+
+// NewLock creates a RedisLock on the client's pool.
+func (cn *AClientRedis) NewLock(key string, ttl time.Duration) *RedisLock {
+	return NewRedisLock(cn.Pool(), key, ttl)
+}
+
+// fenceKey is the counter backing the fencing tokens.
+func (l *RedisLock) fenceKey() string {
+	return l.Key + ":fence"
+}
+
+// TryAcquire attempts to take the lock once.
+func (l *RedisLock) TryAcquire() (bool, error) {
+	if l.pool == nil {
+		return false, fmt.Errorf("redis pool is nil")
+	}
+	if l.Key == "" {
+		return false, fmt.Errorf("lock key is empty")
+	}
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+
+	conn := l.pool.Get()
+	defer conn.Close()
+	fence, err := redis.Int64(scriptLockAcquire.Do(conn, l.Key, l.fenceKey(), token, l.TTL.Milliseconds()))
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock '%s'; %v", l.Key, err)
+	}
+	if fence == 0 {
+		return false, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.token = token
+	l.fence = fence
+	l.lost = make(chan struct{})
+	return true, nil
+}
+
+// Acquire retries TryAcquire every RetryInterval until it succeeds or ctx is done.
+func (l *RedisLock) Acquire(ctx context.Context) error {
+	interval := l.RetryInterval
+	if interval <= 0 {
+		interval = REDIS_LOCK_RETRY_INTERVAL
+	}
+	for {
+		ok, err := l.TryAcquire()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w; key=%s; %v", ErrLockNotAcquired, l.Key, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Fence returns the fencing token of the current acquisition or 0.
+func (l *RedisLock) Fence() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fence
+}
+
+// Refresh extends the TTL if the lock is still held by this instance.
+func (l *RedisLock) Refresh() error {
+	l.mu.Lock()
+	token := l.token
+	l.mu.Unlock()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+
+	conn := l.pool.Get()
+	defer conn.Close()
+	ok, err := redis.Int(scriptLockRefresh.Do(conn, l.Key, token, l.TTL.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock '%s'; %v", l.Key, err)
+	}
+	if ok == 0 {
+		l.markLost()
+		return fmt.Errorf("%w; key=%s", ErrLockNotHeld, l.Key)
+	}
+	return nil
+}
+
+// StartRefresh refreshes the lock every interval (TTL/3 if zero) until
+// Release is called or a refresh finds the lock gone, which closes Lost.
+func (l *RedisLock) StartRefresh(interval time.Duration) {
+	if interval <= 0 {
+		interval = l.TTL / 3
+	}
+	l.mu.Lock()
+	if l.token == "" || l.stop != nil {
+		l.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	l.stop = stop
+	l.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := l.Refresh(); errors.Is(err, ErrLockNotHeld) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Lost is closed when a refresh discovers the lock expired or was taken.
+// It returns nil if the lock was never acquired.
+func (l *RedisLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Release stops refreshing and deletes the lock if still held by this instance.
+func (l *RedisLock) Release() error {
+	l.mu.Lock()
+	token := l.token
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	l.token = ""
+	l.fence = 0
+	l.mu.Unlock()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+
+	conn := l.pool.Get()
+	defer conn.Close()
+	ok, err := redis.Int(scriptLockRelease.Do(conn, l.Key, token))
+	if err != nil {
+		return fmt.Errorf("failed to release lock '%s'; %v", l.Key, err)
+	}
+	if ok == 0 {
+		return fmt.Errorf("%w; key=%s", ErrLockNotHeld, l.Key)
+	}
+	return nil
+}
+
+// markLost closes the lost channel once.
+func (l *RedisLock) markLost() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost != nil {
+		select {
+		case <-l.lost:
+		default:
+			close(l.lost)
+		}
+	}
+}
+
+// WithLock acquires the lock, keeps it refreshed while fn runs and releases it.
+// The context passed to fn is cancelled if the lock is lost.
+func (l *RedisLock) WithLock(ctx context.Context, fn func(ctx context.Context, fence int64) error) error {
+	if err := l.Acquire(ctx); err != nil {
+		return err
+	}
+	l.StartRefresh(0)
+	defer l.Release()
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := l.Lost()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-fnCtx.Done():
+		}
+	}()
+	return fn(fnCtx, l.Fence())
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create lock token; %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
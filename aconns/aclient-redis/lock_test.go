@@ -0,0 +1,103 @@
+package aclient_redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMiniRedis starts an in-process redis and a pool dialing it.
+func newTestMiniRedis(t *testing.T) (*miniredis.Miniredis, *redis.Pool) {
+	mr := miniredis.RunT(t)
+	// Restart keeps the address; reading it once avoids racing a restart.
+	addr := mr.Addr()
+	pool := &redis.Pool{
+		MaxIdle: 5,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+		// Drop idle conns left over from before a restart.
+		TestOnBorrow: func(c redis.Conn, _ time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+	return mr, pool
+}
+
+func TestRedisLock_FencingAndRelease(t *testing.T) {
+	mr, pool := newTestMiniRedis(t)
+
+	l1 := NewRedisLock(pool, "jobs:nightly", time.Second)
+	ok, err := l1.TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), l1.Fence())
+
+	l2 := NewRedisLock(pool, "jobs:nightly", time.Second)
+	ok, err = l2.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// l1 stalls past its TTL; l2 takes over with a higher fence.
+	mr.FastForward(2 * time.Second)
+	ok, err = l2.TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), l2.Fence())
+
+	// The stale holder can neither refresh nor release the new holder's lock.
+	assert.True(t, errors.Is(l1.Refresh(), ErrLockNotHeld))
+	select {
+	case <-l1.Lost():
+	default:
+		t.Fatal("expected l1 to be marked lost")
+	}
+	assert.True(t, errors.Is(l1.Release(), ErrLockNotHeld))
+	assert.True(t, mr.Exists("jobs:nightly"))
+
+	require.NoError(t, l2.Release())
+	assert.False(t, mr.Exists("jobs:nightly"))
+}
+
+func TestRedisLock_RefreshAndAcquireTimeout(t *testing.T) {
+	mr, pool := newTestMiniRedis(t)
+
+	l1 := NewRedisLock(pool, "res", time.Second)
+	require.NoError(t, l1.Acquire(context.Background()))
+	mr.FastForward(800 * time.Millisecond)
+	require.NoError(t, l1.Refresh())
+	mr.FastForward(800 * time.Millisecond)
+	assert.True(t, mr.Exists("res"), "refresh extended the ttl")
+
+	l2 := NewRedisLock(pool, "res", time.Second)
+	l2.RetryInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(l2.Acquire(ctx), ErrLockNotAcquired))
+}
+
+func TestRedisLock_WithLock(t *testing.T) {
+	_, pool := newTestMiniRedis(t)
+
+	l := NewRedisLock(pool, "with", time.Second)
+	var fence int64
+	err := l.WithLock(context.Background(), func(ctx context.Context, f int64) error {
+		fence = f
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), fence)
+
+	// Released afterwards, so another lock can take it.
+	ok, err := NewRedisLock(pool, "with", time.Second).TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,192 @@
+package aclient_redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	REDIS_PUBSUB_RECONNECT_MIN = 100 * time.Millisecond
+	REDIS_PUBSUB_RECONNECT_MAX = 30 * time.Second
+	REDIS_PUBSUB_HEALTH_CHECK  = time.Minute
+)
+
+// RedisPubSub publishes and receives JSON-encoded values of type T on a channel.
+type RedisPubSub[T any] struct {
+	Channel string
+
+	// ReconnectMin and ReconnectMax bound the exponential backoff used when
+	// the subscription connection drops.
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+
+	// OnError receives connection and decode errors. Subscribe keeps running.
+	OnError func(err error)
+
+	// OnSubscribed is called each time the subscription is (re)established.
+	OnSubscribed func()
+
+	pool *redis.Pool
+}
+
+// NewRedisPubSub creates a RedisPubSub for the channel.
+func NewRedisPubSub[T any](pool *redis.Pool, channel string) *RedisPubSub[T] {
+	return &RedisPubSub[T]{
+		Channel:      strings.TrimSpace(channel),
+		ReconnectMin: REDIS_PUBSUB_RECONNECT_MIN,
+		ReconnectMax: REDIS_PUBSUB_RECONNECT_MAX,
+		pool:         pool,
+	}
+}
+
+// Publish sends v and returns the number of subscribers that received it.
+func (ps *RedisPubSub[T]) Publish(v T) (int, error) {
+	if ps.pool == nil {
+		return 0, fmt.Errorf("redis pool is nil")
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal pubsub message; %v", err)
+	}
+	conn := ps.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(conn.Do("PUBLISH", ps.Channel, b))
+	if err != nil {
+		return 0, fmt.Errorf("failed to publish to channel '%s'; %v", ps.Channel, err)
+	}
+	return n, nil
+}
+
+// Subscribe calls fn for every message until ctx is done, reconnecting with
+// backoff when the connection drops. Messages published while disconnected
+// are lost; use RedisQueue when delivery matters. Handler and decode errors
+// go to OnError. It returns nil when ctx is cancelled.
+func (ps *RedisPubSub[T]) Subscribe(ctx context.Context, fn func(v T)) error {
+	if ps.pool == nil {
+		return fmt.Errorf("redis pool is nil")
+	}
+	if ps.Channel == "" {
+		return fmt.Errorf("channel is empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	backoff := ps.getReconnectMin()
+	for {
+		connected, err := ps.receive(ctx, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			ps.onError(fmt.Errorf("pubsub channel '%s' disconnected; %v", ps.Channel, err))
+		}
+		if connected {
+			backoff = ps.getReconnectMin()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > ps.getReconnectMax() {
+			backoff = ps.getReconnectMax()
+		}
+	}
+}
+
+// receive runs one subscription until it fails or ctx is done. connected
+// reports whether the subscription was confirmed before it ended.
+func (ps *RedisPubSub[T]) receive(ctx context.Context, fn func(v T)) (connected bool, err error) {
+	conn, err := ps.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err = psc.Subscribe(ps.Channel); err != nil {
+		return false, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblocks Receive. Closing a dialed conn only closes the socket
+			// under the conn's mutex, so it does not race with the writes
+			// (Ping) made by the loop below.
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch msg := psc.ReceiveWithTimeout(REDIS_PUBSUB_HEALTH_CHECK).(type) {
+		case error:
+			if ne, ok := msg.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				// Idle; ping to detect half-open connections.
+				if err = psc.Ping(""); err != nil {
+					return connected, err
+				}
+				continue
+			}
+			return connected, msg
+		case redis.Subscription:
+			if msg.Kind == "subscribe" {
+				connected = true
+				if ps.OnSubscribed != nil {
+					ps.OnSubscribed()
+				}
+			}
+			if msg.Count == 0 {
+				return connected, nil
+			}
+		case redis.Message:
+			var v T
+			if err = json.Unmarshal(msg.Data, &v); err != nil {
+				ps.onError(fmt.Errorf("failed to unmarshal pubsub message on channel '%s'; %v", ps.Channel, err))
+				continue
+			}
+			fn(v)
+		}
+	}
+}
+
+// dial opens a dedicated connection for the subscription. A pooled conn is
+// not used because its Close unsubscribes and drains replies, which cannot
+// run while the loop in receive is reading.
+func (ps *RedisPubSub[T]) dial(ctx context.Context) (redis.Conn, error) {
+	switch {
+	case ps.pool.DialContext != nil:
+		return ps.pool.DialContext(ctx)
+	case ps.pool.Dial != nil:
+		return ps.pool.Dial()
+	}
+	return nil, fmt.Errorf("redis pool has no dial function")
+}
+
+func (ps *RedisPubSub[T]) onError(err error) {
+	if ps.OnError != nil {
+		ps.OnError(err)
+	}
+}
+
+func (ps *RedisPubSub[T]) getReconnectMin() time.Duration {
+	if ps.ReconnectMin <= 0 {
+		return REDIS_PUBSUB_RECONNECT_MIN
+	}
+	return ps.ReconnectMin
+}
+
+func (ps *RedisPubSub[T]) getReconnectMax() time.Duration {
+	if ps.ReconnectMax <= 0 {
+		return REDIS_PUBSUB_RECONNECT_MAX
+	}
+	return ps.ReconnectMax
+}
@@ -0,0 +1,67 @@
+package aclient_redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	Kind string `json:"kind"`
+	N    int    `json:"n"`
+}
+
+func TestRedisPubSub_Reconnect(t *testing.T) {
+	mr, pool := newTestMiniRedis(t)
+
+	ps := NewRedisPubSub[testEvent](pool, "events")
+	ps.ReconnectMin = 10 * time.Millisecond
+	subscribed := make(chan struct{}, 4)
+	ps.OnSubscribed = func() { subscribed <- struct{}{} }
+
+	got := make(chan testEvent, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.Subscribe(ctx, func(v testEvent) { got <- v })
+	}()
+
+	waitFor := func(ch chan struct{}) {
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for subscription")
+		}
+	}
+	waitFor(subscribed)
+
+	n, err := ps.Publish(testEvent{Kind: "created", N: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, testEvent{Kind: "created", N: 1}, <-got)
+
+	// Drop the connection; the subscriber must come back on its own.
+	mr.Close()
+	require.NoError(t, mr.Restart())
+	waitFor(subscribed)
+
+	_, err = ps.Publish(testEvent{Kind: "updated", N: 2})
+	require.NoError(t, err)
+	select {
+	case v := <-got:
+		assert.Equal(t, 2, v.N)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message after reconnect")
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscribe did not stop")
+	}
+}
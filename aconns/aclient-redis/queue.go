@@ -0,0 +1,308 @@
+package aclient_redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jpfluger/alibs-slim/auuids"
+)
+
+var ErrQueueEmpty = errors.New("redis queue is empty")
+
+const (
+	REDIS_QUEUE_VISIBILITY_TIMEOUT = 30 * time.Second
+	REDIS_QUEUE_POLL_INTERVAL      = 250 * time.Millisecond
+)
+
+// KEYS[1]=pending, KEYS[2]=processing, KEYS[3]=leases; ARGV[1]=deadline ms.
+// Moves the oldest message to processing and records its lease in one step.
+var scriptQueueReserve = redis.NewScript(3, `
+local v = redis.call('RPOPLPUSH', KEYS[1], KEYS[2])
+if v then
+	redis.call('ZADD', KEYS[3], ARGV[1], v)
+end
+return v`)
+
+// KEYS[1]=processing, KEYS[2]=leases; ARGV[1]=message.
+var scriptQueueAck = redis.NewScript(2, `
+redis.call('ZREM', KEYS[2], ARGV[1])
+return redis.call('LREM', KEYS[1], 1, ARGV[1])`)
+
+// KEYS[1]=processing, KEYS[2]=leases, KEYS[3]=target list; ARGV[1]=message, ARGV[2]=replacement.
+// Removes a message from processing and pushes the replacement onto target.
+var scriptQueueMove = redis.NewScript(3, `
+redis.call('ZREM', KEYS[2], ARGV[1])
+local n = redis.call('LREM', KEYS[1], 1, ARGV[1])
+if n > 0 then
+	redis.call('LPUSH', KEYS[3], ARGV[2])
+end
+return n`)
+
+// RedisQueueMessage is a message held by a RedisQueue. Attempts counts how
+// many times the message was delivered before the current delivery.
+type RedisQueueMessage struct {
+	Id         string          `json:"id"`
+	Body       json.RawMessage `json:"body"`
+	Attempts   int             `json:"attempts,omitempty"`
+	EnqueuedAt time.Time       `json:"enqueuedAt"`
+
+	raw string
+}
+
+// Decode unmarshals the body into v.
+func (m *RedisQueueMessage) Decode(v interface{}) error {
+	return json.Unmarshal(m.Body, v)
+}
+
+// RedisQueue is a reliable work queue using the processing-list pattern.
+// Reserve moves a message to a processing list with a lease; Ack removes it.
+// Messages whose lease passes VisibilityTimeout are put back by
+// RequeueExpired, so a crashed worker's message is delivered again.
+// After MaxAttempts deliveries a message goes to the dead letter list.
+type RedisQueue struct {
+	Name              string
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+	MaxAttempts       int // Zero means unlimited.
+
+	pool *redis.Pool
+}
+
+// NewRedisQueue creates a RedisQueue with default timeouts.
+func NewRedisQueue(pool *redis.Pool, name string) *RedisQueue {
+	return &RedisQueue{
+		Name:              strings.TrimSpace(name),
+		VisibilityTimeout: REDIS_QUEUE_VISIBILITY_TIMEOUT,
+		PollInterval:      REDIS_QUEUE_POLL_INTERVAL,
+		pool:              pool,
+	}
+}
+
+// NewQueue creates a RedisQueue on the client's pool.
+func (cn *AClientRedis) NewQueue(name string) *RedisQueue {
+	return NewRedisQueue(cn.Pool(), name)
+}
+
+func (q *RedisQueue) keyPending() string    { return q.Name + ":pending" }
+func (q *RedisQueue) keyProcessing() string { return q.Name + ":processing" }
+func (q *RedisQueue) keyLeases() string     { return q.Name + ":leases" }
+func (q *RedisQueue) keyDead() string       { return q.Name + ":dead" }
+
+func (q *RedisQueue) conn() (redis.Conn, error) {
+	if q.pool == nil {
+		return nil, fmt.Errorf("redis pool is nil")
+	}
+	if q.Name == "" {
+		return nil, fmt.Errorf("queue name is empty")
+	}
+	return q.pool.Get(), nil
+}
+
+// Push marshals body and appends it to the queue. It returns the message id.
+func (q *RedisQueue) Push(body interface{}) (string, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal queue body; %v", err)
+	}
+	msg := &RedisQueueMessage{
+		Id:         auuids.NewUUID().String(),
+		Body:       b,
+		EnqueuedAt: time.Now().UTC(),
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal queue message; %v", err)
+	}
+
+	conn, err := q.conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err = conn.Do("LPUSH", q.keyPending(), raw); err != nil {
+		return "", fmt.Errorf("failed to push to queue '%s'; %v", q.Name, err)
+	}
+	return msg.Id, nil
+}
+
+// TryReserve takes the next message without waiting. It returns ErrQueueEmpty
+// if there is nothing to do.
+func (q *RedisQueue) TryReserve() (*RedisQueueMessage, error) {
+	conn, err := q.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(q.getVisibilityTimeout()).UnixMilli()
+	raw, err := redis.String(scriptQueueReserve.Do(conn, q.keyPending(), q.keyProcessing(), q.keyLeases(), deadline))
+	if err == redis.ErrNil {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve from queue '%s'; %v", q.Name, err)
+	}
+
+	msg := &RedisQueueMessage{}
+	if err = json.Unmarshal([]byte(raw), msg); err != nil {
+		// Leave it in processing; RequeueExpired will dead-letter it.
+		return nil, fmt.Errorf("failed to unmarshal queue message; %v", err)
+	}
+	msg.raw = raw
+	return msg, nil
+}
+
+// Reserve waits for a message, polling every PollInterval until ctx is done.
+func (q *RedisQueue) Reserve(ctx context.Context) (*RedisQueueMessage, error) {
+	interval := q.PollInterval
+	if interval <= 0 {
+		interval = REDIS_QUEUE_POLL_INTERVAL
+	}
+	for {
+		msg, err := q.TryReserve()
+		if !errors.Is(err, ErrQueueEmpty) {
+			return msg, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Ack removes a reserved message. It returns an error if the lease already
+// expired and the message was requeued.
+func (q *RedisQueue) Ack(msg *RedisQueueMessage) error {
+	if msg == nil || msg.raw == "" {
+		return fmt.Errorf("message was not reserved")
+	}
+	conn, err := q.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	n, err := redis.Int(scriptQueueAck.Do(conn, q.keyProcessing(), q.keyLeases(), msg.raw))
+	if err != nil {
+		return fmt.Errorf("failed to ack message '%s'; %v", msg.Id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("message '%s' is no longer reserved", msg.Id)
+	}
+	return nil
+}
+
+// Nack returns a reserved message to the queue for another attempt.
+func (q *RedisQueue) Nack(msg *RedisQueueMessage) error {
+	if msg == nil || msg.raw == "" {
+		return fmt.Errorf("message was not reserved")
+	}
+	conn, err := q.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = q.retry(conn, msg.raw)
+	return err
+}
+
+// Extend pushes out the lease of a reserved message by VisibilityTimeout.
+func (q *RedisQueue) Extend(msg *RedisQueueMessage) error {
+	if msg == nil || msg.raw == "" {
+		return fmt.Errorf("message was not reserved")
+	}
+	conn, err := q.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(q.getVisibilityTimeout()).UnixMilli()
+	n, err := redis.Int(conn.Do("ZADD", q.keyLeases(), "XX", "CH", deadline, msg.raw))
+	if err != nil {
+		return fmt.Errorf("failed to extend message '%s'; %v", msg.Id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("message '%s' is no longer reserved", msg.Id)
+	}
+	return nil
+}
+
+// RequeueExpired moves messages whose lease has passed back to the queue,
+// or to the dead letter list once MaxAttempts is reached. Run it periodically
+// from any worker. It returns the number of messages moved.
+func (q *RedisQueue) RequeueExpired() (int, error) {
+	conn, err := q.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	expired, err := redis.Strings(conn.Do("ZRANGEBYSCORE", q.keyLeases(), "-inf", time.Now().UnixMilli()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read leases of queue '%s'; %v", q.Name, err)
+	}
+	count := 0
+	for _, raw := range expired {
+		n, err := q.retry(conn, raw)
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// retry moves raw from processing to pending with Attempts incremented,
+// or to the dead letter list.
+func (q *RedisQueue) retry(conn redis.Conn, raw string) (int, error) {
+	target := q.keyPending()
+	replacement := raw
+	msg := &RedisQueueMessage{}
+	if err := json.Unmarshal([]byte(raw), msg); err != nil {
+		target = q.keyDead()
+	} else {
+		msg.Attempts++
+		if q.MaxAttempts > 0 && msg.Attempts >= q.MaxAttempts {
+			target = q.keyDead()
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal queue message; %v", err)
+		}
+		replacement = string(b)
+	}
+	n, err := redis.Int(scriptQueueMove.Do(conn, q.keyProcessing(), q.keyLeases(), target, raw, replacement))
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue message in queue '%s'; %v", q.Name, err)
+	}
+	return n, nil
+}
+
+// Len returns the number of pending, processing and dead messages.
+func (q *RedisQueue) Len() (pending int, processing int, dead int, err error) {
+	conn, err := q.conn()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+	if pending, err = redis.Int(conn.Do("LLEN", q.keyPending())); err != nil {
+		return
+	}
+	if processing, err = redis.Int(conn.Do("LLEN", q.keyProcessing())); err != nil {
+		return
+	}
+	dead, err = redis.Int(conn.Do("LLEN", q.keyDead()))
+	return
+}
+
+func (q *RedisQueue) getVisibilityTimeout() time.Duration {
+	if q.VisibilityTimeout <= 0 {
+		return REDIS_QUEUE_VISIBILITY_TIMEOUT
+	}
+	return q.VisibilityTimeout
+}
@@ -0,0 +1,100 @@
+package aclient_redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testJob struct {
+	Name string `json:"name"`
+}
+
+func TestRedisQueue_ReserveAck(t *testing.T) {
+	_, pool := newTestMiniRedis(t)
+	q := NewRedisQueue(pool, "q:mail")
+
+	_, err := q.TryReserve()
+	assert.True(t, errors.Is(err, ErrQueueEmpty))
+
+	_, err = q.Push(testJob{Name: "a"})
+	require.NoError(t, err)
+	_, err = q.Push(testJob{Name: "b"})
+	require.NoError(t, err)
+
+	msg, err := q.Reserve(context.Background())
+	require.NoError(t, err)
+	job := testJob{}
+	require.NoError(t, msg.Decode(&job))
+	assert.Equal(t, "a", job.Name, "fifo order")
+
+	pending, processing, _, err := q.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 1, processing)
+
+	require.NoError(t, q.Ack(msg))
+	assert.Error(t, q.Ack(msg), "double ack")
+	_, processing, _, err = q.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 0, processing)
+}
+
+func TestRedisQueue_VisibilityTimeout(t *testing.T) {
+	_, pool := newTestMiniRedis(t)
+	q := NewRedisQueue(pool, "q:work")
+	q.VisibilityTimeout = 20 * time.Millisecond
+	q.MaxAttempts = 2
+
+	id, err := q.Push(testJob{Name: "crashy"})
+	require.NoError(t, err)
+
+	// Worker reserves then "crashes" without acking.
+	msg, err := q.TryReserve()
+	require.NoError(t, err)
+	assert.Equal(t, id, msg.Id)
+
+	n, err := q.RequeueExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "lease still valid")
+
+	time.Sleep(30 * time.Millisecond)
+	n, err = q.RequeueExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Error(t, q.Ack(msg), "stale worker cannot ack a requeued message")
+
+	msg, err = q.TryReserve()
+	require.NoError(t, err)
+	assert.Equal(t, id, msg.Id)
+	assert.Equal(t, 1, msg.Attempts)
+
+	// Second failure reaches MaxAttempts and dead-letters it.
+	require.NoError(t, q.Nack(msg))
+	pending, processing, dead, err := q.Len()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 0, 1}, []int{pending, processing, dead})
+}
+
+func TestRedisQueue_Extend(t *testing.T) {
+	_, pool := newTestMiniRedis(t)
+	q := NewRedisQueue(pool, "q:long")
+	q.VisibilityTimeout = 40 * time.Millisecond
+
+	_, err := q.Push(testJob{Name: "slow"})
+	require.NoError(t, err)
+	msg, err := q.TryReserve()
+	require.NoError(t, err)
+
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, q.Extend(msg))
+	time.Sleep(25 * time.Millisecond)
+	n, err := q.RequeueExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, q.Ack(msg))
+}
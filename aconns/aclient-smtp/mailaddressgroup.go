@@ -87,6 +87,9 @@ func (mag *MailAddressGroup) Validate() error {
 // MergeInto merges the non-empty properties of the source (mag) into the target (mergeTo)
 // only if the target properties are empty.
 func (mag *MailAddressGroup) MergeInto(mergeTo *MailAddressGroup) {
+	if mag == nil || mergeTo == nil {
+		return
+	}
 	// Merge From
 	if !mag.From.Address.IsEmpty() && mergeTo.From.Address.IsEmpty() {
 		mergeTo.From = mag.From
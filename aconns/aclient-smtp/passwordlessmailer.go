@@ -0,0 +1,69 @@
+package aclient_smtp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aemail"
+)
+
+const (
+	MAILTEMPLATE_PASSWORDLESS_LINK MailTemplateName = "passwordless-link" // Template name for magic-link login emails.
+	MAILTEMPLATE_PASSWORDLESS_CODE MailTemplateName = "passwordless-code" // Template name for email OTP login emails.
+)
+
+// EmailPropertyDataCode extends EmailPropertyData with a one-time code.
+type EmailPropertyDataCode struct {
+	EmailPropertyData
+	Code string `json:"code"`
+}
+
+// PasswordlessMailer sends magic links and email OTPs through a mail manager.
+// It implements anode.IPasswordlessMailer. Templates receive an
+// *EmailPropertyData with ClickLink set for links, or an
+// *EmailPropertyDataCode for codes, and the app name as the subject merge.
+type PasswordlessMailer struct {
+	MailManager  IMailManager        // Nil uses MAILMANAGER().
+	MAGKey       MailAddressGroupKey // Group supplying the From address; MAG_KEY_SYSTEM if empty.
+	TemplateLink MailTemplateName    // MAILTEMPLATE_PASSWORDLESS_LINK if empty.
+	TemplateCode MailTemplateName    // MAILTEMPLATE_PASSWORDLESS_CODE if empty.
+}
+
+// SendPasswordless emails link or code to email. Only the From address is
+// taken from the group, so its recipients never receive login codes.
+func (pm *PasswordlessMailer) SendPasswordless(email string, link string, code string, expires time.Time) error {
+	mm := pm.MailManager
+	if mm == nil {
+		mm = MAILMANAGER()
+	}
+	if mm == nil || !mm.GetIsActive() {
+		return fmt.Errorf("inactive mail manager")
+	}
+	to := aemail.Address{Address: aemail.EmailAddress(email)}
+	if err := to.Validate(); err != nil {
+		return err
+	}
+	from := mm.FromMAG(pm.MAGKey, nil).From
+	if from.Address.IsEmpty() {
+		return fmt.Errorf("mag '%s' has no from address", pm.MAGKey)
+	}
+	mag := NewMAGWithTo(from, to)
+
+	data := NewEmailPropertyData(email, link, expires, "")
+	subjectMerge := []interface{}{data.AppName}
+	if link != "" {
+		name := pm.TemplateLink
+		if name.IsEmpty() {
+			name = MAILTEMPLATE_PASSWORDLESS_LINK
+		}
+		return mm.SendWithRender(name, mag, subjectMerge, data)
+	}
+	if code == "" {
+		return fmt.Errorf("passwordless link and code are empty")
+	}
+	name := pm.TemplateCode
+	if name.IsEmpty() {
+		name = MAILTEMPLATE_PASSWORDLESS_CODE
+	}
+	return mm.SendWithRender(name, mag, subjectMerge, &EmailPropertyDataCode{EmailPropertyData: *data, Code: code})
+}
@@ -0,0 +1,228 @@
+package aclient_smtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jhillyerd/enmime/v2"
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/ahttp"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/jpfluger/alibs-slim/atemplates"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts plain SMTP sessions and keeps each message.
+type fakeSMTPServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	messages [][]byte
+	rcpts    [][]string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTPServer{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpts = append(rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var msg bytes.Buffer
+			for {
+				dl, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dl == ".\r\n" {
+					break
+				}
+				msg.WriteString(strings.TrimPrefix(dl, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.Bytes())
+			s.rcpts = append(s.rcpts, rcpts)
+			s.mu.Unlock()
+			rcpts = nil
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default: // MAIL, NOOP, RSET
+			reply("250 OK")
+		}
+	}
+}
+
+// last returns the text body and recipients of the last message.
+func (s *fakeSMTPServer) last(t *testing.T) (string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.NotEmpty(t, s.messages)
+	env, err := enmime.ReadEnvelope(bytes.NewReader(s.messages[len(s.messages)-1]))
+	require.NoError(t, err)
+	return env.Text, s.rcpts[len(s.rcpts)-1]
+}
+
+// passwordlessSMTPLogin records the signed-in address and method.
+type passwordlessSMTPLogin struct {
+	email  aemail.EmailAddress
+	method asessions.AuthMethod
+}
+
+func (l *passwordlessSMTPLogin) LoginPasswordless(c echo.Context, email aemail.EmailAddress, method asessions.AuthMethod) error {
+	l.email, l.method = email, method
+	return nil
+}
+
+func newPasswordlessMailManager(t *testing.T, smtpPort int) *MailManager {
+	prev := atemplates.FSTEMPLATES()
+	t.Cleanup(func() { atemplates.SetFSTemplates(prev) })
+	fsm, err := atemplates.NewFSManage(map[atemplates.FSSourceType]fs.FS{
+		atemplates.FSSOURCETYPE_SNIPPETS_TEXT: fstest.MapFS{
+			"passwordless-link.gohtml": {Data: []byte("Hello {{.Username}}, sign in: {{.ClickLink}}\n")},
+			"passwordless-code.gohtml": {Data: []byte("Hello {{.Username}}, your code is {{.Code}}\n")},
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+	atemplates.SetFSTemplates(fsm)
+
+	mm := &MailManager{
+		IsActive: true,
+		SMTPs: AClientSMTPs{&AClientSMTP{
+			Adapter:  aconns.Adapter{Type: ADAPTERTYPE_SMTP, Name: MAIL_MANAGER_SMTP_DEFAULT, Host: "127.0.0.1", Port: smtpPort},
+			AuthType: AUTHTYPE_SENDER_NONE,
+			DialMode: DIALMODE_NOTLS,
+		}},
+		Templates: MailTemplates{
+			{Name: MAILTEMPLATE_PASSWORDLESS_LINK, SubjectMerge: "Sign in to %s", SnippetTextName: "passwordless-link.gohtml"},
+			{Name: MAILTEMPLATE_PASSWORDLESS_CODE, SubjectMerge: "Your %s code", SnippetTextName: "passwordless-code.gohtml"},
+		},
+		MAGS: MailAddressGroupMap{
+			MAG_KEY_SYSTEM: NewMAGWithToCCBCCString("noreply@example.com", "admin@example.com", nil, []string{"audit@example.com"}),
+		},
+	}
+	require.NoError(t, mm.Validate())
+	return mm
+}
+
+func TestPasswordlessMailer_EndToEnd(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	mm := newPasswordlessMailManager(t, smtpServer.port())
+
+	e := echo.New()
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	p, err := anode.NewPasswordless([]byte(strings.Repeat("k", 32)), srv.URL+"/login/link", anode.NewPasswordlessMemoryStore(), &PasswordlessMailer{MailManager: mm})
+	require.NoError(t, err)
+	login := &passwordlessSMTPLogin{}
+	ph, err := ahttp.NewPasswordlessHandlers(p, login, "/dash")
+	require.NoError(t, err)
+	e.POST("/login/passwordless", ph.Begin)
+	e.GET("/login/link", ph.Link)
+	e.POST("/login/code", ph.Code)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	post := func(path string, body interface{}) *http.Response {
+		b, _ := json.Marshal(body)
+		resp, err := browser.Post(srv.URL+path, echo.MIMEApplicationJSON, bytes.NewReader(b))
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Magic link.
+	resp := post("/login/passwordless", map[string]string{"email": "user@example.com"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	text, rcpts := smtpServer.last(t)
+	assert.Equal(t, []string{"user@example.com"}, rcpts, "only the user receives the login mail")
+	link := regexp.MustCompile(`https?://\S+`).FindString(text)
+	require.True(t, strings.HasPrefix(link, srv.URL+"/login/link?token="), text)
+
+	resp, err = browser.Get(link)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, aemail.EmailAddress("user@example.com"), login.email)
+	assert.Equal(t, asessions.AUTHMETHOD_MAGICLINK, login.method)
+
+	// Email OTP.
+	resp = post("/login/passwordless", map[string]string{"email": "user@example.com", "kind": "email-otp"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	begin := &ahttp.PasswordlessBeginResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(begin))
+	text, _ = smtpServer.last(t)
+	code := regexp.MustCompile(`\b\d{6}\b`).FindString(text)
+	require.NotEmpty(t, code, text)
+
+	resp = post("/login/code", ahttp.PasswordlessCodeRequest{Id: begin.Id, Code: code})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, asessions.AUTHMETHOD_EMAILOTP, login.method)
+}
+
+func TestPasswordlessMailer_SendPasswordless(t *testing.T) {
+	pm := &PasswordlessMailer{MailManager: &MailManager{}}
+	assert.Error(t, pm.SendPasswordless("user@example.com", "https://example.com", "", time.Now()), "inactive manager")
+
+	smtpServer := newFakeSMTPServer(t)
+	pm.MailManager = newPasswordlessMailManager(t, smtpServer.port())
+	assert.Error(t, pm.SendPasswordless("not-an-email", "https://example.com", "", time.Now()))
+	assert.Error(t, pm.SendPasswordless("user@example.com", "", "", time.Now()))
+
+	pm.MAGKey = MAG_KEY_USERS
+	assert.Error(t, pm.SendPasswordless("user@example.com", "https://example.com", "", time.Now()), "missing mag")
+}
@@ -0,0 +1,80 @@
+package aconnstest
+
+import (
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+const (
+	ADAPTERTYPE_FAKESQL  aconns.AdapterType = "fake-sql"
+	ADAPTERTYPE_FAKEKV   aconns.AdapterType = "fake-kv"
+	ADAPTERTYPE_FAKEHTTP aconns.AdapterType = "fake-http"
+
+	FAKE_HOST = "fake.local"
+)
+
+// FakeAdapter satisfies aconns.IAdapter without touching the network.
+// Test and Refresh consult the FaultInjector so health transitions can
+// be scripted. Compose it into the specific fakes.
+type FakeAdapter struct {
+	aconns.Adapter
+
+	faults *FaultInjector
+
+	muFake sync.RWMutex
+}
+
+// newFakeAdapter initializes the embedded Adapter with a default host.
+func newFakeAdapter(adapterType aconns.AdapterType, name aconns.AdapterName) FakeAdapter {
+	return FakeAdapter{
+		Adapter: aconns.Adapter{
+			Type: adapterType,
+			Name: name,
+			Host: FAKE_HOST,
+		},
+		faults: NewFaultInjector(),
+	}
+}
+
+// Faults returns the FaultInjector, creating one if needed.
+func (fa *FakeAdapter) Faults() *FaultInjector {
+	fa.muFake.Lock()
+	defer fa.muFake.Unlock()
+	if fa.faults == nil {
+		fa.faults = NewFaultInjector()
+	}
+	return fa.faults
+}
+
+// SetFaults replaces the FaultInjector.
+func (fa *FakeAdapter) SetFaults(fi *FaultInjector) {
+	fa.muFake.Lock()
+	defer fa.muFake.Unlock()
+	fa.faults = fi
+}
+
+// Test validates the adapter then applies the next fault, updating health
+// the way a real adapter would.
+func (fa *FakeAdapter) Test() (bool, aconns.TestStatus, error) {
+	if err := fa.Adapter.Validate(); err != nil {
+		return false, aconns.TESTSTATUS_FAILED, err
+	}
+	if err := fa.check(); err != nil {
+		return false, aconns.TESTSTATUS_FAILED, err
+	}
+	return true, aconns.TESTSTATUS_INITIALIZED_SUCCESSFUL, nil
+}
+
+// Refresh applies the next fault and updates health.
+func (fa *FakeAdapter) Refresh() error {
+	return fa.check()
+}
+
+// check applies the next fault and records the resulting health status.
+// Data operations call it so failures surface on use as well as on Test.
+func (fa *FakeAdapter) check() error {
+	f := fa.Faults().Apply()
+	fa.UpdateHealth(f.Type.ToHealthStatus())
+	return f.GetError()
+}
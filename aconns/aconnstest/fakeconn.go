@@ -0,0 +1,25 @@
+package aconnstest
+
+import (
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// NewFakeConn wraps the adapter in a validated *aconns.Conn. The tenantInfo may be nil.
+func NewFakeConn(adapter aconns.IAdapter, tenantInfo *aconns.ConnTenantInfo) *aconns.Conn {
+	conn := &aconns.Conn{
+		Id:         aconns.NewConnId(),
+		Adapter:    adapter,
+		TenantInfo: tenantInfo,
+	}
+	_ = conn.Validate()
+	return conn
+}
+
+// NewFakeConns wraps each adapter with NewFakeConn.
+func NewFakeConns(adapters ...aconns.IAdapter) aconns.IConns {
+	conns := aconns.IConns{}
+	for _, adapter := range adapters {
+		conns = append(conns, NewFakeConn(adapter, nil))
+	}
+	return conns
+}
@@ -0,0 +1,65 @@
+package aconnstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// FakeHTTPAdapter serves requests in-process through an http.ServeMux.
+// Use Client() to hand an *http.Client to the code under test.
+type FakeHTTPAdapter struct {
+	FakeAdapter
+
+	mux *http.ServeMux
+
+	mu sync.RWMutex
+}
+
+// NewFakeHTTPAdapter creates a FakeHTTPAdapter with the given name.
+func NewFakeHTTPAdapter(name aconns.AdapterName) *FakeHTTPAdapter {
+	return &FakeHTTPAdapter{
+		FakeAdapter: newFakeAdapter(ADAPTERTYPE_FAKEHTTP, name),
+		mux:         http.NewServeMux(),
+	}
+}
+
+// Handle registers the handler for the pattern.
+func (fh *FakeHTTPAdapter) Handle(pattern string, handler http.Handler) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the pattern.
+func (fh *FakeHTTPAdapter) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	fh.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// Do serves the request in-process after applying the next fault.
+func (fh *FakeHTTPAdapter) Do(req *http.Request) (*http.Response, error) {
+	if err := fh.check(); err != nil {
+		return nil, err
+	}
+	fh.mu.RLock()
+	mux := fh.mux
+	fh.mu.RUnlock()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// RoundTrip satisfies http.RoundTripper.
+func (fh *FakeHTTPAdapter) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fh.Do(req)
+}
+
+// Client returns an *http.Client whose requests are served by the fake.
+func (fh *FakeHTTPAdapter) Client() *http.Client {
+	return &http.Client{Transport: fh}
+}
@@ -0,0 +1,104 @@
+package aconnstest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// fakeKVItem is a stored value with an optional expiry.
+type fakeKVItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// FakeKVAdapter is an in-memory key-value store standing in for redis or badger.
+type FakeKVAdapter struct {
+	FakeAdapter
+
+	store map[string]fakeKVItem
+	now   func() time.Time
+
+	mu sync.RWMutex
+}
+
+// NewFakeKVAdapter creates a FakeKVAdapter with the given name.
+func NewFakeKVAdapter(name aconns.AdapterName) *FakeKVAdapter {
+	return &FakeKVAdapter{
+		FakeAdapter: newFakeAdapter(ADAPTERTYPE_FAKEKV, name),
+		store:       map[string]fakeKVItem{},
+		now:         time.Now,
+	}
+}
+
+// SetNow overrides the clock used for TTL expiry.
+func (kv *FakeKVAdapter) SetNow(now func() time.Time) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.now = now
+}
+
+// Set stores the value. A ttl <= 0 never expires.
+func (kv *FakeKVAdapter) Set(key string, value []byte, ttl time.Duration) error {
+	if err := kv.check(); err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	item := fakeKVItem{value: append([]byte{}, value...)}
+	if ttl > 0 {
+		item.expiresAt = kv.now().Add(ttl)
+	}
+	kv.store[key] = item
+	return nil
+}
+
+// Get returns the value and whether it exists and has not expired.
+func (kv *FakeKVAdapter) Get(key string) ([]byte, bool, error) {
+	if err := kv.check(); err != nil {
+		return nil, false, err
+	}
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	item, ok := kv.store[key]
+	if !ok || kv.isExpired(item) {
+		return nil, false, nil
+	}
+	return append([]byte{}, item.value...), true, nil
+}
+
+// Delete removes the key.
+func (kv *FakeKVAdapter) Delete(key string) error {
+	if err := kv.check(); err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.store, key)
+	return nil
+}
+
+// Keys returns the sorted, unexpired keys that start with prefix.
+func (kv *FakeKVAdapter) Keys(prefix string) ([]string, error) {
+	if err := kv.check(); err != nil {
+		return nil, err
+	}
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	keys := []string{}
+	for key, item := range kv.store {
+		if strings.HasPrefix(key, prefix) && !kv.isExpired(item) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// isExpired returns true if the item has a passed expiry.
+func (kv *FakeKVAdapter) isExpired(item fakeKVItem) bool {
+	return !item.expiresAt.IsZero() && !kv.now().Before(item.expiresAt)
+}
@@ -0,0 +1,137 @@
+package aconnstest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeAdapter_HealthFollowsFaults(t *testing.T) {
+	sqlA := NewFakeSQLAdapter("sql")
+	var _ aconns.IAdapter = sqlA
+
+	ok, status, err := sqlA.Test()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, aconns.TESTSTATUS_INITIALIZED_SUCCESSFUL, status)
+	assert.True(t, sqlA.IsHealthy())
+
+	sqlA.Faults().FailPing()
+	ok, status, err = sqlA.Test()
+	assert.False(t, ok)
+	assert.Equal(t, aconns.TESTSTATUS_FAILED, status)
+	assert.ErrorIs(t, err, ErrFakePingFailed)
+	assert.False(t, sqlA.IsHealthy())
+	assert.Equal(t, aconns.HEALTHSTATUS_PING_FAILED, sqlA.GetHealth().LastStatus)
+
+	sqlA.Faults().Reset()
+	sqlA.Faults().Script(Fault{Type: FAULTTYPE_AUTH})
+	assert.ErrorIs(t, sqlA.Refresh(), ErrFakeAuthFailed)
+	assert.Equal(t, aconns.HEALTHSTATUS_AUTH_FAILED, sqlA.GetHealth().LastStatus)
+	assert.NoError(t, sqlA.Refresh())
+	assert.True(t, sqlA.IsHealthy())
+}
+
+func TestFakeSQLAdapter(t *testing.T) {
+	sqlA := NewFakeSQLAdapter("sql")
+	sqlA.SetQueryResult("SELECT name FROM users", FakeRow{"name": "a"}, FakeRow{"name": "b"})
+
+	rows, err := sqlA.Query(" SELECT name FROM users ")
+	require.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "b", rows[1]["name"])
+
+	require.NoError(t, sqlA.Exec("DELETE FROM users WHERE id = ?", 1))
+	stmts := sqlA.Statements()
+	require.Len(t, stmts, 2)
+	assert.Equal(t, []interface{}{1}, stmts[1].Args)
+
+	sqlA.Faults().Script(Fault{Type: FAULTTYPE_NETWORK})
+	assert.ErrorIs(t, sqlA.Exec("SELECT 1"), ErrFakeNetworkError)
+	assert.Len(t, sqlA.Statements(), 2)
+}
+
+func TestFakeKVAdapter(t *testing.T) {
+	kv := NewFakeKVAdapter("kv")
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	kv.SetNow(func() time.Time { return now })
+
+	require.NoError(t, kv.Set("a:1", []byte("one"), 0))
+	require.NoError(t, kv.Set("a:2", []byte("two"), time.Minute))
+	require.NoError(t, kv.Set("b:1", []byte("three"), 0))
+
+	val, ok, err := kv.Get("a:2")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "two", string(val))
+
+	keys, err := kv.Keys("a:")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:1", "a:2"}, keys)
+
+	now = now.Add(2 * time.Minute)
+	_, ok, err = kv.Get("a:2")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, kv.Delete("a:1"))
+	keys, _ = kv.Keys("a:")
+	assert.Empty(t, keys)
+
+	kv.Faults().FailAuth()
+	_, _, err = kv.Get("b:1")
+	assert.ErrorIs(t, err, ErrFakeAuthFailed)
+	assert.Equal(t, aconns.HEALTHSTATUS_AUTH_FAILED, kv.GetHealth().LastStatus)
+}
+
+func TestFakeHTTPAdapter(t *testing.T) {
+	fh := NewFakeHTTPAdapter("http")
+	fh.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+
+	resp, err := fh.Client().Get("http://" + FAKE_HOST + "/ping")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "pong", string(body))
+
+	fh.Faults().Script(Fault{Type: FAULTTYPE_TIMEOUT})
+	_, err = fh.Client().Get("http://" + FAKE_HOST + "/ping")
+	assert.ErrorIs(t, err, ErrFakeTimeout)
+}
+
+func TestNewFakeConns_FindByTenant(t *testing.T) {
+	primary := NewFakeSQLAdapter("primary")
+	conns := NewFakeConns(primary, NewFakeKVAdapter("cache"))
+	require.Len(t, conns, 2)
+	for _, conn := range conns {
+		assert.False(t, conn.GetId().IsNil())
+		assert.NotNil(t, conn.GetTenantInfo())
+	}
+	adapter, ok := conns.FindByAdapterName("primary")
+	require.True(t, ok)
+	assert.Equal(t, primary, adapter)
+}
+
+var testGlobal = "original"
+
+func TestSwapGlobals(t *testing.T) {
+	swap := func() func() {
+		prev := testGlobal
+		testGlobal = "swapped"
+		return func() { testGlobal = prev }
+	}
+
+	t.Run("swapped", func(t *testing.T) {
+		SwapGlobals(t, swap, nil)
+		assert.Equal(t, "swapped", testGlobal)
+	})
+	assert.Equal(t, "original", testGlobal)
+}
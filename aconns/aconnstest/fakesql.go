@@ -0,0 +1,106 @@
+package aconnstest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// FakeRow is a single row returned by FakeSQLAdapter.Query.
+type FakeRow map[string]interface{}
+
+// FakeStatement records a statement received by FakeSQLAdapter.
+type FakeStatement struct {
+	Query string
+	Args  []interface{}
+}
+
+// FakeSQLAdapter satisfies aconns.IAdapterDB. It records every statement
+// and answers queries from results registered with SetQueryResult.
+type FakeSQLAdapter struct {
+	FakeAdapter
+
+	Database string `json:"database,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	statements []FakeStatement
+	results    map[string][]FakeRow
+
+	mu sync.RWMutex
+}
+
+var _ aconns.IAdapterDB = (*FakeSQLAdapter)(nil)
+
+// NewFakeSQLAdapter creates a FakeSQLAdapter with the given name.
+func NewFakeSQLAdapter(name aconns.AdapterName) *FakeSQLAdapter {
+	return &FakeSQLAdapter{
+		FakeAdapter: newFakeAdapter(ADAPTERTYPE_FAKESQL, name),
+		Database:    "fakedb",
+		results:     map[string][]FakeRow{},
+	}
+}
+
+// GetDatabase returns the database name.
+func (fs *FakeSQLAdapter) GetDatabase() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.Database
+}
+
+// GetUsername returns the username.
+func (fs *FakeSQLAdapter) GetUsername() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.Username
+}
+
+// GetPassword returns the password.
+func (fs *FakeSQLAdapter) GetPassword() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.Password
+}
+
+// SetQueryResult registers the rows returned for the query. Queries are
+// matched after trimming spaces.
+func (fs *FakeSQLAdapter) SetQueryResult(query string, rows ...FakeRow) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.results == nil {
+		fs.results = map[string][]FakeRow{}
+	}
+	fs.results[strings.TrimSpace(query)] = rows
+}
+
+// Exec records the statement after applying the next fault.
+func (fs *FakeSQLAdapter) Exec(query string, args ...interface{}) error {
+	if err := fs.check(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.statements = append(fs.statements, FakeStatement{Query: strings.TrimSpace(query), Args: args})
+	return nil
+}
+
+// Query records the statement and returns the registered rows. Unregistered
+// queries return no rows and no error.
+func (fs *FakeSQLAdapter) Query(query string, args ...interface{}) ([]FakeRow, error) {
+	if err := fs.check(); err != nil {
+		return nil, err
+	}
+	query = strings.TrimSpace(query)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.statements = append(fs.statements, FakeStatement{Query: query, Args: args})
+	return fs.results[query], nil
+}
+
+// Statements returns a copy of the recorded statements.
+func (fs *FakeSQLAdapter) Statements() []FakeStatement {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return append([]FakeStatement{}, fs.statements...)
+}
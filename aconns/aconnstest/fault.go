@@ -0,0 +1,178 @@
+package aconnstest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+var (
+	ErrFakePingFailed   = errors.New("fake ping failed")
+	ErrFakeAuthFailed   = errors.New("fake auth failed")
+	ErrFakeOpenFailed   = errors.New("fake open failed")
+	ErrFakeTimeout      = errors.New("fake timeout")
+	ErrFakeNetworkError = errors.New("fake network error")
+)
+
+// FaultType identifies the kind of failure a Fault simulates.
+type FaultType string
+
+const (
+	FAULTTYPE_NONE    FaultType = ""
+	FAULTTYPE_PING    FaultType = "ping"
+	FAULTTYPE_AUTH    FaultType = "auth"
+	FAULTTYPE_OPEN    FaultType = "open"
+	FAULTTYPE_TIMEOUT FaultType = "timeout"
+	FAULTTYPE_NETWORK FaultType = "network"
+)
+
+// IsEmpty returns true if no failure is simulated.
+func (ft FaultType) IsEmpty() bool {
+	return ft == FAULTTYPE_NONE
+}
+
+// ToHealthStatus maps the FaultType to the HealthStatus a real adapter would report.
+func (ft FaultType) ToHealthStatus() aconns.HealthStatus {
+	switch ft {
+	case FAULTTYPE_PING:
+		return aconns.HEALTHSTATUS_PING_FAILED
+	case FAULTTYPE_AUTH:
+		return aconns.HEALTHSTATUS_AUTH_FAILED
+	case FAULTTYPE_OPEN:
+		return aconns.HEALTHSTATUS_OPEN_FAILED
+	case FAULTTYPE_TIMEOUT:
+		return aconns.HEALTHSTATUS_TIMEOUT
+	case FAULTTYPE_NETWORK:
+		return aconns.HEALTHSTATUS_NETWORK_ERROR
+	default:
+		return aconns.HEALTHSTATUS_HEALTHY
+	}
+}
+
+// ToError returns the sentinel error for the FaultType or nil.
+func (ft FaultType) ToError() error {
+	switch ft {
+	case FAULTTYPE_PING:
+		return ErrFakePingFailed
+	case FAULTTYPE_AUTH:
+		return ErrFakeAuthFailed
+	case FAULTTYPE_OPEN:
+		return ErrFakeOpenFailed
+	case FAULTTYPE_TIMEOUT:
+		return ErrFakeTimeout
+	case FAULTTYPE_NETWORK:
+		return ErrFakeNetworkError
+	default:
+		return nil
+	}
+}
+
+// Fault is a single scripted step. Latency is applied before the call
+// completes and Type, when set, causes the call to fail.
+type Fault struct {
+	Latency time.Duration
+	Type    FaultType
+	Err     error // Optional override for the error returned by Type.
+}
+
+// GetError returns the error the Fault produces or nil.
+func (f Fault) GetError() error {
+	if f.Type.IsEmpty() {
+		return nil
+	}
+	if f.Err != nil {
+		return fmt.Errorf("%w; %v", f.Type.ToError(), f.Err)
+	}
+	return f.Type.ToError()
+}
+
+// FaultInjector hands out Faults to fake adapters. Scripted faults are
+// consumed in order, one per call; when the script is empty the sticky
+// fault (if any) is returned on every call.
+type FaultInjector struct {
+	script []Fault
+	sticky Fault
+	calls  int
+
+	mu sync.Mutex
+}
+
+// NewFaultInjector creates a FaultInjector with an optional script.
+func NewFaultInjector(script ...Fault) *FaultInjector {
+	return &FaultInjector{script: script}
+}
+
+// Script appends faults to be consumed in order.
+func (fi *FaultInjector) Script(faults ...Fault) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.script = append(fi.script, faults...)
+	return fi
+}
+
+// SetSticky sets the fault returned after the script is exhausted.
+func (fi *FaultInjector) SetSticky(fault Fault) *FaultInjector {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.sticky = fault
+	return fi
+}
+
+// SetLatency sets a sticky latency without failures.
+func (fi *FaultInjector) SetLatency(latency time.Duration) *FaultInjector {
+	return fi.SetSticky(Fault{Latency: latency})
+}
+
+// FailPing makes every call fail with a ping failure until Reset.
+func (fi *FaultInjector) FailPing() *FaultInjector {
+	return fi.SetSticky(Fault{Type: FAULTTYPE_PING})
+}
+
+// FailAuth makes every call fail with an auth failure until Reset.
+func (fi *FaultInjector) FailAuth() *FaultInjector {
+	return fi.SetSticky(Fault{Type: FAULTTYPE_AUTH})
+}
+
+// Reset clears the script, the sticky fault and the call counter.
+func (fi *FaultInjector) Reset() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.script = nil
+	fi.sticky = Fault{}
+	fi.calls = 0
+}
+
+// Calls returns how many times Next was called.
+func (fi *FaultInjector) Calls() int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.calls
+}
+
+// Next returns the next fault to apply.
+func (fi *FaultInjector) Next() Fault {
+	if fi == nil {
+		return Fault{}
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.calls++
+	if len(fi.script) > 0 {
+		f := fi.script[0]
+		fi.script = fi.script[1:]
+		return f
+	}
+	return fi.sticky
+}
+
+// Apply consumes the next fault, sleeps for its latency and returns it.
+func (fi *FaultInjector) Apply() Fault {
+	f := fi.Next()
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	return f
+}
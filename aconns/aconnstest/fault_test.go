@@ -0,0 +1,55 @@
+package aconnstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultType_ToHealthStatus(t *testing.T) {
+	tests := []struct {
+		ft   FaultType
+		want aconns.HealthStatus
+		err  error
+	}{
+		{FAULTTYPE_NONE, aconns.HEALTHSTATUS_HEALTHY, nil},
+		{FAULTTYPE_PING, aconns.HEALTHSTATUS_PING_FAILED, ErrFakePingFailed},
+		{FAULTTYPE_AUTH, aconns.HEALTHSTATUS_AUTH_FAILED, ErrFakeAuthFailed},
+		{FAULTTYPE_OPEN, aconns.HEALTHSTATUS_OPEN_FAILED, ErrFakeOpenFailed},
+		{FAULTTYPE_TIMEOUT, aconns.HEALTHSTATUS_TIMEOUT, ErrFakeTimeout},
+		{FAULTTYPE_NETWORK, aconns.HEALTHSTATUS_NETWORK_ERROR, ErrFakeNetworkError},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.ft.ToHealthStatus(), string(tt.ft))
+		assert.Equal(t, tt.err, tt.ft.ToError(), string(tt.ft))
+	}
+}
+
+func TestFaultInjector_ScriptThenSticky(t *testing.T) {
+	fi := NewFaultInjector(Fault{Type: FAULTTYPE_PING}, Fault{Type: FAULTTYPE_AUTH})
+	fi.SetLatency(time.Millisecond)
+
+	assert.Equal(t, FAULTTYPE_PING, fi.Next().Type)
+	assert.Equal(t, FAULTTYPE_AUTH, fi.Next().Type)
+
+	start := time.Now()
+	f := fi.Apply()
+	assert.True(t, f.Type.IsEmpty())
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond)
+	assert.Equal(t, 3, fi.Calls())
+
+	fi.FailAuth()
+	assert.ErrorIs(t, fi.Next().GetError(), ErrFakeAuthFailed)
+
+	fi.Reset()
+	assert.Equal(t, 0, fi.Calls())
+	assert.NoError(t, fi.Next().GetError())
+}
+
+func TestFault_GetErrorOverride(t *testing.T) {
+	f := Fault{Type: FAULTTYPE_PING, Err: assert.AnError}
+	assert.ErrorIs(t, f.GetError(), ErrFakePingFailed)
+	assert.Contains(t, f.GetError().Error(), assert.AnError.Error())
+}
@@ -0,0 +1,26 @@
+package aconnstest
+
+import (
+	"testing"
+)
+
+// SwapFunc installs a replacement global and returns a function that
+// restores the original. The driver modules export these, for example
+// g_aconns.SwapCONNS, aclient_redis.SwapREDIS and adb_pg.SwapPGCONNS.
+type SwapFunc func() (restore func())
+
+// SwapGlobals runs each swap and registers the restores with t.Cleanup so
+// the globals return to their original state, in reverse order, when the
+// test ends. Tests that swap globals must not run in parallel.
+func SwapGlobals(t testing.TB, swaps ...SwapFunc) {
+	t.Helper()
+	for _, swap := range swaps {
+		if swap == nil {
+			continue
+		}
+		restore := swap()
+		if restore != nil {
+			t.Cleanup(restore)
+		}
+	}
+}
@@ -35,10 +35,19 @@ type ADBPG struct {
 	TLSType string `json:"tlsType,omitempty"`
 
 	QueryHook PGQueryHook `json:"queryHook,omitempty"`
+	QueryLog  PGQueryLog  `json:"queryLog,omitempty"`
+
+	// Replicas serve reads issued through ReadDB and PGModelBase.Select.
+	Replicas             []*PGReplica `json:"replicas,omitempty"`
+	ReplicaMaxLagMS      int          `json:"replicaMaxLagMS,omitempty"`
+	ReplicaCheckInterval int          `json:"replicaCheckInterval,omitempty"`
 
 	db *bun.DB
 
+	replicaNext int
+
 	queryDebug *QueryHook
+	queryLog   *QueryLogHook
 
 	mu sync.RWMutex
 }
@@ -164,6 +173,11 @@ func (cn *ADBPG) openConnection() error {
 
 	db.AddQueryHook(cn.queryDebug)
 
+	if cn.QueryLog.IsEnabled {
+		cn.queryLog = NewQueryLogHook(cn.QueryLog)
+		db.AddQueryHook(cn.queryLog)
+	}
+
 	cn.db = db
 	return nil
 }
@@ -231,6 +245,7 @@ func (cn *ADBPG) CloseConnection() error {
 		cn.db = nil
 		cn.UpdateHealth(aconns.HEALTHSTATUS_CLOSED)
 	}
+	cn.closeReplicas()
 	return nil
 }
 
@@ -258,6 +273,7 @@ func (cn *ADBPG) Refresh() error {
 		cn.db.Close()
 		cn.db = nil
 	}
+	cn.closeReplicas()
 	return cn.openConnection()
 }
 
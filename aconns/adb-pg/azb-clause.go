@@ -0,0 +1,44 @@
+package adb_pg
+
+import (
+	"github.com/jpfluger/alibs-slim/azb"
+	"github.com/uptrace/bun"
+)
+
+// ApplyKeyset adds the WHERE, ORDER and LIMIT of an azb keyset clause to q.
+func ApplyKeyset(q *bun.SelectQuery, clause *azb.KeysetClause) *bun.SelectQuery {
+	if q == nil || clause == nil {
+		return q
+	}
+	if clause.Where != "" {
+		q = q.Where(clause.Where, clause.Args...)
+	}
+	for _, order := range clause.Order {
+		q = q.OrderExpr(order)
+	}
+	if clause.Limit > 0 {
+		q = q.Limit(clause.Limit)
+	}
+	return q
+}
+
+// FNBunSelectKeyset returns an FNBunSelect for PGModelBase.Select that applies clause.
+func FNBunSelectKeyset(clause *azb.KeysetClause) FNBunSelect {
+	return func(q *bun.SelectQuery) *bun.SelectQuery {
+		return ApplyKeyset(q, clause)
+	}
+}
+
+// ApplyFilter adds the WHERE and ORDER of a compiled azb filter to q.
+func ApplyFilter(q *bun.SelectQuery, clause *azb.FilterClause) *bun.SelectQuery {
+	if q == nil || clause == nil {
+		return q
+	}
+	if clause.Where != "" {
+		q = q.Where(clause.Where, clause.Args...)
+	}
+	for _, order := range clause.Order {
+		q = q.OrderExpr(order)
+	}
+	return q
+}
@@ -0,0 +1,49 @@
+package adb_pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jpfluger/alibs-slim/azb"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+func TestApplyKeyset(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	k := azb.NewKeyset([]byte("secret"), azb.KeysetColumn{Name: "i.id", Unique: true})
+	cursor, err := k.EncodeCursor(azb.KEYSETDIRECTION_NEXT, 10)
+	require.NoError(t, err)
+	clause, err := k.Clause(cursor, 2)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT "i"."id" FROM "items" AS "i" WHERE \(\(\("i"\."id" > 10\)\)\) ORDER BY "i"\."id" ASC LIMIT 3`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11).AddRow(12))
+
+	base := &PGModelBase{}
+	base.SetClient(pg)
+	rows := []*testReplicaRow{}
+	require.NoError(t, base.Select(context.Background(), &rows, FNBunSelectKeyset(clause)))
+	require.Len(t, rows, 2)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyFilter(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	ff := azb.FilterFields{"id": {Column: "i.id", Type: azb.FILTERFIELDTYPE_NUMBER}}
+	fq, err := ff.Parse("id gt 5 or id in (1, 2)", "-id")
+	require.NoError(t, err)
+	clause, err := ff.Compile(fq)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT "i"."id" FROM "items" AS "i" WHERE \(\(\("i"\."id" > 5\) OR \("i"\."id" IN \(1, 2\)\)\)\) ORDER BY "i"\."id" DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+
+	rows := []*testReplicaRow{}
+	require.NoError(t, pg.DB().NewSelect().Model(&rows).Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+		return ApplyFilter(q, clause)
+	}).Scan(context.Background()))
+	require.Len(t, rows, 1)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -74,3 +74,17 @@ func (cg *adapterMapGlobal) Remove(name aconns.AdapterName) {
 
 	cg.Map.Delete(name)
 }
+
+// SwapPGADAPTERS installs an empty global adapter map and returns a function
+// that restores the previous one. Intended for tests via aconnstest.SwapGlobals.
+func SwapPGADAPTERS() (restore func()) {
+	gMUAdapterMap.Lock()
+	defer gMUAdapterMap.Unlock()
+	prev := gAdapterMap
+	gAdapterMap = &adapterMapGlobal{Map: NewMapAdapterPG()}
+	return func() {
+		gMUAdapterMap.Lock()
+		defer gMUAdapterMap.Unlock()
+		gAdapterMap = prev
+	}
+}
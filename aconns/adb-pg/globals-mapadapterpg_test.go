@@ -63,3 +63,23 @@ func TestConnMapGlobal_SetErrors(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "name is empty", err.Error())
 }
+
+func TestSwapPGGlobals(t *testing.T) {
+	name := aconns.AdapterName("swap_pg")
+	orig := &ADBPG{ADBAdapterBase: aconns.ADBAdapterBase{Adapter: aconns.Adapter{Name: name}}}
+	assert.NoError(t, PGADAPTERS().Set(orig))
+	defer PGADAPTERS().Remove(name)
+
+	restoreAdapters := SwapPGADAPTERS()
+	restoreConns := SwapPGCONNS()
+	assert.Nil(t, PGADAPTERS().Get(name))
+
+	connId := aconns.NewConnId()
+	assert.NoError(t, PGCONNS().Set(connId, orig))
+	assert.NotNil(t, PGCONNS().Get(connId))
+
+	restoreConns()
+	restoreAdapters()
+	assert.Nil(t, PGCONNS().Get(connId))
+	assert.Equal(t, orig, PGADAPTERS().Get(name))
+}
@@ -79,3 +79,17 @@ func (cg *gConnMapGlobal) Remove(id aconns.ConnId) {
 
 	cg.Map.Delete(id)
 }
+
+// SwapPGCONNS installs an empty global connection map and returns a function
+// that restores the previous one. Intended for tests via aconnstest.SwapGlobals.
+func SwapPGCONNS() (restore func()) {
+	muCM.Lock()
+	defer muCM.Unlock()
+	prev := gConnMap
+	gConnMap = &gConnMapGlobal{Map: NewMapConnPG()}
+	return func() {
+		muCM.Lock()
+		defer muCM.Unlock()
+		gConnMap = prev
+	}
+}
@@ -1,6 +1,7 @@
 package adb_pg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/jpfluger/alibs-slim/aconns"
@@ -10,6 +11,9 @@ import (
 
 type FNBunSelect func(q *bun.SelectQuery) *bun.SelectQuery
 
+// FNBunWrite runs an insert, update or delete on db, which is always a tx.
+type FNBunWrite func(ctx context.Context, db bun.IDB) error
+
 func GetClientByRI(ri *aconns.RI) (*ADBPG, error) {
 	base := PGModelBase{}
 	if err := base.SetClientByRI(ri); err != nil {
@@ -76,6 +80,44 @@ func (base *PGModelBase) SetClientByRIOnce(ri *aconns.RI) error {
 	return base.SetClientByRI(ri)
 }
 
+// Select runs a select on model. Inside RunTx it uses the attached tx;
+// otherwise it reads from a replica chosen by ADBPG.ReadDB, or the primary
+// if ctx was marked by WithPrimaryRead. The RI tenant, if any, scopes
+// PGTenantModel queries and is set as the session tenant (see
+// RunPGTenantRead) so row-level security policies see it.
+func (base *PGModelBase) Select(ctx context.Context, model interface{}, fnSelect FNBunSelect) error {
+	ctx = WithPGTenantRI(ctx, base.ri)
+	tenantId, hasTenant := PGTenantFromCtx(ctx)
+	scan := func(ctx context.Context, db bun.IDB) error {
+		q := db.NewSelect().Model(model)
+		if fnSelect != nil {
+			q = fnSelect(q)
+		}
+		return q.Scan(ctx)
+	}
+	if tx := GetTxByRI(base.ri); tx != nil {
+		if hasTenant {
+			if err := SetPGTenantSession(ctx, tx, tenantId, pgTenantUserId(base.ri)); err != nil {
+				return err
+			}
+		}
+		return scan(ctx, tx)
+	}
+	if base.cli == nil {
+		return fmt.Errorf("no postgres client has been set")
+	}
+	db := base.cli.ReadDB(ctx)
+	if db == nil {
+		return fmt.Errorf("no pg db has been created")
+	}
+	if !hasTenant {
+		return scan(ctx, db)
+	}
+	return RunPGTenantRead(ctx, db, pgTenantUserId(base.ri), func(ctx context.Context, tx bun.Tx) error {
+		return scan(ctx, tx)
+	})
+}
+
 func (base *PGModelBase) GetImportRecordWrapper() aconns.IImportRecordWrapper {
 	return base.irw
 }
@@ -126,3 +168,76 @@ func (base *PGModelBase) OnImportRunAction(objImport aconns.IRecordModel, ri *ac
 
 	return aconns.NewImportRecordResults(objImport.GetPrimaryKeyAsString(), err), err
 }
+
+// Write runs fnWrite and publishes a change event for it through the RI, so
+// models that write through Write, InsertModel, UpdateModel or DeleteModel
+// need not call PublishRecordChange. Inside RunTx it uses the attached tx;
+// otherwise it runs in its own RunTx so the event is published, or written
+// to the outbox, only if the write commits. The RI tenant, if any, is set as
+// the session tenant as in Select. before is nil for inserts and after is
+// nil for deletes.
+func (base *PGModelBase) Write(ctx context.Context, action aconns.RecordActionType, before aconns.IRecordModel, after aconns.IRecordModel, fnWrite FNBunWrite) error {
+	if fnWrite == nil {
+		return fmt.Errorf("fnWrite is nil")
+	}
+	ctx = WithPGTenantRI(ctx, base.ri)
+	write := func(txri *aconns.RI) error {
+		tx := GetTxByRI(txri)
+		if tenantId, ok := PGTenantFromCtx(ctx); ok {
+			if err := SetPGTenantSession(ctx, tx, tenantId, pgTenantUserId(txri)); err != nil {
+				return err
+			}
+		}
+		if err := fnWrite(ctx, tx); err != nil {
+			return err
+		}
+		return base.PublishRecordChange(txri, action, before, after)
+	}
+	if GetTxByRI(base.ri) != nil {
+		return write(base.ri)
+	}
+	if base.cli == nil {
+		return fmt.Errorf("no postgres client has been set")
+	}
+	return base.cli.RunTx(base.ri, write)
+}
+
+// InsertModel inserts model and publishes an insert change event.
+func (base *PGModelBase) InsertModel(ctx context.Context, model aconns.IRecordModel) error {
+	return base.Write(ctx, aconns.REC_ACTION_INSERT, nil, model, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewInsert().Model(model).Exec(ctx)
+		return err
+	})
+}
+
+// UpdateModel updates model by its primary key and publishes an update
+// change event. before is the state prior to the update and may be nil.
+func (base *PGModelBase) UpdateModel(ctx context.Context, model aconns.IRecordModel, before aconns.IRecordModel) error {
+	return base.Write(ctx, aconns.REC_ACTION_UPDATE, before, model, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewUpdate().Model(model).WherePK().Exec(ctx)
+		return err
+	})
+}
+
+// DeleteModel deletes model by its primary key and publishes a delete change event.
+func (base *PGModelBase) DeleteModel(ctx context.Context, model aconns.IRecordModel) error {
+	return base.Write(ctx, aconns.REC_ACTION_DELETE, model, nil, func(ctx context.Context, db bun.IDB) error {
+		_, err := db.NewDelete().Model(model).WherePK().Exec(ctx)
+		return err
+	})
+}
+
+// PublishRecordChange builds a change event from the snapshots and publishes
+// it through the RI. Inside RunTx the event is held until commit. Write and
+// its helpers call it for you; call it directly only for writes made another
+// way, after the write succeeds.
+func (base *PGModelBase) PublishRecordChange(ri *aconns.RI, action aconns.RecordActionType, before aconns.IRecordModel, after aconns.IRecordModel) error {
+	if ri == nil {
+		ri = base.ri
+	}
+	evt, err := aconns.NewRecordChangeEvent(ri, action, before, after)
+	if err != nil {
+		return err
+	}
+	return ri.PublishRecordChange(evt)
+}
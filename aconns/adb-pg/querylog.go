@@ -0,0 +1,193 @@
+package adb_pg
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/alog"
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+)
+
+// PGQueryLog configures QueryLogHook from the adapter's JSON.
+type PGQueryLog struct {
+	IsEnabled bool `json:"isEnabled,omitempty"`
+
+	// Channel is the alog channel; defaults to alog.LOGGER_SQL.
+	Channel alog.ChannelLabel `json:"channel,omitempty"`
+
+	// SlowThresholdMS logs every query at least this slow as a warning.
+	// Zero disables slow-query capture.
+	SlowThresholdMS int `json:"slowThresholdMS,omitempty"`
+
+	// SampleRate is the fraction (0..1) of other successful queries logged
+	// at debug level. Failed queries are always logged.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+
+	// NoRedact logs the query with its literal arguments. By default string
+	// and numeric literals are replaced with '?'.
+	NoRedact bool `json:"noRedact,omitempty"`
+}
+
+type ctxKeyQueryRequestId struct{}
+
+// WithQueryRequestId attaches a request id that QueryLogHook adds to events.
+func WithQueryRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, ctxKeyQueryRequestId{}, requestId)
+}
+
+// QueryRequestIdFromCtx returns the request id set by WithQueryRequestId.
+func QueryRequestIdFromCtx(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKeyQueryRequestId{}).(string)
+	return id
+}
+
+type QueryLogHookOption func(*QueryLogHook)
+
+// QueryLogHookOptionWithLogger writes to logger instead of the alog channel.
+func QueryLogHookOptionWithLogger(logger *zerolog.Logger) QueryLogHookOption {
+	return func(h *QueryLogHook) {
+		h.logger = logger
+	}
+}
+
+// QueryLogHookOptionWithRequestId overrides how the request id is read from context.
+func QueryLogHookOptionWithRequestId(fn func(ctx context.Context) string) QueryLogHookOption {
+	return func(h *QueryLogHook) {
+		h.fnRequestId = fn
+	}
+}
+
+// QueryLogHookOptionWithSampler overrides the random source used for sampling.
+// fn must return a value in [0, 1).
+func QueryLogHookOptionWithSampler(fn func() float64) QueryLogHookOption {
+	return func(h *QueryLogHook) {
+		h.fnSample = fn
+	}
+}
+
+// QueryLogHook writes structured query events to an alog channel: errors at
+// error level, slow queries at warn level and a sample of the rest at debug.
+// Each event carries the duration, rows affected, a normalized fingerprint,
+// the (redacted) query and the request id from context.
+type QueryLogHook struct {
+	cfg         PGQueryLog
+	logger      *zerolog.Logger
+	fnRequestId func(ctx context.Context) string
+	fnSample    func() float64
+}
+
+var _ bun.QueryHook = (*QueryLogHook)(nil)
+
+// NewQueryLogHook creates a QueryLogHook.
+func NewQueryLogHook(cfg PGQueryLog, opts ...QueryLogHookOption) *QueryLogHook {
+	if cfg.Channel.IsEmpty() {
+		cfg.Channel = alog.LOGGER_SQL
+	}
+	h := &QueryLogHook{
+		cfg:         cfg,
+		fnRequestId: QueryRequestIdFromCtx,
+		fnSample:    rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *QueryLogHook) getLogger() *zerolog.Logger {
+	if h.logger != nil {
+		return h.logger
+	}
+	return alog.LOGGER(h.cfg.Channel)
+}
+
+func (h *QueryLogHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *QueryLogHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	dur := time.Since(event.StartTime)
+
+	isError := false
+	switch event.Err {
+	case nil, sql.ErrNoRows, sql.ErrTxDone:
+	default:
+		isError = true
+	}
+	isSlow := h.cfg.SlowThresholdMS > 0 && dur >= time.Duration(h.cfg.SlowThresholdMS)*time.Millisecond
+
+	logger := h.getLogger()
+	var evt *zerolog.Event
+	switch {
+	case isError:
+		evt = logger.Error().Err(event.Err)
+	case isSlow:
+		evt = logger.Warn().Bool("slow", true)
+	case h.cfg.SampleRate > 0 && h.fnSample() < h.cfg.SampleRate:
+		evt = logger.Debug()
+	default:
+		return
+	}
+	if evt == nil {
+		// The channel level filters this event out.
+		return
+	}
+
+	redacted := RedactQuery(event.Query)
+	query := redacted
+	if h.cfg.NoRedact {
+		query = event.Query
+	}
+	evt = evt.
+		Str("operation", event.Operation()).
+		Dur("duration", dur).
+		Str("fingerprint", FingerprintQuery(redacted)).
+		Str("query", query)
+	if event.Result != nil {
+		if n, err := event.Result.RowsAffected(); err == nil {
+			evt = evt.Int64("rowsAffected", n)
+		}
+	}
+	if requestId := h.fnRequestId(ctx); requestId != "" {
+		evt = evt.Str("requestId", requestId)
+	}
+	evt.Msg("pg query")
+}
+
+var (
+	reQueryString  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	reQueryNumber  = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	reQueryInList  = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	reQuerySpaces  = regexp.MustCompile(`\s+`)
+	reQueryDollars = regexp.MustCompile(`\$\d+`)
+)
+
+// RedactQuery replaces string and numeric literals with '?' so values such
+// as emails or tokens never reach the logs.
+func RedactQuery(query string) string {
+	query = reQueryString.ReplaceAllString(query, "?")
+	query = reQueryDollars.ReplaceAllString(query, "?")
+	// Keep digits that are part of identifiers (eg "t1") by requiring a word boundary.
+	query = reQueryNumber.ReplaceAllString(query, "?")
+	return query
+}
+
+// FingerprintQuery returns a stable hash for queries that differ only in
+// literal values, whitespace, case or IN-list length.
+func FingerprintQuery(query string) string {
+	normalized := RedactQuery(query)
+	normalized = reQueryInList.ReplaceAllString(normalized, "(?)")
+	normalized = strings.ToLower(strings.TrimSpace(reQuerySpaces.ReplaceAllString(normalized, " ")))
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
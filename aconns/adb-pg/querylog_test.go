@@ -0,0 +1,107 @@
+package adb_pg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readQueryLogEvents(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	events := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		m := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		events = append(events, m)
+	}
+	return events
+}
+
+func TestQueryLogHook(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	buf := &bytes.Buffer{}
+	logger := zerolog.New(buf).Level(zerolog.DebugLevel)
+
+	sample := 0.9
+	hook := NewQueryLogHook(PGQueryLog{SampleRate: 0.5},
+		QueryLogHookOptionWithLogger(&logger),
+		QueryLogHookOptionWithSampler(func() float64 { return sample }),
+	)
+	pg.db.AddQueryHook(hook)
+	ctx := WithQueryRequestId(context.Background(), "req-1")
+
+	// Not sampled.
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 3))
+	_, err := pg.db.ExecContext(ctx, "UPDATE users SET email = 'a@b.com' WHERE id = 42")
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	// Sampled.
+	sample = 0.1
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 3))
+	_, err = pg.db.ExecContext(ctx, "UPDATE users SET email = 'a@b.com' WHERE id = 42")
+	require.NoError(t, err)
+
+	// Errors are always logged.
+	sample = 0.9
+	mock.ExpectExec("DELETE FROM users").WillReturnError(fmt.Errorf("boom"))
+	_, err = pg.db.ExecContext(context.Background(), "DELETE FROM users WHERE id IN (1, 2, 3)")
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	events := readQueryLogEvents(t, buf)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "debug", events[0]["level"])
+	assert.Equal(t, "UPDATE", events[0]["operation"])
+	assert.Equal(t, float64(3), events[0]["rowsAffected"])
+	assert.Equal(t, "req-1", events[0]["requestId"])
+	assert.Equal(t, "UPDATE users SET email = ? WHERE id = ?", events[0]["query"])
+	assert.NotEmpty(t, events[0]["fingerprint"])
+	assert.Contains(t, events[0], "duration")
+
+	assert.Equal(t, "error", events[1]["level"])
+	assert.Equal(t, "boom", events[1]["error"])
+	assert.NotContains(t, events[1], "requestId")
+	assert.Equal(t, FingerprintQuery("delete from users where id in (7)"), events[1]["fingerprint"])
+}
+
+func TestQueryLogHook_Slow(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	buf := &bytes.Buffer{}
+	logger := zerolog.New(buf)
+
+	hook := NewQueryLogHook(PGQueryLog{SlowThresholdMS: 1, NoRedact: true}, QueryLogHookOptionWithLogger(&logger))
+	pg.db.AddQueryHook(hook)
+
+	mock.ExpectQuery("SELECT pg_sleep").WillDelayFor(5 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"x"}).AddRow(1))
+	rows, err := pg.db.QueryContext(context.Background(), "SELECT pg_sleep(0.005)")
+	require.NoError(t, err)
+	rows.Close()
+
+	events := readQueryLogEvents(t, buf)
+	require.Len(t, events, 1)
+	assert.Equal(t, "warn", events[0]["level"])
+	assert.Equal(t, true, events[0]["slow"])
+	assert.Equal(t, "SELECT pg_sleep(0.005)", events[0]["query"])
+}
+
+func TestFingerprintQuery(t *testing.T) {
+	a := FingerprintQuery("SELECT * FROM t1 WHERE name = 'bob' AND id IN (1,2,3)")
+	b := FingerprintQuery("select *  from t1\n where name = 'o''neil' and id in (9)")
+	c := FingerprintQuery("SELECT * FROM t2 WHERE name = 'bob' AND id IN (1,2,3)")
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.Equal(t, "SELECT * FROM t1 WHERE id = ? AND x = ?", RedactQuery("SELECT * FROM t1 WHERE id = $1 AND x = 10.5"))
+}
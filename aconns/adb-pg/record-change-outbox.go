@@ -0,0 +1,188 @@
+package adb_pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/uptrace/bun"
+)
+
+const PG_RECORD_CHANGE_OUTBOX_TABLE = "record_change_outbox"
+
+// PGRecordChangeOutboxRow is a row of the outbox table. Payload holds the
+// full aconns.RecordChangeEvent.
+type PGRecordChangeOutboxRow struct {
+	bun.BaseModel `bun:"table:record_change_outbox,alias:rco"`
+
+	Seq         int64           `bun:"seq,pk,autoincrement"`
+	Id          string          `bun:"id,notnull"`
+	ModelType   string          `bun:"model_type,notnull"`
+	PrimaryKey  string          `bun:"primary_key,notnull"`
+	Action      string          `bun:"action,notnull"`
+	Payload     json.RawMessage `bun:"payload,type:jsonb,notnull"`
+	CreatedAt   time.Time       `bun:"created_at,notnull"`
+	DeliveredAt bun.NullTime    `bun:"delivered_at"`
+}
+
+// PGRecordChangeOutbox implements aconns.IRecordChangeOutbox with a postgres
+// table. Enqueue writes inside the *bun.Tx attached to the RI (see RunTx) so
+// events commit with the records. Use aconns.RecordChangeRelay to deliver.
+type PGRecordChangeOutbox struct {
+	TableName string
+
+	getDB func() *bun.DB
+}
+
+var _ aconns.IRecordChangeOutbox = (*PGRecordChangeOutbox)(nil)
+
+// NewPGRecordChangeOutbox creates an outbox on the db.
+func NewPGRecordChangeOutbox(db *bun.DB) *PGRecordChangeOutbox {
+	return &PGRecordChangeOutbox{getDB: func() *bun.DB { return db }}
+}
+
+// NewRecordChangeOutbox creates an outbox on the adapter's db.
+func (cn *ADBPG) NewRecordChangeOutbox() *PGRecordChangeOutbox {
+	return &PGRecordChangeOutbox{getDB: cn.DB}
+}
+
+func (o *PGRecordChangeOutbox) getTableName() string {
+	if name := strings.TrimSpace(o.TableName); name != "" {
+		return name
+	}
+	return PG_RECORD_CHANGE_OUTBOX_TABLE
+}
+
+func (o *PGRecordChangeOutbox) db() (*bun.DB, error) {
+	if o.getDB == nil {
+		return nil, fmt.Errorf("no pg db has been created")
+	}
+	db := o.getDB()
+	if db == nil {
+		return nil, fmt.Errorf("no pg db has been created")
+	}
+	return db, nil
+}
+
+// CreateTable creates the outbox table and its pending index if missing.
+func (o *PGRecordChangeOutbox) CreateTable(ctx context.Context) error {
+	db, err := o.db()
+	if err != nil {
+		return err
+	}
+	table := o.getTableName()
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ? (
+	seq bigserial PRIMARY KEY,
+	id uuid NOT NULL UNIQUE,
+	model_type text NOT NULL,
+	primary_key text NOT NULL,
+	action text NOT NULL,
+	payload jsonb NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS ? ON ? (seq) WHERE delivered_at IS NULL;`,
+		bun.Ident(table), bun.Ident(table+"_pending_idx"), bun.Ident(table))
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %v", err)
+	}
+	return nil
+}
+
+// Enqueue implements aconns.IRecordChangeOutbox.
+func (o *PGRecordChangeOutbox) Enqueue(ri *aconns.RI, evts ...*aconns.RecordChangeEvent) error {
+	if len(evts) == 0 {
+		return nil
+	}
+	var idb bun.IDB
+	if tx := GetTxByRI(ri); tx != nil {
+		idb = tx
+	} else {
+		db, err := o.db()
+		if err != nil {
+			return err
+		}
+		idb = db
+	}
+
+	rows := make([]*PGRecordChangeOutboxRow, 0, len(evts))
+	for _, evt := range evts {
+		if evt == nil {
+			continue
+		}
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record change: %v", err)
+		}
+		rows = append(rows, &PGRecordChangeOutboxRow{
+			Id:         evt.Id.String(),
+			ModelType:  evt.ModelType,
+			PrimaryKey: evt.PrimaryKey,
+			Action:     evt.Action.String(),
+			Payload:    payload,
+			CreatedAt:  evt.Time,
+		})
+	}
+	_, err := idb.NewInsert().Model(&rows).ModelTableExpr("?", bun.Ident(o.getTableName())).ExcludeColumn("seq", "delivered_at").Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue record changes: %v", err)
+	}
+	return nil
+}
+
+// FetchPending implements aconns.IRecordChangeOutbox.
+func (o *PGRecordChangeOutbox) FetchPending(limit int) ([]*aconns.RecordChangeEvent, error) {
+	db, err := o.db()
+	if err != nil {
+		return nil, err
+	}
+	rows := []*PGRecordChangeOutboxRow{}
+	err = db.NewSelect().
+		Model(&rows).
+		ModelTableExpr("? AS rco", bun.Ident(o.getTableName())).
+		Where("rco.delivered_at IS NULL").
+		Order("rco.seq ASC").
+		Limit(limit).
+		Scan(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pending record changes: %v", err)
+	}
+
+	evts := make([]*aconns.RecordChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		evt := &aconns.RecordChangeEvent{}
+		if err = json.Unmarshal(row.Payload, evt); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record change seq=%d: %v", row.Seq, err)
+		}
+		evts = append(evts, evt)
+	}
+	return evts, nil
+}
+
+// MarkDelivered implements aconns.IRecordChangeOutbox.
+func (o *PGRecordChangeOutbox) MarkDelivered(ids ...auuids.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, err := o.db()
+	if err != nil {
+		return err
+	}
+	strIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strIds = append(strIds, id.String())
+	}
+	_, err = db.NewUpdate().
+		Table(o.getTableName()).
+		Set("delivered_at = ?", time.Now().UTC()).
+		Where("id IN (?)", bun.In(strIds)).
+		Exec(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to mark record changes delivered: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package adb_pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+func newTestMockPG(t *testing.T) (*ADBPG, sqlmock.Sqlmock) {
+	sqldb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqldb.Close() })
+
+	pg := &ADBPG{}
+	pg.db = bun.NewDB(sqldb, pgdialect.New())
+	pg.UpdateHealth(aconns.HEALTHSTATUS_HEALTHY)
+	return pg, mock
+}
+
+func TestADBPG_RunTx_PublishesAfterCommit(t *testing.T) {
+	restore := aconns.SwapRECORDCHANGES()
+	defer restore()
+
+	got := []*aconns.RecordChangeEvent{}
+	aconns.RECORDCHANGES().Subscribe(aconns.RecordChangeFilter{}, func(evt *aconns.RecordChangeEvent) error {
+		got = append(got, evt)
+		return nil
+	})
+
+	pg, mock := newTestMockPG(t)
+	evt := &aconns.RecordChangeEvent{Id: auuids.NewUUID(), ModelType: "User", PrimaryKey: "1", Action: aconns.REC_ACTION_INSERT}
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err := pg.RunTx(aconns.NewRIAdapter("pg"), func(ri *aconns.RI) error {
+		assert.NotNil(t, GetTxByRI(ri))
+		require.NoError(t, ri.PublishRecordChange(evt))
+		assert.Len(t, got, 0, "must not publish before commit")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = pg.RunTx(aconns.NewRIAdapter("pg"), func(ri *aconns.RI) error {
+		require.NoError(t, ri.PublishRecordChange(evt))
+		return fmt.Errorf("boom")
+	})
+	assert.Error(t, err)
+	assert.Len(t, got, 1, "rolled back changes must be dropped")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestADBPG_RunTx_RollsBackOnPanic(t *testing.T) {
+	restore := aconns.SwapRECORDCHANGES()
+	defer restore()
+
+	got := 0
+	aconns.RECORDCHANGES().Subscribe(aconns.RecordChangeFilter{}, func(evt *aconns.RecordChangeEvent) error {
+		got++
+		return nil
+	})
+
+	pg, mock := newTestMockPG(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	var txri *aconns.RI
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = pg.RunTx(nil, func(ri *aconns.RI) error {
+			txri = ri
+			require.NoError(t, ri.PublishRecordChange(&aconns.RecordChangeEvent{Action: aconns.REC_ACTION_INSERT}))
+			panic("boom")
+		})
+	})
+	require.NoError(t, txri.CommitRecordChanges())
+	assert.Equal(t, 0, got, "changes staged before the panic must be dropped")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// testChangeNote is a minimal IRecordModel written through PGModelBase.
+type testChangeNote struct {
+	bun.BaseModel `bun:"table:change_notes,alias:cn"`
+	PGModelBase   `bun:"-"`
+
+	Id   int    `bun:"id,pk" json:"id"`
+	Body string `bun:"body" json:"body"`
+}
+
+func (m *testChangeNote) Import(ri *aconns.RI, irw aconns.IImportRecordWrapper) (aconns.ImportRecordResults, error) {
+	return nil, nil
+}
+func (m *testChangeNote) Insert(ri *aconns.RI) error { return m.InsertModel(context.Background(), m) }
+func (m *testChangeNote) Update(ri *aconns.RI) error {
+	return m.UpdateModel(context.Background(), m, nil)
+}
+func (m *testChangeNote) Upsert(ri *aconns.RI) error { return nil }
+func (m *testChangeNote) Select(ri *aconns.RI) error { return nil }
+func (m *testChangeNote) SelectIntoNewObject(ri *aconns.RI) (aconns.IRecordModel, error) {
+	return nil, fmt.Errorf("not found")
+}
+func (m *testChangeNote) GetRecordSecurity() aconns.RecordSecurity { return aconns.RecordSecurity{} }
+func (m *testChangeNote) GetPrimaryKeyAsString() string            { return fmt.Sprintf("%d", m.Id) }
+
+func TestPGModelBase_WritePublishesChanges(t *testing.T) {
+	restore := aconns.SwapRECORDCHANGES()
+	defer restore()
+
+	got := []*aconns.RecordChangeEvent{}
+	aconns.RECORDCHANGES().Subscribe(aconns.RecordChangeFilter{}, func(evt *aconns.RecordChangeEvent) error {
+		got = append(got, evt)
+		return nil
+	})
+
+	pg, mock := newTestMockPG(t)
+	ri := aconns.NewRIAdapter("pg")
+	note := &testChangeNote{Id: 7, Body: "x"}
+	note.SetClient(pg)
+	note.ri = ri
+
+	// Outside RunTx the write gets its own tx.
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "change_notes"`).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+	require.NoError(t, note.Insert(ri))
+	require.Len(t, got, 1)
+	assert.Equal(t, aconns.REC_ACTION_INSERT, got[0].Action)
+	assert.Equal(t, "testChangeNote", got[0].ModelType)
+	assert.Equal(t, "7", got[0].PrimaryKey)
+	assert.Empty(t, got[0].Before)
+
+	// A failed write publishes nothing.
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "change_notes"`).WillReturnError(fmt.Errorf("boom"))
+	mock.ExpectRollback()
+	assert.Error(t, note.Update(ri))
+	assert.Len(t, got, 1)
+
+	// Inside RunTx the event waits for the outer commit.
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "change_notes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err := pg.RunTx(ri, func(txri *aconns.RI) error {
+		note.ri = txri
+		if err := note.DeleteModel(context.Background(), note); err != nil {
+			return err
+		}
+		assert.Len(t, got, 1, "must not publish before commit")
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, aconns.REC_ACTION_DELETE, got[1].Action)
+	assert.NotEmpty(t, got[1].Before)
+	assert.Empty(t, got[1].After)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGRecordChangeOutbox(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	outbox := NewPGRecordChangeOutbox(pg.db)
+
+	evt := &aconns.RecordChangeEvent{Id: auuids.NewUUID(), ModelType: "User", PrimaryKey: "1", Action: aconns.REC_ACTION_UPDATE}
+
+	// Enqueue inside RunTx writes through the tx.
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "record_change_outbox"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	err := pg.RunTx(aconns.NewRIAdapter("pg"), func(ri *aconns.RI) error {
+		return outbox.Enqueue(ri, evt)
+	})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(evt)
+	require.NoError(t, err)
+	mock.ExpectQuery(`SELECT .* FROM "record_change_outbox" AS rco WHERE \(rco.delivered_at IS NULL\) ORDER BY "rco"."seq" ASC LIMIT 10`).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "id", "model_type", "primary_key", "action", "payload"}).
+			AddRow(1, evt.Id.String(), "User", "1", "update", payload))
+	evts, err := outbox.FetchPending(10)
+	require.NoError(t, err)
+	require.Len(t, evts, 1)
+	assert.Equal(t, evt.Id.String(), evts[0].Id.String())
+	assert.Equal(t, aconns.REC_ACTION_UPDATE, evts[0].Action)
+
+	mock.ExpectExec(`UPDATE "record_change_outbox" SET delivered_at = .* WHERE \(id IN \('` + evt.Id.String() + `'\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, outbox.MarkDelivered(evt.Id))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,306 @@
+package adb_pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+const (
+	PG_REPLICA_DEFAULT_MAX_LAG_MS     = 5000
+	PG_REPLICA_DEFAULT_CHECK_INTERVAL = 10
+	PG_REPLICA_DEFAULT_CHECK_TIMEOUT  = 5
+)
+
+// sqlReplicaLag returns the replay lag in seconds. A standby that has
+// replayed everything it received reports zero, so an idle primary does not
+// make its replicas look stale.
+const sqlReplicaLag = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// PGReplica is a read replica of an ADBPG. Credentials and database are
+// inherited from the primary; only the address differs.
+type PGReplica struct {
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+
+	db        *bun.DB
+	lag       time.Duration
+	isHealthy bool
+	lastCheck time.Time
+	lastErr   error
+	checking  bool
+	gen       int
+
+	mu sync.RWMutex
+}
+
+// GetAddress returns host:port of the replica.
+func (r *PGReplica) GetAddress() string {
+	port := r.Port
+	if port <= 0 {
+		port = POSTGRES_DEFAULT_PORT
+	}
+	return fmt.Sprintf("%s:%d", strings.TrimSpace(r.Host), port)
+}
+
+// GetLag returns the replication lag measured by the last check.
+func (r *PGReplica) GetLag() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lag
+}
+
+// IsHealthy is true if the last check connected and measured the lag.
+func (r *PGReplica) IsHealthy() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.isHealthy
+}
+
+// GetLastError returns the error of the last failed check or nil.
+func (r *PGReplica) GetLastError() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastErr
+}
+
+func (r *PGReplica) isUsable(maxLag time.Duration) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db != nil && r.isHealthy && r.lag <= maxLag
+}
+
+// check opens the replica if needed and measures its lag. The dial and the
+// lag query run outside the lock, bounded by timeout, so readers keep using
+// the last known state while a check is in flight. Only one check runs at a
+// time; a concurrent call returns the last error without waiting.
+func (r *PGReplica) check(ctx context.Context, open func(r *PGReplica) (*bun.DB, error), timeout time.Duration) error {
+	r.mu.Lock()
+	if r.checking {
+		err := r.lastErr
+		r.mu.Unlock()
+		return err
+	}
+	db, gen := r.beginCheck()
+	r.mu.Unlock()
+	return r.runCheck(ctx, open, timeout, db, gen)
+}
+
+// checkAsync starts a check in the background if the last one is older than
+// interval and none is in flight. It never blocks on the replica.
+func (r *PGReplica) checkAsync(open func(r *PGReplica) (*bun.DB, error), interval, timeout time.Duration) {
+	r.mu.Lock()
+	if r.checking || time.Since(r.lastCheck) < interval {
+		r.mu.Unlock()
+		return
+	}
+	db, gen := r.beginCheck()
+	r.mu.Unlock()
+	go func() { _ = r.runCheck(context.Background(), open, timeout, db, gen) }()
+}
+
+// beginCheck marks a check as running. The caller holds r.mu.
+func (r *PGReplica) beginCheck() (*bun.DB, int) {
+	r.checking = true
+	r.lastCheck = time.Now()
+	return r.db, r.gen
+}
+
+func (r *PGReplica) runCheck(ctx context.Context, open func(r *PGReplica) (*bun.DB, error), timeout time.Duration, db *bun.DB, gen int) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var opened *bun.DB
+	var seconds float64
+	var err error
+	if db == nil {
+		if opened, err = open(r); err == nil {
+			db = opened
+		}
+	}
+	if err == nil {
+		if err = db.NewRaw(sqlReplicaLag).Scan(ctx, &seconds); err != nil {
+			err = fmt.Errorf("replica lag check failed where addr=%s; %v", r.GetAddress(), err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checking = false
+	if gen != r.gen {
+		// Closed while the check ran; drop the result.
+		if opened != nil {
+			opened.Close()
+		}
+		return err
+	}
+	if opened != nil {
+		r.db = opened
+	}
+	if err != nil {
+		r.isHealthy = false
+		r.lastErr = err
+		return err
+	}
+	r.lag = time.Duration(seconds * float64(time.Second))
+	r.isHealthy = true
+	r.lastErr = nil
+	return nil
+}
+
+func (r *PGReplica) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.db != nil {
+		r.db.Close()
+		r.db = nil
+	}
+	r.isHealthy = false
+	r.gen++
+}
+
+func (r *PGReplica) getDB() *bun.DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db
+}
+
+type ctxKeyPGPrimaryRead struct{}
+
+// WithPrimaryRead marks ctx so ReadDB and PGModelBase.Select use the primary.
+// Use it for reads that must see a write the caller just made.
+func WithPrimaryRead(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKeyPGPrimaryRead{}, true)
+}
+
+// IsPrimaryRead reports whether ctx was marked by WithPrimaryRead.
+func IsPrimaryRead(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	b, _ := ctx.Value(ctxKeyPGPrimaryRead{}).(bool)
+	return b
+}
+
+func (cn *ADBPG) getReplicaMaxLag() time.Duration {
+	if cn.ReplicaMaxLagMS <= 0 {
+		return PG_REPLICA_DEFAULT_MAX_LAG_MS * time.Millisecond
+	}
+	return time.Duration(cn.ReplicaMaxLagMS) * time.Millisecond
+}
+
+func (cn *ADBPG) getReplicaCheckInterval() time.Duration {
+	if cn.ReplicaCheckInterval <= 0 {
+		return PG_REPLICA_DEFAULT_CHECK_INTERVAL * time.Second
+	}
+	return time.Duration(cn.ReplicaCheckInterval) * time.Second
+}
+
+func (cn *ADBPG) getReplicaCheckTimeout() time.Duration {
+	if cn.DialTimeout <= 0 {
+		return PG_REPLICA_DEFAULT_CHECK_TIMEOUT * time.Second
+	}
+	return time.Duration(cn.DialTimeout) * time.Second
+}
+
+// openReplica connects to a replica with the primary's settings and hooks.
+func (cn *ADBPG) openReplica(r *PGReplica) (*bun.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cn.DialTimeout)*time.Second)
+	defer cancel()
+
+	connector := cn.getConnConfig()
+	pgdriver.WithAddr(r.GetAddress())(connector.Config())
+	sqldb := sql.OpenDB(connector)
+	if err := sqldb.PingContext(ctx); err != nil {
+		sqldb.Close()
+		return nil, fmt.Errorf("could not ping replica where addr=%s; %v", r.GetAddress(), err)
+	}
+
+	db := bun.NewDB(sqldb, pgdialect.New())
+	if cn.queryDebug != nil {
+		db.AddQueryHook(cn.queryDebug)
+	}
+	if cn.queryLog != nil {
+		db.AddQueryHook(cn.queryLog)
+	}
+	return db, nil
+}
+
+// CheckReplicas measures the lag of every replica now.
+func (cn *ADBPG) CheckReplicas(ctx context.Context) error {
+	var errs []string
+	timeout := cn.getReplicaCheckTimeout()
+	for _, r := range cn.Replicas {
+		if r == nil {
+			continue
+		}
+		if err := r.check(ctx, cn.openReplica, timeout); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("replica checks failed; %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ReadDB returns the db to use for a read. It picks the next healthy replica
+// whose lag is within ReplicaMaxLagMS, judged by the last check only. A
+// replica whose last check is older than ReplicaCheckInterval is re-checked
+// in the background, so a read never waits on a dial or lag query; until its
+// first check completes a replica is not used. Call CheckReplicas after open
+// to warm them up. It falls back to the primary if no replica qualifies or
+// ctx was marked by WithPrimaryRead.
+func (cn *ADBPG) ReadDB(ctx context.Context) *bun.DB {
+	if len(cn.Replicas) == 0 || IsPrimaryRead(ctx) {
+		return cn.DB()
+	}
+	maxLag := cn.getReplicaMaxLag()
+	interval := cn.getReplicaCheckInterval()
+	timeout := cn.getReplicaCheckTimeout()
+
+	cn.mu.Lock()
+	start := cn.replicaNext
+	cn.replicaNext++
+	cn.mu.Unlock()
+
+	for ii := 0; ii < len(cn.Replicas); ii++ {
+		r := cn.Replicas[(start+ii)%len(cn.Replicas)]
+		if r == nil {
+			continue
+		}
+		r.checkAsync(cn.openReplica, interval, timeout)
+		if r.isUsable(maxLag) {
+			return r.getDB()
+		}
+	}
+	return cn.DB()
+}
+
+func (cn *ADBPG) closeReplicas() {
+	for _, r := range cn.Replicas {
+		if r != nil {
+			r.close()
+		}
+	}
+}
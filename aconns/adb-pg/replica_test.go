@@ -0,0 +1,224 @@
+package adb_pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auser"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+type testReplicaRow struct {
+	bun.BaseModel `bun:"table:items,alias:i"`
+	Id            int `bun:"id,pk"`
+}
+
+func newTestMockReplica(t *testing.T) (*PGReplica, sqlmock.Sqlmock) {
+	sqldb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqldb.Close() })
+	return &PGReplica{Host: "replica", db: bun.NewDB(sqldb, pgdialect.New())}, mock
+}
+
+func TestADBPG_ReadDB_Replicas(t *testing.T) {
+	pg, primary := newTestMockPG(t)
+	r1, mock1 := newTestMockReplica(t)
+	r2, mock2 := newTestMockReplica(t)
+	pg.Replicas = []*PGReplica{r1, r2}
+	pg.ReplicaMaxLagMS = 1000
+	pg.ReplicaCheckInterval = 60
+
+	base := &PGModelBase{}
+	base.SetClient(pg)
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+
+	// r1 lags too far behind, so r2 serves the read.
+	mock1.ExpectQuery("pg_is_in_recovery").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(30.0))
+	mock2.ExpectQuery("pg_is_in_recovery").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.2))
+	require.NoError(t, pg.CheckReplicas(context.Background()))
+	mock2.ExpectQuery(`SELECT "i"."id" FROM "items"`).WillReturnRows(rows())
+	row := &testReplicaRow{}
+	require.NoError(t, base.Select(context.Background(), row, nil))
+	assert.Equal(t, 1, row.Id)
+	assert.False(t, r1.isUsable(pg.getReplicaMaxLag()))
+	assert.Equal(t, 200, int(r2.GetLag().Milliseconds()))
+
+	// Checks are cached until the interval passes.
+	mock2.ExpectQuery(`SELECT "i"."id" FROM "items"`).WillReturnRows(rows())
+	require.NoError(t, base.Select(context.Background(), &testReplicaRow{}, nil))
+
+	// Reads after a write can force the primary.
+	primary.ExpectQuery(`SELECT "i"."id" FROM "items"`).WillReturnRows(rows())
+	require.NoError(t, base.Select(WithPrimaryRead(context.Background()), &testReplicaRow{}, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("id = ?", 1)
+	}))
+
+	require.NoError(t, mock1.ExpectationsWereMet())
+	require.NoError(t, mock2.ExpectationsWereMet())
+	require.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestADBPG_ReadDB_FallbackAndTx(t *testing.T) {
+	pg, primary := newTestMockPG(t)
+	r1, mock1 := newTestMockReplica(t)
+	pg.Replicas = []*PGReplica{r1}
+
+	mock1.ExpectQuery("pg_is_in_recovery").WillReturnError(assert.AnError)
+	assert.Error(t, pg.CheckReplicas(context.Background()))
+	assert.Same(t, pg.DB(), pg.ReadDB(context.Background()))
+	assert.False(t, r1.IsHealthy())
+	assert.Error(t, r1.GetLastError())
+
+	// Selects inside a transaction stay on the primary's tx.
+	primary.ExpectBegin()
+	primary.ExpectQuery(`SELECT "i"."id" FROM "items"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	primary.ExpectCommit()
+	err := pg.RunTx(aconns.NewRIAdapter("primary"), func(ri *aconns.RI) error {
+		base := &PGModelBase{}
+		base.SetClient(pg)
+		base.ri = ri
+		row := &testReplicaRow{}
+		if err := base.Select(context.Background(), row, nil); err != nil {
+			return err
+		}
+		assert.Equal(t, 7, row.Id)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, primary.ExpectationsWereMet())
+	require.NoError(t, mock1.ExpectationsWereMet())
+}
+
+func TestADBPG_ReadDB_TenantSession(t *testing.T) {
+	pg, primary := newTestMockPG(t)
+	r1, mock1 := newTestMockReplica(t)
+	pg.Replicas = []*PGReplica{r1}
+	tenantId := auuids.NewUUID()
+	uid := auser.NewUID()
+
+	ri := aconns.NewRISECAdapter("pg", auser.NewRecordUserIdentity(uid, nil))
+	ri.SetTenantInfo(&aconns.ConnTenantInfo{TenantId: tenantId})
+	base := &PGModelBase{}
+	base.SetClient(pg)
+	base.ri = ri
+
+	// A replica read runs in a read-only tx that sets the session tenant
+	// first, so row-level security policies see it.
+	mock1.ExpectQuery("pg_is_in_recovery").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.0))
+	require.NoError(t, pg.CheckReplicas(context.Background()))
+	mock1.ExpectBegin()
+	mock1.ExpectExec(`SELECT set_config\('app.tenant_id', '` + tenantId.String() + `', true\), set_config\('app.user_id', '` + uid.String() + `', true\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock1.ExpectQuery(`FROM "notes" AS "n" WHERE \("n"\."tenant_id" = '` + tenantId.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body"}).AddRow(3, "r"))
+	mock1.ExpectCommit()
+	note := &testTenantNote{}
+	require.NoError(t, base.Select(context.Background(), note, nil))
+	assert.Equal(t, 3, note.Id)
+
+	// A failed session setup rolls back and returns the error.
+	mock1.ExpectBegin()
+	mock1.ExpectExec(`set_config`).WillReturnError(assert.AnError)
+	mock1.ExpectRollback()
+	assert.Error(t, base.Select(context.Background(), &testTenantNote{}, nil))
+
+	// Without a tenant, plain reads are unchanged.
+	base.ri = aconns.NewRIAdapter("pg")
+	mock1.ExpectQuery(`SELECT "i"."id" FROM "items"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	require.NoError(t, base.Select(context.Background(), &testReplicaRow{}, nil))
+
+	require.NoError(t, mock1.ExpectationsWereMet())
+	require.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestPGReplica_CheckOutsideLock(t *testing.T) {
+	pg, _ := newTestMockPG(t)
+	r1 := &PGReplica{Host: "replica"}
+	pg.Replicas = []*PGReplica{r1}
+
+	// A hung dial holds no lock: other readers fall back to the primary and
+	// a concurrent check returns without waiting.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- r1.check(context.Background(), func(r *PGReplica) (*bun.DB, error) {
+			close(started)
+			<-release
+			return nil, assert.AnError
+		}, time.Second)
+	}()
+	<-started
+
+	readDone := make(chan *bun.DB, 1)
+	go func() { readDone <- pg.ReadDB(context.Background()) }()
+	select {
+	case db := <-readDone:
+		assert.Same(t, pg.DB(), db)
+	case <-time.After(time.Second):
+		t.Fatal("ReadDB blocked on a replica check")
+	}
+	assert.NoError(t, r1.check(context.Background(), func(r *PGReplica) (*bun.DB, error) {
+		t.Fatal("a second check must not run while one is in flight")
+		return nil, nil
+	}, time.Second))
+	assert.False(t, r1.IsHealthy())
+
+	close(release)
+	assert.ErrorIs(t, <-done, assert.AnError)
+	assert.ErrorIs(t, r1.GetLastError(), assert.AnError)
+}
+
+func TestADBPG_ReadDB_CheckInBackground(t *testing.T) {
+	pg, _ := newTestMockPG(t)
+	r1, mock1 := newTestMockReplica(t)
+	pg.Replicas = []*PGReplica{r1}
+
+	// A due check runs in the background: the read that notices it uses the
+	// last known state and does not wait on the lag query.
+	mock1.ExpectQuery("pg_is_in_recovery").WillDelayFor(2 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.0))
+	begin := time.Now()
+	assert.Same(t, pg.DB(), pg.ReadDB(context.Background()))
+	assert.Same(t, pg.DB(), pg.ReadDB(context.Background()))
+	assert.Less(t, time.Since(begin), time.Second)
+
+	assert.Eventually(t, r1.IsHealthy, 5*time.Second, 10*time.Millisecond)
+	assert.Same(t, r1.getDB(), pg.ReadDB(context.Background()))
+	require.NoError(t, mock1.ExpectationsWereMet())
+}
+
+func TestPGReplica_CheckTimeout(t *testing.T) {
+	r1, mock1 := newTestMockReplica(t)
+	mock1.ExpectQuery("pg_is_in_recovery").WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.0))
+
+	begin := time.Now()
+	err := r1.check(context.Background(), nil, 50*time.Millisecond)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(begin), 2*time.Second)
+	assert.False(t, r1.IsHealthy())
+}
+
+func TestPGReplica_CloseDuringCheck(t *testing.T) {
+	r1 := &PGReplica{Host: "replica"}
+	sqldb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery("pg_is_in_recovery").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.0))
+	mock.ExpectClose()
+
+	require.NoError(t, r1.check(context.Background(), func(r *PGReplica) (*bun.DB, error) {
+		r.close()
+		return bun.NewDB(sqldb, pgdialect.New()), nil
+	}, time.Second))
+	assert.Nil(t, r1.getDB(), "a db opened before close is discarded")
+	assert.False(t, r1.IsHealthy())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
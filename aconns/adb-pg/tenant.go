@@ -0,0 +1,281 @@
+package adb_pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/uptrace/bun"
+)
+
+var ErrPGTenantRequired = errors.New("tenant context required")
+
+const (
+	PG_TENANT_COLUMN       = "tenant_id"
+	PG_TENANT_SETTING      = "app.tenant_id"
+	PG_TENANT_USER_SETTING = "app.user_id"
+)
+
+type ctxKeyPGTenant struct{}
+type ctxKeyPGTenantBypass struct{}
+
+// WithPGTenant returns a context that scopes tenant model queries to tenantId.
+func WithPGTenant(ctx context.Context, tenantId auuids.UUID) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKeyPGTenant{}, tenantId)
+}
+
+// WithPGTenantRI scopes ctx to the tenant of the RI, if it has one and ctx
+// is not already scoped.
+func WithPGTenantRI(ctx context.Context, ri *aconns.RI) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := PGTenantFromCtx(ctx); ok {
+		return ctx
+	}
+	if ti := ri.GetTenantInfo(); ti != nil && !ti.TenantId.IsNil() {
+		return WithPGTenant(ctx, ti.TenantId)
+	}
+	return ctx
+}
+
+// PGTenantFromCtx returns the tenant set by WithPGTenant.
+func PGTenantFromCtx(ctx context.Context) (auuids.UUID, bool) {
+	if ctx == nil {
+		return auuids.UUID{}, false
+	}
+	id, ok := ctx.Value(ctxKeyPGTenant{}).(auuids.UUID)
+	if !ok || id.IsNil() {
+		return auuids.UUID{}, false
+	}
+	return id, true
+}
+
+// WithPGTenantBypass lets tenant model queries run without a tenant, for
+// migrations and cross-tenant system jobs. Row-level security still applies
+// in the database unless the role bypasses it.
+func WithPGTenantBypass(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKeyPGTenantBypass{}, true)
+}
+
+// IsPGTenantBypass reports whether ctx was marked by WithPGTenantBypass.
+func IsPGTenantBypass(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	b, _ := ctx.Value(ctxKeyPGTenantBypass{}).(bool)
+	return b
+}
+
+// PGTenantModel is embedded in models of tenant-scoped tables. Its bun hooks
+// refuse queries whose context has no tenant (see WithPGTenant) and add a
+// tenant filter to selects, updates and deletes, so a forgotten WHERE clause
+// cannot leak rows even where row-level security is not enforced. Inserts
+// and updates write TenantId as is, so set it on the model; the policy from
+// PGTenantPolicy rejects rows of another tenant.
+type PGTenantModel struct {
+	TenantId auuids.UUID `bun:"tenant_id,type:uuid,notnull" json:"tenantId,omitempty"`
+}
+
+var (
+	_ bun.BeforeSelectHook = (*PGTenantModel)(nil)
+	_ bun.BeforeInsertHook = (*PGTenantModel)(nil)
+	_ bun.BeforeUpdateHook = (*PGTenantModel)(nil)
+	_ bun.BeforeDeleteHook = (*PGTenantModel)(nil)
+)
+
+func (m *PGTenantModel) BeforeSelect(ctx context.Context, query *bun.SelectQuery) error {
+	tenantId, err := requirePGTenant(ctx)
+	if err != nil || tenantId.IsNil() {
+		return err
+	}
+	query.Where("?TableAlias.? = ?", bun.Ident(PG_TENANT_COLUMN), tenantId.String())
+	return nil
+}
+
+func (m *PGTenantModel) BeforeInsert(ctx context.Context, query *bun.InsertQuery) error {
+	_, err := requirePGTenant(ctx)
+	return err
+}
+
+func (m *PGTenantModel) BeforeUpdate(ctx context.Context, query *bun.UpdateQuery) error {
+	tenantId, err := requirePGTenant(ctx)
+	if err != nil || tenantId.IsNil() {
+		return err
+	}
+	query.Where("?TableAlias.? = ?", bun.Ident(PG_TENANT_COLUMN), tenantId.String())
+	return nil
+}
+
+func (m *PGTenantModel) BeforeDelete(ctx context.Context, query *bun.DeleteQuery) error {
+	tenantId, err := requirePGTenant(ctx)
+	if err != nil || tenantId.IsNil() {
+		return err
+	}
+	query.Where("?TableAlias.? = ?", bun.Ident(PG_TENANT_COLUMN), tenantId.String())
+	return nil
+}
+
+// requirePGTenant returns the tenant of ctx, a nil id when bypassed, or
+// ErrPGTenantRequired.
+func requirePGTenant(ctx context.Context) (auuids.UUID, error) {
+	if tenantId, ok := PGTenantFromCtx(ctx); ok {
+		return tenantId, nil
+	}
+	if IsPGTenantBypass(ctx) {
+		return auuids.UUID{}, nil
+	}
+	return auuids.UUID{}, ErrPGTenantRequired
+}
+
+// SetPGTenantSession sets the tenant and user session variables read by the
+// policies from PGTenantPolicy. Inside a transaction the values last until
+// commit or rollback; on a pooled connection they would leak to the next
+// borrower, so always pass a tx.
+func SetPGTenantSession(ctx context.Context, tx bun.IDB, tenantId auuids.UUID, userId string) error {
+	if tx == nil {
+		return fmt.Errorf("tx is nil")
+	}
+	if tenantId.IsNil() {
+		return ErrPGTenantRequired
+	}
+	_, err := tx.ExecContext(ctx, "SELECT set_config(?, ?, true), set_config(?, ?, true)",
+		PG_TENANT_SETTING, tenantId.String(), PG_TENANT_USER_SETTING, userId)
+	if err != nil {
+		return fmt.Errorf("failed to set tenant session; %v", err)
+	}
+	return nil
+}
+
+// RunTenantTx is RunTx for the tenant of the RI (see RI.SetTenantInfo). The
+// tenant and RI user are set as session variables at the start of the
+// transaction so row-level security policies apply to every statement in fn.
+func (cn *ADBPG) RunTenantTx(ri *aconns.RI, fn func(ri *aconns.RI) error) error {
+	ti := ri.GetTenantInfo()
+	if ti == nil || ti.TenantId.IsNil() {
+		return fmt.Errorf("%w; ri has no tenant info", ErrPGTenantRequired)
+	}
+	userId := pgTenantUserId(ri)
+	return cn.RunTx(ri, func(txri *aconns.RI) error {
+		if err := SetPGTenantSession(context.Background(), GetTxByRI(txri), ti.TenantId, userId); err != nil {
+			return err
+		}
+		return fn(txri)
+	})
+}
+
+// RunPGTenantRead runs fn in a read-only transaction on db with the tenant
+// of ctx (see WithPGTenant) set as session variables, so row-level security
+// policies also apply to reads made outside RunTenantTx, such as those
+// served by a replica.
+func RunPGTenantRead(ctx context.Context, db *bun.DB, userId string, fn func(ctx context.Context, tx bun.Tx) error) error {
+	if db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	tenantId, ok := PGTenantFromCtx(ctx)
+	if !ok {
+		return ErrPGTenantRequired
+	}
+	return db.RunInTx(ctx, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx bun.Tx) error {
+		if err := SetPGTenantSession(ctx, tx, tenantId, userId); err != nil {
+			return err
+		}
+		return fn(ctx, tx)
+	})
+}
+
+func pgTenantUserId(ri *aconns.RI) string {
+	if uid := ri.GetUser().UID; !uid.IsNil() {
+		return uid.String()
+	}
+	return ""
+}
+
+// PGTenantPolicy generates row-level security for tenant-scoped tables.
+// Each table gets a policy that limits reads and writes to rows whose
+// Column matches the Setting session variable; with no tenant set, no rows
+// are visible.
+type PGTenantPolicy struct {
+	Tables  []string `json:"tables,omitempty"`
+	Column  string   `json:"column,omitempty"`  // Defaults to PG_TENANT_COLUMN.
+	Setting string   `json:"setting,omitempty"` // Defaults to PG_TENANT_SETTING.
+
+	// NoForce leaves the table owner exempt from the policies.
+	NoForce bool `json:"noForce,omitempty"`
+}
+
+// SQL returns the statements that enable row-level security and (re)create
+// the tenant policy on every table.
+func (p PGTenantPolicy) SQL() (string, error) {
+	column := strings.TrimSpace(p.Column)
+	if column == "" {
+		column = PG_TENANT_COLUMN
+	}
+	setting := strings.TrimSpace(p.Setting)
+	if setting == "" {
+		setting = PG_TENANT_SETTING
+	}
+	if len(p.Tables) == 0 {
+		return "", fmt.Errorf("no tables for tenant policy")
+	}
+
+	check := fmt.Sprintf("%s = NULLIF(current_setting('%s', true), '')::uuid", Escape(column), strings.ReplaceAll(setting, "'", "''"))
+	sb := strings.Builder{}
+	for _, table := range p.Tables {
+		table = strings.TrimSpace(table)
+		if table == "" {
+			return "", fmt.Errorf("empty table name in tenant policy")
+		}
+		name := escapeQualified(table)
+		policy := Escape(strings.ReplaceAll(table, ".", "_") + "_tenant_isolation")
+		sb.WriteString(fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY;\n", name))
+		if !p.NoForce {
+			sb.WriteString(fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY;\n", name))
+		}
+		sb.WriteString(fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s;\n", policy, name))
+		sb.WriteString(fmt.Sprintf("CREATE POLICY %s ON %s USING (%s) WITH CHECK (%s);\n", policy, name, check, check))
+	}
+	return sb.String(), nil
+}
+
+// ApplyToConnActionMap appends the policy SQL to the CREATE step so it runs
+// after the tables are created.
+func (p PGTenantPolicy) ApplyToConnActionMap(cam aconns.ConnActionMap) error {
+	if cam == nil {
+		return fmt.Errorf("conn action map is nil")
+	}
+	text, err := p.SQL()
+	if err != nil {
+		return err
+	}
+	item := cam.GetItem(aconns.CONNACTIONTYPE_CREATE)
+	if item == nil {
+		item = &aconns.ConnSystemItem{}
+		cam[aconns.CONNACTIONTYPE_CREATE] = item
+	}
+	if existing := strings.TrimSpace(item.Text); existing != "" {
+		text = existing + "\n\n" + text
+	}
+	item.Text = text
+	item.DoSkip = false
+	return nil
+}
+
+// escapeQualified escapes each part of a schema-qualified name.
+func escapeQualified(name string) string {
+	parts := strings.Split(name, ".")
+	for ii, part := range parts {
+		parts[ii] = Escape(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ".")
+}
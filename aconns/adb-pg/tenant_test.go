@@ -0,0 +1,99 @@
+package adb_pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auser"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+)
+
+type testTenantNote struct {
+	bun.BaseModel `bun:"table:notes,alias:n"`
+	PGTenantModel
+
+	Id   int    `bun:"id,pk"`
+	Body string `bun:"body"`
+}
+
+func TestPGTenantModel_Hooks(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	tenantId := auuids.NewUUID()
+
+	err := pg.DB().NewSelect().Model(&testTenantNote{}).Scan(context.Background())
+	assert.ErrorIs(t, err, ErrPGTenantRequired)
+	_, err = pg.DB().NewDelete().Model(&testTenantNote{Id: 1}).WherePK().Exec(context.Background())
+	assert.ErrorIs(t, err, ErrPGTenantRequired)
+	_, err = pg.DB().NewInsert().Model(&testTenantNote{Id: 1}).Exec(context.Background())
+	assert.ErrorIs(t, err, ErrPGTenantRequired)
+
+	ctx := WithPGTenant(context.Background(), tenantId)
+	mock.ExpectQuery(`SELECT .* FROM "notes" AS "n" WHERE \("n"\."tenant_id" = '` + tenantId.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body"}).AddRow(1, "hi"))
+	notes := []*testTenantNote{}
+	require.NoError(t, pg.DB().NewSelect().Model(&notes).Scan(ctx))
+	require.Len(t, notes, 1)
+
+	mock.ExpectExec(`UPDATE "notes" AS "n" SET .* WHERE \("n"\."tenant_id" = '` + tenantId.String() + `'\) AND \("n"\."id" = 1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = pg.DB().NewUpdate().Model(&testTenantNote{PGTenantModel: PGTenantModel{TenantId: tenantId}, Id: 1, Body: "x"}).WherePK().Exec(ctx)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT .* FROM "notes" AS "n"$`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	require.NoError(t, pg.DB().NewSelect().Model(&notes).Scan(WithPGTenantBypass(context.Background())))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestADBPG_RunTenantTx(t *testing.T) {
+	pg, mock := newTestMockPG(t)
+	tenantId := auuids.NewUUID()
+	uid := auser.NewUID()
+
+	ri := aconns.NewRISECAdapter("pg", auser.NewRecordUserIdentity(uid, nil))
+	assert.ErrorIs(t, pg.RunTenantTx(ri, func(ri *aconns.RI) error { return nil }), ErrPGTenantRequired)
+
+	ri.SetTenantInfo(&aconns.ConnTenantInfo{TenantId: tenantId, Region: "us"})
+	setConfig := `SELECT set_config\('app.tenant_id', '` + tenantId.String() + `', true\), set_config\('app.user_id', '` + uid.String() + `', true\)`
+	mock.ExpectBegin()
+	mock.ExpectExec(setConfig).WillReturnResult(sqlmock.NewResult(0, 0))
+	// Select sets the session again, as it does for any tx it is given.
+	mock.ExpectExec(setConfig).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM "notes" AS "n" WHERE \("n"\."tenant_id" = '` + tenantId.String() + `'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body"}).AddRow(2, "x"))
+	mock.ExpectCommit()
+
+	err := pg.RunTenantTx(ri, func(txri *aconns.RI) error {
+		assert.Equal(t, tenantId, txri.GetTenantInfo().TenantId)
+		base := &PGModelBase{}
+		base.SetClient(pg)
+		base.ri = txri
+		note := &testTenantNote{}
+		return base.Select(context.Background(), note, nil)
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGTenantPolicy(t *testing.T) {
+	cam := aconns.NewConnActionMap()
+	cam[aconns.CONNACTIONTYPE_CREATE].Text = "CREATE TABLE notes (id int, tenant_id uuid);"
+
+	p := PGTenantPolicy{Tables: []string{"notes", "app.files"}}
+	require.NoError(t, p.ApplyToConnActionMap(cam))
+
+	text := cam[aconns.CONNACTIONTYPE_CREATE].Text
+	assert.Contains(t, text, "CREATE TABLE notes")
+	assert.Contains(t, text, `ALTER TABLE "notes" ENABLE ROW LEVEL SECURITY;`)
+	assert.Contains(t, text, `ALTER TABLE "app"."files" FORCE ROW LEVEL SECURITY;`)
+	assert.Contains(t, text, `CREATE POLICY "notes_tenant_isolation" ON "notes" USING ("tenant_id" = NULLIF(current_setting('app.tenant_id', true), '')::uuid)`)
+	assert.Contains(t, text, `DROP POLICY IF EXISTS "app_files_tenant_isolation" ON "app"."files";`)
+
+	_, err := PGTenantPolicy{}.SQL()
+	assert.Error(t, err)
+}
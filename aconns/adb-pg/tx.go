@@ -0,0 +1,54 @@
+package adb_pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/uptrace/bun"
+)
+
+// RunImportTx satisfies aconns.FNRecordImportTx.
+func (cn *ADBPG) RunImportTx(ri *aconns.RI, fn func(ri *aconns.RI) error) error {
+	return cn.RunTx(ri, fn)
+}
+
+// RunTx begins a transaction, attaches it to a copy of the RI and commits if
+// fn succeeds. Models implementing IRecordModelTx can retrieve it with
+// GetTxByRI. Record change events staged on the RI are published only after
+// the commit and dropped on rollback, including when fn panics.
+func (cn *ADBPG) RunTx(ri *aconns.RI, fn func(ri *aconns.RI) error) error {
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no pg db has been created")
+	}
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %v", err)
+	}
+
+	txri := ri.WithTx(&tx)
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			txri.DiscardRecordChanges()
+			panic(p)
+		}
+	}()
+	if err = fn(txri); err != nil {
+		_ = tx.Rollback()
+		txri.DiscardRecordChanges()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		txri.DiscardRecordChanges()
+		return fmt.Errorf("failed to commit tx: %v", err)
+	}
+	return txri.CommitRecordChanges()
+}
+
+// GetTxByRI returns the *bun.Tx attached by RunImportTx or nil.
+func GetTxByRI(ri *aconns.RI) *bun.Tx {
+	tx, _ := ri.GetTx().(*bun.Tx)
+	return tx
+}
@@ -0,0 +1,458 @@
+package adb_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/autils"
+	"github.com/uptrace/bun"                       // Bun is a SQL-first Golang ORM for PostgreSQL, MySQL, MSSQL, and SQLite.
+	"github.com/uptrace/bun/dialect/sqlitedialect" // SQLite dialect for Bun ORM.
+	_ "modernc.org/sqlite"                         // Pure-Go (CGO-free) SQLite driver registered as "sqlite".
+)
+
+const (
+	ADAPTERTYPE_SQLITE aconns.AdapterType = "sqlite"
+
+	// SQLITE_DRIVER_NAME is the database/sql driver name registered by modernc.org/sqlite.
+	SQLITE_DRIVER_NAME = "sqlite"
+
+	// SQLITE_MEMORY is the special database name for an in-memory database.
+	SQLITE_MEMORY = ":memory:"
+
+	// SQLITE_DEFAULT_HOST is used when Host is empty since SQLite is always local.
+	SQLITE_DEFAULT_HOST = "localhost"
+
+	SQLITE_DEFAULT_BUSY_TIMEOUT = 5000 // milliseconds
+	SQLITE_CONNECTION_TIMEOUT   = 5    // seconds
+)
+
+// ADBSqlite represents a SQLite adapter. The database is either a file path
+// or ":memory:". Username and password are not used by SQLite and are always empty.
+type ADBSqlite struct {
+	aconns.Adapter
+
+	// Database is the file path to the SQLite database or ":memory:".
+	Database string `json:"database,omitempty"`
+
+	// JournalMode sets the journal_mode pragma (eg "WAL", "DELETE"). Ignored for in-memory databases.
+	JournalMode string `json:"journalMode,omitempty"`
+
+	// BusyTimeout in milliseconds that a connection waits on a locked database.
+	BusyTimeout int `json:"busyTimeout,omitempty"`
+
+	// ConnectionTimeout in seconds used when pinging the database.
+	ConnectionTimeout int `json:"connectionTimeout,omitempty"`
+
+	// DisableForeignKeys turns off foreign key enforcement, which is on by default.
+	DisableForeignKeys bool `json:"disableForeignKeys,omitempty"`
+
+	// IsReadOnly opens the database file in read-only mode.
+	IsReadOnly bool `json:"isReadOnly,omitempty"`
+
+	sqldb *sql.DB
+	db    *bun.DB
+
+	mu sync.RWMutex
+}
+
+// validate checks if the ADBSqlite object is valid.
+func (cn *ADBSqlite) validate() error {
+	if strings.TrimSpace(cn.GetHost()) == "" {
+		cn.Host = SQLITE_DEFAULT_HOST
+	}
+	if err := cn.Adapter.Validate(); err != nil {
+		return err
+	}
+
+	cn.Database = strings.TrimSpace(cn.Database)
+	if cn.Database == "" {
+		cn.UpdateHealth(aconns.HEALTHSTATUS_VALIDATE_FAILED)
+		return aconns.ErrDatabaseIsEmpty
+	}
+
+	if cn.BusyTimeout <= 0 {
+		cn.BusyTimeout = SQLITE_DEFAULT_BUSY_TIMEOUT
+	}
+	if cn.ConnectionTimeout <= 0 {
+		cn.ConnectionTimeout = SQLITE_CONNECTION_TIMEOUT
+	}
+
+	cn.JournalMode = strings.ToUpper(strings.TrimSpace(cn.JournalMode))
+	switch cn.JournalMode {
+	case "", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		cn.UpdateHealth(aconns.HEALTHSTATUS_VALIDATE_FAILED)
+		return fmt.Errorf("invalid journal mode '%s'", cn.JournalMode)
+	}
+
+	return nil
+}
+
+// Validate checks if the ADBSqlite object is valid.
+func (cn *ADBSqlite) Validate() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.validate()
+}
+
+// IsMemory returns true if the database is in-memory.
+func (cn *ADBSqlite) IsMemory() bool {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	return cn.isMemory()
+}
+
+// isMemory returns true if the database is in-memory.
+func (cn *ADBSqlite) isMemory() bool {
+	return cn.Database == SQLITE_MEMORY || strings.HasPrefix(cn.Database, "file::memory:")
+}
+
+// GetDatabase returns the database file path.
+func (cn *ADBSqlite) GetDatabase() string {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	return cn.Database
+}
+
+// GetUsername returns an empty string because SQLite does not use credentials.
+func (cn *ADBSqlite) GetUsername() string {
+	return ""
+}
+
+// GetPassword returns an empty string because SQLite does not use credentials.
+func (cn *ADBSqlite) GetPassword() string {
+	return ""
+}
+
+// Test attempts to validate the ADBSqlite, open a connection if necessary, and test the connection.
+func (cn *ADBSqlite) Test() (bool, aconns.TestStatus, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.test()
+}
+
+func (cn *ADBSqlite) test() (bool, aconns.TestStatus, error) {
+	if err := cn.validate(); err != nil {
+		cn.UpdateHealth(aconns.HEALTHSTATUS_VALIDATE_FAILED)
+		return false, aconns.TESTSTATUS_FAILED, err
+	}
+
+	if cn.sqldb == nil {
+		if err := cn.openConnection(); err != nil {
+			cn.UpdateHealth(aconns.HEALTHSTATUS_OPEN_FAILED)
+			return false, aconns.TESTSTATUS_FAILED, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cn.ConnectionTimeout)*time.Second)
+	defer cancel()
+	if err := cn.testConnectionWithCtx(ctx, cn.sqldb); err != nil {
+		status := aconns.HEALTHSTATUS_PING_FAILED
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = aconns.HEALTHSTATUS_TIMEOUT
+		}
+		cn.UpdateHealth(status)
+		return false, aconns.TESTSTATUS_FAILED, err
+	}
+
+	cn.UpdateHealth(aconns.HEALTHSTATUS_HEALTHY)
+	return true, aconns.TESTSTATUS_INITIALIZED_SUCCESSFUL, nil
+}
+
+// OpenConnection opens a connection to the SQLite database.
+func (cn *ADBSqlite) OpenConnection() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if err := cn.validate(); err != nil {
+		return err
+	}
+	return cn.openConnection()
+}
+
+// openConnection opens a connection to the SQLite database.
+func (cn *ADBSqlite) openConnection() error {
+	if !cn.isMemory() && !cn.IsReadOnly {
+		if dir := filepath.Dir(cn.Database); dir != "" && dir != "." {
+			if _, err := autils.CleanDirWithMkdirOption(dir, "", true); err != nil {
+				return fmt.Errorf("could not create directory for sqlite where database=%s; %v", cn.Database, err)
+			}
+		}
+	}
+
+	sqldb, err := sql.Open(SQLITE_DRIVER_NAME, cn.getConnString())
+	if err != nil {
+		return fmt.Errorf("could not open conn for sqlite where database=%s; %v", cn.Database, err)
+	}
+
+	// Each connection to ":memory:" is its own database, so pin the pool to a
+	// single connection to keep the data visible across queries.
+	if cn.isMemory() {
+		sqldb.SetMaxOpenConns(1)
+		sqldb.SetConnMaxLifetime(0)
+		sqldb.SetConnMaxIdleTime(0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cn.ConnectionTimeout)*time.Second)
+	defer cancel()
+	if err = cn.testConnectionWithCtx(ctx, sqldb); err != nil {
+		sqldb.Close()
+		return err
+	}
+
+	cn.sqldb = sqldb
+	cn.db = bun.NewDB(sqldb, sqlitedialect.New())
+	return nil
+}
+
+// GetConnString returns the DSN passed to the SQLite driver.
+func (cn *ADBSqlite) GetConnString() string {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	return cn.getConnString()
+}
+
+// getConnString returns the DSN passed to the SQLite driver.
+func (cn *ADBSqlite) getConnString() string {
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cn.BusyTimeout))
+	if cn.DisableForeignKeys {
+		params.Add("_pragma", "foreign_keys(0)")
+	} else {
+		params.Add("_pragma", "foreign_keys(1)")
+	}
+	if cn.JournalMode != "" && !cn.isMemory() {
+		params.Add("_pragma", fmt.Sprintf("journal_mode(%s)", cn.JournalMode))
+	}
+	if cn.IsReadOnly && !cn.isMemory() {
+		params.Add("mode", "ro")
+	}
+
+	dsn := cn.Database
+	if cn.Database == SQLITE_MEMORY {
+		dsn = "file::memory:"
+	} else if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + params.Encode()
+}
+
+// testConnectionWithCtx tests the connection to the SQLite database using a provided context.
+func (cn *ADBSqlite) testConnectionWithCtx(ctx context.Context, db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("no sqlite db has been created where database=%s", cn.Database)
+	}
+	var isValid int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&isValid); err != nil {
+		return fmt.Errorf("test connection failed for sqlite where database=%s; %v", cn.Database, err)
+	}
+	return nil
+}
+
+// CloseConnection closes the connection to the SQLite database.
+func (cn *ADBSqlite) CloseConnection() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	// bun.DB wraps sqldb, so closing it closes the underlying sql.DB as well.
+	if cn.db != nil {
+		if err := cn.db.Close(); err != nil {
+			return fmt.Errorf("error when closing sqlite db where database=%s; %v", cn.Database, err)
+		}
+		cn.db = nil
+		cn.sqldb = nil
+	}
+	cn.UpdateHealth(aconns.HEALTHSTATUS_CLOSED)
+
+	return nil
+}
+
+// Refresh refreshes the SQLite connection by closing the existing one (if any) and opening a new one.
+// An in-memory database loses its contents on refresh.
+func (cn *ADBSqlite) Refresh() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.db != nil {
+		cn.db.Close()
+		cn.db = nil
+		cn.sqldb = nil
+	}
+	if err := cn.validate(); err != nil {
+		return err
+	}
+	return cn.openConnection()
+}
+
+// DB returns the bun.DB instance.
+func (cn *ADBSqlite) DB() *bun.DB {
+	cn.mu.RLock()
+	if cn.db != nil && cn.IsHealthy() && !cn.GetHealth().IsStale(5*time.Minute) {
+		defer cn.mu.RUnlock()
+		return cn.db
+	}
+	cn.mu.RUnlock()
+
+	// Upgrade to write lock for refresh
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.test() // Refresh and test
+	return cn.db
+}
+
+// SQLDB returns the sql.DB instance.
+func (cn *ADBSqlite) SQLDB() *sql.DB {
+	cn.mu.RLock()
+	if cn.sqldb != nil && cn.IsHealthy() && !cn.GetHealth().IsStale(5*time.Minute) {
+		defer cn.mu.RUnlock()
+		return cn.sqldb
+	}
+	cn.mu.RUnlock()
+
+	// Upgrade to write lock for refresh
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.test() // Refresh and test
+	return cn.sqldb
+}
+
+// Count returns the count of records in the model.
+func (cn *ADBSqlite) Count(model interface{}) (int, error) {
+	db := cn.DB()
+	if db == nil {
+		return 0, fmt.Errorf("no sqlite db has been created")
+	}
+	return db.NewSelect().Model(model).Count(context.Background())
+}
+
+// SelectAll selects all records from the model.
+func (cn *ADBSqlite) SelectAll(model interface{}) error {
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no sqlite db has been created")
+	}
+	return db.NewSelect().
+		Model(model).
+		Scan(context.Background())
+}
+
+// Truncate deletes all records from the table based on the model.
+// SQLite has no TRUNCATE statement; bun issues a DELETE instead.
+func (cn *ADBSqlite) Truncate(model interface{}) (sql.Result, error) {
+	db := cn.DB()
+	if db == nil {
+		return nil, fmt.Errorf("no sqlite db has been created")
+	}
+	return db.NewTruncateTable().Model(model).Exec(context.Background())
+}
+
+// CreateTable creates the table for the model if it does not exist.
+func (cn *ADBSqlite) CreateTable(model interface{}) error {
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no sqlite db has been created")
+	}
+	_, err := db.NewCreateTable().Model(model).IfNotExists().Exec(context.Background())
+	return err
+}
+
+// GetSandboxAdapter returns a sandbox adapter for the SQLite database.
+func (cn *ADBSqlite) GetSandboxAdapter() (aconns.ISBAdapter, error) {
+	return cn.GetSandboxAdapterWithHelper(nil)
+}
+
+// GetSandboxAdapterWithHelper returns a sandbox adapter that can run the
+// ConnActionMap sql supplied by the helper.
+func (cn *ADBSqlite) GetSandboxAdapterWithHelper(helper aconns.ISBAdapterHelper) (aconns.ISBAdapter, error) {
+	if cn == nil {
+		return nil, fmt.Errorf("no sqlite db has been created")
+	}
+	if cn.DB() == nil {
+		return nil, fmt.Errorf("no sqlite db has been created where adapter=%s", cn.GetName().String())
+	}
+	return &SandboxSqlite{
+		db:      cn.DB(),
+		adapter: cn,
+		helper:  helper,
+	}, nil
+}
+
+// ExecuteSQLFile reads and executes an SQL file as a single command.
+func (cn *ADBSqlite) ExecuteSQLFile(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read SQL file: %w", err)
+	}
+	if err = cn.ExecuteSQL(string(data)); err != nil {
+		return fmt.Errorf("failed to execute SQL file: %w", err)
+	}
+	return nil
+}
+
+// ExecuteSQL executes the given string in a single command.
+func (cn *ADBSqlite) ExecuteSQL(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return fmt.Errorf("SQL command is empty")
+	}
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no sqlite db has been created")
+	}
+	if _, err := db.ExecContext(context.Background(), command); err != nil {
+		return fmt.Errorf("failed to execute SQL command: %w", err)
+	}
+	return nil
+}
+
+// ExecuteSQLInTx executes the given SQL string in a transaction.
+func (cn *ADBSqlite) ExecuteSQLInTx(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return fmt.Errorf("SQL command is empty")
+	}
+	db := cn.DB()
+	if db == nil {
+		return fmt.Errorf("no sqlite db has been created")
+	}
+
+	tx, txErr := db.BeginTx(context.Background(), nil)
+	if txErr != nil {
+		return fmt.Errorf("failed to begin tx: %v", txErr)
+	}
+
+	if _, execErr := tx.ExecContext(context.Background(), command); execErr != nil {
+		tx.Rollback() // Ignore rollback err; focus on exec err
+		return fmt.Errorf("failed to execute SQL in tx: %v", execErr)
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return fmt.Errorf("failed to commit tx: %v", commitErr)
+	}
+
+	return nil
+}
+
+// RunConnAction validates the ConnActionMap and executes the sql for the given action in a transaction.
+func (cn *ADBSqlite) RunConnAction(actionMap aconns.ConnActionMap, action aconns.ConnActionType) error {
+	if err := actionMap.Validate(); err != nil {
+		return fmt.Errorf("invalid conn action map; %v", err)
+	}
+	item := actionMap.MustGetItem(action)
+	if item.DoSkip {
+		return nil
+	}
+	return cn.ExecuteSQLInTx(item.Text)
+}
+
+// ADBSqlites represents a slice of ADBSqlite pointers.
+type ADBSqlites []*ADBSqlite
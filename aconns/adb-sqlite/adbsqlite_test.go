@@ -0,0 +1,211 @@
+package adb_sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSqliteModel struct {
+	ID   int64  `bun:"id,pk,autoincrement"`
+	Name string `bun:"name"`
+}
+
+func newTestSqlite(database string) *ADBSqlite {
+	return &ADBSqlite{
+		Adapter: aconns.Adapter{
+			Type: ADAPTERTYPE_SQLITE,
+			Name: aconns.AdapterName("test_sqlite"),
+		},
+		Database: database,
+	}
+}
+
+func TestADBSqlite_Validate(t *testing.T) {
+	cn := newTestSqlite(SQLITE_MEMORY)
+	assert.NoError(t, cn.Validate())
+	assert.Equal(t, SQLITE_DEFAULT_HOST, cn.GetHost())
+	assert.Equal(t, SQLITE_DEFAULT_BUSY_TIMEOUT, cn.BusyTimeout)
+	assert.Equal(t, SQLITE_CONNECTION_TIMEOUT, cn.ConnectionTimeout)
+	assert.True(t, cn.IsMemory())
+	assert.Empty(t, cn.GetUsername())
+	assert.Empty(t, cn.GetPassword())
+
+	cn = newTestSqlite("")
+	assert.ErrorIs(t, cn.Validate(), aconns.ErrDatabaseIsEmpty)
+
+	cn = newTestSqlite(SQLITE_MEMORY)
+	cn.JournalMode = "bogus"
+	assert.Error(t, cn.Validate())
+
+	var _ aconns.IAdapterDB = cn
+}
+
+func TestADBSqlite_GetConnString(t *testing.T) {
+	cn := newTestSqlite("/tmp/test.db")
+	cn.JournalMode = "wal"
+	cn.IsReadOnly = true
+	assert.NoError(t, cn.Validate())
+	dsn := cn.GetConnString()
+	assert.Contains(t, dsn, "file:/tmp/test.db?")
+	assert.Contains(t, dsn, "journal_mode%28WAL%29")
+	assert.Contains(t, dsn, "foreign_keys%281%29")
+	assert.Contains(t, dsn, "mode=ro")
+
+	cn = newTestSqlite(SQLITE_MEMORY)
+	cn.JournalMode = "wal"
+	assert.NoError(t, cn.Validate())
+	dsn = cn.GetConnString()
+	assert.Contains(t, dsn, "file::memory:?")
+	assert.NotContains(t, dsn, "journal_mode")
+}
+
+func TestADBSqlite_TestAndHealth(t *testing.T) {
+	cn := newTestSqlite(SQLITE_MEMORY)
+	ok, status, err := cn.Test()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, aconns.TESTSTATUS_INITIALIZED_SUCCESSFUL, status)
+	assert.True(t, cn.IsHealthy())
+	assert.Equal(t, aconns.HEALTHSTATUS_HEALTHY, cn.GetHealth().LastStatus)
+
+	assert.NoError(t, cn.CloseConnection())
+	assert.False(t, cn.IsHealthy())
+	assert.Equal(t, aconns.HEALTHSTATUS_CLOSED, cn.GetHealth().LastStatus)
+
+	// DB reopens a closed connection.
+	assert.NotNil(t, cn.DB())
+	assert.True(t, cn.IsHealthy())
+	assert.NoError(t, cn.CloseConnection())
+}
+
+func TestADBSqlite_Bun(t *testing.T) {
+	cn := newTestSqlite(SQLITE_MEMORY)
+	require.NoError(t, cn.OpenConnection())
+	defer cn.CloseConnection()
+
+	require.NoError(t, cn.CreateTable((*testSqliteModel)(nil)))
+
+	models := []*testSqliteModel{{Name: "one"}, {Name: "two"}}
+	_, err := cn.DB().NewInsert().Model(&models).Exec(t.Context())
+	require.NoError(t, err)
+
+	count, err := cn.Count((*testSqliteModel)(nil))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var found []*testSqliteModel
+	require.NoError(t, cn.SelectAll(&found))
+	assert.Len(t, found, 2)
+
+	_, err = cn.Truncate((*testSqliteModel)(nil))
+	require.NoError(t, err)
+	count, err = cn.Count((*testSqliteModel)(nil))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestADBSqlite_FileDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "nested", "test.db")
+	cn := newTestSqlite(dbPath)
+	cn.JournalMode = "WAL"
+	require.NoError(t, cn.OpenConnection())
+	require.NoError(t, cn.ExecuteSQLInTx(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`))
+	require.NoError(t, cn.ExecuteSQL(`INSERT INTO items (name) VALUES ('a')`))
+	require.NoError(t, cn.CloseConnection())
+
+	// Data survives a reopen.
+	require.NoError(t, cn.Refresh())
+	defer cn.CloseConnection()
+	var name string
+	require.NoError(t, cn.SQLDB().QueryRow(`SELECT name FROM items`).Scan(&name))
+	assert.Equal(t, "a", name)
+	assert.Error(t, cn.ExecuteSQLInTx(`INSERT INTO missing VALUES (1)`))
+}
+
+func TestADBSqlite_RunConnAction(t *testing.T) {
+	cn := newTestSqlite(SQLITE_MEMORY)
+	require.NoError(t, cn.OpenConnection())
+	defer cn.CloseConnection()
+
+	actionMap := aconns.ConnActionMap{
+		aconns.CONNACTIONTYPE_CREATE: &aconns.ConnSystemItem{Text: `CREATE TABLE things (id INTEGER PRIMARY KEY)`},
+		aconns.CONNACTIONTYPE_DELETE: &aconns.ConnSystemItem{Text: `DROP TABLE things`},
+	}
+	require.NoError(t, cn.RunConnAction(actionMap, aconns.CONNACTIONTYPE_CREATE))
+	var count int
+	require.NoError(t, cn.SQLDB().QueryRow(`SELECT count(*) FROM things`).Scan(&count))
+	assert.Equal(t, 0, count)
+	require.NoError(t, cn.RunConnAction(actionMap, aconns.CONNACTIONTYPE_DELETE))
+	assert.Error(t, cn.RunConnAction(aconns.ConnActionMap{}, aconns.CONNACTIONTYPE_CREATE))
+}
+
+// testHelper is a minimal ISBAdapterHelper for sandbox tests.
+type testHelper struct {
+	actionMap aconns.ConnActionMap
+	action    aconns.ConnActionType
+}
+
+func (h *testHelper) GetMap() aconns.ConnSystemHelper {
+	return aconns.ConnSystemHelper{ActionMap: h.actionMap, Action: h.action}
+}
+func (h *testHelper) CheckVersionState() aconns.ConnActionType { return h.action }
+func (h *testHelper) IsCreate() bool                           { return h.action == aconns.CONNACTIONTYPE_CREATE }
+func (h *testHelper) IsUpgrade() bool                          { return h.action == aconns.CONNACTIONTYPE_UPGRADE }
+func (h *testHelper) IsDowngrade() bool                        { return h.action == aconns.CONNACTIONTYPE_DOWNGRADE }
+func (h *testHelper) IsDelete() bool                           { return h.action == aconns.CONNACTIONTYPE_DELETE }
+func (h *testHelper) HasFromVersion() bool                     { return false }
+func (h *testHelper) HasToVersion() bool                       { return false }
+func (h *testHelper) GetFromVersion() semver.Version           { return semver.Version{} }
+func (h *testHelper) GetToVersion() semver.Version             { return semver.Version{} }
+func (h *testHelper) MustGetByAction() string                  { return h.MustGet(h.action) }
+func (h *testHelper) MustGetCreate() string                    { return h.MustGet(aconns.CONNACTIONTYPE_CREATE) }
+func (h *testHelper) MustGetUpgrade() string                   { return h.MustGet(aconns.CONNACTIONTYPE_UPGRADE) }
+func (h *testHelper) MustGetDowngrade() string                 { return h.MustGet(aconns.CONNACTIONTYPE_DOWNGRADE) }
+func (h *testHelper) MustGetDelete() string                    { return h.MustGet(aconns.CONNACTIONTYPE_DELETE) }
+func (h *testHelper) MustGet(action aconns.ConnActionType) string {
+	item := h.actionMap.GetItem(action)
+	if item == nil {
+		return ""
+	}
+	return item.Text
+}
+
+func TestSandboxSqlite(t *testing.T) {
+	cn := newTestSqlite(SQLITE_MEMORY)
+	require.NoError(t, cn.OpenConnection())
+	defer cn.CloseConnection()
+
+	helper := &testHelper{
+		actionMap: aconns.ConnActionMap{
+			aconns.CONNACTIONTYPE_CREATE: &aconns.ConnSystemItem{Text: `CREATE TABLE test_sqlite_models (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO test_sqlite_models (name) VALUES ('x')`},
+		},
+		action: aconns.CONNACTIONTYPE_CREATE,
+	}
+	sba, err := cn.GetSandboxAdapterWithHelper(helper)
+	require.NoError(t, err)
+	sb := sba.(*SandboxSqlite)
+	assert.Equal(t, ADAPTERTYPE_SQLITE, sb.GetType())
+	assert.True(t, sb.SupportsModels())
+	require.NoError(t, sb.RunMapByAction())
+
+	var models []*testSqliteModel
+	require.NoError(t, sb.QueryModel(`SELECT id, name FROM test_sqlite_models`, &models))
+	require.Len(t, models, 1)
+	assert.Equal(t, "x", models[0].Name)
+
+	rows, err := sb.QueryArgs(`SELECT name FROM test_sqlite_models WHERE id = ?`, models[0].ID)
+	require.NoError(t, err)
+	defer rows.Close()
+	assert.True(t, rows.Next())
+	var name string
+	require.NoError(t, rows.Scan(&name))
+	assert.Equal(t, "x", name)
+
+	assert.Error(t, sb.RunMapAction(""))
+	assert.Error(t, sb.RunCommand(" "))
+}
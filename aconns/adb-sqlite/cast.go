@@ -0,0 +1,18 @@
+package adb_sqlite
+
+import "fmt"
+
+// ToADBSqlite attempts to cast any interface{} to *ADBSqlite.
+// It handles both *ADBSqlite and ADBSqlite input types.
+func ToADBSqlite(v interface{}) (*ADBSqlite, error) {
+	switch t := v.(type) {
+	case *ADBSqlite:
+		// Already a pointer, just return it
+		return t, nil
+	case ADBSqlite:
+		// Value, take its address
+		return &t, nil
+	default:
+		return nil, fmt.Errorf("cannot cast %T to *ADBSqlite", v)
+	}
+}
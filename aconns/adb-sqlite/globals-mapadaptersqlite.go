@@ -0,0 +1,76 @@
+package adb_sqlite
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// SQLITE_MASTER is the AdapterName for the master SQLite connection.
+const SQLITE_MASTER aconns.AdapterName = "sqlite:master"
+
+// gAdapterMap is the global connection map.
+var gAdapterMap *adapterMapGlobal
+var gMUAdapterMap sync.RWMutex
+
+// adapterMapGlobal holds the global map of SQLite connections.
+type adapterMapGlobal struct {
+	Map *MapAdapterSqlite
+	mu  sync.RWMutex
+}
+
+func init() {
+	gAdapterMap = &adapterMapGlobal{Map: NewMapAdapterSqlite()}
+}
+
+// SQLITEADAPTERS returns the global adapters map.
+func SQLITEADAPTERS() *adapterMapGlobal {
+	gMUAdapterMap.RLock()
+	defer gMUAdapterMap.RUnlock()
+	return gAdapterMap
+}
+
+// Get retrieves an ADBSqlite by its AdapterName.
+func (cg *adapterMapGlobal) Get(name aconns.AdapterName) *ADBSqlite {
+	if name.IsEmpty() {
+		return nil
+	}
+
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+
+	client, exists := cg.Map.Get(name)
+	if !exists {
+		return nil
+	}
+	return client
+}
+
+// Set adds or updates an ADBSqlite in the connection map.
+func (cg *adapterMapGlobal) Set(cn *ADBSqlite) error {
+	if cn == nil {
+		return fmt.Errorf("adapterMapGlobal is nil")
+	}
+	if cn.GetName().IsEmpty() {
+		return fmt.Errorf("name is empty")
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.Map.Set(cn.GetName(), cn)
+	return nil
+}
+
+// Remove deletes an ADBSqlite from the connection map by its AdapterName.
+func (cg *adapterMapGlobal) Remove(name aconns.AdapterName) {
+	if name.IsEmpty() {
+		return
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.Map.Delete(name)
+}
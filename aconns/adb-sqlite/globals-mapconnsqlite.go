@@ -0,0 +1,81 @@
+package adb_sqlite
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+)
+
+// gConnMap is the global instance of gConnMapGlobal, which manages SQLite connections.
+var gConnMap *gConnMapGlobal
+
+// muCM is a mutex to ensure safe initialization and access to the global gConnMap.
+var muCM sync.RWMutex
+
+// gConnMapGlobal encapsulates the global map of SQLite connections and provides thread-safe access.
+type gConnMapGlobal struct {
+	// Map stores the actual thread-safe mapping of connection IDs to ADBSqlite instances.
+	Map *MapConnSqlite
+	// mu ensures thread-safe operations on the gConnMapGlobal structure itself.
+	mu sync.RWMutex
+}
+
+// init initializes the global gConnMap instance with a new MapConnSqlite.
+func init() {
+	gConnMap = &gConnMapGlobal{Map: NewMapConnSqlite()}
+}
+
+// SQLITECONNS provides thread-safe access to the global gConnMap instance.
+func SQLITECONNS() *gConnMapGlobal {
+	muCM.RLock()
+	defer muCM.RUnlock()
+	return gConnMap
+}
+
+// Get retrieves an ADBSqlite instance from the connection map using the provided connection ID.
+// If the ID is nil or no instance exists, it returns nil.
+func (cg *gConnMapGlobal) Get(id aconns.ConnId) *ADBSqlite {
+	if id.IsNil() {
+		return nil
+	}
+
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+
+	client, exists := cg.Map.Get(id)
+	if !exists {
+		return nil
+	}
+	return client
+}
+
+// Set adds or updates an ADBSqlite instance in the connection map with the given connection ID.
+// It returns an error if the instance is nil or has an empty name.
+func (cg *gConnMapGlobal) Set(id aconns.ConnId, cn *ADBSqlite) error {
+	if cn == nil {
+		return fmt.Errorf("ADBSqlite instance is nil")
+	}
+	if cn.GetName().IsEmpty() {
+		return fmt.Errorf("connection name is empty")
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.Map.Set(id, cn)
+	return nil
+}
+
+// Remove deletes an ADBSqlite instance from the connection map by its connection ID.
+// If the provided ID is nil, the method does nothing.
+func (cg *gConnMapGlobal) Remove(id aconns.ConnId) {
+	if id.IsNil() {
+		return
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.Map.Delete(id)
+}
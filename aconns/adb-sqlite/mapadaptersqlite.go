@@ -0,0 +1,41 @@
+package adb_sqlite
+
+import (
+	"github.com/jpfluger/alibs-slim/aconns"
+	"sync"
+)
+
+// MapAdapterSqlite is a thread-safe map for storing ADBSqlite instances by AdapterName.
+type MapAdapterSqlite struct {
+	mu    sync.RWMutex
+	store map[aconns.AdapterName]*ADBSqlite
+}
+
+// NewMapAdapterSqlite creates a new MapAdapterSqlite.
+func NewMapAdapterSqlite() *MapAdapterSqlite {
+	return &MapAdapterSqlite{
+		store: make(map[aconns.AdapterName]*ADBSqlite),
+	}
+}
+
+// Get safely retrieves an ADBSqlite instance by its AdapterName.
+func (msl *MapAdapterSqlite) Get(name aconns.AdapterName) (*ADBSqlite, bool) {
+	msl.mu.RLock()
+	defer msl.mu.RUnlock()
+	client, exists := msl.store[name]
+	return client, exists
+}
+
+// Set safely sets an ADBSqlite instance with the given AdapterName.
+func (msl *MapAdapterSqlite) Set(name aconns.AdapterName, client *ADBSqlite) {
+	msl.mu.Lock()
+	defer msl.mu.Unlock()
+	msl.store[name] = client
+}
+
+// Delete safely removes an ADBSqlite instance by its AdapterName.
+func (msl *MapAdapterSqlite) Delete(name aconns.AdapterName) {
+	msl.mu.Lock()
+	defer msl.mu.Unlock()
+	delete(msl.store, name)
+}
@@ -0,0 +1,33 @@
+package adb_sqlite
+
+import (
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapSqlite_GetSetDelete(t *testing.T) {
+	msl := NewMapAdapterSqlite()
+
+	// Define an AdapterName for testing
+	name := aconns.AdapterName("test_adapter")
+
+	// Test setting an ADBSqlite
+	client := &ADBSqlite{}
+	msl.Set(name, client)
+
+	// Test getting the ADBSqlite
+	retrievedClient, exists := msl.Get(name)
+	assert.True(t, exists)
+	assert.Equal(t, client, retrievedClient)
+
+	// Test getting a non-existent ADBSqlite
+	_, exists = msl.Get(aconns.AdapterName("non_existent_adapter"))
+	assert.False(t, exists)
+
+	// Test deleting the ADBSqlite
+	msl.Delete(name)
+	_, exists = msl.Get(name)
+	assert.False(t, exists)
+}
@@ -0,0 +1,50 @@
+package adb_sqlite
+
+import (
+	"github.com/jpfluger/alibs-slim/aconns"
+	"sync"
+)
+
+// MapConnSqlite is a thread-safe map for storing ADBSqlite instances by ConnId.
+type MapConnSqlite struct {
+	mu    sync.RWMutex
+	store map[aconns.ConnId]*ADBSqlite
+}
+
+// NewMapConnSqlite creates a new MapConnSqlite.
+func NewMapConnSqlite() *MapConnSqlite {
+	return &MapConnSqlite{
+		store: make(map[aconns.ConnId]*ADBSqlite),
+	}
+}
+
+// Get safely retrieves an ADBSqlite instance by its AdapterName.
+func (msl *MapConnSqlite) Get(id aconns.ConnId) (*ADBSqlite, bool) {
+	msl.mu.RLock()
+	defer msl.mu.RUnlock()
+	if id.IsNil() {
+		return nil, false
+	}
+	client, exists := msl.store[id]
+	return client, exists
+}
+
+// Set safely sets an ADBSqlite instance with the given AdapterName.
+func (msl *MapConnSqlite) Set(id aconns.ConnId, client *ADBSqlite) {
+	msl.mu.Lock()
+	defer msl.mu.Unlock()
+	if id.IsNil() {
+		return
+	}
+	msl.store[id] = client
+}
+
+// Delete safely removes an ADBSqlite instance by its AdapterName.
+func (msl *MapConnSqlite) Delete(id aconns.ConnId) {
+	msl.mu.Lock()
+	defer msl.mu.Unlock()
+	if id.IsNil() {
+		return
+	}
+	delete(msl.store, id)
+}
@@ -0,0 +1,128 @@
+package adb_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/uptrace/bun"
+)
+
+// SandboxSqlite is the sandboxed adapter for SQLite. It supports both
+// raw row scanning and bun models plus running ConnActionMap sql.
+type SandboxSqlite struct {
+	db      *bun.DB
+	helper  aconns.ISBAdapterHelper
+	adapter *ADBSqlite
+}
+
+var _ aconns.ISBAdapterSqlMap = (*SandboxSqlite)(nil)
+
+// GetType returns the adapter type.
+func (sba *SandboxSqlite) GetType() aconns.AdapterType {
+	return sba.adapter.GetType()
+}
+
+// GetName returns the adapter name.
+func (sba *SandboxSqlite) GetName() aconns.AdapterName {
+	return sba.adapter.GetName()
+}
+
+// GetHost returns the adapter host.
+func (sba *SandboxSqlite) GetHost() string {
+	return sba.adapter.GetHost()
+}
+
+// SupportsModels returns true as bun models are supported.
+func (sba *SandboxSqlite) SupportsModels() bool {
+	return true
+}
+
+// Query executes a query and returns the result rows.
+func (sba *SandboxSqlite) Query(query string) (aconns.ISBAdapterSqlRows, error) {
+	return sba.QueryArgs(query)
+}
+
+// QueryArgs executes a query with arguments and returns the result rows.
+func (sba *SandboxSqlite) QueryArgs(query string, args ...interface{}) (result aconns.ISBAdapterSqlRows, err error) {
+	if sba == nil || sba.db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+	return aconns.NewSBAdapterSql(sba.adapter, sba.db.DB).QueryArgs(query, args...)
+}
+
+// QueryModel scans the query results into the model.
+func (sba *SandboxSqlite) QueryModel(query string, model interface{}) error {
+	return sba.QueryModelArgs(query, model)
+}
+
+// QueryModelArgs scans the query results with arguments into the model.
+func (sba *SandboxSqlite) QueryModelArgs(query string, model interface{}, args ...interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occurred: %v", r)
+		}
+	}()
+	if sba == nil || sba.db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return fmt.Errorf("empty query")
+	}
+	var q *bun.RawQuery
+	// the final check ("args[0] == nil") is for users who accidentally add a nil for an arg
+	if len(args) == 0 || args[0] == nil {
+		q = sba.db.NewRaw(query)
+	} else {
+		q = sba.db.NewRaw(query, args...)
+	}
+	if err = q.Scan(context.Background(), model); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// RunCommand executes the text against the database.
+func (sba *SandboxSqlite) RunCommand(text string) error {
+	if sba == nil || sba.db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("empty text")
+	}
+	_, err := sba.db.Exec(text)
+	return err
+}
+
+// RunMapByAction runs the sql for the action set on the helper.
+func (sba *SandboxSqlite) RunMapByAction() error {
+	if sba.helper == nil {
+		return fmt.Errorf("helper is nil")
+	}
+	return sba.RunCommand(sba.helper.MustGetByAction())
+}
+
+// RunMapAction runs the sql mapped to the action.
+func (sba *SandboxSqlite) RunMapAction(action aconns.ConnActionType) error {
+	if sba.helper == nil {
+		return fmt.Errorf("helper is nil")
+	}
+	if action.IsEmpty() {
+		return fmt.Errorf("empty action")
+	}
+	return sba.RunCommand(sba.helper.MustGet(action))
+}
+
+// GetAdapterHelper returns the helper or nil.
+func (sba *SandboxSqlite) GetAdapterHelper() aconns.ISBAdapterHelper {
+	if sba.helper == nil {
+		return nil
+	}
+	return sba.helper
+}
@@ -0,0 +1,309 @@
+package aconns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/auuids"
+)
+
+var ErrNoTenantRoute = errors.New("no healthy connection for tenant")
+
+// RouteReason explains why a connection was chosen by ConnTenantRouter.
+type RouteReason string
+
+const (
+	// ROUTEREASON_PRIMARY indicates the best ranked tenant connection was healthy.
+	ROUTEREASON_PRIMARY RouteReason = "primary"
+
+	// ROUTEREASON_SECONDARY indicates one or more better ranked tenant
+	// connections were skipped and a lower ranked one was chosen.
+	ROUTEREASON_SECONDARY RouteReason = "secondary"
+
+	// ROUTEREASON_SHARED indicates no tenant connection was healthy and a
+	// shared connection (one without a TenantId) was chosen.
+	ROUTEREASON_SHARED RouteReason = "shared"
+)
+
+// IsEmpty returns true if the RouteReason is empty.
+func (rr RouteReason) IsEmpty() bool {
+	return strings.TrimSpace(string(rr)) == ""
+}
+
+// String returns the string representation of the RouteReason.
+func (rr RouteReason) String() string {
+	return string(rr)
+}
+
+// ConnRouteSkip records a candidate the router passed over.
+type ConnRouteSkip struct {
+	AdapterName AdapterName  `json:"adapterName,omitempty"`
+	Region      string       `json:"region,omitempty"`
+	Priority    int          `json:"priority,omitempty"`
+	Status      HealthStatus `json:"status,omitempty"`
+}
+
+// ConnRoute is the result of a routing decision.
+type ConnRoute struct {
+	Conn     IConn           `json:"-"`
+	TenantId auuids.UUID     `json:"tenantId,omitempty"`
+	Region   string          `json:"region,omitempty"`
+	Priority int             `json:"priority,omitempty"`
+	Reason   RouteReason     `json:"reason,omitempty"`
+	IsSticky bool            `json:"isSticky,omitempty"` // True when returned from the request context cache.
+	Skipped  []ConnRouteSkip `json:"skipped,omitempty"`
+}
+
+// GetAdapter returns the adapter of the chosen connection or nil.
+func (cr *ConnRoute) GetAdapter() IAdapter {
+	if cr == nil || cr.Conn == nil {
+		return nil
+	}
+	return cr.Conn.GetAdapter()
+}
+
+// Explain returns a human-readable description of the routing decision.
+func (cr *ConnRoute) Explain() string {
+	if cr == nil || cr.Conn == nil {
+		return "no route"
+	}
+	name := ""
+	if adapter := cr.GetAdapter(); adapter != nil {
+		name = adapter.GetName().String()
+	}
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s route to adapter '%s' (region=%s, priority=%d)", cr.Reason, name, cr.Region, cr.Priority))
+	if cr.IsSticky {
+		sb.WriteString(" from sticky cache")
+	}
+	for _, skip := range cr.Skipped {
+		sb.WriteString(fmt.Sprintf("; skipped '%s' (region=%s, priority=%d, status=%s)", skip.AdapterName, skip.Region, skip.Priority, skip.Status))
+	}
+	return sb.String()
+}
+
+// ConnRouteRequest describes what the caller needs.
+type ConnRouteRequest struct {
+	TenantId auuids.UUID
+
+	// Regions lists preferred regions in order. Candidates in earlier regions
+	// rank ahead of later ones; unlisted regions rank last. Matching is case-insensitive.
+	Regions []string
+
+	// AuthScope, when set, limits candidates to connections having the scope.
+	AuthScope AuthScope
+}
+
+// ConnTenantRouter picks the best healthy connection for a tenant.
+// Candidates are ranked by region preference and then by ConnTenantInfo.Priority
+// (lower is preferred). Unhealthy and ignored connections are skipped.
+type ConnTenantRouter struct {
+	// Conns is the candidate pool.
+	Conns IConns
+
+	// AllowShared permits falling back to connections without a TenantId
+	// when the tenant has no healthy connection.
+	AllowShared bool
+
+	// AllowUntested treats connections whose health has never been checked
+	// as healthy. Connections with a failed status are always skipped.
+	AllowUntested bool
+}
+
+// NewConnTenantRouter creates a ConnTenantRouter for the connections.
+func NewConnTenantRouter(conns IConns) *ConnTenantRouter {
+	return &ConnTenantRouter{Conns: conns}
+}
+
+// Resolve selects a connection for the request.
+func (r *ConnTenantRouter) Resolve(req ConnRouteRequest) (*ConnRoute, error) {
+	if r == nil {
+		return nil, fmt.Errorf("router is nil")
+	}
+	if req.TenantId.IsNil() {
+		return nil, fmt.Errorf("tenantId is required")
+	}
+
+	var tenantConns, sharedConns IConns
+	for _, conn := range r.Conns {
+		if conn == nil || conn.DoIgnore() || conn.GetAdapter() == nil {
+			continue
+		}
+		if !req.AuthScope.IsEmpty() && !conn.GetAuthScopes().Has(req.AuthScope) {
+			continue
+		}
+		ti := conn.GetTenantInfo()
+		if ti.TenantId == req.TenantId {
+			tenantConns = append(tenantConns, conn)
+		} else if ti.TenantId.IsNil() {
+			sharedConns = append(sharedConns, conn)
+		}
+	}
+
+	route := &ConnRoute{TenantId: req.TenantId}
+	for ii, conn := range r.rank(tenantConns, req.Regions) {
+		if r.isRoutable(conn) {
+			route.setConn(conn)
+			if ii == 0 {
+				route.Reason = ROUTEREASON_PRIMARY
+			} else {
+				route.Reason = ROUTEREASON_SECONDARY
+			}
+			return route, nil
+		}
+		route.skip(conn)
+	}
+
+	if r.AllowShared {
+		for _, conn := range r.rank(sharedConns, req.Regions) {
+			if r.isRoutable(conn) {
+				route.setConn(conn)
+				route.Reason = ROUTEREASON_SHARED
+				return route, nil
+			}
+			route.skip(conn)
+		}
+	}
+
+	return route, fmt.Errorf("%w; tenantId=%s", ErrNoTenantRoute, req.TenantId.String())
+}
+
+// ResolveCtx resolves like Resolve but reuses a prior choice stored in a
+// context created with WithConnRouteCache, as long as that connection is
+// still healthy. Without a cache it behaves like Resolve.
+func (r *ConnTenantRouter) ResolveCtx(ctx context.Context, req ConnRouteRequest) (*ConnRoute, error) {
+	cache := connRouteCacheFromCtx(ctx)
+	if cache == nil {
+		return r.Resolve(req)
+	}
+	key := req.cacheKey()
+	if prev, ok := cache.get(key); ok && r.isRoutable(prev.Conn) {
+		sticky := *prev
+		sticky.IsSticky = true
+		return &sticky, nil
+	}
+	route, err := r.Resolve(req)
+	if err != nil {
+		cache.remove(key)
+		return route, err
+	}
+	cache.set(key, route)
+	return route, nil
+}
+
+// isRoutable returns true if the connection may receive traffic.
+func (r *ConnTenantRouter) isRoutable(conn IConn) bool {
+	if conn == nil || conn.DoIgnore() {
+		return false
+	}
+	hc := conn.GetHealth()
+	if hc == nil {
+		return false
+	}
+	if hc.IsHealthy {
+		return true
+	}
+	return r.AllowUntested && hc.LastCheck.IsZero() && !hc.LastStatus.IsFailed()
+}
+
+// rank returns a copy of conns ordered by region preference then priority.
+func (r *ConnTenantRouter) rank(conns IConns, regions []string) IConns {
+	ranked := append(IConns{}, conns...)
+	regionRank := func(conn IConn) int {
+		region := strings.ToLower(strings.TrimSpace(conn.GetTenantInfo().Region))
+		for ii, pref := range regions {
+			if strings.ToLower(strings.TrimSpace(pref)) == region {
+				return ii
+			}
+		}
+		return len(regions)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ri, rj := regionRank(ranked[i]), regionRank(ranked[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return ranked[i].GetTenantInfo().Priority < ranked[j].GetTenantInfo().Priority
+	})
+	return ranked
+}
+
+// setConn records the chosen connection on the route.
+func (cr *ConnRoute) setConn(conn IConn) {
+	ti := conn.GetTenantInfo()
+	cr.Conn = conn
+	cr.Region = ti.Region
+	cr.Priority = ti.Priority
+}
+
+// skip records a passed-over connection on the route.
+func (cr *ConnRoute) skip(conn IConn) {
+	ti := conn.GetTenantInfo()
+	status := HEALTHSTATUS_UNKNOWN
+	if hc := conn.GetHealth(); hc != nil && !hc.LastStatus.IsEmpty() {
+		status = hc.LastStatus
+	}
+	cr.Skipped = append(cr.Skipped, ConnRouteSkip{
+		AdapterName: conn.GetAdapter().GetName(),
+		Region:      ti.Region,
+		Priority:    ti.Priority,
+		Status:      status,
+	})
+}
+
+// cacheKey identifies a request for sticky routing.
+func (req ConnRouteRequest) cacheKey() string {
+	return req.TenantId.String() + "|" + strings.ToLower(strings.Join(req.Regions, ",")) + "|" + req.AuthScope.String()
+}
+
+type ctxKeyConnRouteCache struct{}
+
+// connRouteCache holds routes chosen during a single request.
+type connRouteCache struct {
+	routes map[string]*ConnRoute
+	mu     sync.RWMutex
+}
+
+// WithConnRouteCache returns a context that makes ResolveCtx sticky for the
+// lifetime of the context, typically one request.
+func WithConnRouteCache(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if connRouteCacheFromCtx(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKeyConnRouteCache{}, &connRouteCache{routes: map[string]*ConnRoute{}})
+}
+
+// connRouteCacheFromCtx returns the cache or nil.
+func connRouteCacheFromCtx(ctx context.Context) *connRouteCache {
+	if ctx == nil {
+		return nil
+	}
+	cache, _ := ctx.Value(ctxKeyConnRouteCache{}).(*connRouteCache)
+	return cache
+}
+
+func (c *connRouteCache) get(key string) (*ConnRoute, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	route, ok := c.routes[key]
+	return route, ok
+}
+
+func (c *connRouteCache) set(key string, route *ConnRoute) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes[key] = route
+}
+
+func (c *connRouteCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.routes, key)
+}
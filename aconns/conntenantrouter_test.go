@@ -0,0 +1,131 @@
+package aconns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouterTestConn(name string, tenantId auuids.UUID, region string, priority int, status HealthStatus) *Conn {
+	adapter := &Adapter{Type: "test", Name: AdapterName(name), Host: "localhost"}
+	if !status.IsEmpty() {
+		adapter.UpdateHealth(status)
+	}
+	return &Conn{
+		Id:         NewConnId(),
+		Adapter:    adapter,
+		TenantInfo: &ConnTenantInfo{TenantId: tenantId, Region: region, Priority: priority},
+	}
+}
+
+func TestConnTenantRouter_Resolve(t *testing.T) {
+	tenant := auuids.NewUUID()
+	other := auuids.NewUUID()
+
+	east1 := newRouterTestConn("east-1", tenant, "us-east", 1, HEALTHSTATUS_HEALTHY)
+	east2 := newRouterTestConn("east-2", tenant, "us-east", 2, HEALTHSTATUS_HEALTHY)
+	west1 := newRouterTestConn("west-1", tenant, "us-west", 0, HEALTHSTATUS_HEALTHY)
+	foreign := newRouterTestConn("foreign", other, "us-east", 0, HEALTHSTATUS_HEALTHY)
+	shared := newRouterTestConn("shared", auuids.UUID{}, "us-east", 0, HEALTHSTATUS_HEALTHY)
+
+	router := NewConnTenantRouter(IConns{east2, foreign, west1, east1, shared})
+
+	// Region preference beats priority.
+	route, err := router.Resolve(ConnRouteRequest{TenantId: tenant, Regions: []string{"US-EAST"}})
+	require.NoError(t, err)
+	assert.Equal(t, east1, route.Conn)
+	assert.Equal(t, ROUTEREASON_PRIMARY, route.Reason)
+	assert.Empty(t, route.Skipped)
+
+	// Without a region preference, priority decides.
+	route, err = router.Resolve(ConnRouteRequest{TenantId: tenant})
+	require.NoError(t, err)
+	assert.Equal(t, west1, route.Conn)
+
+	// Unhealthy primary falls back to a secondary.
+	east1.Adapter.(*Adapter).UpdateHealth(HEALTHSTATUS_PING_FAILED)
+	route, err = router.Resolve(ConnRouteRequest{TenantId: tenant, Regions: []string{"us-east"}})
+	require.NoError(t, err)
+	assert.Equal(t, east2, route.Conn)
+	assert.Equal(t, ROUTEREASON_SECONDARY, route.Reason)
+	require.Len(t, route.Skipped, 1)
+	assert.Equal(t, AdapterName("east-1"), route.Skipped[0].AdapterName)
+	assert.Equal(t, HEALTHSTATUS_PING_FAILED, route.Skipped[0].Status)
+	assert.Contains(t, route.Explain(), "skipped 'east-1'")
+
+	// All tenant conns down; shared only when allowed.
+	east2.Adapter.(*Adapter).UpdateHealth(HEALTHSTATUS_TIMEOUT)
+	west1.Adapter.(*Adapter).UpdateHealth(HEALTHSTATUS_AUTH_FAILED)
+	_, err = router.Resolve(ConnRouteRequest{TenantId: tenant})
+	assert.ErrorIs(t, err, ErrNoTenantRoute)
+
+	router.AllowShared = true
+	route, err = router.Resolve(ConnRouteRequest{TenantId: tenant})
+	require.NoError(t, err)
+	assert.Equal(t, shared, route.Conn)
+	assert.Equal(t, ROUTEREASON_SHARED, route.Reason)
+	assert.Len(t, route.Skipped, 3)
+
+	_, err = router.Resolve(ConnRouteRequest{})
+	assert.Error(t, err)
+}
+
+func TestConnTenantRouter_AllowUntestedAndScope(t *testing.T) {
+	tenant := auuids.NewUUID()
+	untested := newRouterTestConn("untested", tenant, "eu", 0, "")
+	scoped := newRouterTestConn("scoped", tenant, "eu", 1, HEALTHSTATUS_HEALTHY)
+	scoped.AuthScopes = AuthScopes{"reports"}
+
+	router := NewConnTenantRouter(IConns{untested, scoped})
+	route, err := router.Resolve(ConnRouteRequest{TenantId: tenant})
+	require.NoError(t, err)
+	assert.Equal(t, scoped, route.Conn)
+	assert.Equal(t, HEALTHSTATUS_UNKNOWN, route.Skipped[0].Status)
+
+	router.AllowUntested = true
+	route, err = router.Resolve(ConnRouteRequest{TenantId: tenant})
+	require.NoError(t, err)
+	assert.Equal(t, untested, route.Conn)
+
+	route, err = router.Resolve(ConnRouteRequest{TenantId: tenant, AuthScope: "reports"})
+	require.NoError(t, err)
+	assert.Equal(t, scoped, route.Conn)
+}
+
+func TestConnTenantRouter_ResolveCtxSticky(t *testing.T) {
+	tenant := auuids.NewUUID()
+	a := newRouterTestConn("a", tenant, "eu", 2, HEALTHSTATUS_HEALTHY)
+	b := newRouterTestConn("b", tenant, "eu", 1, HEALTHSTATUS_PING_FAILED)
+	router := NewConnTenantRouter(IConns{a, b})
+	req := ConnRouteRequest{TenantId: tenant}
+
+	ctx := WithConnRouteCache(context.Background())
+	assert.Equal(t, ctx, WithConnRouteCache(ctx))
+
+	route, err := router.ResolveCtx(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, a, route.Conn)
+	assert.False(t, route.IsSticky)
+
+	// b recovers and would now win, but the request stays on a.
+	b.Adapter.(*Adapter).UpdateHealth(HEALTHSTATUS_HEALTHY)
+	route, err = router.ResolveCtx(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, a, route.Conn)
+	assert.True(t, route.IsSticky)
+
+	// A fresh request picks b.
+	route, err = router.ResolveCtx(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, b, route.Conn)
+
+	// If the sticky conn fails, the request re-routes.
+	a.Adapter.(*Adapter).UpdateHealth(HEALTHSTATUS_NETWORK_ERROR)
+	route, err = router.ResolveCtx(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, b, route.Conn)
+	assert.False(t, route.IsSticky)
+}
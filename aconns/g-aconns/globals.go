@@ -77,6 +77,13 @@ func (cmg *connMapGlobal) SetByIConns(conns aconns.IConns) {
 	}
 }
 
+// NewTenantRouter returns a ConnTenantRouter over a snapshot of the global connections.
+func (cmg *connMapGlobal) NewTenantRouter() *aconns.ConnTenantRouter {
+	cmg.mu.RLock()
+	defer cmg.mu.RUnlock()
+	return aconns.NewConnTenantRouter(cmg.Map.ToArray())
+}
+
 // Reset reinitializes the global connMap.
 func (cmg *connMapGlobal) Reset() {
 	cmg.mu.Lock()
@@ -84,3 +91,17 @@ func (cmg *connMapGlobal) Reset() {
 	cmg.Map = aconns.IConnMap{}
 	cmg.Index = map[aconns.AdapterName]aconns.ConnId{}
 }
+
+// SwapCONNS installs an empty global connection map and returns a function
+// that restores the previous one. Intended for tests via aconnstest.SwapGlobals.
+func SwapCONNS() (restore func()) {
+	muCM.Lock()
+	defer muCM.Unlock()
+	prev := connMap
+	connMap = &connMapGlobal{Map: aconns.IConnMap{}, Index: map[aconns.AdapterName]aconns.ConnId{}}
+	return func() {
+		muCM.Lock()
+		defer muCM.Unlock()
+		connMap = prev
+	}
+}
@@ -18,6 +18,27 @@ require (
 	github.com/stretchr/testify v1.11.1
 )
 
+require (
+	github.com/bojanz/address v1.3.1 // indirect
+	github.com/fsnotify/fsnotify v1.9.0 // indirect
+	github.com/go-co-op/gocron/v2 v2.18.2 // indirect
+	github.com/jonboulle/clockwork v0.5.0 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/nyaruka/phonenumbers v1.6.7 // indirect
+	github.com/oschwald/geoip2-golang v1.13.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.1 // indirect
+	github.com/paulmach/orb v0.12.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/robfig/cron/v3 v3.0.1 // indirect
+	github.com/uptrace/bun/dialect/sqlitedialect v1.2.16 // indirect
+	golang.org/x/exp v0.0.0-20251125195548-87e1e737ad39 // indirect
+	google.golang.org/protobuf v1.36.10 // indirect
+	modernc.org/libc v1.67.7 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.12.1 // indirect
+	modernc.org/sqlite v1.40.1 // indirect
+)
+
 require (
 	dario.cat/mergo v1.0.2 // indirect
 	filippo.io/edwards25519 v1.1.0 // indirect
@@ -53,11 +74,12 @@ require (
 	github.com/jhillyerd/enmime/v2 v2.2.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jlaffaye/ftp v0.0.0-20220301011324-fed5bc26b7fa // indirect
+	github.com/jpfluger/alibs-slim/aconns/adb-sqlite v0.9.12
 	github.com/labstack/echo/v4 v4.13.4 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/mattn/go-isatty v0.0.24 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/mileusna/timezones v0.0.0-20220627120747-ad570b2850c0 // indirect
 	github.com/nbutton23/zxcvbn-go v0.0.0-20210217022336-fa2cb2858354 // indirect
@@ -116,3 +138,5 @@ replace github.com/jpfluger/alibs-slim/aconns/adb-mysql => ../adb-mysql
 replace github.com/jpfluger/alibs-slim/aconns/adb-oracle => ../adb-oracle
 
 replace github.com/jpfluger/alibs-slim/aconns/adb-pg => ../adb-pg
+
+replace github.com/jpfluger/alibs-slim/aconns/adb-sqlite => ../adb-sqlite
@@ -12,6 +12,7 @@ import (
 	adb_mysql "github.com/jpfluger/alibs-slim/aconns/adb-mysql"
 	adb_oracle "github.com/jpfluger/alibs-slim/aconns/adb-oracle"
 	adb_pg "github.com/jpfluger/alibs-slim/aconns/adb-pg"
+	adb_sqlite "github.com/jpfluger/alibs-slim/aconns/adb-sqlite"
 	"github.com/jpfluger/alibs-slim/areflect"
 	"reflect"
 )
@@ -44,6 +45,8 @@ func returnTypeManagerConnAdapters(typeName string) (reflect.Type, error) {
 		rtype = reflect.TypeOf(adb_oracle.ADBOracle{})
 	case adb_pg.ADAPTERTYPE_PG:
 		rtype = reflect.TypeOf(adb_pg.ADBPG{})
+	case adb_sqlite.ADAPTERTYPE_SQLITE:
+		rtype = reflect.TypeOf(adb_sqlite.ADBSqlite{})
 	default:
 		return nil, fmt.Errorf("unknown adapter type: %s", typeName)
 	}
@@ -0,0 +1,105 @@
+package aconns
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/auuids"
+)
+
+// IRecordChangeOutbox persists change events next to the records they
+// describe so they survive a crash between commit and delivery.
+type IRecordChangeOutbox interface {
+	// Enqueue stores events using the tx attached to the RI, if any, so they
+	// commit or roll back with the write.
+	Enqueue(ri *RI, evts ...*RecordChangeEvent) error
+
+	// FetchPending returns up to limit undelivered events, oldest first.
+	FetchPending(limit int) ([]*RecordChangeEvent, error)
+
+	// MarkDelivered flags events so they are not fetched again.
+	MarkDelivered(ids ...auuids.UUID) error
+}
+
+// RecordChangeRelay moves events from an outbox to a bus. An event is marked
+// delivered only after every subscriber accepted it, so subscribers may see
+// an event more than once (at-least-once delivery).
+type RecordChangeRelay struct {
+	Outbox    IRecordChangeOutbox
+	Bus       *RecordChangeBus // If nil, RECORDCHANGES() is used.
+	BatchSize int              // Defaults to 100.
+}
+
+// NewRecordChangeRelay creates a RecordChangeRelay.
+func NewRecordChangeRelay(outbox IRecordChangeOutbox, bus *RecordChangeBus) *RecordChangeRelay {
+	return &RecordChangeRelay{Outbox: outbox, Bus: bus}
+}
+
+// RunOnce delivers one batch and returns how many events were delivered.
+// Delivery stops at the first failed event to preserve ordering.
+func (r *RecordChangeRelay) RunOnce() (int, error) {
+	if r == nil || r.Outbox == nil {
+		return 0, fmt.Errorf("outbox is nil")
+	}
+	bus := r.Bus
+	if bus == nil {
+		bus = RECORDCHANGES()
+	}
+	evts, err := r.Outbox.FetchPending(r.getBatchSize())
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch pending record changes; %v", err)
+	}
+
+	delivered := []auuids.UUID{}
+	var errPublish error
+	for _, evt := range evts {
+		if errPublish = bus.Publish(evt); errPublish != nil {
+			break
+		}
+		delivered = append(delivered, evt.Id)
+	}
+	if len(delivered) > 0 {
+		if err = r.Outbox.MarkDelivered(delivered...); err != nil {
+			return 0, fmt.Errorf("failed to mark record changes delivered; %v", err)
+		}
+	}
+	if errPublish != nil {
+		return len(delivered), fmt.Errorf("failed to publish record change; %v", errPublish)
+	}
+	return len(delivered), nil
+}
+
+func (r *RecordChangeRelay) getBatchSize() int {
+	if r.BatchSize <= 0 {
+		return 100
+	}
+	return r.BatchSize
+}
+
+// Run calls RunOnce every interval until ctx is done. Errors are passed to
+// onError, if set, and do not stop the loop.
+func (r *RecordChangeRelay) Run(ctx context.Context, interval time.Duration, onError func(err error)) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Drain full batches without waiting for the next tick.
+		for {
+			n, err := r.RunOnce()
+			if err != nil && onError != nil {
+				onError(err)
+			}
+			if err != nil || n < r.getBatchSize() {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package aconns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/auser"
+	"github.com/jpfluger/alibs-slim/auuids"
+)
+
+// IRecordModelType is optionally implemented by record models to name
+// themselves in change events. Otherwise the Go type name is used.
+type IRecordModelType interface {
+	GetRecordModelType() string
+}
+
+// RecordChangeEvent describes a committed insert, update or delete.
+// Before is empty for inserts and After is empty for deletes.
+type RecordChangeEvent struct {
+	Id          auuids.UUID              `json:"id"`
+	ModelType   string                   `json:"modelType"`
+	PrimaryKey  string                   `json:"primaryKey"`
+	Action      RecordActionType         `json:"action"`
+	User        auser.RecordUserIdentity `json:"user,omitempty"`
+	Event       string                   `json:"event,omitempty"`
+	ConnId      ConnId                   `json:"connId,omitempty"`
+	AdapterName AdapterName              `json:"adapterName,omitempty"`
+	Before      json.RawMessage          `json:"before,omitempty"`
+	After       json.RawMessage          `json:"after,omitempty"`
+	Time        time.Time                `json:"time"`
+}
+
+// NewRecordChangeEvent snapshots before and after (either may be nil) into an
+// event. The user comes from the RecordSecurity of the newest snapshot and
+// falls back to the RI user.
+func NewRecordChangeEvent(ri *RI, action RecordActionType, before IRecordModel, after IRecordModel) (*RecordChangeEvent, error) {
+	model := after
+	if isNilRecordModel(model) {
+		model = before
+	}
+	if isNilRecordModel(model) {
+		return nil, fmt.Errorf("before and after are nil")
+	}
+	if action.IsEmpty() {
+		return nil, fmt.Errorf("action is empty")
+	}
+
+	evt := &RecordChangeEvent{
+		Id:         auuids.NewUUID(),
+		ModelType:  GetRecordModelType(model),
+		PrimaryKey: model.GetPrimaryKeyAsString(),
+		Action:     action.TrimSpace(),
+		User:       model.GetRecordSecurity().User,
+		Time:       time.Now().UTC(),
+	}
+	if ri != nil {
+		evt.ConnId = ri.GetConnId()
+		evt.AdapterName = ri.GetAdapterName()
+		evt.Event = ri.GetEvent()
+		if evt.User.IsEmpty() {
+			evt.User = ri.GetUser()
+		}
+	}
+
+	var err error
+	if !isNilRecordModel(before) {
+		if evt.Before, err = json.Marshal(before); err != nil {
+			return nil, fmt.Errorf("failed to marshal before; %v", err)
+		}
+	}
+	if !isNilRecordModel(after) {
+		if evt.After, err = json.Marshal(after); err != nil {
+			return nil, fmt.Errorf("failed to marshal after; %v", err)
+		}
+	}
+	return evt, nil
+}
+
+// GetRecordModelType returns the change event model type for the model.
+func GetRecordModelType(model interface{}) string {
+	if mt, ok := model.(IRecordModelType); ok {
+		return mt.GetRecordModelType()
+	}
+	rtype := reflect.TypeOf(model)
+	for rtype != nil && rtype.Kind() == reflect.Ptr {
+		rtype = rtype.Elem()
+	}
+	if rtype == nil {
+		return ""
+	}
+	return rtype.Name()
+}
+
+// isNilRecordModel handles typed nil pointers stored in the interface.
+func isNilRecordModel(model IRecordModel) bool {
+	if model == nil {
+		return true
+	}
+	rv := reflect.ValueOf(model)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// FNRecordChangeHandler receives change events. Handlers must be idempotent
+// because outbox delivery is at-least-once; use RecordChangeEvent.Id to dedupe.
+type FNRecordChangeHandler func(evt *RecordChangeEvent) error
+
+// RecordChangeFilter limits which events a subscriber receives.
+// Empty fields match everything.
+type RecordChangeFilter struct {
+	ModelTypes []string
+	Actions    []RecordActionType
+}
+
+// Matches returns true if the event passes the filter.
+func (f RecordChangeFilter) Matches(evt *RecordChangeEvent) bool {
+	if evt == nil {
+		return false
+	}
+	if len(f.ModelTypes) > 0 {
+		found := false
+		for _, mt := range f.ModelTypes {
+			if mt == evt.ModelType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Actions) > 0 {
+		for _, action := range f.Actions {
+			if action == evt.Action {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+type recordChangeSub struct {
+	id     int
+	filter RecordChangeFilter
+	fn     FNRecordChangeHandler
+}
+
+// RecordChangeBus delivers change events to in-process subscribers.
+// When an outbox is set, RI.PublishRecordChange writes to the outbox instead
+// and a RecordChangeRelay delivers to the bus.
+type RecordChangeBus struct {
+	subs   []*recordChangeSub
+	nextId int
+	outbox IRecordChangeOutbox
+
+	mu sync.RWMutex
+}
+
+// NewRecordChangeBus creates an empty RecordChangeBus.
+func NewRecordChangeBus() *RecordChangeBus {
+	return &RecordChangeBus{}
+}
+
+// Subscribe registers fn for events matching the filter and returns a
+// function that removes the subscription.
+func (b *RecordChangeBus) Subscribe(filter RecordChangeFilter, fn FNRecordChangeHandler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextId++
+	id := b.nextId
+	b.subs = append(b.subs, &recordChangeSub{id: id, filter: filter, fn: fn})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for ii, sub := range b.subs {
+			if sub.id == id {
+				b.subs = append(b.subs[:ii], b.subs[ii+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish synchronously delivers each event to matching subscribers.
+// Every subscriber is called; their errors are joined.
+func (b *RecordChangeBus) Publish(evts ...*RecordChangeEvent) error {
+	b.mu.RLock()
+	subs := append([]*recordChangeSub{}, b.subs...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, evt := range evts {
+		for _, sub := range subs {
+			if sub.fn == nil || !sub.filter.Matches(evt) {
+				continue
+			}
+			if err := sub.fn(evt); err != nil {
+				errs = append(errs, fmt.Errorf("subscriber %d failed for event %s; %v", sub.id, evt.Id.String(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetOutbox routes RI.PublishRecordChange through the outbox. Pass nil to
+// publish directly.
+func (b *RecordChangeBus) SetOutbox(outbox IRecordChangeOutbox) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outbox = outbox
+}
+
+// GetOutbox returns the outbox or nil.
+func (b *RecordChangeBus) GetOutbox() IRecordChangeOutbox {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.outbox
+}
+
+var recordChanges = NewRecordChangeBus()
+var muRC sync.RWMutex
+
+// RECORDCHANGES returns the global RecordChangeBus.
+func RECORDCHANGES() *RecordChangeBus {
+	muRC.RLock()
+	defer muRC.RUnlock()
+	return recordChanges
+}
+
+// SwapRECORDCHANGES installs an empty global bus and returns a function that
+// restores the previous one. Intended for tests via aconnstest.SwapGlobals.
+func SwapRECORDCHANGES() (restore func()) {
+	muRC.Lock()
+	defer muRC.Unlock()
+	prev := recordChanges
+	recordChanges = NewRecordChangeBus()
+	return func() {
+		muRC.Lock()
+		defer muRC.Unlock()
+		recordChanges = prev
+	}
+}
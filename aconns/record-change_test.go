@@ -0,0 +1,217 @@
+package aconns
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/auser"
+	"github.com/jpfluger/alibs-slim/auuids"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testChangeModel is a minimal IRecordModel for change events.
+type testChangeModel struct {
+	Id   string         `json:"id"`
+	Name string         `json:"name"`
+	Sec  RecordSecurity `json:"sec"`
+}
+
+func (m *testChangeModel) Import(ri *RI, irw IImportRecordWrapper) (ImportRecordResults, error) {
+	return nil, nil
+}
+func (m *testChangeModel) Insert(ri *RI) error { return nil }
+func (m *testChangeModel) Update(ri *RI) error { return nil }
+func (m *testChangeModel) Upsert(ri *RI) error { return nil }
+func (m *testChangeModel) Select(ri *RI) error { return nil }
+func (m *testChangeModel) SelectIntoNewObject(ri *RI) (IRecordModel, error) {
+	return nil, fmt.Errorf("not found")
+}
+func (m *testChangeModel) GetRecordSecurity() RecordSecurity { return m.Sec }
+func (m *testChangeModel) GetPrimaryKeyAsString() string     { return m.Id }
+
+// testChangeOutbox is an in-memory IRecordChangeOutbox. Enqueue inside a tx
+// is visible only after commitTx, mimicking a database transaction.
+type testChangeOutbox struct {
+	pending   []*RecordChangeEvent
+	delivered map[string]bool
+	staged    map[interface{}][]*RecordChangeEvent
+	mu        sync.Mutex
+}
+
+func newTestChangeOutbox() *testChangeOutbox {
+	return &testChangeOutbox{delivered: map[string]bool{}, staged: map[interface{}][]*RecordChangeEvent{}}
+}
+
+func (o *testChangeOutbox) Enqueue(ri *RI, evts ...*RecordChangeEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if tx := ri.GetTx(); tx != nil {
+		o.staged[tx] = append(o.staged[tx], evts...)
+		return nil
+	}
+	o.pending = append(o.pending, evts...)
+	return nil
+}
+
+func (o *testChangeOutbox) commitTx(tx interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, o.staged[tx]...)
+	delete(o.staged, tx)
+}
+
+func (o *testChangeOutbox) FetchPending(limit int) ([]*RecordChangeEvent, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []*RecordChangeEvent{}
+	for _, evt := range o.pending {
+		if !o.delivered[evt.Id.String()] && len(out) < limit {
+			out = append(out, evt)
+		}
+	}
+	return out, nil
+}
+
+func (o *testChangeOutbox) MarkDelivered(ids ...auuids.UUID) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range ids {
+		o.delivered[id.String()] = true
+	}
+	return nil
+}
+
+func TestNewRecordChangeEvent(t *testing.T) {
+	user := auser.NewRecordUserIdentityByEmail(aemail.EmailAddress("bob@example.com"))
+	ri := NewRISECAdapter("pg", user)
+
+	before := &testChangeModel{Id: "1", Name: "old"}
+	after := &testChangeModel{Id: "1", Name: "new"}
+	evt, err := NewRecordChangeEvent(ri, REC_ACTION_UPDATE, before, after)
+	require.NoError(t, err)
+	assert.False(t, evt.Id.IsNil())
+	assert.Equal(t, "testChangeModel", evt.ModelType)
+	assert.Equal(t, "1", evt.PrimaryKey)
+	assert.Equal(t, REC_ACTION_UPDATE, evt.Action)
+	assert.Equal(t, AdapterName("pg"), evt.AdapterName)
+	assert.Equal(t, user.String(), evt.User.String(), "falls back to the RI user")
+
+	snap := &testChangeModel{}
+	require.NoError(t, json.Unmarshal(evt.Before, snap))
+	assert.Equal(t, "old", snap.Name)
+
+	var nilModel *testChangeModel
+	evt, err = NewRecordChangeEvent(ri, REC_ACTION_DELETE, before, nilModel)
+	require.NoError(t, err)
+	assert.Empty(t, evt.After)
+
+	_, err = NewRecordChangeEvent(ri, REC_ACTION_INSERT, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestRecordChangeBus_SubscribeFilter(t *testing.T) {
+	bus := NewRecordChangeBus()
+	var all, deletes int
+	unsubAll := bus.Subscribe(RecordChangeFilter{}, func(evt *RecordChangeEvent) error {
+		all++
+		return nil
+	})
+	bus.Subscribe(RecordChangeFilter{ModelTypes: []string{"User"}, Actions: []RecordActionType{REC_ACTION_DELETE}}, func(evt *RecordChangeEvent) error {
+		deletes++
+		return fmt.Errorf("index offline")
+	})
+
+	assert.NoError(t, bus.Publish(&RecordChangeEvent{ModelType: "User", Action: REC_ACTION_INSERT}))
+	err := bus.Publish(&RecordChangeEvent{ModelType: "User", Action: REC_ACTION_DELETE})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "index offline")
+	assert.Equal(t, 2, all, "all subscribers are called even when one fails")
+	assert.Equal(t, 1, deletes)
+
+	unsubAll()
+	_ = bus.Publish(&RecordChangeEvent{ModelType: "Org", Action: REC_ACTION_INSERT})
+	assert.Equal(t, 2, all)
+}
+
+func TestRI_PublishRecordChange_AfterCommit(t *testing.T) {
+	restore := SwapRECORDCHANGES()
+	defer restore()
+
+	got := 0
+	RECORDCHANGES().Subscribe(RecordChangeFilter{}, func(evt *RecordChangeEvent) error {
+		got++
+		return nil
+	})
+
+	// No tx publishes immediately.
+	require.NoError(t, NewRIAdapter("pg").PublishRecordChange(&RecordChangeEvent{Action: REC_ACTION_INSERT}))
+	assert.Equal(t, 1, got)
+
+	// With a tx the event waits for commit.
+	txri := NewRIAdapter("pg").WithTx("tx")
+	require.NoError(t, txri.PublishRecordChange(&RecordChangeEvent{Action: REC_ACTION_INSERT}))
+	assert.Equal(t, 1, got)
+	require.NoError(t, txri.CommitRecordChanges())
+	assert.Equal(t, 2, got)
+
+	// Rollback drops it.
+	txri = NewRIAdapter("pg").WithTx("tx")
+	require.NoError(t, txri.PublishRecordChange(&RecordChangeEvent{Action: REC_ACTION_INSERT}))
+	txri.DiscardRecordChanges()
+	require.NoError(t, txri.CommitRecordChanges())
+	assert.Equal(t, 2, got)
+
+	// A nil RI still yields a tx-bound RI.
+	var nilri *RI
+	assert.Equal(t, "tx", nilri.WithTx("tx").GetTx())
+}
+
+func TestRecordChangeRelay_AtLeastOnce(t *testing.T) {
+	restore := SwapRECORDCHANGES()
+	defer restore()
+
+	outbox := newTestChangeOutbox()
+	RECORDCHANGES().SetOutbox(outbox)
+
+	seen := map[string]int{}
+	fail := true
+	RECORDCHANGES().Subscribe(RecordChangeFilter{}, func(evt *RecordChangeEvent) error {
+		seen[evt.PrimaryKey]++
+		if evt.PrimaryKey == "2" && fail {
+			return fmt.Errorf("temporary failure")
+		}
+		return nil
+	})
+
+	txri := NewRIAdapter("pg").WithTx("tx1")
+	for _, pk := range []string{"1", "2", "3"} {
+		evt, err := NewRecordChangeEvent(txri, REC_ACTION_INSERT, nil, &testChangeModel{Id: pk})
+		require.NoError(t, err)
+		require.NoError(t, txri.PublishRecordChange(evt))
+	}
+	assert.Empty(t, seen, "outbox mode never publishes directly")
+
+	relay := NewRecordChangeRelay(outbox, nil)
+	n, err := relay.RunOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "nothing is visible before the tx commits")
+
+	outbox.commitTx("tx1")
+	n, err = relay.RunOnce()
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+
+	fail = false
+	n, err = relay.RunOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]int{"1": 1, "2": 2, "3": 1}, seen)
+
+	n, err = relay.RunOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,138 @@
+package aconns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/jpfluger/alibs-slim/autils"
+)
+
+// IRecordExportAction is implemented by record types that can be exported.
+// Export loads the records into the receiver, which is then marshalled in
+// the same form Import expects (usually a slice of models).
+type IRecordExportAction interface {
+	Export(ri *RI) error
+}
+
+// RecordExportFile is a file written by RecordExportPipeline.
+type RecordExportFile struct {
+	Path     string `json:"path"`
+	TypeName string `json:"typeName"`
+	Count    int    `json:"count"`
+}
+
+// RecordExportPipeline writes registered record types to DirExports using
+// the layout read by RecordImportPipeline. Files are named
+// "NNN-typeName.(json|ndjson)" with NNN following dependency order, so the
+// directory can be imported back as is.
+type RecordExportPipeline struct {
+	DirExports string           `json:"dirExports"`
+	TypeNames  []string         `json:"typeNames"`
+	Format     RecordFileFormat `json:"format,omitempty"`
+}
+
+// NewRecordExportPipeline creates a RecordExportPipeline for the type names.
+func NewRecordExportPipeline(dirExports string, typeNames ...string) *RecordExportPipeline {
+	return &RecordExportPipeline{
+		DirExports: dirExports,
+		TypeNames:  typeNames,
+		Format:     RECORDFILEFORMAT_JSON,
+	}
+}
+
+// Run exports each type and returns the written files in order.
+func (p *RecordExportPipeline) Run(ri *RI) ([]*RecordExportFile, error) {
+	if ri == nil {
+		return nil, fmt.Errorf("ri is nil")
+	}
+	if len(p.TypeNames) == 0 {
+		return nil, fmt.Errorf("typeNames is empty")
+	}
+	format := p.Format
+	if format.IsEmpty() {
+		format = RECORDFILEFORMAT_JSON
+	}
+	if format != RECORDFILEFORMAT_JSON && format != RECORDFILEFORMAT_NDJSON {
+		return nil, fmt.Errorf("unsupported format '%s'", format)
+	}
+	dir, err := autils.CleanDirWithMkdirOption(p.DirExports, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	rtypes := map[string]reflect.Type{}
+	deps := map[string][]string{}
+	hints := map[string]int{}
+	for ii, typeName := range p.TypeNames {
+		typeName = strings.TrimSpace(typeName)
+		rtype, err := ResolveRecordType(typeName)
+		if err != nil {
+			return nil, err
+		}
+		rtypes[typeName] = rtype
+		deps[typeName] = getRecordDependencies(rtype)
+		hints[typeName] = ii
+	}
+	ordered, err := sortRecordTypesByDependency(deps, hints)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*RecordExportFile{}
+	for ii, typeName := range ordered {
+		obj := newRecordInstance(rtypes[typeName])
+		action, ok := obj.(IRecordExportAction)
+		if !ok {
+			return files, fmt.Errorf("type '%s' does not implement IRecordExportAction", typeName)
+		}
+		if err = action.Export(ri); err != nil {
+			return files, fmt.Errorf("failed to export '%s'; %v", typeName, err)
+		}
+		b, count, err := marshalRecordExport(obj, format)
+		if err != nil {
+			return files, fmt.Errorf("failed to marshal '%s'; %v", typeName, err)
+		}
+		path := filepath.Join(dir, FormatRecordFileName((ii+1)*10, typeName, format))
+		if err = os.WriteFile(path, b, 0644); err != nil {
+			return files, fmt.Errorf("failed to write '%s'; %v", path, err)
+		}
+		files = append(files, &RecordExportFile{Path: path, TypeName: typeName, Count: count})
+	}
+	return files, nil
+}
+
+// marshalRecordExport encodes obj. For NDJSON, slices are written one element
+// per line; anything else is a single line.
+func marshalRecordExport(obj interface{}, format RecordFileFormat) ([]byte, int, error) {
+	rv := reflect.Indirect(reflect.ValueOf(obj))
+	isSlice := rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array
+	count := 1
+	if isSlice {
+		count = rv.Len()
+	}
+
+	if format != RECORDFILEFORMAT_NDJSON {
+		b, err := json.MarshalIndent(obj, "", "  ")
+		return b, count, err
+	}
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	if !isSlice {
+		if err := enc.Encode(obj); err != nil {
+			return nil, 0, err
+		}
+		return buf.Bytes(), count, nil
+	}
+	for ii := 0; ii < rv.Len(); ii++ {
+		if err := enc.Encode(rv.Index(ii).Interface()); err != nil {
+			return nil, 0, err
+		}
+	}
+	return buf.Bytes(), count, nil
+}
@@ -0,0 +1,335 @@
+package aconns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/atags"
+	"github.com/jpfluger/alibs-slim/autils"
+)
+
+var ErrRecordImportFailed = errors.New("record import failed")
+
+// FNRecordImportTx wraps the import of a single file. Implementations begin a
+// transaction, attach it to the RI (see RI.SetTx), call fn and then commit or
+// roll back depending on the error returned.
+type FNRecordImportTx func(ri *RI, fn func(ri *RI) error) error
+
+// RecordImportFile is a file discovered by RecordImportPipeline.Scan.
+type RecordImportFile struct {
+	Path      string           `json:"path"`
+	TypeName  string           `json:"typeName"`
+	Format    RecordFileFormat `json:"format"`
+	Order     int              `json:"order,omitempty"`
+	DependsOn []string         `json:"dependsOn,omitempty"`
+
+	rtype reflect.Type
+}
+
+// RecordImportPipeline walks DirImports for "[order-]typeName.json" and
+// "[order-]typeName.ndjson" files, resolves each type name through the
+// TYPEMANAGER_RECORDMODELS type manager and calls IRecordAction.Import.
+// Files are imported in dependency order (see IRecordImportDependencies),
+// then by order prefix and path. Each file runs inside RunInTx.
+type RecordImportPipeline struct {
+	DirImports   string                `json:"dirImports"`
+	OnExistsType RecImportOnExistsType `json:"onExistsType,omitempty"`
+	ModifiedDate time.Time             `json:"modifiedDate,omitempty"`
+	Tags         atags.TagArrStrings   `json:"tags,omitempty"`
+
+	// StopOnError stops the run at the first failed file.
+	StopOnError bool `json:"stopOnError,omitempty"`
+
+	// ReportPath, if set, is where the JSON report is written after Run.
+	ReportPath string `json:"reportPath,omitempty"`
+
+	// RunInTx wraps each file. If nil, files run without a transaction.
+	RunInTx FNRecordImportTx `json:"-"`
+}
+
+// NewRecordImportPipeline creates a RecordImportPipeline for the directory.
+func NewRecordImportPipeline(dirImports string, onExistsType RecImportOnExistsType) *RecordImportPipeline {
+	return &RecordImportPipeline{
+		DirImports:   dirImports,
+		OnExistsType: onExistsType,
+	}
+}
+
+// GetDirImports implements IImportRecordWrapper.
+func (p *RecordImportPipeline) GetDirImports() string {
+	return p.DirImports
+}
+
+// GetRecImportOnExistsType implements IImportRecordWrapper.
+func (p *RecordImportPipeline) GetRecImportOnExistsType() RecImportOnExistsType {
+	return p.OnExistsType
+}
+
+// GetModifiedDate implements IImportRecordWrapper.
+func (p *RecordImportPipeline) GetModifiedDate() time.Time {
+	return p.ModifiedDate
+}
+
+// GetTag implements IImportRecordWrapper.
+func (p *RecordImportPipeline) GetTag(key atags.TagKey) *atags.TagKeyValueString {
+	return p.Tags.Find(key)
+}
+
+// Scan finds the import files under DirImports and returns them in import order.
+func (p *RecordImportPipeline) Scan() ([]*RecordImportFile, error) {
+	dir := strings.TrimSpace(p.DirImports)
+	if dir == "" {
+		return nil, fmt.Errorf("dirImports is empty")
+	}
+	if !autils.DirExists(dir) {
+		return nil, fmt.Errorf("dirImports does not exist; dir=%s", dir)
+	}
+
+	files := []*RecordImportFile{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		order, typeName, format, ok := ParseRecordFileName(d.Name())
+		if !ok {
+			return nil
+		}
+		files = append(files, &RecordImportFile{
+			Path:     path,
+			TypeName: typeName,
+			Format:   format,
+			Order:    order,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan dirImports; %v", err)
+	}
+
+	deps := map[string][]string{}
+	hints := map[string]int{}
+	for _, file := range files {
+		rtype, err := ResolveRecordType(file.TypeName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve record type for file '%s'; %v", file.Path, err)
+		}
+		file.rtype = rtype
+		file.DependsOn = getRecordDependencies(rtype)
+		if _, ok := deps[file.TypeName]; !ok {
+			deps[file.TypeName] = file.DependsOn
+			hints[file.TypeName] = file.Order
+		} else if file.Order < hints[file.TypeName] {
+			hints[file.TypeName] = file.Order
+		}
+	}
+
+	ordered, err := sortRecordTypesByDependency(deps, hints)
+	if err != nil {
+		return nil, err
+	}
+	rank := map[string]int{}
+	for ii, typeName := range ordered {
+		rank[typeName] = ii
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if rank[files[i].TypeName] != rank[files[j].TypeName] {
+			return rank[files[i].TypeName] < rank[files[j].TypeName]
+		}
+		if files[i].Order != files[j].Order {
+			return files[i].Order < files[j].Order
+		}
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// Run scans and imports all files. A report is always returned once the scan
+// succeeds. If any file fails, the error wraps ErrRecordImportFailed.
+func (p *RecordImportPipeline) Run(ri *RI) (*RecordImportReport, error) {
+	if ri == nil {
+		return nil, fmt.Errorf("ri is nil")
+	}
+	files, err := p.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RecordImportReport{
+		DirImports: p.DirImports,
+		StartedAt:  time.Now().UTC(),
+	}
+	for _, file := range files {
+		fr := p.importFile(ri, file)
+		report.add(fr)
+		if fr.Error != "" && p.StopOnError {
+			break
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	if p.ReportPath != "" {
+		if err = report.Save(p.ReportPath); err != nil {
+			return report, err
+		}
+	}
+	if report.CountFilesFailed > 0 {
+		return report, fmt.Errorf("%w; %d of %d files failed", ErrRecordImportFailed, report.CountFilesFailed, len(files))
+	}
+	return report, nil
+}
+
+// importFile imports a single file inside RunInTx.
+func (p *RecordImportPipeline) importFile(ri *RI, file *RecordImportFile) *RecordImportFileReport {
+	fr := &RecordImportFileReport{
+		Path:     file.Path,
+		TypeName: file.TypeName,
+	}
+
+	runInTx := p.RunInTx
+	if runInTx == nil {
+		runInTx = func(ri *RI, fn func(ri *RI) error) error {
+			return fn(ri)
+		}
+	}
+
+	var results ImportRecordResults
+	err := runInTx(ri, func(txri *RI) error {
+		// Results from a rolled back attempt are discarded.
+		results = ImportRecordResults{}
+		return p.readFile(file, func(b []byte) error {
+			obj := newRecordInstance(file.rtype)
+			action, ok := obj.(IRecordAction)
+			if !ok {
+				return fmt.Errorf("type '%s' does not implement IRecordAction", file.TypeName)
+			}
+			// An NDJSON line holds one record; slice types receive it as a single element.
+			if file.Format == RECORDFILEFORMAT_NDJSON && file.rtype.Kind() == reflect.Slice {
+				b = append(append([]byte{'['}, b...), ']')
+			}
+			if err := json.Unmarshal(b, obj); err != nil {
+				return fmt.Errorf("failed to unmarshal; %v", err)
+			}
+			res, err := action.Import(txri, p)
+			results = append(results, res...)
+			return err
+		})
+	})
+
+	fr.setResults(results)
+	if err != nil {
+		fr.Error = err.Error()
+		fr.IsRolledBack = p.RunInTx != nil
+	}
+	return fr
+}
+
+// readFile passes the whole JSON file or each non-empty NDJSON line to fn.
+func (p *RecordImportPipeline) readFile(file *RecordImportFile, fn func(b []byte) error) error {
+	b, err := os.ReadFile(file.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read file; %v", err)
+	}
+	if file.Format != RECORDFILEFORMAT_NDJSON {
+		return fn(b)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if err = fn(text); err != nil {
+			return fmt.Errorf("line %d; %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// RecordImportResult is the report form of ImportRecordResult.
+type RecordImportResult struct {
+	Id           string    `json:"id,omitempty"`
+	ImportedDate time.Time `json:"importedDate,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// RecordImportFileReport summarizes the import of one file.
+type RecordImportFileReport struct {
+	Path         string                `json:"path"`
+	TypeName     string                `json:"typeName"`
+	Results      []*RecordImportResult `json:"results,omitempty"`
+	CountOk      int                   `json:"countOk"`
+	CountSkipped int                   `json:"countSkipped"`
+	Error        string                `json:"error,omitempty"`
+	IsRolledBack bool                  `json:"isRolledBack,omitempty"`
+}
+
+// setResults converts ImportRecordResults. Results with an error are counted
+// as skipped since Import returns a non-nil error for real failures.
+func (fr *RecordImportFileReport) setResults(results ImportRecordResults) {
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		rr := &RecordImportResult{Id: res.Id, ImportedDate: res.ImportedDate}
+		if res.Error != nil {
+			rr.Error = res.Error.Error()
+			fr.CountSkipped++
+		} else {
+			fr.CountOk++
+		}
+		fr.Results = append(fr.Results, rr)
+	}
+}
+
+// RecordImportReport summarizes a RecordImportPipeline run.
+type RecordImportReport struct {
+	DirImports       string                    `json:"dirImports"`
+	StartedAt        time.Time                 `json:"startedAt"`
+	FinishedAt       time.Time                 `json:"finishedAt"`
+	Files            []*RecordImportFileReport `json:"files"`
+	CountFilesFailed int                       `json:"countFilesFailed"`
+	CountOk          int                       `json:"countOk"`
+	CountSkipped     int                       `json:"countSkipped"`
+}
+
+func (r *RecordImportReport) add(fr *RecordImportFileReport) {
+	r.Files = append(r.Files, fr)
+	if fr.Error != "" {
+		r.CountFilesFailed++
+		return
+	}
+	r.CountOk += fr.CountOk
+	r.CountSkipped += fr.CountSkipped
+}
+
+// Save writes the report as indented JSON, creating the parent directory.
+func (r *RecordImportReport) Save(path string) error {
+	if _, err := autils.CleanDirWithMkdirOption(filepath.Dir(path), "", true); err != nil {
+		return fmt.Errorf("failed to create report dir; %v", err)
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report; %v", err)
+	}
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("failed to write report; %v", err)
+	}
+	return nil
+}
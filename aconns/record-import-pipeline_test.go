@@ -0,0 +1,356 @@
+package aconns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/areflect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	_ = areflect.TypeManager().Register(TYPEMANAGER_RECORDMODELS, "aconn-tests", returnTypeManagerRecordModels)
+}
+
+func returnTypeManagerRecordModels(typeName string) (reflect.Type, error) {
+	var rtype reflect.Type
+	switch typeName {
+	case "test-orgs":
+		rtype = reflect.TypeOf(testRecOrgs{})
+	case "test-users":
+		rtype = reflect.TypeOf(testRecUsers{})
+	case "testCamelOrgs":
+		rtype = reflect.TypeOf(testRecOrgs{})
+	case "test-cycle-a":
+		rtype = reflect.TypeOf(testRecCycleA{})
+	case "test-cycle-b":
+		rtype = reflect.TypeOf(testRecCycleB{})
+	}
+	return rtype, nil
+}
+
+// testRecStore is a keyed store. Writes go to the *testRecStore attached with
+// RI.SetTx when present, which the test tx runner merges on success.
+type testRecStore struct {
+	rows  map[string]string
+	order []string
+	mu    sync.Mutex
+}
+
+func newTestRecStore() *testRecStore {
+	return &testRecStore{rows: map[string]string{}}
+}
+
+var testRecDB = newTestRecStore()
+
+func (s *testRecStore) put(key, val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rows[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.rows[key] = val
+}
+
+func (s *testRecStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.rows[key]
+	return val, ok
+}
+
+func testRecStoreFromRI(ri *RI) *testRecStore {
+	if tx, ok := ri.GetTx().(*testRecStore); ok {
+		return tx
+	}
+	return testRecDB
+}
+
+func testRecImport(ri *RI, irw IImportRecordWrapper, prefix, id, name string) (ImportRecordResults, error) {
+	if name == "fail" {
+		return NewImportRecordResults(id, fmt.Errorf("forced failure")), fmt.Errorf("forced failure")
+	}
+	key := prefix + ":" + id
+	if _, exists := testRecDB.get(key); exists && irw.GetRecImportOnExistsType() == REC_IMPORT_ON_EXISTS_IGNORE {
+		return NewImportRecordResults(id, fmt.Errorf("skipped import recordExists=true")), nil
+	}
+	testRecStoreFromRI(ri).put(key, name)
+	return NewImportRecordResults(id, nil), nil
+}
+
+type testRecOrg struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type testRecOrgs []*testRecOrg
+
+func (orgs *testRecOrgs) Import(ri *RI, irw IImportRecordWrapper) (ImportRecordResults, error) {
+	results := ImportRecordResults{}
+	for _, org := range *orgs {
+		res, err := testRecImport(ri, irw, "org", org.Id, org.Name)
+		results = append(results, res...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (orgs *testRecOrgs) Export(ri *RI) error {
+	for _, key := range testRecDB.order {
+		if val, _ := testRecDB.get(key); len(key) > 4 && key[:4] == "org:" {
+			*orgs = append(*orgs, &testRecOrg{Id: key[4:], Name: val})
+		}
+	}
+	return nil
+}
+
+type testRecUser struct {
+	Id    string `json:"id"`
+	OrgId string `json:"orgId"`
+	Name  string `json:"name"`
+}
+
+type testRecUsers []*testRecUser
+
+func (users *testRecUsers) GetImportDependencies() []string {
+	return []string{"test-orgs"}
+}
+
+func (users *testRecUsers) Import(ri *RI, irw IImportRecordWrapper) (ImportRecordResults, error) {
+	results := ImportRecordResults{}
+	for _, user := range *users {
+		if _, ok := testRecStoreFromRI(ri).get("org:" + user.OrgId); !ok {
+			if _, ok = testRecDB.get("org:" + user.OrgId); !ok {
+				return results, fmt.Errorf("org '%s' not found", user.OrgId)
+			}
+		}
+		res, err := testRecImport(ri, irw, "user", user.Id, user.Name)
+		results = append(results, res...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (users *testRecUsers) Export(ri *RI) error {
+	for _, key := range testRecDB.order {
+		if val, _ := testRecDB.get(key); len(key) > 5 && key[:5] == "user:" {
+			*users = append(*users, &testRecUser{Id: key[5:], OrgId: "o1", Name: val})
+		}
+	}
+	return nil
+}
+
+type testRecCycleA []string
+
+func (a *testRecCycleA) GetImportDependencies() []string { return []string{"test-cycle-b"} }
+func (a *testRecCycleA) Import(ri *RI, irw IImportRecordWrapper) (ImportRecordResults, error) {
+	return nil, nil
+}
+
+type testRecCycleB []string
+
+func (b *testRecCycleB) GetImportDependencies() []string { return []string{"test-cycle-a"} }
+func (b *testRecCycleB) Import(ri *RI, irw IImportRecordWrapper) (ImportRecordResults, error) {
+	return nil, nil
+}
+
+// testRecTxRunner stages writes in a private store and merges them on success.
+func testRecTxRunner(ri *RI, fn func(ri *RI) error) error {
+	tx := newTestRecStore()
+	txri := ri.WithTx(tx)
+	if err := fn(txri); err != nil {
+		return err
+	}
+	for _, key := range tx.order {
+		testRecDB.put(key, tx.rows[key])
+	}
+	return nil
+}
+
+func resetTestRecDB() {
+	testRecDB = newTestRecStore()
+}
+
+func writeTestRecFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+
+func TestParseRecordFileName(t *testing.T) {
+	order, typeName, format, ok := ParseRecordFileName("020-users.ndjson")
+	assert.True(t, ok)
+	assert.Equal(t, 20, order)
+	assert.Equal(t, "users", typeName)
+	assert.Equal(t, RECORDFILEFORMAT_NDJSON, format)
+
+	order, typeName, format, ok = ParseRecordFileName("Orgs.JSON")
+	assert.True(t, ok)
+	assert.Equal(t, 0, order)
+	assert.Equal(t, "Orgs", typeName)
+	assert.Equal(t, RECORDFILEFORMAT_JSON, format)
+
+	_, _, _, ok = ParseRecordFileName("readme.md")
+	assert.False(t, ok)
+
+	assert.Equal(t, "010-users.json", FormatRecordFileName(10, "users", ""))
+}
+
+func TestSortRecordTypesByDependency(t *testing.T) {
+	ordered, err := sortRecordTypesByDependency(map[string][]string{
+		"c": {"b"},
+		"b": {"a", "missing"},
+		"a": nil,
+		"z": nil,
+	}, map[string]int{"z": -1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"z", "a", "b", "c"}, ordered)
+
+	_, err = sortRecordTypesByDependency(map[string][]string{"a": {"b"}, "b": {"a"}}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a, b")
+}
+
+func TestRecordImportPipeline_Run(t *testing.T) {
+	resetTestRecDB()
+	dir := t.TempDir()
+	// Users sort first by prefix but depend on orgs, so orgs must run first.
+	writeTestRecFile(t, dir, "01-test-users.ndjson", "{\"id\":\"u1\",\"orgId\":\"o1\",\"name\":\"bob\"}\n\n{\"id\":\"u2\",\"orgId\":\"o1\",\"name\":\"sue\"}\n")
+	writeTestRecFile(t, dir, "nested/99-test-orgs.json", `[{"id":"o1","name":"acme"}]`)
+	writeTestRecFile(t, dir, "notes.txt", "ignored")
+
+	reportPath := filepath.Join(t.TempDir(), "reports", "import.json")
+	p := NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_IGNORE)
+	p.ReportPath = reportPath
+	p.RunInTx = testRecTxRunner
+
+	files, err := p.Scan()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "test-orgs", files[0].TypeName)
+	assert.Equal(t, "test-users", files[1].TypeName)
+	assert.Equal(t, []string{"test-orgs"}, files[1].DependsOn)
+
+	report, err := p.Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.CountOk)
+	assert.Equal(t, 0, report.CountFilesFailed)
+	val, ok := testRecDB.get("user:u2")
+	assert.True(t, ok)
+	assert.Equal(t, "sue", val)
+
+	b, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	saved := &RecordImportReport{}
+	require.NoError(t, json.Unmarshal(b, saved))
+	require.Len(t, saved.Files, 2)
+	assert.Equal(t, 2, saved.Files[1].CountOk)
+
+	// A second run skips existing records.
+	p.ReportPath = ""
+	report, err = p.Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.CountOk)
+	assert.Equal(t, 3, report.CountSkipped)
+	assert.Equal(t, "skipped import recordExists=true", report.Files[0].Results[0].Error)
+}
+
+func TestRecordImportPipeline_RollbackPerFile(t *testing.T) {
+	resetTestRecDB()
+	dir := t.TempDir()
+	writeTestRecFile(t, dir, "10-test-orgs.json", `[{"id":"o1","name":"acme"}]`)
+	writeTestRecFile(t, dir, "20-test-users.ndjson", "{\"id\":\"u1\",\"orgId\":\"o1\",\"name\":\"bob\"}\n{\"id\":\"u2\",\"orgId\":\"o1\",\"name\":\"fail\"}\n")
+
+	p := NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_UPDATE)
+	p.RunInTx = testRecTxRunner
+
+	report, err := p.Run(NewRI(ConnId{}))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRecordImportFailed))
+	require.Len(t, report.Files, 2)
+	assert.Empty(t, report.Files[0].Error)
+	assert.Contains(t, report.Files[1].Error, "line 2")
+	assert.True(t, report.Files[1].IsRolledBack)
+
+	_, ok := testRecDB.get("org:o1")
+	assert.True(t, ok)
+	_, ok = testRecDB.get("user:u1")
+	assert.False(t, ok, "u1 must be rolled back with the failed file")
+}
+
+func TestRecordImportPipeline_Errors(t *testing.T) {
+	_, err := NewRecordImportPipeline("", REC_IMPORT_ON_EXISTS_IGNORE).Scan()
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	writeTestRecFile(t, dir, "unknown-type.json", `[]`)
+	_, err = NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_IGNORE).Scan()
+	assert.Error(t, err)
+
+	dir = t.TempDir()
+	writeTestRecFile(t, dir, "test-cycle-a.json", `[]`)
+	writeTestRecFile(t, dir, "test-cycle-b.json", `[]`)
+	_, err = NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_IGNORE).Scan()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+}
+
+func TestRecordExportPipeline_RoundTrip(t *testing.T) {
+	resetTestRecDB()
+	testRecDB.put("user:u1", "bob")
+	testRecDB.put("org:o1", "acme")
+
+	dir := t.TempDir()
+	p := NewRecordExportPipeline(dir, "test-users", "test-orgs")
+	p.Format = RECORDFILEFORMAT_NDJSON
+	files, err := p.Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, filepath.Join(dir, "010-test-orgs.ndjson"), files[0].Path)
+	assert.Equal(t, filepath.Join(dir, "020-test-users.ndjson"), files[1].Path)
+	assert.Equal(t, 1, files[1].Count)
+
+	resetTestRecDB()
+	report, err := NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_IGNORE).Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.CountOk)
+	val, ok := testRecDB.get("user:u1")
+	assert.True(t, ok)
+	assert.Equal(t, "bob", val)
+}
+
+func TestRecordPipeline_MixedCaseTypeName(t *testing.T) {
+	resetTestRecDB()
+	testRecDB.put("org:o1", "acme")
+
+	dir := t.TempDir()
+	files, err := NewRecordExportPipeline(dir, "testCamelOrgs").Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, filepath.Join(dir, "010-testCamelOrgs.json"), files[0].Path)
+
+	resetTestRecDB()
+	p := NewRecordImportPipeline(dir, REC_IMPORT_ON_EXISTS_IGNORE)
+	scanned, err := p.Scan()
+	require.NoError(t, err)
+	require.Len(t, scanned, 1)
+	assert.Equal(t, "testCamelOrgs", scanned[0].TypeName)
+	report, err := p.Run(NewRI(ConnId{}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.CountOk)
+
+	// A different case is a different type name.
+	_, err = NewRecordExportPipeline(t.TempDir(), "testcamelorgs").Run(NewRI(ConnId{}))
+	assert.Error(t, err)
+}
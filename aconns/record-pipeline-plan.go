@@ -0,0 +1,168 @@
+package aconns
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jpfluger/alibs-slim/areflect"
+)
+
+// TYPEMANAGER_RECORDMODELS is the areflect.TypeManager key used to resolve
+// record type names found in import and export directories. Registered
+// functions must return (nil, nil) for type names they do not own so that
+// other registrants are consulted.
+const TYPEMANAGER_RECORDMODELS = "recordmodels"
+
+// IRecordImportDependencies is optionally implemented by record models that
+// must be imported after other record types (eg foreign keys).
+type IRecordImportDependencies interface {
+	// GetImportDependencies returns the type names that must be imported first.
+	GetImportDependencies() []string
+}
+
+// RecordFileFormat is the serialization of an import or export file.
+type RecordFileFormat string
+
+const (
+	// RECORDFILEFORMAT_JSON holds a single object or an array passed as one value to Import.
+	RECORDFILEFORMAT_JSON RecordFileFormat = "json"
+	// RECORDFILEFORMAT_NDJSON holds one object per line, each passed to Import.
+	RECORDFILEFORMAT_NDJSON RecordFileFormat = "ndjson"
+)
+
+// IsEmpty checks if the RecordFileFormat is empty.
+func (rf RecordFileFormat) IsEmpty() bool {
+	return strings.TrimSpace(string(rf)) == ""
+}
+
+// String returns the string representation of the RecordFileFormat.
+func (rf RecordFileFormat) String() string {
+	return string(rf)
+}
+
+// reRecordFileName matches "[order-]typeName.(json|ndjson)". The extension
+// is case-insensitive.
+var reRecordFileName = regexp.MustCompile(`^(?:(\d+)[-_])?([A-Za-z0-9][A-Za-z0-9._:-]*)\.((?i:json|ndjson))$`)
+
+// ParseRecordFileName splits a file name like "020-users.ndjson" into its
+// optional order prefix, type name and format. The type name keeps its case
+// because TypeManager lookups are case-sensitive.
+func ParseRecordFileName(name string) (order int, typeName string, format RecordFileFormat, ok bool) {
+	m := reRecordFileName.FindStringSubmatch(strings.TrimSpace(name))
+	if m == nil {
+		return 0, "", "", false
+	}
+	if m[1] != "" {
+		order, _ = strconv.Atoi(m[1])
+	}
+	return order, m[2], RecordFileFormat(strings.ToLower(m[3])), true
+}
+
+// FormatRecordFileName is the reverse of ParseRecordFileName.
+func FormatRecordFileName(order int, typeName string, format RecordFileFormat) string {
+	if format.IsEmpty() {
+		format = RECORDFILEFORMAT_JSON
+	}
+	return fmt.Sprintf("%03d-%s.%s", order, typeName, format)
+}
+
+// ResolveRecordType finds the reflect.Type registered for the type name.
+// Pointer types are dereferenced.
+func ResolveRecordType(typeName string) (reflect.Type, error) {
+	typeName = strings.TrimSpace(typeName)
+	if typeName == "" {
+		return nil, fmt.Errorf("type name is empty")
+	}
+	rtype, err := areflect.TypeManager().FindReflectType(TYPEMANAGER_RECORDMODELS, typeName)
+	if err != nil {
+		return nil, err
+	}
+	if rtype == nil {
+		return nil, fmt.Errorf("failed to find type '%s'", typeName)
+	}
+	if rtype.Kind() == reflect.Ptr {
+		rtype = rtype.Elem()
+	}
+	return rtype, nil
+}
+
+// newRecordInstance returns a pointer to a new zero value of rtype.
+func newRecordInstance(rtype reflect.Type) interface{} {
+	return reflect.New(rtype).Interface()
+}
+
+// getRecordDependencies returns the import dependencies declared by the type.
+func getRecordDependencies(rtype reflect.Type) []string {
+	if deps, ok := newRecordInstance(rtype).(IRecordImportDependencies); ok {
+		out := []string{}
+		for _, dep := range deps.GetImportDependencies() {
+			if dep = strings.TrimSpace(dep); dep != "" {
+				out = append(out, dep)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// sortRecordTypesByDependency orders type names so dependencies come first.
+// Among types that are ready, the lower hint (then name) goes first.
+// Dependencies not in the set are ignored. A cycle returns an error.
+func sortRecordTypesByDependency(deps map[string][]string, hints map[string]int) ([]string, error) {
+	inDegree := map[string]int{}
+	dependents := map[string][]string{}
+	for typeName := range deps {
+		inDegree[typeName] += 0
+		for _, dep := range deps[typeName] {
+			if _, ok := deps[dep]; !ok || dep == typeName {
+				continue
+			}
+			inDegree[typeName]++
+			dependents[dep] = append(dependents[dep], typeName)
+		}
+	}
+
+	less := func(a, b string) bool {
+		if hints[a] != hints[b] {
+			return hints[a] < hints[b]
+		}
+		return a < b
+	}
+
+	ready := []string{}
+	for typeName, n := range inDegree {
+		if n == 0 {
+			ready = append(ready, typeName)
+		}
+	}
+
+	ordered := []string{}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		next := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, next)
+		for _, dependent := range dependents[next] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) != len(deps) {
+		cyclic := []string{}
+		for typeName, n := range inDegree {
+			if n > 0 {
+				cyclic = append(cyclic, typeName)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("record type dependency cycle among: %s", strings.Join(cyclic, ", "))
+	}
+	return ordered, nil
+}
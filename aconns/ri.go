@@ -30,6 +30,16 @@ type RI struct {
 	// If true, wipes any history on save except for what sec contains.
 	noApplyHistory bool
 
+	// An optional driver transaction (eg *bun.Tx) that record models
+	// should use instead of opening their own. Set by import pipelines.
+	tx interface{}
+
+	// Change events staged while tx is open. See PublishRecordChange.
+	changes []*RecordChangeEvent
+
+	// The tenant the RI acts for. Used by tenant-scoped adapters (eg row-level security).
+	tenantInfo *ConnTenantInfo
+
 	// RI's typically do not survive beyond a single go routine
 	mu sync.RWMutex
 }
@@ -149,3 +159,104 @@ func (ri *RI) GetEvent() string {
 	defer ri.mu.RUnlock()
 	return ri.Event
 }
+
+// SetTx attaches a driver transaction to the RI.
+func (ri *RI) SetTx(tx interface{}) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.tx = tx
+}
+
+// WithTx returns a copy of the RI, including its RecordSecurity override,
+// with the transaction attached. Staged change events are not copied.
+func (ri *RI) WithTx(tx interface{}) *RI {
+	if ri == nil {
+		return &RI{tx: tx}
+	}
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	return &RI{
+		ConnId:         ri.ConnId,
+		AdapterName:    ri.AdapterName,
+		User:           ri.User,
+		Event:          ri.Event,
+		sec:            ri.sec,
+		noApplyHistory: ri.noApplyHistory,
+		tx:             tx,
+		tenantInfo:     ri.tenantInfo,
+	}
+}
+
+// GetTx returns the driver transaction attached to the RI or nil.
+func (ri *RI) GetTx() interface{} {
+	if ri == nil {
+		return nil
+	}
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	return ri.tx
+}
+
+// SetTenantInfo sets the tenant the RI acts for.
+func (ri *RI) SetTenantInfo(info *ConnTenantInfo) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.tenantInfo = info
+}
+
+// GetTenantInfo returns the tenant the RI acts for or nil.
+func (ri *RI) GetTenantInfo() *ConnTenantInfo {
+	if ri == nil {
+		return nil
+	}
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	return ri.tenantInfo
+}
+
+// PublishRecordChange sends a change event for a write made through this RI.
+// If the global bus has an outbox, the event is enqueued (inside the attached
+// tx, if any). Otherwise, with a tx attached, the event is staged until
+// CommitRecordChanges; without a tx it is published immediately.
+func (ri *RI) PublishRecordChange(evt *RecordChangeEvent) error {
+	if ri == nil || evt == nil {
+		return fmt.Errorf("ri or evt is nil")
+	}
+	bus := RECORDCHANGES()
+	if outbox := bus.GetOutbox(); outbox != nil {
+		return outbox.Enqueue(ri, evt)
+	}
+	ri.mu.Lock()
+	if ri.tx != nil {
+		ri.changes = append(ri.changes, evt)
+		ri.mu.Unlock()
+		return nil
+	}
+	ri.mu.Unlock()
+	return bus.Publish(evt)
+}
+
+// CommitRecordChanges publishes staged change events. Call after the tx commits.
+func (ri *RI) CommitRecordChanges() error {
+	if ri == nil {
+		return nil
+	}
+	ri.mu.Lock()
+	changes := ri.changes
+	ri.changes = nil
+	ri.mu.Unlock()
+	if len(changes) == 0 {
+		return nil
+	}
+	return RECORDCHANGES().Publish(changes...)
+}
+
+// DiscardRecordChanges drops staged change events. Call after a rollback.
+func (ri *RI) DiscardRecordChanges() {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.changes = nil
+}
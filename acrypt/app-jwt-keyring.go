@@ -0,0 +1,43 @@
+package acrypt
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	globalsJWTKeyring *JWTKeyring
+	muJWTKeyring      sync.RWMutex // Ensures thread-safe access to globalsJWTKeyring.
+)
+
+// GetAppJWTKeyring returns the global JWTKeyring or nil.
+func GetAppJWTKeyring() *JWTKeyring {
+	muJWTKeyring.RLock()
+	defer muJWTKeyring.RUnlock()
+	return globalsJWTKeyring
+}
+
+// APPJWTKEYRING is a shortcut to GetAppJWTKeyring().
+// Prior to using, set the keyring using SetAppJWTKeyring.
+func APPJWTKEYRING() *JWTKeyring {
+	return GetAppJWTKeyring()
+}
+
+// SetAppJWTKeyring sets the global JWTKeyring used to sign user tokens.
+// Use force=true to overwrite an existing instance.
+func SetAppJWTKeyring(target *JWTKeyring, force bool) error {
+	if target == nil {
+		return fmt.Errorf("cannot set a nil JWTKeyring")
+	}
+
+	muJWTKeyring.Lock()
+	defer muJWTKeyring.Unlock()
+
+	if globalsJWTKeyring != nil && !force {
+		return fmt.Errorf("JWTKeyring is already set; use force=true to overwrite")
+	}
+
+	globalsJWTKeyring = target
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package acrypt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// BREACHED_BLOOM_MAGIC prefixes serialized bloom filters.
+const BREACHED_BLOOM_MAGIC = "ABF1"
+
+const (
+	// BREACHED_BLOOM_MAX_BITS caps the size of a filter read from a file
+	// (4 GiB), well above a full corpus at a 0.1% false-positive rate.
+	BREACHED_BLOOM_MAX_BITS = 1 << 35
+	// BREACHED_BLOOM_MAX_HASHES caps the hash functions of a filter read from a file.
+	BREACHED_BLOOM_MAX_HASHES = 64
+
+	breachedBloomReadChunk = 1 << 17 // Words read at a time when the input size is unknown.
+)
+
+// BreachedBloomFilter is a compact, probabilistic breach corpus. It never
+// misses a listed password but may report an unlisted one at the configured
+// false-positive rate. Filters are built once and then only read.
+type BreachedBloomFilter struct {
+	bits []uint64
+	m    uint64 // Number of bits.
+	k    uint32 // Number of hash functions.
+}
+
+// NewBreachedBloomFilter sizes a filter for n entries at false-positive rate p.
+func NewBreachedBloomFilter(n int, p float64) (*BreachedBloomFilter, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("bloom filter entries must be greater than 0")
+	}
+	if p <= 0 || p >= 1 {
+		return nil, fmt.Errorf("bloom filter false-positive rate must be between 0 and 1")
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return newBreachedBloomFilter(m, k), nil
+}
+
+func newBreachedBloomFilter(m uint64, k uint32) *BreachedBloomFilter {
+	return &BreachedBloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// locations derives k bit positions from a SHA-1 by double hashing.
+func (bf *BreachedBloomFilter) locations(sum []byte, fn func(idx uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	for ii := uint32(0); ii < bf.k; ii++ {
+		if !fn((h1 + uint64(ii)*h2) % bf.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddSHA1 adds a hex SHA-1 to the filter.
+func (bf *BreachedBloomFilter) AddSHA1(hash string) error {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) != BREACHED_SHA1_LENGTH/2 {
+		return fmt.Errorf("invalid sha1 %q", hash)
+	}
+	bf.locations(sum, func(idx uint64) bool {
+		bf.bits[idx/64] |= 1 << (idx % 64)
+		return true
+	})
+	return nil
+}
+
+// Add adds a plaintext password to the filter.
+func (bf *BreachedBloomFilter) Add(password string) {
+	_ = bf.AddSHA1(BreachedSHA1(password))
+}
+
+// IsBreached reports whether password may be in the corpus.
+func (bf *BreachedBloomFilter) IsBreached(password string) (bool, error) {
+	return bf.IsBreachedSHA1(BreachedSHA1(password))
+}
+
+// IsBreachedSHA1 reports whether the hex SHA-1 may be in the corpus.
+func (bf *BreachedBloomFilter) IsBreachedSHA1(hash string) (bool, error) {
+	if bf == nil || bf.m == 0 {
+		return false, fmt.Errorf("bloom filter is nil")
+	}
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) != BREACHED_SHA1_LENGTH/2 {
+		return false, fmt.Errorf("invalid sha1 %q", hash)
+	}
+	return bf.locations(sum, func(idx uint64) bool {
+		return bf.bits[idx/64]&(1<<(idx%64)) != 0
+	}), nil
+}
+
+// WriteTo serializes the filter.
+func (bf *BreachedBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, len(BREACHED_BLOOM_MAGIC)+12)
+	copy(header, BREACHED_BLOOM_MAGIC)
+	binary.BigEndian.PutUint32(header[4:], bf.k)
+	binary.BigEndian.PutUint64(header[8:], bf.m)
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.BigEndian, bf.bits); err != nil {
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(header) + len(bf.bits)*8), nil
+}
+
+// ReadBreachedBloomFilter loads a filter written by WriteTo.
+func ReadBreachedBloomFilter(r io.Reader) (*BreachedBloomFilter, error) {
+	header := make([]byte, len(BREACHED_BLOOM_MAGIC)+12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter header; %v", err)
+	}
+	if string(header[:4]) != BREACHED_BLOOM_MAGIC {
+		return nil, fmt.Errorf("invalid bloom filter header")
+	}
+	k := binary.BigEndian.Uint32(header[4:])
+	m := binary.BigEndian.Uint64(header[8:])
+	if k == 0 || m == 0 || k > BREACHED_BLOOM_MAX_HASHES || m > BREACHED_BLOOM_MAX_BITS {
+		return nil, fmt.Errorf("invalid bloom filter size")
+	}
+	words := (m + 63) / 64
+	if remaining, ok := breachedBloomRemaining(r); ok && uint64(remaining) < words*8 {
+		return nil, fmt.Errorf("bloom filter is truncated; expected %d bytes but %d remain", words*8, remaining)
+	}
+
+	// Read in chunks so a header that overstates the size cannot allocate
+	// more than the data actually present.
+	br := bufio.NewReader(r)
+	bits := make([]uint64, 0, min(words, breachedBloomReadChunk))
+	for uint64(len(bits)) < words {
+		chunk := make([]uint64, min(words-uint64(len(bits)), breachedBloomReadChunk))
+		if err := binary.Read(br, binary.BigEndian, chunk); err != nil {
+			return nil, fmt.Errorf("failed to read bloom filter bits; %v", err)
+		}
+		bits = append(bits, chunk...)
+	}
+	return &BreachedBloomFilter{bits: bits, m: m, k: k}, nil
+}
+
+// breachedBloomRemaining returns the bytes left in r if r knows its size.
+func breachedBloomRemaining(r io.Reader) (int64, bool) {
+	switch rr := r.(type) {
+	case interface{ Len() int }:
+		return int64(rr.Len()), true
+	case io.Seeker:
+		cur, err := rr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := rr.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err = rr.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// LoadBreachedBloomFilter loads a filter from a file.
+func LoadBreachedBloomFilter(path string) (*BreachedBloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bloom filter; %v", err)
+	}
+	defer f.Close()
+	return ReadBreachedBloomFilter(f)
+}
+
+// BuildBreachedBloomFilter builds a filter from a downloaded corpus of
+// "SHA1HEX[:COUNT]" lines. n is the expected number of entries and p the
+// false-positive rate. Entries with a count below minCount are skipped.
+func BuildBreachedBloomFilter(r io.Reader, n int, p float64, minCount int) (*BreachedBloomFilter, error) {
+	bf, err := NewBreachedBloomFilter(n, p)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		hash, count, err := parseBreachedLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if count < 1 || count < minCount {
+			continue
+		}
+		if err = bf.AddSHA1(hash); err != nil {
+			return nil, err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached corpus; %v", err)
+	}
+	return bf, nil
+}
@@ -0,0 +1,191 @@
+package acrypt
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	BREACHED_PREFIX_LENGTH = 5  // Hex characters in a k-anonymity range prefix.
+	BREACHED_SHA1_LENGTH   = 40 // Hex characters in a SHA-1 hash.
+)
+
+// IBreachedPasswords reports whether a password appears in a breach corpus.
+type IBreachedPasswords interface {
+	IsBreached(password string) (bool, error)
+}
+
+// BreachedSHA1 returns the upper-case hex SHA-1 of password, the format used by breach corpora.
+// SHA-1 is used only to look up public corpora, never to store passwords.
+func BreachedSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseBreachedLine parses a corpus line "SHA1HEX[:COUNT]". A missing count is 1.
+func parseBreachedLine(line string) (hash string, count int, err error) {
+	line = strings.TrimSpace(line)
+	hash, countStr, hasCount := strings.Cut(line, ":")
+	hash = strings.ToUpper(strings.TrimSpace(hash))
+	count = 1
+	if hasCount {
+		if count, err = strconv.Atoi(strings.TrimSpace(countStr)); err != nil {
+			return "", 0, fmt.Errorf("invalid count in line %q", line)
+		}
+	}
+	return hash, count, nil
+}
+
+// isBreachedSHA1Hex returns true if hash is a 40-character hex SHA-1, so its
+// prefix is safe to use as a file name.
+func isBreachedSHA1Hex(hash string) bool {
+	if len(hash) != BREACHED_SHA1_LENGTH {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// BreachedPrefixFS checks passwords against a directory of k-anonymity range
+// files, as served by the Pwned Passwords range API. Each file is named by the
+// first five hex characters of the SHA-1 and holds "SUFFIX:COUNT" lines.
+// Only the prefix file of the candidate is read.
+type BreachedPrefixFS struct {
+	FS fs.FS
+
+	// MinCount ignores entries seen fewer times; padding entries with a count
+	// of zero are always ignored.
+	MinCount int
+}
+
+// NewBreachedPrefixDir creates a BreachedPrefixFS on a local directory.
+func NewBreachedPrefixDir(dir string) (*BreachedPrefixFS, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached prefix dir; %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached prefix path '%s' is not a directory", dir)
+	}
+	return &BreachedPrefixFS{FS: os.DirFS(dir)}, nil
+}
+
+// IsBreached reports whether the SHA-1 of password is listed in its prefix file.
+func (b *BreachedPrefixFS) IsBreached(password string) (bool, error) {
+	return b.IsBreachedSHA1(BreachedSHA1(password))
+}
+
+// IsBreachedSHA1 reports whether the hex SHA-1 is listed in its prefix file.
+func (b *BreachedPrefixFS) IsBreachedSHA1(hash string) (bool, error) {
+	if b == nil || b.FS == nil {
+		return false, fmt.Errorf("breached prefix fs is nil")
+	}
+	hash = strings.ToUpper(hash)
+	if !isBreachedSHA1Hex(hash) {
+		return false, fmt.Errorf("invalid sha1 %q", hash)
+	}
+	f, err := b.FS.Open(hash[:BREACHED_PREFIX_LENGTH])
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open breached prefix file; %v", err)
+	}
+	defer f.Close()
+
+	suffix := hash[BREACHED_PREFIX_LENGTH:]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < len(suffix) || !strings.EqualFold(line[:len(suffix)], suffix) {
+			continue
+		}
+		_, count, err := parseBreachedLine(line)
+		if err != nil {
+			return false, err
+		}
+		return count > 0 && count >= b.MinCount, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached prefix file; %v", err)
+	}
+	return false, nil
+}
+
+// WriteBreachedPrefixDir splits a downloaded corpus of "SHA1HEX[:COUNT]" lines
+// into range files in dir. Entries with a count below minCount are skipped.
+// It returns the number of hashes written. Corpora ordered by hash are
+// written in a single pass; unordered corpora are appended to.
+func WriteBreachedPrefixDir(r io.Reader, dir string, minCount int) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create breached prefix dir; %v", err)
+	}
+	var (
+		f      *os.File
+		w      *bufio.Writer
+		prefix string
+		total  int
+	)
+	closeFile := func() error {
+		if f == nil {
+			return nil
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		hash, count, err := parseBreachedLine(scanner.Text())
+		if err != nil {
+			closeFile()
+			return total, err
+		}
+		if !isBreachedSHA1Hex(hash) {
+			closeFile()
+			return total, fmt.Errorf("invalid sha1 %q", hash)
+		}
+		if count < 1 || count < minCount {
+			continue
+		}
+		if p := hash[:BREACHED_PREFIX_LENGTH]; p != prefix {
+			if err = closeFile(); err != nil {
+				return total, fmt.Errorf("failed to write breached prefix file; %v", err)
+			}
+			prefix = p
+			if f, err = os.OpenFile(filepath.Join(dir, prefix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+				f = nil
+				return total, fmt.Errorf("failed to open breached prefix file; %v", err)
+			}
+			w = bufio.NewWriter(f)
+		}
+		if _, err = fmt.Fprintf(w, "%s:%d\n", hash[BREACHED_PREFIX_LENGTH:], count); err != nil {
+			closeFile()
+			return total, fmt.Errorf("failed to write breached prefix file; %v", err)
+		}
+		total++
+	}
+	if err := scanner.Err(); err != nil {
+		closeFile()
+		return total, fmt.Errorf("failed to read breached corpus; %v", err)
+	}
+	if err := closeFile(); err != nil {
+		return total, fmt.Errorf("failed to write breached prefix file; %v", err)
+	}
+	return total, nil
+}
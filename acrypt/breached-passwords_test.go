@@ -0,0 +1,152 @@
+package acrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// breachedTestCorpus is in the downloadable "SHA1:COUNT" format.
+var breachedTestCorpus = strings.Join([]string{
+	BreachedSHA1("password") + ":9545824",
+	BreachedSHA1("letmein") + ":1",
+	BreachedSHA1("Summer2024!") + ":42",
+	strings.Repeat("0", BREACHED_SHA1_LENGTH) + ":0", // padding
+}, "\n")
+
+func TestBreachedSHA1(t *testing.T) {
+	assert.Equal(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", BreachedSHA1("password"))
+}
+
+func TestBreachedPrefixFS(t *testing.T) {
+	hash := BreachedSHA1("password")
+	b := &BreachedPrefixFS{FS: fstest.MapFS{
+		hash[:5]: {Data: []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\n" + strings.ToLower(hash[5:]) + ":9545824\n")},
+		"00000":  {Data: []byte(strings.Repeat("0", 35) + ":0\n")},
+	}}
+
+	ok, err := b.IsBreached("password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.IsBreached("correct horse battery staple 9!")
+	assert.NoError(t, err)
+	assert.False(t, ok, "missing prefix files are not breached")
+
+	ok, err = b.IsBreachedSHA1(strings.Repeat("0", BREACHED_SHA1_LENGTH))
+	assert.NoError(t, err)
+	assert.False(t, ok, "padding entries are ignored")
+
+	b.MinCount = 10000000
+	ok, _ = b.IsBreached("password")
+	assert.False(t, ok)
+
+	_, err = b.IsBreachedSHA1("abc")
+	assert.Error(t, err)
+	_, err = b.IsBreachedSHA1("../.." + strings.Repeat("A", 35))
+	assert.Error(t, err)
+}
+
+func TestWriteBreachedPrefixDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ranges")
+	n, err := WriteBreachedPrefixDir(strings.NewReader(breachedTestCorpus), dir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "letmein and padding are skipped")
+
+	b, err := NewBreachedPrefixDir(dir)
+	require.NoError(t, err)
+	for pw, want := range map[string]bool{"password": true, "Summer2024!": true, "letmein": false, "unlisted": false} {
+		ok, err := b.IsBreached(pw)
+		assert.NoError(t, err)
+		assert.Equal(t, want, ok, pw)
+	}
+
+	_, err = WriteBreachedPrefixDir(strings.NewReader("nothex:1"), dir, 0)
+	assert.Error(t, err)
+
+	// A 40-character line that is not hex must not become a file name.
+	escape := filepath.Join(t.TempDir(), "escape")
+	_, err = WriteBreachedPrefixDir(strings.NewReader("../.."+strings.Repeat("A", 35)+":1"), escape, 0)
+	assert.Error(t, err)
+	entries, err := os.ReadDir(escape)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = NewBreachedPrefixDir(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestBreachedBloomFilter(t *testing.T) {
+	bf, err := BuildBreachedBloomFilter(strings.NewReader(breachedTestCorpus), 100, 0.001, 0)
+	require.NoError(t, err)
+	for _, pw := range []string{"password", "letmein", "Summer2024!"} {
+		ok, err := bf.IsBreached(pw)
+		assert.NoError(t, err)
+		assert.True(t, ok, pw)
+	}
+
+	// Round trip through a file.
+	var buf bytes.Buffer
+	_, err = bf.WriteTo(&buf)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	loaded, err := LoadBreachedBloomFilter(path)
+	require.NoError(t, err)
+	ok, err := loaded.IsBreached("password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The false-positive rate stays near the configured rate.
+	fp := 0
+	for ii := 0; ii < 10000; ii++ {
+		if ok, _ = loaded.IsBreached(fmt.Sprintf("unlisted-%d", ii)); ok {
+			fp++
+		}
+	}
+	assert.Less(t, fp, 50)
+
+	_, err = ReadBreachedBloomFilter(strings.NewReader("XXXX00000000000000000000"))
+	assert.Error(t, err)
+	_, err = NewBreachedBloomFilter(0, 0.01)
+	assert.Error(t, err)
+	_, err = NewBreachedBloomFilter(10, 1)
+	assert.Error(t, err)
+}
+
+func TestReadBreachedBloomFilter_HostileHeader(t *testing.T) {
+	header := func(k uint32, m uint64) []byte {
+		b := make([]byte, len(BREACHED_BLOOM_MAGIC)+12)
+		copy(b, BREACHED_BLOOM_MAGIC)
+		binary.BigEndian.PutUint32(b[4:], k)
+		binary.BigEndian.PutUint64(b[8:], m)
+		return b
+	}
+
+	// Sizes past the maximums are rejected outright.
+	_, err := ReadBreachedBloomFilter(bytes.NewReader(header(3, math.MaxUint64)))
+	assert.Error(t, err)
+	_, err = ReadBreachedBloomFilter(bytes.NewReader(header(BREACHED_BLOOM_MAX_HASHES+1, 64)))
+	assert.Error(t, err)
+
+	// A size within the maximums but beyond the data is rejected before
+	// allocating, whether the reader knows its length or not.
+	data := append(header(3, BREACHED_BLOOM_MAX_BITS), make([]byte, 64)...)
+	_, err = ReadBreachedBloomFilter(bytes.NewReader(data))
+	assert.ErrorContains(t, err, "truncated")
+	path := filepath.Join(t.TempDir(), "hostile.bloom")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	_, err = LoadBreachedBloomFilter(path)
+	assert.ErrorContains(t, err, "truncated")
+	_, err = ReadBreachedBloomFilter(io.MultiReader(bytes.NewReader(data)))
+	assert.ErrorContains(t, err, "failed to read bloom filter bits")
+}
@@ -0,0 +1,594 @@
+package acrypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP Message Signatures (RFC 9421) with ed25519 keys, plus the
+// Content-Digest header (RFC 9530) that binds the body to the signature.
+
+const (
+	HTTPSIG_HEADER_SIGNATURE       = "Signature"
+	HTTPSIG_HEADER_SIGNATURE_INPUT = "Signature-Input"
+	HTTPSIG_HEADER_CONTENT_DIGEST  = "Content-Digest"
+
+	HTTPSIG_ALG_ED25519   = "ed25519"
+	HTTPSIG_LABEL_DEFAULT = "sig1"
+
+	HTTPSIG_MAX_AGE_DEFAULT  = 5 * time.Minute
+	HTTPSIG_LEEWAY_DEFAULT   = 30 * time.Second
+	HTTPSIG_MAX_BODY_DEFAULT = 10 << 20
+)
+
+var (
+	ErrHTTPSignatureMissing     = errors.New("http signature missing")
+	ErrHTTPSignatureInvalid     = errors.New("http signature invalid")
+	ErrHTTPSignatureExpired     = errors.New("http signature expired")
+	ErrHTTPSignatureKeyNotFound = errors.New("http signature key not found")
+)
+
+// HTTPSIG_COMPONENTS_DEFAULT are the components signed for every request.
+// Signers add "content-digest" and "content-type" when the request has a body.
+var HTTPSIG_COMPONENTS_DEFAULT = []string{"@method", "@authority", "@path", "@query"}
+
+// IHTTPSignatureKeyResolver returns the ed25519 public key named by a keyid.
+type IHTTPSignatureKeyResolver interface {
+	HTTPSignatureKey(keyId string) (ed25519.PublicKey, error)
+}
+
+// HTTPSignatureKey returns the ed25519 key with kid.
+func (s *JWKSet) HTTPSignatureKey(keyId string) (ed25519.PublicKey, error) {
+	jwk := s.Find(keyId)
+	if jwk == nil || (jwk.Use != "" && jwk.Use != "sig") {
+		return nil, fmt.Errorf("%w; keyid '%s'", ErrHTTPSignatureKeyNotFound, keyId)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w; keyid '%s'; %v", ErrHTTPSignatureKeyNotFound, keyId, err)
+	}
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w; keyid '%s' is not ed25519", ErrHTTPSignatureKeyNotFound, keyId)
+	}
+	return edPub, nil
+}
+
+// HTTPSignatureKey returns the remote ed25519 key with kid, refreshing the
+// cached set when the kid is unknown.
+func (jr *JWKSRemote) HTTPSignatureKey(keyId string) (ed25519.PublicKey, error) {
+	pub, alg, err := jr.key(keyId)
+	if err != nil {
+		return nil, fmt.Errorf("%w; %v", ErrHTTPSignatureKeyNotFound, err)
+	}
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok || alg != JWTALG_EDDSA.String() {
+		return nil, fmt.Errorf("%w; keyid '%s' is not ed25519", ErrHTTPSignatureKeyNotFound, keyId)
+	}
+	return edPub, nil
+}
+
+// HTTPSignatureSigner signs outgoing requests with an ed25519 private key.
+type HTTPSignatureSigner struct {
+	KeyId      string
+	PrivateKey ed25519.PrivateKey
+	Label      string        // Signature label; HTTPSIG_LABEL_DEFAULT if empty.
+	Components []string      // Covered components; the defaults if empty.
+	Expires    time.Duration // Adds an expires parameter when > 0.
+	fnNow      func() time.Time
+}
+
+// NewHTTPSignatureSigner creates a signer for keyId.
+func NewHTTPSignatureSigner(keyId string, privateKey ed25519.PrivateKey) (*HTTPSignatureSigner, error) {
+	s := &HTTPSignatureSigner{KeyId: keyId, PrivateKey: privateKey}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks the key and keyid.
+func (s *HTTPSignatureSigner) Validate() error {
+	if s == nil {
+		return fmt.Errorf("http signature signer is nil")
+	}
+	s.KeyId = strings.TrimSpace(s.KeyId)
+	if s.KeyId == "" || strings.ContainsAny(s.KeyId, "\"\\") {
+		return fmt.Errorf("invalid http signature keyid '%s'", s.KeyId)
+	}
+	if len(s.PrivateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key size %d", len(s.PrivateKey))
+	}
+	return nil
+}
+
+func (s *HTTPSignatureSigner) now() time.Time {
+	if s.fnNow != nil {
+		return s.fnNow()
+	}
+	return time.Now()
+}
+
+// Sign sets the Content-Digest, Signature-Input and Signature headers of req,
+// replacing any earlier signature. The body is read and restored.
+func (s *HTTPSignatureSigner) Sign(req *http.Request) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if req == nil || req.URL == nil {
+		return fmt.Errorf("http request is nil")
+	}
+	body, err := httpSigReadBody(req, 0)
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		req.Header.Set(HTTPSIG_HEADER_CONTENT_DIGEST, NewContentDigest(body))
+	}
+
+	components := s.Components
+	if len(components) == 0 {
+		components = append([]string{}, HTTPSIG_COMPONENTS_DEFAULT...)
+		if len(body) > 0 {
+			components = append(components, "content-digest")
+			if req.Header.Get("Content-Type") != "" {
+				components = append(components, "content-type")
+			}
+		}
+	}
+
+	created := s.now()
+	params := httpSigSerializeParams(components, created, s.Expires, s.KeyId)
+	base, err := httpSigBase(req, components, params)
+	if err != nil {
+		return err
+	}
+	label := s.Label
+	if label == "" {
+		label = HTTPSIG_LABEL_DEFAULT
+	}
+	sig := ed25519.Sign(s.PrivateKey, []byte(base))
+	req.Header.Set(HTTPSIG_HEADER_SIGNATURE_INPUT, label+"="+params)
+	req.Header.Set(HTTPSIG_HEADER_SIGNATURE, label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+// HTTPSignatureVerifier verifies signed incoming requests.
+type HTTPSignatureVerifier struct {
+	Keys               IHTTPSignatureKeyResolver
+	Label              string        // Signature label to verify; the first signature if empty.
+	RequiredComponents []string      // Components that must be covered; the defaults if empty.
+	MaxAge             time.Duration // Oldest accepted created time; HTTPSIG_MAX_AGE_DEFAULT if 0.
+	Leeway             time.Duration // Allowed clock skew; HTTPSIG_LEEWAY_DEFAULT if 0.
+	MaxBodyBytes       int64         // Largest body read to check the digest; HTTPSIG_MAX_BODY_DEFAULT if 0.
+	fnNow              func() time.Time
+}
+
+// NewHTTPSignatureVerifier creates a verifier with the default settings.
+func NewHTTPSignatureVerifier(keys IHTTPSignatureKeyResolver) *HTTPSignatureVerifier {
+	return &HTTPSignatureVerifier{Keys: keys}
+}
+
+func (v *HTTPSignatureVerifier) now() time.Time {
+	if v.fnNow != nil {
+		return v.fnNow()
+	}
+	return time.Now()
+}
+
+// Verify checks the signature of req and returns the keyid that signed it.
+// A request with a body must cover a matching content-digest.
+func (v *HTTPSignatureVerifier) Verify(req *http.Request) (string, error) {
+	if v == nil || v.Keys == nil {
+		return "", fmt.Errorf("http signature verifier has no keys")
+	}
+	if req == nil || req.URL == nil {
+		return "", fmt.Errorf("http request is nil")
+	}
+	inputs, err := httpSigParseInputs(strings.Join(req.Header.Values(HTTPSIG_HEADER_SIGNATURE_INPUT), ", "))
+	if err != nil {
+		return "", err
+	}
+	if len(inputs) == 0 {
+		return "", ErrHTTPSignatureMissing
+	}
+	input := inputs[0]
+	if v.Label != "" {
+		input = nil
+		for _, in := range inputs {
+			if in.label == v.Label {
+				input = in
+				break
+			}
+		}
+		if input == nil {
+			return "", fmt.Errorf("%w; label '%s'", ErrHTTPSignatureMissing, v.Label)
+		}
+	}
+	sig, err := httpSigFindSignature(strings.Join(req.Header.Values(HTTPSIG_HEADER_SIGNATURE), ", "), input.label)
+	if err != nil {
+		return "", err
+	}
+
+	required := v.RequiredComponents
+	if len(required) == 0 {
+		required = HTTPSIG_COMPONENTS_DEFAULT
+	}
+	for _, name := range required {
+		if !input.covers(name) {
+			return "", fmt.Errorf("%w; component '%s' is not covered", ErrHTTPSignatureInvalid, name)
+		}
+	}
+	if input.alg != "" && input.alg != HTTPSIG_ALG_ED25519 {
+		return "", fmt.Errorf("%w; unsupported alg '%s'", ErrHTTPSignatureInvalid, input.alg)
+	}
+	if input.keyId == "" || input.created == nil {
+		return "", fmt.Errorf("%w; keyid and created are required", ErrHTTPSignatureInvalid)
+	}
+
+	maxAge, leeway := v.MaxAge, v.Leeway
+	if maxAge <= 0 {
+		maxAge = HTTPSIG_MAX_AGE_DEFAULT
+	}
+	if leeway <= 0 {
+		leeway = HTTPSIG_LEEWAY_DEFAULT
+	}
+	now := v.now()
+	created := time.Unix(*input.created, 0)
+	if created.After(now.Add(leeway)) {
+		return "", fmt.Errorf("%w; created is in the future", ErrHTTPSignatureInvalid)
+	}
+	if now.Sub(created) > maxAge+leeway {
+		return "", ErrHTTPSignatureExpired
+	}
+	if input.expires != nil && !now.Before(time.Unix(*input.expires, 0).Add(leeway)) {
+		return "", ErrHTTPSignatureExpired
+	}
+
+	maxBody := v.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = HTTPSIG_MAX_BODY_DEFAULT
+	}
+	body, err := httpSigReadBody(req, maxBody)
+	if err != nil {
+		return "", err
+	}
+	if input.covers("content-digest") {
+		if err = VerifyContentDigest(req.Header.Get(HTTPSIG_HEADER_CONTENT_DIGEST), body); err != nil {
+			return "", err
+		}
+	} else if len(body) > 0 {
+		return "", fmt.Errorf("%w; content-digest is not covered", ErrHTTPSignatureInvalid)
+	}
+
+	pub, err := v.Keys.HTTPSignatureKey(input.keyId)
+	if err != nil {
+		return "", err
+	}
+	base, err := httpSigBase(req, input.components, input.raw)
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(pub, []byte(base), sig) {
+		return "", ErrHTTPSignatureInvalid
+	}
+	return input.keyId, nil
+}
+
+// NewContentDigest returns the sha-256 Content-Digest value of body.
+func NewContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// VerifyContentDigest checks every sha-256 and sha-512 digest in header
+// against body. At least one of them must be present.
+func VerifyContentDigest(header string, body []byte) error {
+	found := false
+	for _, member := range httpSigSplitMembers(header) {
+		name, value, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		var sum []byte
+		switch strings.TrimSpace(name) {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		digest, err := httpSigDecodeBytes(value)
+		if err != nil || subtle.ConstantTimeCompare(digest, sum) != 1 {
+			return fmt.Errorf("%w; content-digest mismatch", ErrHTTPSignatureInvalid)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%w; no supported content-digest", ErrHTTPSignatureInvalid)
+	}
+	return nil
+}
+
+// httpSigReadBody reads and restores the request body. A maxBytes above 0
+// limits the size.
+func httpSigReadBody(req *http.Request, maxBytes int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	var r io.Reader = req.Body
+	if maxBytes > 0 {
+		r = io.LimitReader(req.Body, maxBytes+1)
+	}
+	body, err := io.ReadAll(r)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body; %v", err)
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("request body exceeds %d bytes", maxBytes)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	return body, nil
+}
+
+// httpSigSerializeParams serializes the signature parameters, which are
+// also the value of the "@signature-params" line.
+func httpSigSerializeParams(components []string, created time.Time, expires time.Duration, keyId string) string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	for ii, name := range components {
+		if ii > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(strconv.Quote(strings.ToLower(name)))
+	}
+	sb.WriteString(");created=")
+	sb.WriteString(strconv.FormatInt(created.Unix(), 10))
+	if expires > 0 {
+		sb.WriteString(";expires=")
+		sb.WriteString(strconv.FormatInt(created.Add(expires).Unix(), 10))
+	}
+	sb.WriteString(`;keyid="` + keyId + `";alg="` + HTTPSIG_ALG_ED25519 + `"`)
+	return sb.String()
+}
+
+// httpSigBase builds the signature base of req.
+func httpSigBase(req *http.Request, components []string, params string) (string, error) {
+	var sb strings.Builder
+	seen := map[string]bool{}
+	for _, name := range components {
+		name = strings.ToLower(name)
+		if seen[name] {
+			return "", fmt.Errorf("%w; duplicate component '%s'", ErrHTTPSignatureInvalid, name)
+		}
+		seen[name] = true
+		value, err := httpSigComponent(req, name)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(strconv.Quote(name) + ": " + value + "\n")
+	}
+	sb.WriteString(`"@signature-params": ` + params)
+	return sb.String(), nil
+}
+
+// httpSigComponent returns the value of a derived component or header field.
+func httpSigComponent(req *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return strings.ToUpper(req.Method), nil
+	case "@authority":
+		return httpSigAuthority(req), nil
+	case "@scheme":
+		return httpSigScheme(req), nil
+	case "@path":
+		return httpSigPath(req), nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	case "@target-uri":
+		uri := httpSigScheme(req) + "://" + httpSigAuthority(req) + httpSigPath(req)
+		if req.URL.RawQuery != "" {
+			uri += "?" + req.URL.RawQuery
+		}
+		return uri, nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("%w; unsupported component '%s'", ErrHTTPSignatureInvalid, name)
+	}
+	values := req.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w; header '%s' is missing", ErrHTTPSignatureInvalid, name)
+	}
+	for ii := range values {
+		values[ii] = strings.TrimSpace(values[ii])
+	}
+	return strings.Join(values, ", "), nil
+}
+
+func httpSigAuthority(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host = strings.ToLower(host)
+	scheme := httpSigScheme(req)
+	if (scheme == "https" && strings.HasSuffix(host, ":443")) || (scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return host
+}
+
+func httpSigScheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return strings.ToLower(req.URL.Scheme)
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func httpSigPath(req *http.Request) string {
+	if path := req.URL.EscapedPath(); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// httpSigInput is one parsed Signature-Input member.
+type httpSigInput struct {
+	label      string
+	raw        string // The serialized value, used as the "@signature-params" line.
+	components []string
+	created    *int64
+	expires    *int64
+	keyId      string
+	alg        string
+}
+
+func (in *httpSigInput) covers(name string) bool {
+	for _, c := range in.components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// httpSigParseInputs parses a Signature-Input dictionary. Component
+// parameters (eg "@query-param";name="a") are not supported.
+func httpSigParseInputs(header string) ([]*httpSigInput, error) {
+	var inputs []*httpSigInput
+	for _, member := range httpSigSplitMembers(header) {
+		label, raw, ok := strings.Cut(member, "=")
+		if !ok || label == "" || !strings.HasPrefix(raw, "(") {
+			return nil, fmt.Errorf("%w; malformed signature-input", ErrHTTPSignatureInvalid)
+		}
+		end := strings.Index(raw, ")")
+		if end < 0 {
+			return nil, fmt.Errorf("%w; malformed signature-input", ErrHTTPSignatureInvalid)
+		}
+		in := &httpSigInput{label: label, raw: raw}
+		for _, item := range strings.Fields(raw[1:end]) {
+			name, err := strconv.Unquote(item)
+			if err != nil || name == "" || name != strings.ToLower(name) {
+				return nil, fmt.Errorf("%w; unsupported component %s", ErrHTTPSignatureInvalid, item)
+			}
+			in.components = append(in.components, name)
+		}
+		if rest := raw[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ";") {
+				return nil, fmt.Errorf("%w; malformed signature-input", ErrHTTPSignatureInvalid)
+			}
+			for _, param := range strings.Split(rest[1:], ";") {
+				key, value, _ := strings.Cut(param, "=")
+				switch key {
+				case "created", "expires":
+					n, err := strconv.ParseInt(value, 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("%w; invalid %s", ErrHTTPSignatureInvalid, key)
+					}
+					if key == "created" {
+						in.created = &n
+					} else {
+						in.expires = &n
+					}
+				case "keyid", "alg", "nonce", "tag":
+					s, err := strconv.Unquote(value)
+					if err != nil {
+						return nil, fmt.Errorf("%w; invalid %s", ErrHTTPSignatureInvalid, key)
+					}
+					if key == "keyid" {
+						in.keyId = s
+					} else if key == "alg" {
+						in.alg = s
+					}
+				}
+			}
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, nil
+}
+
+// httpSigFindSignature returns the signature bytes for label.
+func httpSigFindSignature(header string, label string) ([]byte, error) {
+	for _, member := range httpSigSplitMembers(header) {
+		name, value, ok := strings.Cut(member, "=")
+		if !ok || name != label {
+			continue
+		}
+		sig, err := httpSigDecodeBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w; malformed signature", ErrHTTPSignatureInvalid)
+		}
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%w; no signature for label '%s'", ErrHTTPSignatureMissing, label)
+}
+
+// httpSigDecodeBytes decodes a structured-field byte sequence (":b64:").
+func httpSigDecodeBytes(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return nil, fmt.Errorf("not a byte sequence")
+	}
+	return base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+}
+
+// httpSigSplitMembers splits a structured-field dictionary on the commas
+// that are outside quoted strings and inner lists.
+func httpSigSplitMembers(header string) []string {
+	var members []string
+	depth, quoted, escaped, start := 0, false, false, 0
+	add := func(end int) {
+		if m := strings.TrimSpace(header[start:end]); m != "" {
+			members = append(members, m)
+		}
+	}
+	for ii := 0; ii < len(header); ii++ {
+		ch := header[ii]
+		switch {
+		case escaped:
+			escaped = false
+		case quoted:
+			if ch == '\\' {
+				escaped = true
+			} else if ch == '"' {
+				quoted = false
+			}
+		case ch == '"':
+			quoted = true
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			add(ii)
+			start = ii + 1
+		}
+	}
+	add(len(header))
+	return members
+}
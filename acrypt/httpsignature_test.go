@@ -0,0 +1,166 @@
+package acrypt
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The ed25519 example (B.2.6) of RFC 9421.
+func TestHTTPSignature_RFC9421Example(t *testing.T) {
+	block, _ := pem.Decode([]byte("-----BEGIN PUBLIC KEY-----\nMCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=\n-----END PUBLIC KEY-----\n"))
+	require.NotNil(t, block)
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "18")
+	req.Header.Set(HTTPSIG_HEADER_SIGNATURE_INPUT, `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+	req.Header.Set(HTTPSIG_HEADER_SIGNATURE, `sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:`)
+
+	inputs, err := httpSigParseInputs(req.Header.Get(HTTPSIG_HEADER_SIGNATURE_INPUT))
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "test-key-ed25519", inputs[0].keyId)
+	sig, err := httpSigFindSignature(req.Header.Get(HTTPSIG_HEADER_SIGNATURE), "sig-b26")
+	require.NoError(t, err)
+	base, err := httpSigBase(req, inputs[0].components, inputs[0].raw)
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(pub.(ed25519.PublicKey), []byte(base), sig))
+}
+
+func newHTTPSignatureTestKey(t *testing.T) (*JWKSet, *HTTPSignatureSigner) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	jwk, err := NewJWK(pub, JWTALG_EDDSA, "")
+	require.NoError(t, err)
+	signer, err := NewHTTPSignatureSigner(jwk.Kid, priv)
+	require.NoError(t, err)
+	return &JWKSet{Keys: []*JWK{jwk}}, signer
+}
+
+func TestHTTPSignature_SignVerify(t *testing.T) {
+	keys, signer := newHTTPSignatureTestKey(t)
+	verifier := NewHTTPSignatureVerifier(keys)
+
+	req := httptest.NewRequest(http.MethodPost, "https://api.example.com/v1/orders?id=7", strings.NewReader(`{"qty":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	require.NoError(t, signer.Sign(req))
+	assert.Contains(t, req.Header.Get(HTTPSIG_HEADER_SIGNATURE_INPUT), `"content-digest" "content-type");created=`)
+
+	keyId, err := verifier.Verify(req)
+	require.NoError(t, err)
+	assert.Equal(t, signer.KeyId, keyId)
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"qty":1}`, string(body), "body is restored")
+
+	// A GET has no digest.
+	req = httptest.NewRequest(http.MethodGet, "https://api.example.com/v1/orders", nil)
+	require.NoError(t, signer.Sign(req))
+	assert.Empty(t, req.Header.Get(HTTPSIG_HEADER_CONTENT_DIGEST))
+	_, err = verifier.Verify(req)
+	assert.NoError(t, err)
+}
+
+func TestHTTPSignature_Tampered(t *testing.T) {
+	keys, signer := newHTTPSignatureTestKey(t)
+	verifier := NewHTTPSignatureVerifier(keys)
+
+	sign := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "https://api.example.com/v1/orders", strings.NewReader(`{"qty":1}`))
+		require.NoError(t, signer.Sign(req))
+		return req
+	}
+
+	req := sign()
+	req.Body = io.NopCloser(strings.NewReader(`{"qty":9}`))
+	_, err := verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+
+	req = sign()
+	req.URL.Path = "/v1/admin"
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+
+	req = sign()
+	req.Method = http.MethodPut
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+
+	req = sign()
+	req.Header.Del(HTTPSIG_HEADER_SIGNATURE)
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureMissing), err)
+
+	_, err = verifier.Verify(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.True(t, errors.Is(err, ErrHTTPSignatureMissing), err)
+
+	// A body must be covered by a digest.
+	signer.Components = HTTPSIG_COMPONENTS_DEFAULT
+	req = sign()
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+	signer.Components = nil
+
+	// Unknown key.
+	otherKeys, _ := newHTTPSignatureTestKey(t)
+	_, err = NewHTTPSignatureVerifier(otherKeys).Verify(sign())
+	assert.True(t, errors.Is(err, ErrHTTPSignatureKeyNotFound), err)
+
+	// A required component must be covered.
+	verifier.RequiredComponents = []string{"@method", "x-tenant"}
+	_, err = verifier.Verify(sign())
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+}
+
+func TestHTTPSignature_Expiry(t *testing.T) {
+	keys, signer := newHTTPSignatureTestKey(t)
+	now := time.Unix(1700000000, 0)
+	signer.fnNow = func() time.Time { return now }
+	signer.Expires = time.Minute
+	verifier := NewHTTPSignatureVerifier(keys)
+
+	req := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	require.NoError(t, signer.Sign(req))
+
+	verifier.fnNow = func() time.Time { return now.Add(30 * time.Second) }
+	_, err := verifier.Verify(req)
+	assert.NoError(t, err)
+
+	verifier.fnNow = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureExpired), err)
+
+	signer.Expires = 0
+	require.NoError(t, signer.Sign(req))
+	verifier.fnNow = func() time.Time { return now.Add(HTTPSIG_MAX_AGE_DEFAULT + time.Minute) }
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureExpired), err)
+
+	verifier.fnNow = func() time.Time { return now.Add(-time.Hour) }
+	_, err = verifier.Verify(req)
+	assert.True(t, errors.Is(err, ErrHTTPSignatureInvalid), err)
+}
+
+func TestHTTPSignature_ContentDigest(t *testing.T) {
+	body := []byte(`{"hello": "world"}`)
+	// The sha-256 example of RFC 9530.
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", NewContentDigest(body))
+	assert.NoError(t, VerifyContentDigest(NewContentDigest(body), body))
+	assert.NoError(t, VerifyContentDigest("sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:", body))
+	assert.Error(t, VerifyContentDigest(NewContentDigest([]byte("other")), body))
+	assert.Error(t, VerifyContentDigest("md5=:"+base64.StdEncoding.EncodeToString([]byte("x"))+":", body))
+}
@@ -0,0 +1,179 @@
+package acrypt
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JWKS_REMOTE_CACHE_TTL      = time.Hour
+	JWKS_REMOTE_MIN_REFRESH    = 30 * time.Second
+	JWKS_REMOTE_TIMEOUT        = 10 * time.Second
+	jwksRemoteMaxResponseBytes = 1 << 20
+)
+
+// JWKSRemoteOptions configures a JWKSRemote. Zero values use the defaults.
+type JWKSRemoteOptions struct {
+	Client             *http.Client
+	CacheTTL           time.Duration // Used when the response has no Cache-Control max-age.
+	MinRefreshInterval time.Duration // Limits refetches triggered by unknown kids.
+}
+
+// JWKSRemote verifies JWTs against a remote JWKS document. Keys are cached
+// for the response max-age (or CacheTTL). A token with an unknown kid
+// triggers a refetch, at most once per MinRefreshInterval, so keys rotated
+// in by the issuer are picked up without waiting for the cache to expire.
+// If a refetch fails, the cached keys remain in use.
+type JWKSRemote struct {
+	url         string
+	opts        JWKSRemoteOptions
+	keys        map[string]crypto.PublicKey
+	algs        map[string]string
+	expiresAt   time.Time
+	lastAttempt time.Time
+	fnNow       func() time.Time
+	mu          sync.Mutex
+}
+
+// NewJWKSRemote creates a verifier for the JWKS at url. Keys are fetched on first use.
+func NewJWKSRemote(url string, opts *JWKSRemoteOptions) (*JWKSRemote, error) {
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("invalid jwks url '%s'", url)
+	}
+	jr := &JWKSRemote{url: url}
+	if opts != nil {
+		jr.opts = *opts
+	}
+	if jr.opts.Client == nil {
+		jr.opts.Client = &http.Client{Timeout: JWKS_REMOTE_TIMEOUT}
+	}
+	if jr.opts.CacheTTL <= 0 {
+		jr.opts.CacheTTL = JWKS_REMOTE_CACHE_TTL
+	}
+	if jr.opts.MinRefreshInterval <= 0 {
+		jr.opts.MinRefreshInterval = JWKS_REMOTE_MIN_REFRESH
+	}
+	return jr, nil
+}
+
+func (jr *JWKSRemote) now() time.Time {
+	if jr.fnNow != nil {
+		return jr.fnNow()
+	}
+	return time.Now()
+}
+
+// Refresh fetches the JWKS now.
+func (jr *JWKSRemote) Refresh(ctx context.Context) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	return jr.refresh(ctx)
+}
+
+func (jr *JWKSRemote) refresh(ctx context.Context) error {
+	jr.lastAttempt = jr.now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jr.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create jwks request; %v", err)
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	resp, err := jr.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch jwks; %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch jwks; status %d", resp.StatusCode)
+	}
+
+	set := &JWKSet{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, jwksRemoteMaxResponseBytes)).Decode(set); err != nil {
+		return fmt.Errorf("failed to decode jwks; %v", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	algs := map[string]string{}
+	for _, jwk := range set.Keys {
+		alg := jwk.GetAlg()
+		if jwk.Kid == "" || (jwk.Use != "" && jwk.Use != "sig") || !alg.IsValid() {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue // Skip keys we cannot use rather than failing the whole set.
+		}
+		keys[jwk.Kid] = pub
+		algs[jwk.Kid] = alg.String()
+	}
+
+	jr.keys, jr.algs = keys, algs
+	jr.expiresAt = jr.now().Add(cacheMaxAge(resp.Header.Get("Cache-Control"), jr.opts.CacheTTL))
+	return nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header or def.
+func cacheMaxAge(header string, def time.Duration) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if secs, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return def
+}
+
+// key returns the public key and alg for kid, refreshing when the cache has
+// expired or the kid is unknown.
+func (jr *JWKSRemote) key(kid string) (crypto.PublicKey, string, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	now := jr.now()
+	_, known := jr.keys[kid]
+	canRefresh := jr.lastAttempt.IsZero() || now.Sub(jr.lastAttempt) >= jr.opts.MinRefreshInterval
+	if (jr.keys == nil || !now.Before(jr.expiresAt) || !known) && canRefresh {
+		ctx, cancel := context.WithTimeout(context.Background(), JWKS_REMOTE_TIMEOUT)
+		err := jr.refresh(ctx)
+		cancel()
+		if err != nil && jr.keys == nil {
+			return nil, "", err
+		}
+	}
+	pub, ok := jr.keys[kid]
+	if !ok {
+		return nil, "", fmt.Errorf("%w; kid '%s'", ErrJWTKeyNotFound, kid)
+	}
+	return pub, jr.algs[kid], nil
+}
+
+// Verify parses tokenString into claims with the remote key named by the
+// kid header. The token alg must match the key alg.
+func (jr *JWKSRemote) Verify(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("%w; token has no kid", ErrJWTKeyNotFound)
+		}
+		pub, alg, err := jr.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method '%s' for kid '%s'", token.Method.Alg(), kid)
+		}
+		return pub, nil
+	}, append([]jwt.ParserOption{jwt.WithValidMethods(jwtValidMethods)}, opts...)...)
+}
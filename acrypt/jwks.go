@@ -0,0 +1,206 @@
+package acrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWTAlg is a JWS signing algorithm supported by JWTKeyring.
+type JWTAlg string
+
+const (
+	JWTALG_ES256 JWTAlg = "ES256" // ECDSA P-256 with SHA-256; FIPS-approved (default).
+	JWTALG_EDDSA JWTAlg = "EdDSA" // Ed25519.
+	JWTALG_RS256 JWTAlg = "RS256" // RSA PKCS#1 v1.5 with SHA-256, 2048-bit keys.
+)
+
+// IsEmpty returns true if the algorithm is not set.
+func (a JWTAlg) IsEmpty() bool {
+	return a == ""
+}
+
+// IsValid returns true if the algorithm is supported.
+func (a JWTAlg) IsValid() bool {
+	return a == JWTALG_ES256 || a == JWTALG_EDDSA || a == JWTALG_RS256
+}
+
+// String returns the algorithm name.
+func (a JWTAlg) String() string {
+	return string(a)
+}
+
+// JWK is a public JSON Web Key (RFC 7517) for ES256, EdDSA or RS256.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// Find returns the key with kid or nil.
+func (s *JWKSet) Find(kid string) *JWK {
+	if s == nil {
+		return nil
+	}
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+var b64url = base64.RawURLEncoding
+
+// NewJWK converts a public key to a JWK. An empty kid is replaced by the
+// RFC 7638 thumbprint.
+func NewJWK(pub crypto.PublicKey, alg JWTAlg, kid string) (*JWK, error) {
+	jwk := &JWK{Use: "sig", Alg: alg.String()}
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if alg != JWTALG_ES256 || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ecdsa key requires ES256 and P-256")
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", "P-256"
+		jwk.X = b64url.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64url.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		if alg != JWTALG_EDDSA {
+			return nil, fmt.Errorf("ed25519 key requires EdDSA")
+		}
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = b64url.EncodeToString(k)
+	case *rsa.PublicKey:
+		if alg != JWTALG_RS256 {
+			return nil, fmt.Errorf("rsa key requires RS256")
+		}
+		jwk.Kty = "RSA"
+		jwk.N = b64url.EncodeToString(k.N.Bytes())
+		jwk.E = b64url.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	jwk.Kid = kid
+	if jwk.Kid == "" {
+		var err error
+		if jwk.Kid, err = jwk.Thumbprint(); err != nil {
+			return nil, err
+		}
+	}
+	return jwk, nil
+}
+
+// GetAlg returns the alg of the key. alg is optional in RFC 7517, so when
+// it is missing it is inferred from kty and crv: RSA is RS256, EC P-256 is
+// ES256 and OKP Ed25519 is EdDSA. An alg that does not fit the key type is
+// returned empty.
+func (j *JWK) GetAlg() JWTAlg {
+	var alg JWTAlg
+	switch {
+	case j.Kty == "RSA":
+		alg = JWTALG_RS256
+	case j.Kty == "EC" && j.Crv == "P-256":
+		alg = JWTALG_ES256
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		alg = JWTALG_EDDSA
+	}
+	if j.Alg != "" && JWTAlg(j.Alg) != alg {
+		return ""
+	}
+	return alg
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint, base64url encoded.
+func (j *JWK) Thumbprint() (string, error) {
+	// Required members only, in lexicographic order.
+	var members interface{}
+	switch j.Kty {
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	default:
+		return "", fmt.Errorf("unsupported jwk kty '%s'", j.Kty)
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwk; %v", err)
+	}
+	sum := sha256.Sum256(b)
+	return b64url.EncodeToString(sum[:]), nil
+}
+
+// PublicKey parses the JWK into an *ecdsa.PublicKey, ed25519.PublicKey or *rsa.PublicKey.
+func (j *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported jwk curve '%s'", j.Crv)
+		}
+		x, errX := b64url.DecodeString(j.X)
+		y, errY := b64url.DecodeString(j.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid jwk ec coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("jwk point is not on the curve")
+		}
+		return pub, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported jwk curve '%s'", j.Crv)
+		}
+		x, err := b64url.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid jwk ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "RSA":
+		n, errN := b64url.DecodeString(j.N)
+		e, errE := b64url.DecodeString(j.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid jwk rsa key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("jwk rsa key is smaller than 2048 bits")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported jwk kty '%s'", j.Kty)
+}
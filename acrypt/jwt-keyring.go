@@ -0,0 +1,363 @@
+package acrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrJWTKeyNotFound = errors.New("jwt signing key not found")
+
+// IJWTVerifier verifies tokens signed by keys it can resolve by kid.
+type IJWTVerifier interface {
+	Verify(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error)
+}
+
+// IJWKSPublisher returns the public keys to publish at a JWKS endpoint.
+type IJWKSPublisher interface {
+	JWKS() *JWKSet
+}
+
+// JWTSigningKey is an asymmetric JWT signing key. The private key is
+// serialized as PKCS#8 PEM, so persist keyrings encrypted (eg as a secret).
+type JWTSigningKey struct {
+	Kid       string     `json:"kid"`
+	Alg       JWTAlg     `json:"alg"`
+	Key       string     `json:"key"` // PKCS#8 PEM private key.
+	CreatedAt time.Time  `json:"createdAt"`
+	RetireAt  *time.Time `json:"retireAt,omitempty"` // When set, the key stops verifying and is unpublished after this time.
+	signer    crypto.Signer
+}
+
+// NewJWTSigningKey generates a key for alg. The kid is the RFC 7638 thumbprint.
+func NewJWTSigningKey(alg JWTAlg) (*JWTSigningKey, error) {
+	if alg.IsEmpty() {
+		alg = JWTALG_ES256
+	}
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case JWTALG_ES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case JWTALG_EDDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case JWTALG_RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported jwt alg '%s'", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key; %v", alg, err)
+	}
+	return NewJWTSigningKeyFromSigner(alg, signer)
+}
+
+// NewJWTSigningKeyFromSigner wraps an existing private key.
+func NewJWTSigningKeyFromSigner(alg JWTAlg, signer crypto.Signer) (*JWTSigningKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key; %v", err)
+	}
+	key := &JWTSigningKey{
+		Alg:       alg,
+		Key:       string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt: time.Now().UTC(),
+		signer:    signer,
+	}
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Validate parses the private key and sets the kid if empty.
+func (k *JWTSigningKey) Validate() error {
+	if !k.Alg.IsValid() {
+		return fmt.Errorf("unsupported jwt alg '%s'", k.Alg)
+	}
+	if k.signer == nil {
+		block, _ := pem.Decode([]byte(k.Key))
+		if block == nil {
+			return fmt.Errorf("failed to decode jwt key PEM")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse jwt key; %v", err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("jwt key is not a signer")
+		}
+		k.signer = signer
+	}
+	jwk, err := NewJWK(k.signer.Public(), k.Alg, k.Kid)
+	if err != nil {
+		return err
+	}
+	k.Kid = jwk.Kid
+	return nil
+}
+
+// PublicJWK returns the public key as a JWK.
+func (k *JWTSigningKey) PublicJWK() (*JWK, error) {
+	if k.signer == nil {
+		return nil, fmt.Errorf("jwt key is not validated")
+	}
+	return NewJWK(k.signer.Public(), k.Alg, k.Kid)
+}
+
+// IsRetired returns true if the key is past its RetireAt.
+func (k *JWTSigningKey) IsRetired(now time.Time) bool {
+	return k.RetireAt != nil && !now.Before(*k.RetireAt)
+}
+
+// jwtSigningMethod returns the golang-jwt method for alg.
+func jwtSigningMethod(alg JWTAlg) jwt.SigningMethod {
+	switch alg {
+	case JWTALG_ES256:
+		return jwt.SigningMethodES256
+	case JWTALG_EDDSA:
+		return jwt.SigningMethodEdDSA
+	case JWTALG_RS256:
+		return jwt.SigningMethodRS256
+	}
+	return nil
+}
+
+// JWTKeyring signs JWTs with its active key and verifies them with any key
+// that has not retired, selected by the kid header. Rotate replaces the
+// active key and keeps the old one for OverlapHours, which should cover the
+// longest token lifetime plus the JWKS cache time of remote verifiers.
+type JWTKeyring struct {
+	Alg          JWTAlg           `json:"alg"`
+	RotateHours  int              `json:"rotateHours,omitempty"`  // Rotate the active key after this age; 0 disables RotateIfDue.
+	OverlapHours int              `json:"overlapHours,omitempty"` // Keep rotated keys this long.
+	ActiveKid    string           `json:"activeKid"`
+	Keys         []*JWTSigningKey `json:"keys"`
+	fnNow        func() time.Time
+	mu           sync.RWMutex
+}
+
+// NewJWTKeyring creates a keyring with a new active key.
+func NewJWTKeyring(alg JWTAlg, rotateHours, overlapHours int) (*JWTKeyring, error) {
+	kr := &JWTKeyring{Alg: alg, RotateHours: rotateHours, OverlapHours: overlapHours}
+	if kr.Alg.IsEmpty() {
+		kr.Alg = JWTALG_ES256
+	}
+	if _, err := kr.Rotate(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+func (kr *JWTKeyring) now() time.Time {
+	if kr.fnNow != nil {
+		return kr.fnNow()
+	}
+	return time.Now().UTC()
+}
+
+// Validate parses every key, eg after loading the keyring from JSON.
+func (kr *JWTKeyring) Validate() error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if !kr.Alg.IsValid() {
+		return fmt.Errorf("unsupported jwt alg '%s'", kr.Alg)
+	}
+	for _, key := range kr.Keys {
+		if err := key.Validate(); err != nil {
+			return err
+		}
+	}
+	if kr.find(kr.ActiveKid) == nil {
+		return fmt.Errorf("%w; active kid '%s'", ErrJWTKeyNotFound, kr.ActiveKid)
+	}
+	return nil
+}
+
+func (kr *JWTKeyring) find(kid string) *JWTSigningKey {
+	for _, key := range kr.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Find returns the key with kid or nil.
+func (kr *JWTKeyring) Find(kid string) *JWTSigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.find(kid)
+}
+
+// Active returns the signing key.
+func (kr *JWTKeyring) Active() *JWTSigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.find(kr.ActiveKid)
+}
+
+// Clone returns a copy of the keyring that can be rotated without
+// affecting kr, eg to save it before kr starts signing with the new key.
+// The copies share the parsed private keys.
+func (kr *JWTKeyring) Clone() *JWTKeyring {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	c := &JWTKeyring{
+		Alg:          kr.Alg,
+		RotateHours:  kr.RotateHours,
+		OverlapHours: kr.OverlapHours,
+		ActiveKid:    kr.ActiveKid,
+		Keys:         make([]*JWTSigningKey, 0, len(kr.Keys)),
+		fnNow:        kr.fnNow,
+	}
+	for _, key := range kr.Keys {
+		k := *key
+		if key.RetireAt != nil {
+			retireAt := *key.RetireAt
+			k.RetireAt = &retireAt
+		}
+		c.Keys = append(c.Keys, &k)
+	}
+	return c
+}
+
+// Set replaces the settings and keys of kr with those of from.
+func (kr *JWTKeyring) Set(from *JWTKeyring) {
+	if from == nil || from == kr {
+		return
+	}
+	from.mu.RLock()
+	defer from.mu.RUnlock()
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.Alg = from.Alg
+	kr.RotateHours = from.RotateHours
+	kr.OverlapHours = from.OverlapHours
+	kr.ActiveKid = from.ActiveKid
+	kr.Keys = append([]*JWTSigningKey{}, from.Keys...)
+}
+
+// Rotate generates a new active key. The previous key retires after
+// OverlapHours and expired keys are pruned.
+func (kr *JWTKeyring) Rotate() (*JWTSigningKey, error) {
+	key, err := NewJWTSigningKey(kr.Alg)
+	if err != nil {
+		return nil, err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	now := kr.now()
+	key.CreatedAt = now
+	if prev := kr.find(kr.ActiveKid); prev != nil {
+		retireAt := now.Add(time.Duration(kr.OverlapHours) * time.Hour)
+		prev.RetireAt = &retireAt
+	}
+	kr.Keys = append(kr.Keys, key)
+	kr.ActiveKid = key.Kid
+	kr.prune(now)
+	return key, nil
+}
+
+// RotateIfDue rotates when the active key is older than RotateHours. It is
+// meant to be called on a schedule (eg by an acron task).
+func (kr *JWTKeyring) RotateIfDue() (bool, error) {
+	kr.mu.RLock()
+	active := kr.find(kr.ActiveKid)
+	due := kr.RotateHours > 0 && (active == nil || !kr.now().Before(active.CreatedAt.Add(time.Duration(kr.RotateHours)*time.Hour)))
+	kr.mu.RUnlock()
+	if !due {
+		kr.Prune()
+		return false, nil
+	}
+	_, err := kr.Rotate()
+	return err == nil, err
+}
+
+// Prune removes retired keys and returns how many were removed.
+func (kr *JWTKeyring) Prune() int {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return kr.prune(kr.now())
+}
+
+func (kr *JWTKeyring) prune(now time.Time) int {
+	keys := make([]*JWTSigningKey, 0, len(kr.Keys))
+	for _, key := range kr.Keys {
+		if key.Kid == kr.ActiveKid || !key.IsRetired(now) {
+			keys = append(keys, key)
+		}
+	}
+	removed := len(kr.Keys) - len(keys)
+	kr.Keys = keys
+	return removed
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (kr *JWTKeyring) Sign(claims jwt.Claims) (string, error) {
+	key := kr.Active()
+	if key == nil || key.signer == nil {
+		return "", fmt.Errorf("%w; no active key", ErrJWTKeyNotFound)
+	}
+	token := jwt.NewWithClaims(jwtSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.Kid
+	signed, err := token.SignedString(key.signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt; %v", err)
+	}
+	return signed, nil
+}
+
+// Verify parses tokenString into claims, selecting the key by kid. The
+// token alg must match the key alg.
+func (kr *JWTKeyring) Verify(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		kr.mu.RLock()
+		key := kr.find(kid)
+		kr.mu.RUnlock()
+		if key == nil || key.signer == nil || key.IsRetired(kr.now()) {
+			return nil, fmt.Errorf("%w; kid '%s'", ErrJWTKeyNotFound, kid)
+		}
+		if token.Method.Alg() != key.Alg.String() {
+			return nil, fmt.Errorf("unexpected signing method '%s' for kid '%s'", token.Method.Alg(), kid)
+		}
+		return key.signer.Public(), nil
+	}, append([]jwt.ParserOption{jwt.WithValidMethods(jwtValidMethods)}, opts...)...)
+}
+
+var jwtValidMethods = []string{JWTALG_ES256.String(), JWTALG_EDDSA.String(), JWTALG_RS256.String()}
+
+// JWKS returns the public keys that have not retired, newest first.
+func (kr *JWTKeyring) JWKS() *JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	now := kr.now()
+	keys := make([]*JWTSigningKey, 0, len(kr.Keys))
+	for _, key := range kr.Keys {
+		if key.signer != nil && !key.IsRetired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+
+	set := &JWKSet{Keys: []*JWK{}}
+	for _, key := range keys {
+		if jwk, err := key.PublicJWK(); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
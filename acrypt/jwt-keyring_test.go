@@ -0,0 +1,206 @@
+package acrypt
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWTKeyringSignVerify(t *testing.T) {
+	for _, alg := range []JWTAlg{JWTALG_ES256, JWTALG_EDDSA, JWTALG_RS256} {
+		kr, err := NewJWTKeyring(alg, 24, 2)
+		assert.NoError(t, err)
+
+		signed, err := kr.Sign(jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+		assert.NoError(t, err)
+		claims := &jwt.RegisteredClaims{}
+		token, err := kr.Verify(signed, claims)
+		assert.NoError(t, err, alg)
+		assert.Equal(t, "alice", claims.Subject)
+		assert.Equal(t, kr.ActiveKid, token.Header["kid"])
+
+		// The JWK round-trips to the same key and thumbprint.
+		jwk := kr.JWKS().Find(kr.ActiveKid)
+		assert.NotNil(t, jwk)
+		assert.Equal(t, alg.String(), jwk.Alg)
+		thumb, err := jwk.Thumbprint()
+		assert.NoError(t, err)
+		assert.Equal(t, kr.ActiveKid, thumb)
+		_, err = jwk.PublicKey()
+		assert.NoError(t, err)
+	}
+
+	// An HMAC token using the public key bytes as the secret must not verify.
+	kr, _ := NewJWTKeyring(JWTALG_EDDSA, 0, 0)
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "mallory"})
+	hs.Header["kid"] = kr.ActiveKid
+	forged, err := hs.SignedString([]byte(kr.Active().signer.Public().(ed25519.PublicKey)))
+	assert.NoError(t, err)
+	_, err = kr.Verify(forged, &jwt.RegisteredClaims{})
+	assert.True(t, errors.Is(err, jwt.ErrTokenSignatureInvalid), "%v", err)
+}
+
+func TestJWTKeyringRotation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr := &JWTKeyring{Alg: JWTALG_ES256, RotateHours: 24, OverlapHours: 2, fnNow: func() time.Time { return now }}
+	_, err := kr.Rotate()
+	assert.NoError(t, err)
+	oldKid := kr.ActiveKid
+	oldToken, err := kr.Sign(jwt.RegisteredClaims{Subject: "old"})
+	assert.NoError(t, err)
+
+	now = now.Add(23 * time.Hour)
+	rotated, err := kr.RotateIfDue()
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+
+	now = now.Add(time.Hour)
+	rotated, err = kr.RotateIfDue()
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	assert.NotEqual(t, oldKid, kr.ActiveKid)
+
+	// During the overlap both keys are published and old tokens verify.
+	assert.Len(t, kr.JWKS().Keys, 2)
+	assert.Equal(t, kr.ActiveKid, kr.JWKS().Keys[0].Kid)
+	_, err = kr.Verify(oldToken, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = kr.Verify(oldToken, &jwt.RegisteredClaims{})
+	assert.True(t, errors.Is(err, ErrJWTKeyNotFound), "%v", err)
+	assert.Len(t, kr.JWKS().Keys, 1)
+	assert.Equal(t, 1, kr.Prune())
+
+	// The keyring persists and reloads.
+	b, err := json.Marshal(kr)
+	assert.NoError(t, err)
+	loaded := &JWTKeyring{}
+	assert.NoError(t, json.Unmarshal(b, loaded))
+	assert.NoError(t, loaded.Validate())
+	signed, err := loaded.Sign(jwt.RegisteredClaims{Subject: "reloaded"})
+	assert.NoError(t, err)
+	_, err = kr.Verify(signed, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+}
+
+func TestJWKSRemote(t *testing.T) {
+	kr, err := NewJWTKeyring(JWTALG_ES256, 0, 1)
+	assert.NoError(t, err)
+	var fetches int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		_ = json.NewEncoder(w).Encode(kr.JWKS())
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	remote, err := NewJWKSRemote(srv.URL, &JWKSRemoteOptions{MinRefreshInterval: time.Minute})
+	assert.NoError(t, err)
+	remote.fnNow = func() time.Time { return now }
+
+	signed, _ := kr.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	claims := &jwt.RegisteredClaims{}
+	_, err = remote.Verify(signed, claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "second verify should use the cache")
+
+	// A new kid right after a fetch is rate limited, then picked up.
+	_, err = kr.Rotate()
+	assert.NoError(t, err)
+	signed, _ = kr.Sign(jwt.RegisteredClaims{Subject: "bob"})
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.True(t, errors.Is(err, ErrJWTKeyNotFound), "%v", err)
+	now = now.Add(time.Minute)
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// After max-age the set is refetched; failures keep the cached keys.
+	failing.Store(true)
+	now = now.Add(11 * time.Minute)
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+
+	// Tokens from another issuer are rejected.
+	other, _ := NewJWTKeyring(JWTALG_ES256, 0, 0)
+	signed, _ = other.Sign(jwt.RegisteredClaims{Subject: "eve"})
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+
+	_, err = NewJWKSRemote("ftp://example.com/jwks", nil)
+	assert.Error(t, err)
+}
+
+func TestJWKSRemote_NoAlg(t *testing.T) {
+	krs := map[JWTAlg]*JWTKeyring{}
+	set := &JWKSet{}
+	for _, alg := range []JWTAlg{JWTALG_ES256, JWTALG_EDDSA, JWTALG_RS256} {
+		kr, err := NewJWTKeyring(alg, 0, 1)
+		assert.NoError(t, err)
+		krs[alg] = kr
+		for _, jwk := range kr.JWKS().Keys {
+			jwk.Alg = "" // Many IdPs leave alg out.
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	defer srv.Close()
+
+	remote, err := NewJWKSRemote(srv.URL, nil)
+	assert.NoError(t, err)
+	for alg, kr := range krs {
+		signed, _ := kr.Sign(jwt.RegisteredClaims{Subject: alg.String()})
+		claims := &jwt.RegisteredClaims{}
+		_, err = remote.Verify(signed, claims)
+		assert.NoError(t, err, alg)
+		assert.Equal(t, alg.String(), claims.Subject)
+	}
+
+	// The inferred alg must still match the token alg.
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{})
+	hs.Header["kid"] = krs[JWTALG_RS256].Active().Kid
+	signed, _ := hs.SignedString([]byte("secret"))
+	_, err = remote.Verify(signed, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+
+	assert.Equal(t, JWTALG_ES256, (&JWK{Kty: "EC", Crv: "P-256"}).GetAlg())
+	assert.Equal(t, JWTAlg(""), (&JWK{Kty: "EC", Crv: "P-384"}).GetAlg())
+	assert.Equal(t, JWTAlg(""), (&JWK{Kty: "RSA", Alg: "ES256"}).GetAlg())
+}
+
+func TestJWTKeyringCloneSet(t *testing.T) {
+	kr, err := NewJWTKeyring(JWTALG_ES256, 1, 1)
+	assert.NoError(t, err)
+	activeKid := kr.Active().Kid
+
+	next := kr.Clone()
+	_, err = next.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, activeKid, kr.Active().Kid, "rotating a clone must not change the original")
+	assert.Nil(t, kr.Find(activeKid).RetireAt)
+
+	kr.Set(next)
+	assert.Equal(t, next.Active().Kid, kr.Active().Kid)
+	assert.NotNil(t, kr.Find(activeKid).RetireAt)
+	assert.Len(t, kr.JWKS().Keys, 2)
+}
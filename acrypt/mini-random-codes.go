@@ -1,9 +1,9 @@
 package acrypt
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
-	"time"
+	"math/big"
 )
 
 // Adapted from:
@@ -59,12 +59,17 @@ func generateRandomCodes(codes *MiniRandomCodes, count int, length int, divider
 		charset = defaultCharset
 	}
 
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Codes may be used as credentials (eg backup codes), so use crypto/rand.
+	max := big.NewInt(int64(len(charset)))
 	code := make([]byte, length)
 
 	for i := 0; i < count; i++ {
 		for j := range code {
-			code[j] = charset[seededRand.Intn(len(charset))]
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return fmt.Errorf("failed to generate random code; %v", err)
+			}
+			code[j] = charset[n.Int64()]
 		}
 
 		if divider != "" && length > 2 {
@@ -0,0 +1,263 @@
+package acrypt
+
+import (
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrOTPReplayed = errors.New("otp code already used")
+
+const (
+	OTP_DIGITS_DEFAULT         = 6
+	TOTP_PERIOD_DEFAULT        = 30 // Seconds.
+	TOTP_SKEW_DEFAULT          = 1  // Steps accepted either side of the current step.
+	TOTP_RESYNC_WINDOW_DEFAULT = 10 // Steps searched either side by Resync.
+	HOTP_LOOKAHEAD_DEFAULT     = 10 // Counters searched ahead by HOTP.Validate.
+)
+
+// OTPAlgorithm is the HMAC hash of an OTP (RFC 4226 / RFC 6238).
+type OTPAlgorithm string
+
+const (
+	OTPALG_SHA1   OTPAlgorithm = "SHA1"
+	OTPALG_SHA256 OTPAlgorithm = "SHA256"
+	OTPALG_SHA512 OTPAlgorithm = "SHA512"
+)
+
+// IsEmpty returns true if the algorithm is not set.
+func (alg OTPAlgorithm) IsEmpty() bool {
+	return strings.TrimSpace(string(alg)) == ""
+}
+
+// IsValid returns true if the algorithm is supported. Empty is valid and means SHA1.
+func (alg OTPAlgorithm) IsValid() bool {
+	switch alg {
+	case "", OTPALG_SHA1, OTPALG_SHA256, OTPALG_SHA512:
+		return true
+	}
+	return false
+}
+
+// String returns the algorithm name, defaulting to SHA1.
+func (alg OTPAlgorithm) String() string {
+	if alg.IsEmpty() {
+		return string(OTPALG_SHA1)
+	}
+	return string(alg)
+}
+
+func (alg OTPAlgorithm) otp() otp.Algorithm {
+	switch alg {
+	case OTPALG_SHA256:
+		return otp.AlgorithmSHA256
+	case OTPALG_SHA512:
+		return otp.AlgorithmSHA512
+	}
+	return otp.AlgorithmSHA1
+}
+
+// otpDigits returns digits or the default.
+func otpDigits(digits int) int {
+	if digits <= 0 {
+		return OTP_DIGITS_DEFAULT
+	}
+	return digits
+}
+
+// OTPGenerateCode returns the RFC 4226 code of secret (base32) for counter.
+func OTPGenerateCode(secret string, counter uint64, digits int, alg OTPAlgorithm) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("otp secret is empty")
+	}
+	if digits = otpDigits(digits); digits != 6 && digits != 8 {
+		return "", fmt.Errorf("unsupported otp digits %d", digits)
+	}
+	if !alg.IsValid() {
+		return "", fmt.Errorf("unsupported otp algorithm '%s'", alg)
+	}
+	return hotp.GenerateCodeCustom(secret, counter, hotp.ValidateOpts{Digits: otp.Digits(digits), Algorithm: alg.otp()})
+}
+
+// otpMatch compares a submitted code in constant time.
+func otpMatch(secret string, counter uint64, digits int, alg OTPAlgorithm, code string) (bool, error) {
+	expected, err := OTPGenerateCode(secret, counter, digits, alg)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.TrimSpace(code))) == 1, nil
+}
+
+// HOTP is an RFC 4226 counter-based one-time password. Counter is the next
+// expected counter and advances past each accepted code, so persist it.
+type HOTP struct {
+	Secret    string       `json:"secret,omitempty"`
+	Counter   uint64       `json:"counter"`
+	Digits    int          `json:"digits,omitempty"`
+	Algorithm OTPAlgorithm `json:"algorithm,omitempty"`
+	Lookahead int          `json:"lookahead,omitempty"` // Counters searched ahead for tokens pressed without logging in; 0 uses HOTP_LOOKAHEAD_DEFAULT.
+}
+
+// HasSecret checks if the HOTP has a secret set.
+func (ho *HOTP) HasSecret() bool {
+	return ho != nil && ho.Secret != ""
+}
+
+// Validate accepts code if it matches Counter or one of the next Lookahead
+// counters, then moves Counter past the match so the code cannot be reused.
+func (ho *HOTP) Validate(code string) (bool, error) {
+	if !ho.HasSecret() || strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+	lookahead := ho.Lookahead
+	if lookahead <= 0 {
+		lookahead = HOTP_LOOKAHEAD_DEFAULT
+	}
+	for ii := 0; ii <= lookahead; ii++ {
+		ok, err := otpMatch(ho.Secret, ho.Counter+uint64(ii), ho.Digits, ho.Algorithm, code)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			ho.Counter += uint64(ii) + 1
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OTPAuthKey is a parsed otpauth:// URI, the format of authenticator QR codes.
+type OTPAuthKey struct {
+	Type      string       `json:"type"` // "totp" or "hotp"
+	Issuer    string       `json:"issuer,omitempty"`
+	Account   string       `json:"account,omitempty"`
+	Secret    string       `json:"secret"`
+	Algorithm OTPAlgorithm `json:"algorithm,omitempty"`
+	Digits    int          `json:"digits,omitempty"`
+	Period    int          `json:"period,omitempty"`  // TOTP only.
+	Counter   uint64       `json:"counter,omitempty"` // HOTP only.
+}
+
+// ParseOTPAuthURI parses an otpauth:// URI, eg to migrate secrets exported
+// from another system. Defaults are applied for missing parameters.
+func ParseOTPAuthURI(uri string) (*OTPAuthKey, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse otpauth uri; %v", err)
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("invalid otpauth uri scheme '%s'", u.Scheme)
+	}
+	key := &OTPAuthKey{Type: strings.ToLower(u.Host)}
+	if key.Type != "totp" && key.Type != "hotp" {
+		return nil, fmt.Errorf("invalid otpauth type '%s'", u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		key.Issuer, key.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		key.Account = strings.TrimSpace(label)
+	}
+	q := u.Query()
+	if issuer := strings.TrimSpace(q.Get("issuer")); issuer != "" {
+		key.Issuer = issuer // The parameter takes precedence over the label prefix.
+	}
+
+	key.Secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(q.Get("secret"), " ", ""), "="))
+	if key.Secret == "" {
+		return nil, fmt.Errorf("otpauth uri has no secret")
+	}
+	if _, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(key.Secret); err != nil {
+		return nil, fmt.Errorf("invalid otpauth secret; %v", err)
+	}
+
+	key.Algorithm = OTPAlgorithm(strings.ToUpper(q.Get("algorithm")))
+	if key.Algorithm.IsEmpty() {
+		key.Algorithm = OTPALG_SHA1
+	}
+	if !key.Algorithm.IsValid() {
+		return nil, fmt.Errorf("unsupported otpauth algorithm '%s'", key.Algorithm)
+	}
+	if key.Digits, err = otpAuthInt(q, "digits", OTP_DIGITS_DEFAULT); err != nil {
+		return nil, err
+	}
+	if key.Digits != 6 && key.Digits != 8 {
+		return nil, fmt.Errorf("unsupported otpauth digits %d", key.Digits)
+	}
+	if key.Type == "totp" {
+		if key.Period, err = otpAuthInt(q, "period", TOTP_PERIOD_DEFAULT); err != nil {
+			return nil, err
+		}
+		if key.Period <= 0 {
+			return nil, fmt.Errorf("invalid otpauth period %d", key.Period)
+		}
+	} else {
+		if !q.Has("counter") {
+			return nil, fmt.Errorf("hotp otpauth uri has no counter")
+		}
+		if key.Counter, err = strconv.ParseUint(q.Get("counter"), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid otpauth counter; %v", err)
+		}
+	}
+	return key, nil
+}
+
+func otpAuthInt(q url.Values, name string, def int) (int, error) {
+	if q.Get(name) == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(q.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid otpauth %s; %v", name, err)
+	}
+	return v, nil
+}
+
+// String returns the key as an otpauth:// URI.
+func (key *OTPAuthKey) String() string {
+	label := key.Account
+	if key.Issuer != "" {
+		label = key.Issuer + ":" + key.Account
+	}
+	q := url.Values{}
+	q.Set("secret", key.Secret)
+	if key.Issuer != "" {
+		q.Set("issuer", key.Issuer)
+	}
+	q.Set("algorithm", key.Algorithm.String())
+	q.Set("digits", strconv.Itoa(otpDigits(key.Digits)))
+	if key.Type == "hotp" {
+		q.Set("counter", strconv.FormatUint(key.Counter, 10))
+	} else {
+		period := key.Period
+		if period <= 0 {
+			period = TOTP_PERIOD_DEFAULT
+		}
+		q.Set("period", strconv.Itoa(period))
+	}
+	u := url.URL{Scheme: "otpauth", Host: key.Type, Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// ToTOTP returns the key as a TOTP. Replay and drift state start empty.
+func (key *OTPAuthKey) ToTOTP() (*TOTP, error) {
+	if key.Type != "totp" {
+		return nil, fmt.Errorf("otpauth key is '%s', not totp", key.Type)
+	}
+	return &TOTP{Secret: key.Secret, Digits: key.Digits, Period: key.Period, Algorithm: key.Algorithm}, nil
+}
+
+// ToHOTP returns the key as an HOTP.
+func (key *OTPAuthKey) ToHOTP() (*HOTP, error) {
+	if key.Type != "hotp" {
+		return nil, fmt.Errorf("otpauth key is '%s', not hotp", key.Type)
+	}
+	return &HOTP{Secret: key.Secret, Counter: key.Counter, Digits: key.Digits, Algorithm: key.Algorithm}, nil
+}
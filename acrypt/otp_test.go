@@ -0,0 +1,182 @@
+package acrypt
+
+import (
+	"encoding/base32"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var (
+	otpRFCSecret20 = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	otpRFCSecret32 = base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+)
+
+func TestOTPGenerateCode_RFCVectors(t *testing.T) {
+	// RFC 4226 Appendix D.
+	for counter, want := range []string{"755224", "287082", "359152", "969429", "338314"} {
+		code, err := OTPGenerateCode(otpRFCSecret20, uint64(counter), 6, OTPALG_SHA1)
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+	// RFC 6238 Appendix B at T=59.
+	tot := &TOTP{Secret: otpRFCSecret20, Digits: 8}
+	code, err := tot.GenerateCodeAt(time.Unix(59, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "94287082", code)
+	tot = &TOTP{Secret: otpRFCSecret32, Digits: 8, Algorithm: OTPALG_SHA256}
+	code, err = tot.GenerateCodeAt(time.Unix(59, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "46119246", code)
+
+	_, err = OTPGenerateCode(otpRFCSecret20, 0, 7, OTPALG_SHA1)
+	assert.Error(t, err)
+	_, err = OTPGenerateCode(otpRFCSecret20, 0, 6, "MD5")
+	assert.Error(t, err)
+}
+
+func TestTOTP_ValidateReplayAndSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tot := &TOTP{Secret: otpRFCSecret20}
+	code, _ := tot.GenerateCodeAt(now)
+
+	ok, err := tot.ValidateAt(code, now, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The same code is a replay within its period.
+	ok, err = tot.ValidateAt(code, now.Add(5*time.Second), 1)
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, ErrOTPReplayed))
+
+	// The previous step's code is also rejected once a later step was used.
+	prev, _ := tot.GenerateCodeAt(now.Add(-30 * time.Second))
+	ok, err = tot.ValidateAt(prev, now.Add(5*time.Second), 1)
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, ErrOTPReplayed))
+
+	// A code two steps ahead is outside a skew of 1 but inside 2, and is
+	// remembered as drift.
+	later := now.Add(90 * time.Second)
+	ahead, _ := tot.GenerateCodeAt(later.Add(60 * time.Second))
+	ok, err = tot.ValidateAt(ahead, later, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = tot.ValidateAt(ahead, later, 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), tot.Drift)
+
+	// With the drift learned, the next code from the fast clock is on the current step.
+	next, _ := tot.GenerateCodeAt(later.Add(90 * time.Second))
+	ok, err = tot.ValidateAt(next, later.Add(30*time.Second), 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestTOTP_Resync(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tot := &TOTP{Secret: otpRFCSecret20, Digits: 8, Period: 60, Algorithm: OTPALG_SHA256}
+
+	// The authenticator clock is five minutes slow.
+	slow := now.Add(-5 * time.Minute)
+	code1, _ := tot.GenerateCodeAt(slow.Add(-60 * time.Second))
+	code2, _ := tot.GenerateCodeAt(slow)
+	ok, _ := tot.ValidateAt(code2, now, -1)
+	assert.False(t, ok)
+
+	ok, err := tot.ResyncAt(code1, code2, now, 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(-5), tot.Drift)
+
+	nextCode, _ := tot.GenerateCodeAt(slow.Add(60 * time.Second))
+	ok, err = tot.ValidateAt(nextCode, now.Add(60*time.Second), 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Codes that are not consecutive do not resync.
+	tot = &TOTP{Secret: otpRFCSecret20}
+	code1, _ = tot.GenerateCodeAt(now)
+	code2, _ = tot.GenerateCodeAt(now.Add(90 * time.Second))
+	ok, _ = tot.ResyncAt(code1, code2, now, 0)
+	assert.False(t, ok)
+}
+
+func TestHOTP_ValidateLookahead(t *testing.T) {
+	ho := &HOTP{Secret: otpRFCSecret20, Lookahead: 3}
+	ok, err := ho.Validate("755224")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), ho.Counter)
+
+	ok, _ = ho.Validate("755224")
+	assert.False(t, ok, "a used code must not validate again")
+
+	// Counter 4 is within the lookahead of 3 from counter 1.
+	ok, _ = ho.Validate("338314")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), ho.Counter)
+
+	ho.Counter = 0
+	ok, _ = ho.Validate("338314")
+	assert.False(t, ok, "counter 4 is outside a lookahead of 3 from counter 0")
+}
+
+func TestParseOTPAuthURI(t *testing.T) {
+	key, err := ParseOTPAuthURI("otpauth://totp/ACME%20Co:john.doe@email.com?secret=HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ&issuer=ACME%20Co&algorithm=SHA256&digits=8&period=60")
+	assert.NoError(t, err)
+	assert.Equal(t, "totp", key.Type)
+	assert.Equal(t, "ACME Co", key.Issuer)
+	assert.Equal(t, "john.doe@email.com", key.Account)
+	assert.Equal(t, OTPALG_SHA256, key.Algorithm)
+	assert.Equal(t, 8, key.Digits)
+	assert.Equal(t, 60, key.Period)
+
+	tot, err := key.ToTOTP()
+	assert.NoError(t, err)
+	assert.Equal(t, 60, tot.Period)
+	_, err = key.ToHOTP()
+	assert.Error(t, err)
+
+	// The URI round-trips.
+	again, err := ParseOTPAuthURI(key.String())
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	key, err = ParseOTPAuthURI("otpauth://hotp/alice?secret=" + otpRFCSecret20 + "&counter=42")
+	assert.NoError(t, err)
+	assert.Equal(t, OTPALG_SHA1, key.Algorithm)
+	assert.Equal(t, 6, key.Digits)
+	ho, err := key.ToHOTP()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), ho.Counter)
+
+	for _, bad := range []string{
+		"https://totp/alice?secret=" + otpRFCSecret20,
+		"otpauth://totp/alice",
+		"otpauth://totp/alice?secret=not*base32",
+		"otpauth://totp/alice?secret=" + otpRFCSecret20 + "&digits=7",
+		"otpauth://totp/alice?secret=" + otpRFCSecret20 + "&algorithm=MD5",
+		"otpauth://hotp/alice?secret=" + otpRFCSecret20,
+		"otpauth://motp/alice?secret=" + otpRFCSecret20,
+	} {
+		_, err = ParseOTPAuthURI(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestTOTPGenerateWithOptions(t *testing.T) {
+	tot, err := TOTPGenerateWithOptions("ACME", "alice", 0, 8, 60, OTPALG_SHA512)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, tot.Digits)
+	assert.Equal(t, OTPALG_SHA512, tot.Algorithm)
+	code, err := tot.GenerateCodeAt(time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, code, 8)
+
+	tot, err = TOTPGenerate("ACME", "alice", 0)
+	assert.NoError(t, err)
+	assert.Zero(t, tot.Digits, "defaults are not stored")
+}
@@ -0,0 +1,409 @@
+package acrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Envelope encryption for SecretsManager.
+
+Each enveloped secret is encrypted with its own random data key (DEK). The DEK
+is wrapped (encrypted) by a key-encryption key (KEK) held in the SecretsKeyring
+and identified by a key id. Rotating the KEK only re-wraps the small DEKs; the
+secret ciphertexts are untouched. A full re-encryption replaces the DEKs too
+and is meant for keys believed to be compromised.
+
+The keyring persists only KEK metadata (id, salt, check value, dates). The key
+material is supplied at runtime through Unlock or UnlockWithPassword. Retired
+KEKs stay in the keyring for decrypt-only use until nothing references them.
+*/
+
+var (
+	ErrSecretsKEKNotFound = errors.New("key-encryption key not found")
+	ErrSecretsKEKLocked   = errors.New("key-encryption key is locked")
+)
+
+const (
+	SECRETS_KEK_SIZE = 32
+	secretsDEKSize   = 32
+	secretsKEKCheck  = "acrypt-kek-check"
+)
+
+// SecretsKEK is a key-encryption key. Only its metadata is serialized.
+type SecretsKEK struct {
+	Id        string     `json:"id"`
+	Salt      string     `json:"salt,omitempty"` // Base64 PBKDF2 salt when derived from a password.
+	Check     string     `json:"check"`          // Identifies the key material without revealing it.
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"` // Decrypt-only once set.
+	key       []byte
+}
+
+// NewSecretsKEK creates a KEK from 32 bytes of key material.
+func NewSecretsKEK(id string, key []byte) (*SecretsKEK, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, fmt.Errorf("kek id is empty")
+	}
+	if len(key) != SECRETS_KEK_SIZE {
+		return nil, fmt.Errorf("kek must be %d bytes", SECRETS_KEK_SIZE)
+	}
+	kek := &SecretsKEK{Id: id, CreatedAt: time.Now().UTC(), key: append([]byte{}, key...)}
+	kek.Check = kek.checkValue(kek.key)
+	return kek, nil
+}
+
+// NewSecretsKEKRandom creates a KEK from random key material and returns
+// the material so the caller can store it (eg in a KMS or env variable).
+func NewSecretsKEKRandom(id string) (*SecretsKEK, []byte, error) {
+	key := make([]byte, SECRETS_KEK_SIZE)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate kek; %v", err)
+	}
+	kek, err := NewSecretsKEK(id, key)
+	return kek, key, err
+}
+
+// NewSecretsKEKFromPassword creates a KEK derived from password with PBKDF2-SHA256.
+func NewSecretsKEKFromPassword(id, password string) (*SecretsKEK, error) {
+	if strings.TrimSpace(password) == "" {
+		return nil, fmt.Errorf("kek password is empty")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt; %v", err)
+	}
+	kek, err := NewSecretsKEK(id, pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, SECRETS_KEK_SIZE, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	kek.Salt = base64.StdEncoding.EncodeToString(salt)
+	return kek, nil
+}
+
+func (kek *SecretsKEK) checkValue(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(secretsKEKCheck + ":" + kek.Id))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Unlock sets the key material after verifying it against Check.
+func (kek *SecretsKEK) Unlock(key []byte) error {
+	if len(key) != SECRETS_KEK_SIZE {
+		return fmt.Errorf("kek must be %d bytes", SECRETS_KEK_SIZE)
+	}
+	if !hmac.Equal([]byte(kek.checkValue(key)), []byte(kek.Check)) {
+		return fmt.Errorf("key material does not match kek '%s'", kek.Id)
+	}
+	kek.key = append([]byte{}, key...)
+	return nil
+}
+
+// UnlockWithPassword derives the key material from password and unlocks.
+func (kek *SecretsKEK) UnlockWithPassword(password string) error {
+	if kek.Salt == "" {
+		return fmt.Errorf("kek '%s' is not password-derived", kek.Id)
+	}
+	salt, err := base64.StdEncoding.DecodeString(kek.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid kek salt; %v", err)
+	}
+	return kek.Unlock(pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, SECRETS_KEK_SIZE, sha256.New))
+}
+
+// IsUnlocked returns true if the key material is loaded.
+func (kek *SecretsKEK) IsUnlocked() bool {
+	return len(kek.key) > 0
+}
+
+// IsRetired returns true if the KEK may only decrypt.
+func (kek *SecretsKEK) IsRetired() bool {
+	return kek.RetiredAt != nil
+}
+
+// SecretsKEKs is a collection of KEKs.
+type SecretsKEKs []*SecretsKEK
+
+// SecretsKeyring holds the KEKs of a SecretsManager. New DEKs are always
+// wrapped by the active KEK.
+type SecretsKeyring struct {
+	ActiveId string      `json:"activeId"`
+	KEKs     SecretsKEKs `json:"keks"`
+	mu       sync.RWMutex
+}
+
+// NewSecretsKeyring creates a keyring with kek as the active key.
+func NewSecretsKeyring(kek *SecretsKEK) (*SecretsKeyring, error) {
+	kr := &SecretsKeyring{}
+	if err := kr.Add(kek, true); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+func (kr *SecretsKeyring) find(id string) *SecretsKEK {
+	for _, kek := range kr.KEKs {
+		if kek.Id == id {
+			return kek
+		}
+	}
+	return nil
+}
+
+// Add adds kek and optionally makes it the active key. The previous active
+// key is retired, so it remains usable for decryption only.
+func (kr *SecretsKeyring) Add(kek *SecretsKEK, activate bool) error {
+	if kek == nil || strings.TrimSpace(kek.Id) == "" {
+		return fmt.Errorf("kek is nil or has no id")
+	}
+	if !kek.IsUnlocked() {
+		return fmt.Errorf("%w; '%s'", ErrSecretsKEKLocked, kek.Id)
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kr.find(kek.Id) != nil {
+		return fmt.Errorf("kek '%s' already exists", kek.Id)
+	}
+	kr.KEKs = append(kr.KEKs, kek)
+	if activate {
+		kr.activate(kek)
+	}
+	return nil
+}
+
+func (kr *SecretsKeyring) activate(kek *SecretsKEK) {
+	if prev := kr.find(kr.ActiveId); prev != nil && prev != kek && prev.RetiredAt == nil {
+		now := time.Now().UTC()
+		prev.RetiredAt = &now
+	}
+	kek.RetiredAt = nil
+	kr.ActiveId = kek.Id
+}
+
+// Find returns the KEK with id or nil.
+func (kr *SecretsKeyring) Find(id string) *SecretsKEK {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.find(id)
+}
+
+// GetActiveId returns the id of the active KEK.
+func (kr *SecretsKeyring) GetActiveId() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.ActiveId
+}
+
+// Unlock loads the key material of KEK id.
+func (kr *SecretsKeyring) Unlock(id string, key []byte) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kek := kr.find(id)
+	if kek == nil {
+		return fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+	}
+	return kek.Unlock(key)
+}
+
+// UnlockWithPassword loads the key material of a password-derived KEK id.
+func (kr *SecretsKeyring) UnlockWithPassword(id, password string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kek := kr.find(id)
+	if kek == nil {
+		return fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+	}
+	return kek.UnlockWithPassword(password)
+}
+
+// Retire marks KEK id as decrypt-only. The active key cannot be retired;
+// activate another key instead.
+func (kr *SecretsKeyring) Retire(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kek := kr.find(id)
+	if kek == nil {
+		return fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+	}
+	if kek.Id == kr.ActiveId {
+		return fmt.Errorf("cannot retire the active kek '%s'", id)
+	}
+	if kek.RetiredAt == nil {
+		now := time.Now().UTC()
+		kek.RetiredAt = &now
+	}
+	return nil
+}
+
+// remove deletes KEK id. Callers must ensure nothing is wrapped by it.
+func (kr *SecretsKeyring) remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if id == kr.ActiveId {
+		return fmt.Errorf("cannot remove the active kek '%s'", id)
+	}
+	for ii, kek := range kr.KEKs {
+		if kek.Id == id {
+			kr.KEKs = append(kr.KEKs[:ii], kr.KEKs[ii+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+}
+
+// wrap encrypts dek with the active KEK.
+func (kr *SecretsKeyring) wrap(dek []byte, aad string) (string, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	kek := kr.find(kr.ActiveId)
+	if kek == nil {
+		return "", nil, fmt.Errorf("%w; no active kek", ErrSecretsKEKNotFound)
+	}
+	if !kek.IsUnlocked() {
+		return "", nil, fmt.Errorf("%w; '%s'", ErrSecretsKEKLocked, kek.Id)
+	}
+	wrapped, err := aesGCMSealKey(kek.key, dek, []byte(aad+"|"+kek.Id))
+	return kek.Id, wrapped, err
+}
+
+// unwrap decrypts a DEK wrapped by KEK id, which may be retired.
+func (kr *SecretsKeyring) unwrap(id string, wrapped []byte, aad string) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	kek := kr.find(id)
+	if kek == nil {
+		return nil, fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+	}
+	if !kek.IsUnlocked() {
+		return nil, fmt.Errorf("%w; '%s'", ErrSecretsKEKLocked, id)
+	}
+	return aesGCMOpenKey(kek.key, wrapped, []byte(aad+"|"+id))
+}
+
+// SecretsEnvelope is an enveloped secret value: the ciphertext under a DEK
+// and the DEK wrapped by KEK KeyId.
+type SecretsEnvelope struct {
+	KeyId      string    `json:"keyId"`
+	WrappedDEK string    `json:"wrappedDek"` // Base64.
+	Ciphertext string    `json:"ciphertext"` // Base64.
+	SealedAt   time.Time `json:"sealedAt"`   // When the data was last encrypted.
+	WrappedAt  time.Time `json:"wrappedAt"`  // When the DEK was last wrapped.
+}
+
+// sealSecretsEnvelope encrypts value under a new DEK wrapped by the active KEK.
+// The secret key is authenticated so envelopes cannot be swapped between items.
+func sealSecretsEnvelope(kr *SecretsKeyring, itemKey SecretsKey, value []byte) (*SecretsEnvelope, error) {
+	dek := make([]byte, secretsDEKSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("failed to generate dek; %v", err)
+	}
+	ciphertext, err := aesGCMSealKey(dek, value, []byte(itemKey))
+	if err != nil {
+		return nil, err
+	}
+	env := &SecretsEnvelope{Ciphertext: base64.StdEncoding.EncodeToString(ciphertext), SealedAt: time.Now().UTC()}
+	if err = env.wrapDEK(kr, itemKey, dek); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (env *SecretsEnvelope) wrapDEK(kr *SecretsKeyring, itemKey SecretsKey, dek []byte) error {
+	keyId, wrapped, err := kr.wrap(dek, string(itemKey))
+	if err != nil {
+		return err
+	}
+	env.KeyId = keyId
+	env.WrappedDEK = base64.StdEncoding.EncodeToString(wrapped)
+	env.WrappedAt = time.Now().UTC()
+	return nil
+}
+
+func (env *SecretsEnvelope) unwrapDEK(kr *SecretsKeyring, itemKey SecretsKey) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(env.WrappedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped dek; %v", err)
+	}
+	dek, err := kr.unwrap(env.KeyId, wrapped, string(itemKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap dek for '%s'; %w", itemKey, err)
+	}
+	return dek, nil
+}
+
+// open decrypts the envelope.
+func (env *SecretsEnvelope) open(kr *SecretsKeyring, itemKey SecretsKey) ([]byte, error) {
+	dek, err := env.unwrapDEK(kr, itemKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope ciphertext; %v", err)
+	}
+	value, err := aesGCMOpenKey(dek, ciphertext, []byte(itemKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt '%s'; %v", itemKey, err)
+	}
+	return value, nil
+}
+
+// rewrap re-wraps the DEK under the active KEK without touching the ciphertext.
+func (env *SecretsEnvelope) rewrap(kr *SecretsKeyring, itemKey SecretsKey) error {
+	dek, err := env.unwrapDEK(kr, itemKey)
+	if err != nil {
+		return err
+	}
+	return env.wrapDEK(kr, itemKey, dek)
+}
+
+// aesGCMSealKey encrypts with AES-256-GCM under a raw key and returns nonce|ciphertext.
+func aesGCMSealKey(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// aesGCMOpenKey reverses aesGCMSealKey.
+func aesGCMOpenKey(key, ciphertext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -7,9 +7,12 @@ import (
 
 // SecretsItem represents a single item in the secrets management system.
 type SecretsItem struct {
-	Key   SecretsKey   `json:"key"`   // The key associated with the secret item.
-	Value SecretsValue `json:"value"` // The current value of the secret.
-	mu    sync.RWMutex // Mutex to protect concurrent access to the SecretsItem.
+	Key      SecretsKey       `json:"key"`                // The key associated with the secret item.
+	Value    SecretsValue     `json:"value"`              // The current value of the secret.
+	Envelope *SecretsEnvelope `json:"envelope,omitempty"` // Replaces Value when sealed by a SecretsKeyring.
+	// Replaces Value.OldValue when a secret is sealed mid-rotation.
+	OldEnvelope *SecretsEnvelope `json:"oldEnvelope,omitempty"`
+	mu          sync.RWMutex     // Mutex to protect concurrent access to the SecretsItem.
 }
 
 // NewSecretsItem creates a new SecretsItem with the provided key and value.
@@ -87,6 +90,25 @@ func (si *SecretsItem) SetValueDecrypted(value string, encoding EncodingType, en
 	si.Value.Value.Validate(fmt.Sprintf("%s;%s;%s;%s", CRYPTMODE_DECRYPTED, encoding, encryption, value))
 }
 
+// GetEnvelope safely retrieves the envelope of the SecretsItem.
+func (si *SecretsItem) GetEnvelope() *SecretsEnvelope {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.Envelope
+}
+
+// GetOldEnvelope safely retrieves the envelope of the old value, if any.
+func (si *SecretsItem) GetOldEnvelope() *SecretsEnvelope {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.OldEnvelope
+}
+
+// IsEnveloped returns true if the value is sealed by a SecretsKeyring.
+func (si *SecretsItem) IsEnveloped() bool {
+	return si.GetEnvelope() != nil
+}
+
 // IsExpired checks if the secret or its old value has expired.
 func (si *SecretsItem) IsExpired() bool {
 	si.mu.RLock()
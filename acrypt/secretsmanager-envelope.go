@@ -0,0 +1,372 @@
+package acrypt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SetKeyring sets the keyring used for envelope encryption. Once set, new
+// secrets are sealed into envelopes by SetSecret; call EnvelopeSecrets to
+// convert existing ones.
+func (sm *SecretsManager) SetKeyring(kr *SecretsKeyring) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.Keyring = kr
+}
+
+// GetKeyring returns the keyring or nil.
+func (sm *SecretsManager) GetKeyring() *SecretsKeyring {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.Keyring
+}
+
+// decodeItem returns the plaintext of item from its envelope or its
+// password-encrypted value.
+func (sm *SecretsManager) decodeItem(item *SecretsItem) ([]byte, error) {
+	if env := item.GetEnvelope(); env != nil {
+		if sm.Keyring == nil {
+			return nil, fmt.Errorf("secret '%s' is enveloped but no keyring is set", item.GetKey())
+		}
+		return env.open(sm.Keyring, item.GetKey())
+	}
+	return item.GetDecodedValue(sm.masterPassword)
+}
+
+// decodeOldItem returns the plaintext of the old value of item, or nil if
+// there is none or it has expired.
+func (sm *SecretsManager) decodeOldItem(item *SecretsItem) ([]byte, error) {
+	item.mu.RLock()
+	oldEnv := item.OldEnvelope
+	oldValue := item.Value.OldValue
+	expiresAt := item.Value.OldValueExpiresAt
+	item.mu.RUnlock()
+	if expiresAt != nil && time.Now().After(*expiresAt) {
+		return nil, nil
+	}
+	if oldEnv != nil {
+		if sm.Keyring == nil {
+			return nil, fmt.Errorf("secret '%s' is enveloped but no keyring is set", item.GetKey())
+		}
+		return oldEnv.open(sm.Keyring, secretsOldKey(item.GetKey()))
+	}
+	if oldValue.IsEmpty() {
+		return nil, nil
+	}
+	return oldValue.Decode(sm.masterPassword)
+}
+
+// secretsOldKey authenticates the old value envelope so it cannot be
+// swapped with the current one.
+func secretsOldKey(key SecretsKey) SecretsKey {
+	return key + "#old"
+}
+
+// sealItem replaces the value of item with an envelope holding b. An old
+// value still in its rotation window is sealed into OldEnvelope.
+func (sm *SecretsManager) sealItem(item *SecretsItem, b []byte) error {
+	old, err := sm.decodeOldItem(item)
+	if err != nil {
+		return fmt.Errorf("failed to decode old value of secret '%s'; %v", item.GetKey(), err)
+	}
+	env, err := sealSecretsEnvelope(sm.Keyring, item.GetKey(), b)
+	if err != nil {
+		return fmt.Errorf("failed to seal secret '%s'; %v", item.GetKey(), err)
+	}
+	var oldEnv *SecretsEnvelope
+	if old != nil {
+		if oldEnv, err = sealSecretsEnvelope(sm.Keyring, secretsOldKey(item.GetKey()), old); err != nil {
+			return fmt.Errorf("failed to seal old value of secret '%s'; %v", item.GetKey(), err)
+		}
+	}
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.Envelope = env
+	item.OldEnvelope = oldEnv
+	oldExpiresAt := item.Value.OldValueExpiresAt
+	if oldEnv == nil {
+		oldExpiresAt = nil
+	}
+	item.Value = SecretsValue{MaxDuration: item.Value.MaxDuration, ExpiresAt: item.Value.ExpiresAt, OldValueExpiresAt: oldExpiresAt}
+	return nil
+}
+
+// GetOldSecret returns the previous value of a secret during its rotation
+// window, or nil if it has none or it has expired.
+func (sm *SecretsManager) GetOldSecret(key SecretsKey) ([]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	item := sm.Secrets.Find(key)
+	if item == nil {
+		return nil, fmt.Errorf("secret '%s' not found", key)
+	}
+	return sm.decodeOldItem(item)
+}
+
+// canSeal is true when the keyring has an unlocked active KEK.
+func (sm *SecretsManager) canSeal() bool {
+	if sm.Keyring == nil {
+		return false
+	}
+	kek := sm.Keyring.Find(sm.Keyring.GetActiveId())
+	return kek != nil && kek.IsUnlocked()
+}
+
+// EnvelopeSecrets moves every password-encrypted secret into an envelope
+// under the active KEK and returns the number converted.
+func (sm *SecretsManager) EnvelopeSecrets() (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if !sm.canSeal() {
+		return 0, fmt.Errorf("%w; keyring has no unlocked active kek", ErrSecretsKEKLocked)
+	}
+	count := 0
+	for _, item := range sm.Secrets {
+		if item.IsEnveloped() {
+			continue
+		}
+		b, err := sm.decodeItem(item)
+		if err != nil {
+			return count, fmt.Errorf("failed to decode secret '%s'; %v", item.GetKey(), err)
+		}
+		if err = sm.sealItem(item, b); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RotateKEK makes kek the active key and retires the previous one for
+// decrypt-only use. With rewrap, every DEK is re-wrapped by kek at once;
+// otherwise call RewrapSecrets later. Returns the number re-wrapped.
+func (sm *SecretsManager) RotateKEK(kek *SecretsKEK, rewrap bool) (int, error) {
+	sm.mu.Lock()
+	if sm.Keyring == nil {
+		kr, err := NewSecretsKeyring(kek)
+		if err != nil {
+			sm.mu.Unlock()
+			return 0, err
+		}
+		sm.Keyring = kr
+	} else if err := sm.Keyring.Add(kek, true); err != nil {
+		sm.mu.Unlock()
+		return 0, err
+	}
+	sm.mu.Unlock()
+
+	if !rewrap {
+		return 0, nil
+	}
+	return sm.RewrapSecrets()
+}
+
+// RewrapSecrets re-wraps the DEK of every envelope not on the active KEK.
+// The secret ciphertexts are not changed. Returns the number re-wrapped.
+func (sm *SecretsManager) RewrapSecrets() (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if !sm.canSeal() {
+		return 0, fmt.Errorf("%w; keyring has no unlocked active kek", ErrSecretsKEKLocked)
+	}
+	activeId := sm.Keyring.GetActiveId()
+	count := 0
+	for _, item := range sm.Secrets {
+		env, err := rewrapSecretsEnvelope(sm.Keyring, item.GetEnvelope(), item.GetKey(), activeId)
+		if err != nil {
+			return count, err
+		}
+		oldEnv, err := rewrapSecretsEnvelope(sm.Keyring, item.GetOldEnvelope(), secretsOldKey(item.GetKey()), activeId)
+		if err != nil {
+			return count, err
+		}
+		if env == nil && oldEnv == nil {
+			continue
+		}
+		item.mu.Lock()
+		if env != nil {
+			item.Envelope = env
+		}
+		if oldEnv != nil {
+			item.OldEnvelope = oldEnv
+		}
+		item.mu.Unlock()
+		count++
+	}
+	return count, nil
+}
+
+// rewrapSecretsEnvelope returns a copy of env re-wrapped by the active KEK,
+// or nil if env is nil or already on it. A failure leaves env unchanged.
+func rewrapSecretsEnvelope(kr *SecretsKeyring, env *SecretsEnvelope, itemKey SecretsKey, activeId string) (*SecretsEnvelope, error) {
+	if env == nil || env.KeyId == activeId {
+		return nil, nil
+	}
+	next := *env
+	if err := next.rewrap(kr, itemKey); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// ReencryptSecrets encrypts secrets again under new DEKs wrapped by the
+// active KEK, for keys believed compromised. Only envelopes wrapped by one
+// of keyIds are re-encrypted, or all secrets if none are given; secrets
+// still under the master password are converted to envelopes. Returns the
+// number re-encrypted.
+func (sm *SecretsManager) ReencryptSecrets(keyIds ...string) (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if !sm.canSeal() {
+		return 0, fmt.Errorf("%w; keyring has no unlocked active kek", ErrSecretsKEKLocked)
+	}
+	match := map[string]bool{}
+	for _, id := range keyIds {
+		match[id] = true
+	}
+	count := 0
+	for _, item := range sm.Secrets {
+		env, oldEnv := item.GetEnvelope(), item.GetOldEnvelope()
+		if len(match) > 0 && (env == nil || !match[env.KeyId]) && (oldEnv == nil || !match[oldEnv.KeyId]) {
+			continue
+		}
+		b, err := sm.decodeItem(item)
+		if err != nil {
+			return count, fmt.Errorf("failed to decode secret '%s'; %v", item.GetKey(), err)
+		}
+		if err = sm.sealItem(item, b); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RemoveKEK deletes a retired KEK from the keyring once no envelope uses it.
+func (sm *SecretsManager) RemoveKEK(id string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.Keyring == nil {
+		return fmt.Errorf("%w; '%s'", ErrSecretsKEKNotFound, id)
+	}
+	var used SecretsKeys
+	for _, item := range sm.Secrets {
+		env, oldEnv := item.GetEnvelope(), item.GetOldEnvelope()
+		if (env != nil && env.KeyId == id) || (oldEnv != nil && oldEnv.KeyId == id) {
+			used = append(used, item.GetKey())
+		}
+	}
+	if len(used) > 0 {
+		return fmt.Errorf("kek '%s' still wraps %d secrets; rewrap first", id, len(used))
+	}
+	return sm.Keyring.remove(id)
+}
+
+// SecretsKeyUsage lists the secrets wrapped by one KEK.
+type SecretsKeyUsage struct {
+	KeyId      string      `json:"keyId"`
+	IsActive   bool        `json:"isActive,omitempty"`
+	IsRetired  bool        `json:"isRetired,omitempty"`
+	IsUnlocked bool        `json:"isUnlocked,omitempty"`
+	IsMissing  bool        `json:"isMissing,omitempty"` // Referenced by envelopes but not in the keyring.
+	Secrets    SecretsKeys `json:"secrets"`
+}
+
+// SecretsKeyUsageReport shows which secrets use which key.
+type SecretsKeyUsageReport struct {
+	Keys           []*SecretsKeyUsage `json:"keys"`
+	MasterPassword SecretsKeys        `json:"masterPassword,omitempty"` // Secrets not yet enveloped.
+	NeedsRewrap    SecretsKeys        `json:"needsRewrap,omitempty"`    // Enveloped secrets not on the active key.
+}
+
+// KeyUsageReport reports, for each KEK, the secrets whose DEKs it wraps.
+// It reads metadata only and works with a locked keyring.
+func (sm *SecretsManager) KeyUsageReport() *SecretsKeyUsageReport {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	report := &SecretsKeyUsageReport{}
+	byId := map[string]*SecretsKeyUsage{}
+	activeId := ""
+	if sm.Keyring != nil {
+		sm.Keyring.mu.RLock()
+		activeId = sm.Keyring.ActiveId
+		for _, kek := range sm.Keyring.KEKs {
+			usage := &SecretsKeyUsage{
+				KeyId:      kek.Id,
+				IsActive:   kek.Id == activeId,
+				IsRetired:  kek.IsRetired(),
+				IsUnlocked: kek.IsUnlocked(),
+				Secrets:    SecretsKeys{},
+			}
+			byId[kek.Id] = usage
+			report.Keys = append(report.Keys, usage)
+		}
+		sm.Keyring.mu.RUnlock()
+	}
+
+	for _, item := range sm.Secrets {
+		env := item.GetEnvelope()
+		if env == nil {
+			report.MasterPassword = append(report.MasterPassword, item.GetKey())
+			continue
+		}
+		keyIds := []string{env.KeyId}
+		if oldEnv := item.GetOldEnvelope(); oldEnv != nil && oldEnv.KeyId != env.KeyId {
+			keyIds = append(keyIds, oldEnv.KeyId)
+		}
+		needsRewrap := false
+		for _, keyId := range keyIds {
+			usage, ok := byId[keyId]
+			if !ok {
+				usage = &SecretsKeyUsage{KeyId: keyId, IsMissing: true, Secrets: SecretsKeys{}}
+				byId[keyId] = usage
+				report.Keys = append(report.Keys, usage)
+			}
+			usage.Secrets = append(usage.Secrets, item.GetKey())
+			needsRewrap = needsRewrap || keyId != activeId
+		}
+		if needsRewrap {
+			report.NeedsRewrap = append(report.NeedsRewrap, item.GetKey())
+		}
+	}
+	for _, usage := range report.Keys {
+		sort.Slice(usage.Secrets, func(i, j int) bool { return usage.Secrets[i] < usage.Secrets[j] })
+	}
+	return report
+}
+
+// String formats the report for command-line output.
+func (r *SecretsKeyUsageReport) String() string {
+	sb := strings.Builder{}
+	for _, usage := range r.Keys {
+		var flags []string
+		if usage.IsActive {
+			flags = append(flags, "active")
+		}
+		if usage.IsRetired {
+			flags = append(flags, "retired")
+		}
+		if usage.IsMissing {
+			flags = append(flags, "missing")
+		} else if !usage.IsUnlocked {
+			flags = append(flags, "locked")
+		}
+		sb.WriteString(fmt.Sprintf("kek %s [%s]: %d secrets\n", usage.KeyId, strings.Join(flags, ","), len(usage.Secrets)))
+		for _, key := range usage.Secrets {
+			sb.WriteString("  " + key.String() + "\n")
+		}
+	}
+	if len(r.MasterPassword) > 0 {
+		sb.WriteString(fmt.Sprintf("master password: %d secrets\n", len(r.MasterPassword)))
+		for _, key := range r.MasterPassword {
+			sb.WriteString("  " + key.String() + "\n")
+		}
+	}
+	if len(r.NeedsRewrap) > 0 {
+		sb.WriteString(fmt.Sprintf("needs rewrap: %d secrets\n", len(r.NeedsRewrap)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,224 @@
+package acrypt
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEnvelopeManager(t *testing.T) (*SecretsManager, []byte) {
+	kek, key, err := NewSecretsKEKRandom("kek-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewSecretsManager("test_master_password")
+	kr, err := NewSecretsKeyring(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.SetKeyring(kr)
+	for _, key := range []string{"db", "jwt", "smtp"} {
+		if err = sm.SetSecret(NewSecretsItem(SecretsKey(key), key+"-value", ENCODINGTYPE_PLAIN, ENCRYPTIONTYPE_AES256)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sm, key
+}
+
+func TestSecretsManagerEnvelope(t *testing.T) {
+	sm, _ := newTestEnvelopeManager(t)
+	item := sm.FindSecret("db")
+	env := item.GetEnvelope()
+	if env == nil || env.KeyId != "kek-1" || item.Value.HasValue() {
+		t.Fatalf("expected db to be enveloped under kek-1, got %+v", env)
+	}
+	if got := string(sm.GetSecret("db")); got != "db-value" {
+		t.Errorf("expected db-value, got %s", got)
+	}
+
+	// Envelopes are bound to their secret key.
+	other := sm.FindSecret("jwt")
+	other.Envelope = &SecretsEnvelope{KeyId: env.KeyId, WrappedDEK: env.WrappedDEK, Ciphertext: env.Ciphertext}
+	if _, err := sm.decodeItem(other); err == nil {
+		t.Error("expected a swapped envelope to fail")
+	}
+}
+
+func TestSecretsManagerRotateKEK(t *testing.T) {
+	sm, _ := newTestEnvelopeManager(t)
+	ciphertext := sm.FindSecret("db").GetEnvelope().Ciphertext
+
+	kek2, err := NewSecretsKEKFromPassword("kek-2", "new-master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sm.RotateKEK(kek2, false); err != nil || n != 0 {
+		t.Fatalf("rotate: %d, %v", n, err)
+	}
+	report := sm.KeyUsageReport()
+	if len(report.NeedsRewrap) != 3 || !report.Keys[0].IsRetired || !report.Keys[1].IsActive {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	// The retired key still decrypts, but new secrets use the active key.
+	if err = sm.SetSecret(NewSecretsItem("api", "api-value", ENCODINGTYPE_PLAIN, ENCRYPTIONTYPE_AES256)); err != nil {
+		t.Fatal(err)
+	}
+	if sm.FindSecret("api").GetEnvelope().KeyId != "kek-2" {
+		t.Error("expected new secret under kek-2")
+	}
+	if err = sm.RemoveKEK("kek-1"); err == nil {
+		t.Error("expected kek-1 removal to fail while in use")
+	}
+
+	n, err := sm.RewrapSecrets()
+	if err != nil || n != 3 {
+		t.Fatalf("rewrap: %d, %v", n, err)
+	}
+	env := sm.FindSecret("db").GetEnvelope()
+	if env.KeyId != "kek-2" || env.Ciphertext != ciphertext {
+		t.Errorf("rewrap must keep the ciphertext, got %+v", env)
+	}
+	if err = sm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = sm.RemoveKEK("kek-1"); err != nil {
+		t.Fatal(err)
+	}
+	out := sm.KeyUsageReport().String()
+	if !strings.Contains(out, "kek kek-2 [active]: 4 secrets") {
+		t.Errorf("unexpected report:\n%s", out)
+	}
+
+	n, err = sm.ReencryptSecrets("kek-2")
+	if err != nil || n != 4 {
+		t.Fatalf("reencrypt: %d, %v", n, err)
+	}
+	if sm.FindSecret("db").GetEnvelope().Ciphertext == ciphertext {
+		t.Error("reencrypt must replace the ciphertext")
+	}
+	if got := string(sm.GetSecret("smtp")); got != "smtp-value" {
+		t.Errorf("expected smtp-value, got %s", got)
+	}
+}
+
+func TestSecretsManagerEnvelopeReload(t *testing.T) {
+	sm, key := newTestEnvelopeManager(t)
+	data, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "db-value") {
+		t.Fatal("plaintext leaked into json")
+	}
+
+	loaded := NewSecretsManager("")
+	if err = json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if err = loaded.Validate(); !errors.Is(err, ErrSecretsKEKLocked) {
+		t.Fatalf("expected locked keyring, got %v", err)
+	}
+	if !strings.Contains(loaded.KeyUsageReport().String(), "locked") {
+		t.Error("expected report to show the locked key")
+	}
+	if err = loaded.GetKeyring().Unlock("kek-1", make([]byte, SECRETS_KEK_SIZE)); err == nil {
+		t.Error("expected wrong key material to be rejected")
+	}
+	if err = loaded.GetKeyring().Unlock("kek-1", key); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(loaded.GetSecret("jwt")); got != "jwt-value" {
+		t.Errorf("expected jwt-value, got %s", got)
+	}
+}
+
+func TestSecretsManagerEnvelopeSecrets(t *testing.T) {
+	sm := NewSecretsManager("test_master_password")
+	item := NewSecretsItem("legacy", "legacy-value", ENCODINGTYPE_PLAIN, ENCRYPTIONTYPE_AES256)
+	if err := item.Value.EnsureCryptMode("test_master_password", CRYPTMODE_ENCRYPTED); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.SetSecret(item); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.EnvelopeSecrets(); !errors.Is(err, ErrSecretsKEKLocked) {
+		t.Fatalf("expected no keyring error, got %v", err)
+	}
+
+	kek, err := NewSecretsKEKFromPassword("master", "test_master_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sm.RotateKEK(kek, false); err != nil {
+		t.Fatal(err)
+	}
+	if report := sm.KeyUsageReport(); len(report.MasterPassword) != 1 {
+		t.Fatalf("expected one legacy secret, got %+v", report)
+	}
+	if n, err := sm.EnvelopeSecrets(); err != nil || n != 1 {
+		t.Fatalf("envelope: %d, %v", n, err)
+	}
+	if err = sm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(sm.GetSecret("legacy")); got != "legacy-value" {
+		t.Errorf("expected legacy-value, got %s", got)
+	}
+}
+
+func TestSecretsManagerEnvelopeKeepsOldValue(t *testing.T) {
+	sm, _ := newTestEnvelopeManager(t)
+	item := NewSecretsItem("api", "api-v1", ENCODINGTYPE_PLAIN, ENCRYPTIONTYPE_AES256)
+	item.Value.Rotate("d;plain;aes256;api-v2", time.Hour)
+	if err := sm.SetSecret(item); err != nil {
+		t.Fatal(err)
+	}
+	if item.GetOldEnvelope() == nil || !item.Value.OldValue.IsEmpty() || item.Value.OldValueExpiresAt == nil {
+		t.Fatalf("expected the old value to be sealed, got %s", item.Value.OldValue)
+	}
+	check := func(step string) {
+		t.Helper()
+		if got := string(sm.GetSecret("api")); got != "api-v2" {
+			t.Errorf("%s: expected api-v2, got %s", step, got)
+		}
+		if old, err := sm.GetOldSecret("api"); err != nil || string(old) != "api-v1" {
+			t.Errorf("%s: expected old api-v1, got %s, %v", step, old, err)
+		}
+	}
+	check("seal")
+
+	kek2, _, err := NewSecretsKEKRandom("kek-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sm.RotateKEK(kek2, true); err != nil {
+		t.Fatal(err)
+	}
+	if item.GetOldEnvelope().KeyId != "kek-2" {
+		t.Error("expected the old value to be re-wrapped")
+	}
+	check("rewrap")
+
+	if _, err = sm.ReencryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	check("reencrypt")
+	if err = sm.RemoveKEK("kek-1"); err != nil {
+		t.Errorf("expected kek-1 to be unused, got %v", err)
+	}
+
+	// An expired old value is dropped when sealed again.
+	past := time.Now().Add(-time.Minute)
+	item.Value.OldValueExpiresAt = &past
+	if _, err = sm.ReencryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if item.GetOldEnvelope() != nil {
+		t.Error("expected the expired old value to be dropped")
+	}
+	if old, err := sm.GetOldSecret("api"); err != nil || old != nil {
+		t.Errorf("expected no old value, got %s, %v", old, err)
+	}
+}
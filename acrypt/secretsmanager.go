@@ -21,7 +21,8 @@ type ISecretsManager interface {
 
 // SecretsManager manages secrets within an application.
 type SecretsManager struct {
-	Secrets SecretsItems `json:"secrets,omitempty"` // Collection of secrets.
+	Secrets SecretsItems    `json:"secrets,omitempty"` // Collection of secrets.
+	Keyring *SecretsKeyring `json:"keyring,omitempty"` // Optional KEKs for envelope encryption.
 	//mappedSecrets  SecretsItemsMap // Cached secrets map for efficient lookup.
 	decodedSecrets DecodedSecretsMap // Cache decoded secrets for efficient lookup
 	masterPassword string            // Required master password for encoding/decoding.
@@ -70,9 +71,14 @@ func (sm *SecretsManager) SetSecret(item *SecretsItem) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if b, err := item.GetDecodedValue(sm.masterPassword); err != nil {
+	if b, err := sm.decodeItem(item); err != nil {
 		return fmt.Errorf("failed to get decoded value; %v", err)
 	} else {
+		if !item.IsEnveloped() && sm.canSeal() {
+			if err = sm.sealItem(item, b); err != nil {
+				return err
+			}
+		}
 		if err = sm.Secrets.Set(item); err != nil {
 			return fmt.Errorf("failed to set secret; %v", err)
 		}
@@ -97,11 +103,17 @@ func (sm *SecretsManager) Validate() error {
 		if secret.GetKey().IsEmpty() {
 			return fmt.Errorf("secret key is empty")
 		}
-		if b, err := secret.Decode(sm.masterPassword, false); err != nil {
-			return fmt.Errorf("failed to decode secret; %v", err)
+		var b []byte
+		var err error
+		if secret.IsEnveloped() {
+			b, err = sm.decodeItem(secret)
 		} else {
-			sm.decodedSecrets[secret.GetKey()] = b
+			b, err = secret.Decode(sm.masterPassword, false)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode secret; %w", err)
 		}
+		sm.decodedSecrets[secret.GetKey()] = b
 	}
 	return nil
 }
@@ -162,11 +174,15 @@ func (sm *SecretsManager) RemoveSecret(key SecretsKey) {
 }
 
 // EnsureCryptMode ensures all secrets are in the specified CryptMode.
+// Enveloped secrets are protected by the keyring and are skipped.
 func (sm *SecretsManager) EnsureCryptMode(targetMode CryptMode, password string) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	for _, item := range sm.Secrets {
+		if item.IsEnveloped() {
+			continue
+		}
 		if err := item.Value.EnsureCryptMode(password, targetMode); err != nil {
 			return fmt.Errorf("failed to set CryptMode for key %s: %v", item.GetKey(), err)
 		}
@@ -182,6 +198,7 @@ func (sm *SecretsManager) GetMasterPassword() string {
 }
 
 // SetMasterPassword sets the master password and updates all secrets.
+// Enveloped secrets are skipped; rotate their KEK with RotateKEK.
 func (sm *SecretsManager) SetMasterPassword(oldPassword, newPassword string) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -191,6 +208,9 @@ func (sm *SecretsManager) SetMasterPassword(oldPassword, newPassword string) err
 	}
 
 	for _, item := range sm.Secrets {
+		if item.IsEnveloped() {
+			continue
+		}
 		decodedValue, err := item.Value.Decode(oldPassword, false)
 		if err != nil {
 			return fmt.Errorf("failed to decode secret for key %s with old password: %v", item.GetKey(), err)
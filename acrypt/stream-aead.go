@@ -0,0 +1,684 @@
+package acrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stream format (version 1):
+//
+//	header: magic "ACS1" | version u8 | cipher u8 | kdf u8 | chunkSize u32 |
+//	        iterations u32 | salt [16] | noncePrefix [7] | keyIdLen u8 | keyId
+//	chunks: Seal(plaintext[chunkSize]) ... Seal(final plaintext[0..chunkSize])
+//
+// This is the STREAM construction (Hoang, Reyhanitabar, Rogaway, Vizár): each
+// chunk nonce is noncePrefix | counter u32 | lastFlag u8, so chunks cannot be
+// reordered, dropped or moved between files, and a stream that ends without a
+// chunk flagged last is reported as truncated. The encoded header is the
+// additional data of every chunk, so it cannot be edited either. The key is
+// derived per stream from the salt (HKDF for raw keys, PBKDF2 for passphrases),
+// and chunks have a fixed size, so any range can be decrypted without reading
+// the chunks before it.
+
+var (
+	ErrStreamHeaderInvalid = errors.New("stream header is invalid")
+	ErrStreamTruncated     = errors.New("stream is truncated")
+	ErrStreamAuthFailed    = errors.New("stream chunk failed authentication")
+)
+
+// StreamCipher is the AEAD used for the chunks of a stream.
+type StreamCipher uint8
+
+const (
+	STREAMCIPHER_AES256GCM        StreamCipher = 1 // FIPS-approved (default).
+	STREAMCIPHER_CHACHA20POLY1305 StreamCipher = 2 // Faster without AES hardware; not FIPS-approved.
+)
+
+// StreamKDF is how the stream key is derived from the caller's secret.
+type StreamKDF uint8
+
+const (
+	STREAMKDF_HKDF_SHA256   StreamKDF = 1 // Secret is a raw key of at least 32 bytes.
+	STREAMKDF_PBKDF2_SHA256 StreamKDF = 2 // Secret is a passphrase.
+)
+
+const (
+	STREAM_VERSION           = 1
+	STREAM_CHUNKSIZE_DEFAULT = 64 * 1024
+	STREAM_CHUNKSIZE_MIN     = 64
+	STREAM_CHUNKSIZE_MAX     = 16 * 1024 * 1024
+	STREAM_ITERATIONS_MAX    = 10000000
+	streamMagic              = "ACS1"
+	streamKeySize            = 32
+	streamNoncePrefixSize    = 7
+	streamHeaderFixedSize    = 4 + 1 + 1 + 1 + 4 + 4 + saltSize + streamNoncePrefixSize + 1
+	streamHKDFInfo           = "acrypt-stream-v1"
+	streamMaxChunks          = 1 << 32
+)
+
+// StreamHeader is the decoded header of a stream.
+type StreamHeader struct {
+	Version     uint8
+	Cipher      StreamCipher
+	KDF         StreamKDF
+	ChunkSize   int
+	Iterations  int
+	Salt        []byte
+	NoncePrefix []byte
+	KeyId       string
+}
+
+// StreamOptions configures a stream writer. The zero value selects
+// AES-256-GCM with 64 KiB chunks and, for passphrases, 600,000 iterations.
+type StreamOptions struct {
+	Cipher     StreamCipher
+	ChunkSize  int
+	Iterations int
+	KeyId      string // Stored in the header so readers can pick the key; not secret.
+}
+
+// StreamKeyFunc returns the secret for a stream: the raw key for
+// STREAMKDF_HKDF_SHA256 or the passphrase for STREAMKDF_PBKDF2_SHA256.
+// Use h.KeyId to select among several keys.
+type StreamKeyFunc func(h *StreamHeader) ([]byte, error)
+
+// StreamKeyFromBytes returns a StreamKeyFunc that always uses secret.
+func StreamKeyFromBytes(secret []byte) StreamKeyFunc {
+	return func(h *StreamHeader) ([]byte, error) {
+		return secret, nil
+	}
+}
+
+// StreamKeyFromMap returns a StreamKeyFunc that looks up the header key id in keys.
+func StreamKeyFromMap(keys map[string][]byte) StreamKeyFunc {
+	return func(h *StreamHeader) ([]byte, error) {
+		if key, ok := keys[h.KeyId]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("no stream key for key id '%s'", h.KeyId)
+	}
+}
+
+func (h *StreamHeader) encode() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, streamHeaderFixedSize+len(h.KeyId)))
+	buf.WriteString(streamMagic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(byte(h.Cipher))
+	buf.WriteByte(byte(h.KDF))
+	_ = binary.Write(buf, binary.BigEndian, uint32(h.ChunkSize))
+	_ = binary.Write(buf, binary.BigEndian, uint32(h.Iterations))
+	buf.Write(h.Salt)
+	buf.Write(h.NoncePrefix)
+	buf.WriteByte(byte(len(h.KeyId)))
+	buf.WriteString(h.KeyId)
+	return buf.Bytes()
+}
+
+// ReadStreamHeader reads and validates the header at the start of r and
+// returns it with its encoded bytes.
+func ReadStreamHeader(r io.Reader) (*StreamHeader, []byte, error) {
+	fixed := make([]byte, streamHeaderFixedSize)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, fmt.Errorf("%w; %v", ErrStreamHeaderInvalid, err)
+	}
+	if string(fixed[:4]) != streamMagic {
+		return nil, nil, fmt.Errorf("%w; bad magic", ErrStreamHeaderInvalid)
+	}
+	h := &StreamHeader{
+		Version:    fixed[4],
+		Cipher:     StreamCipher(fixed[5]),
+		KDF:        StreamKDF(fixed[6]),
+		ChunkSize:  int(binary.BigEndian.Uint32(fixed[7:11])),
+		Iterations: int(binary.BigEndian.Uint32(fixed[11:15])),
+	}
+	off := 15
+	h.Salt = append([]byte{}, fixed[off:off+saltSize]...)
+	off += saltSize
+	h.NoncePrefix = append([]byte{}, fixed[off:off+streamNoncePrefixSize]...)
+	off += streamNoncePrefixSize
+
+	keyId := make([]byte, int(fixed[off]))
+	if _, err := io.ReadFull(r, keyId); err != nil {
+		return nil, nil, fmt.Errorf("%w; %v", ErrStreamHeaderInvalid, err)
+	}
+	h.KeyId = string(keyId)
+
+	if err := h.validate(); err != nil {
+		return nil, nil, err
+	}
+	return h, append(fixed, keyId...), nil
+}
+
+func (h *StreamHeader) validate() error {
+	if h.Version != STREAM_VERSION {
+		return fmt.Errorf("%w; unsupported version %d", ErrStreamHeaderInvalid, h.Version)
+	}
+	if h.Cipher != STREAMCIPHER_AES256GCM && h.Cipher != STREAMCIPHER_CHACHA20POLY1305 {
+		return fmt.Errorf("%w; unsupported cipher %d", ErrStreamHeaderInvalid, h.Cipher)
+	}
+	switch h.KDF {
+	case STREAMKDF_HKDF_SHA256:
+	case STREAMKDF_PBKDF2_SHA256:
+		if h.Iterations < 1 || h.Iterations > STREAM_ITERATIONS_MAX {
+			return fmt.Errorf("%w; invalid iterations %d", ErrStreamHeaderInvalid, h.Iterations)
+		}
+	default:
+		return fmt.Errorf("%w; unsupported kdf %d", ErrStreamHeaderInvalid, h.KDF)
+	}
+	if h.ChunkSize < STREAM_CHUNKSIZE_MIN || h.ChunkSize > STREAM_CHUNKSIZE_MAX {
+		return fmt.Errorf("%w; invalid chunk size %d", ErrStreamHeaderInvalid, h.ChunkSize)
+	}
+	if len(h.KeyId) > 255 {
+		return fmt.Errorf("%w; key id is longer than 255 bytes", ErrStreamHeaderInvalid)
+	}
+	return nil
+}
+
+// newAEAD derives the stream key from secret and creates the chunk AEAD.
+func (h *StreamHeader) newAEAD(secret []byte) (cipher.AEAD, error) {
+	var key []byte
+	switch h.KDF {
+	case STREAMKDF_HKDF_SHA256:
+		if len(secret) < streamKeySize {
+			return nil, fmt.Errorf("stream key must be at least %d bytes", streamKeySize)
+		}
+		var err error
+		if key, err = hkdf.Key(sha256.New, secret, h.Salt, streamHKDFInfo, streamKeySize); err != nil {
+			return nil, fmt.Errorf("failed to derive stream key: %w", err)
+		}
+	case STREAMKDF_PBKDF2_SHA256:
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("stream passphrase is empty")
+		}
+		key = pbkdf2.Key(secret, h.Salt, h.Iterations, streamKeySize, sha256.New)
+	}
+
+	if h.Cipher == STREAMCIPHER_CHACHA20POLY1305 {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// streamState is shared by the writer and readers.
+type streamState struct {
+	header    *StreamHeader
+	aad       []byte
+	aead      cipher.AEAD
+	chunkSize int
+}
+
+func (s *streamState) nonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.header.NoncePrefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], uint32(counter))
+	if last {
+		nonce[streamNoncePrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// encChunkSize is the size of a full chunk on disk.
+func (s *streamState) encChunkSize() int {
+	return s.chunkSize + s.aead.Overhead()
+}
+
+// open decrypts chunk counter. When the chunk fails as the last chunk but
+// authenticates as a middle one, the stream was cut at a chunk boundary.
+func (s *streamState) open(chunk []byte, counter uint64, last bool) ([]byte, error) {
+	// Not in place: a failed Open clears dst, which is needed for the retry.
+	plain, err := s.aead.Open(nil, s.nonce(counter, last), chunk, s.aad)
+	if err == nil {
+		return plain, nil
+	}
+	if last {
+		if _, err2 := s.aead.Open(nil, s.nonce(counter, false), chunk, s.aad); err2 == nil {
+			return nil, ErrStreamTruncated
+		}
+	}
+	return nil, fmt.Errorf("%w; chunk %d", ErrStreamAuthFailed, counter)
+}
+
+// StreamWriter encrypts everything written to it as a chunked AEAD stream.
+// Close must be called to write the final chunk; without it readers report
+// the stream as truncated.
+type StreamWriter struct {
+	streamState
+	w       io.Writer
+	buf     []byte
+	counter uint64
+	closed  bool
+	err     error
+}
+
+// NewStreamWriter writes the stream header to w and returns a writer that
+// encrypts with a raw key of at least 32 bytes.
+func NewStreamWriter(w io.Writer, key []byte, opts *StreamOptions) (*StreamWriter, error) {
+	return newStreamWriter(w, key, STREAMKDF_HKDF_SHA256, opts)
+}
+
+// NewStreamWriterWithPassphrase writes the stream header to w and returns a
+// writer that encrypts with a key derived from passphrase by PBKDF2-SHA256.
+func NewStreamWriterWithPassphrase(w io.Writer, passphrase string, opts *StreamOptions) (*StreamWriter, error) {
+	return newStreamWriter(w, []byte(passphrase), STREAMKDF_PBKDF2_SHA256, opts)
+}
+
+func newStreamWriter(w io.Writer, secret []byte, kdf StreamKDF, opts *StreamOptions) (*StreamWriter, error) {
+	if w == nil {
+		return nil, fmt.Errorf("stream writer is nil")
+	}
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+	h := &StreamHeader{
+		Version:     STREAM_VERSION,
+		Cipher:      opts.Cipher,
+		KDF:         kdf,
+		ChunkSize:   opts.ChunkSize,
+		KeyId:       opts.KeyId,
+		Salt:        make([]byte, saltSize),
+		NoncePrefix: make([]byte, streamNoncePrefixSize),
+	}
+	if h.Cipher == 0 {
+		h.Cipher = STREAMCIPHER_AES256GCM
+	}
+	if h.ChunkSize == 0 {
+		h.ChunkSize = STREAM_CHUNKSIZE_DEFAULT
+	}
+	if kdf == STREAMKDF_PBKDF2_SHA256 {
+		h.Iterations = opts.Iterations
+		if h.Iterations == 0 {
+			h.Iterations = pbkdf2Iterations
+		}
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := io.ReadFull(rand.Reader, h.NoncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	aead, err := h.newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	sw := &StreamWriter{
+		streamState: streamState{header: h, aad: h.encode(), aead: aead, chunkSize: h.ChunkSize},
+		w:           w,
+		buf:         make([]byte, 0, h.ChunkSize),
+	}
+	if _, err = w.Write(sw.aad); err != nil {
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+	return sw, nil
+}
+
+// Header returns the stream header.
+func (sw *StreamWriter) Header() *StreamHeader {
+	return sw.header
+}
+
+// Write buffers p and writes every full chunk that is known not to be last.
+func (sw *StreamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("stream writer is closed")
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	total := 0
+	for len(p) > 0 {
+		// A full buffer is only flushed once more data arrives, so the
+		// final chunk is always written by Close.
+		if len(sw.buf) == sw.chunkSize {
+			if sw.err = sw.flush(false); sw.err != nil {
+				return total, sw.err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):sw.chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.flush(true)
+}
+
+func (sw *StreamWriter) flush(last bool) error {
+	if sw.counter >= streamMaxChunks {
+		return fmt.Errorf("stream exceeds the maximum number of chunks")
+	}
+	chunk := sw.aead.Seal(nil, sw.nonce(sw.counter, last), sw.buf, sw.aad)
+	if _, err := sw.w.Write(chunk); err != nil {
+		return fmt.Errorf("failed to write stream chunk: %w", err)
+	}
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+// StreamReader decrypts a stream sequentially. Read returns plaintext only
+// after its chunk has been authenticated, and returns ErrStreamTruncated if
+// the stream ends before the final chunk.
+type StreamReader struct {
+	streamState
+	r       *bufio.Reader
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+// NewStreamReader reads the stream header from r and resolves its key with fnKey.
+func NewStreamReader(r io.Reader, fnKey StreamKeyFunc) (*StreamReader, error) {
+	if r == nil || fnKey == nil {
+		return nil, fmt.Errorf("stream reader or key func is nil")
+	}
+	h, aad, err := ReadStreamHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	state, err := newStreamState(h, aad, fnKey)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{streamState: *state, r: bufio.NewReaderSize(r, state.encChunkSize()+1)}, nil
+}
+
+func newStreamState(h *StreamHeader, aad []byte, fnKey StreamKeyFunc) (*streamState, error) {
+	secret, err := fnKey(h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := h.newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &streamState{header: h, aad: aad, aead: aead, chunkSize: h.ChunkSize}, nil
+}
+
+// Header returns the stream header.
+func (sr *StreamReader) Header() *StreamHeader {
+	return sr.header
+}
+
+// Read implements io.Reader.
+func (sr *StreamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.readChunk()
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+func (sr *StreamReader) readChunk() error {
+	chunk := make([]byte, sr.encChunkSize())
+	n, err := io.ReadFull(sr.r, chunk)
+	switch {
+	case err == io.EOF:
+		return ErrStreamTruncated
+	case err == io.ErrUnexpectedEOF:
+		// Only the last chunk may be short.
+	case err != nil:
+		return fmt.Errorf("failed to read stream chunk: %w", err)
+	}
+	last := n < len(chunk)
+	if !last {
+		if _, err = sr.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return fmt.Errorf("failed to read stream chunk: %w", err)
+		}
+	}
+	if n < sr.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+	if sr.counter >= streamMaxChunks {
+		return fmt.Errorf("%w; too many chunks", ErrStreamAuthFailed)
+	}
+	plain, err := sr.open(chunk[:n], sr.counter, last)
+	if err != nil {
+		return err
+	}
+	sr.counter++
+	sr.plain = plain
+	sr.done = last
+	return nil
+}
+
+// StreamReaderAt decrypts any range of a stream held in an io.ReaderAt,
+// reading and authenticating only the chunks covering the range. It also
+// implements io.ReadSeeker over the plaintext.
+type StreamReaderAt struct {
+	streamState
+	r         io.ReaderAt
+	headerLen int64
+	nChunks   int64
+	size      int64
+	pos       int64
+
+	// The most recently decrypted chunk, for sequential reads. ReadAt may be
+	// called concurrently, so the cache is guarded by mu.
+	mu         sync.Mutex
+	cacheIdx   int64
+	cachePlain []byte
+}
+
+// NewStreamReaderAt reads the stream header from r, which holds size bytes
+// of stream, and resolves its key with fnKey.
+func NewStreamReaderAt(r io.ReaderAt, size int64, fnKey StreamKeyFunc) (*StreamReaderAt, error) {
+	if r == nil || fnKey == nil {
+		return nil, fmt.Errorf("stream reader or key func is nil")
+	}
+	h, aad, err := ReadStreamHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	state, err := newStreamState(h, aad, fnKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sra := &StreamReaderAt{streamState: *state, r: r, headerLen: int64(len(aad)), cacheIdx: -1}
+	body := size - sra.headerLen
+	enc := int64(state.encChunkSize())
+	sra.nChunks = (body + enc - 1) / enc
+	lastLen := body - (sra.nChunks-1)*enc
+	if sra.nChunks == 0 || lastLen < int64(state.aead.Overhead()) {
+		return nil, ErrStreamTruncated
+	}
+	if sra.nChunks > streamMaxChunks {
+		return nil, fmt.Errorf("%w; too many chunks", ErrStreamHeaderInvalid)
+	}
+	sra.size = body - sra.nChunks*int64(state.aead.Overhead())
+	return sra, nil
+}
+
+// Header returns the stream header.
+func (sra *StreamReaderAt) Header() *StreamHeader {
+	return sra.header
+}
+
+// Size returns the plaintext size.
+func (sra *StreamReaderAt) Size() int64 {
+	return sra.size
+}
+
+func (sra *StreamReaderAt) chunk(idx int64) ([]byte, error) {
+	sra.mu.Lock()
+	if idx == sra.cacheIdx {
+		plain := sra.cachePlain
+		sra.mu.Unlock()
+		return plain, nil
+	}
+	sra.mu.Unlock()
+
+	enc := int64(sra.encChunkSize())
+	off := sra.headerLen + idx*enc
+	buf := make([]byte, enc)
+	n, err := sra.r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read stream chunk: %w", err)
+	}
+	last := idx == sra.nChunks-1
+	if !last && n < len(buf) {
+		return nil, ErrStreamTruncated
+	}
+	plain, err := sra.open(buf[:n], uint64(idx), last)
+	if err != nil {
+		return nil, err
+	}
+	sra.mu.Lock()
+	sra.cacheIdx, sra.cachePlain = idx, plain
+	sra.mu.Unlock()
+	return plain, nil
+}
+
+// ReadAt implements io.ReaderAt over the plaintext.
+func (sra *StreamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	total := 0
+	for len(p) > 0 {
+		if off >= sra.size {
+			return total, io.EOF
+		}
+		idx := off / int64(sra.chunkSize)
+		plain, err := sra.chunk(idx)
+		if err != nil {
+			return total, err
+		}
+		n := copy(p, plain[off-idx*int64(sra.chunkSize):])
+		p = p[n:]
+		off += int64(n)
+		total += n
+	}
+	return total, nil
+}
+
+// Read implements io.Reader from the current position.
+func (sra *StreamReaderAt) Read(p []byte) (int, error) {
+	n, err := sra.ReadAt(p, sra.pos)
+	sra.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker over the plaintext.
+func (sra *StreamReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sra.pos
+	case io.SeekEnd:
+		offset += sra.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	sra.pos = offset
+	return offset, nil
+}
+
+// StreamEncryptFile encrypts inputPath to outputPath with a passphrase.
+func StreamEncryptFile(inputPath, outputPath, passphrase string, opts *StreamOptions) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+
+	sw, err := NewStreamWriterWithPassphrase(out, passphrase, opts)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(sw, in); err != nil {
+		return fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	if err = sw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// StreamDecryptFile decrypts inputPath to outputPath with a passphrase. On
+// failure the partial output file is removed.
+func StreamDecryptFile(inputPath, outputPath, passphrase string) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer in.Close()
+
+	sr, err := NewStreamReader(in, StreamKeyFromBytes([]byte(passphrase)))
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err = io.Copy(out, sr); err != nil {
+		out.Close()
+		_ = os.Remove(outputPath)
+		return fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	return out.Close()
+}
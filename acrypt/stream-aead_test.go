@@ -0,0 +1,226 @@
+package acrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestStreamKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return key
+}
+
+func encryptTestStream(t *testing.T, key, plain []byte, opts *StreamOptions) []byte {
+	buf := &bytes.Buffer{}
+	sw, err := NewStreamWriter(buf, key, opts)
+	assert.NoError(t, err)
+	// Uneven writes cross chunk boundaries.
+	for len(plain) > 0 {
+		n := 37
+		if n > len(plain) {
+			n = len(plain)
+		}
+		_, err = sw.Write(plain[:n])
+		assert.NoError(t, err)
+		plain = plain[n:]
+	}
+	assert.NoError(t, sw.Close())
+	return buf.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := newTestStreamKey(t)
+	for _, cipherId := range []StreamCipher{STREAMCIPHER_AES256GCM, STREAMCIPHER_CHACHA20POLY1305} {
+		// Empty, partial, exact and multi-chunk plaintexts.
+		for _, size := range []int{0, 10, 128, 256, 1000} {
+			plain := make([]byte, size)
+			_, _ = rand.Read(plain)
+			enc := encryptTestStream(t, key, plain, &StreamOptions{Cipher: cipherId, ChunkSize: 128, KeyId: "k1"})
+
+			sr, err := NewStreamReader(bytes.NewReader(enc), StreamKeyFromMap(map[string][]byte{"k1": key}))
+			assert.NoError(t, err)
+			assert.Equal(t, "k1", sr.Header().KeyId)
+			out, err := io.ReadAll(sr)
+			assert.NoError(t, err, "cipher %d size %d", cipherId, size)
+			assert.True(t, bytes.Equal(plain, out))
+		}
+	}
+}
+
+func TestStreamTamperAndTruncation(t *testing.T) {
+	key := newTestStreamKey(t)
+	plain := bytes.Repeat([]byte("0123456789"), 100)
+	enc := encryptTestStream(t, key, plain, &StreamOptions{ChunkSize: 100})
+	h, aad, err := ReadStreamHeader(bytes.NewReader(enc))
+	assert.NoError(t, err)
+	chunk := 100 + 16
+	headerLen := len(aad)
+	assert.Equal(t, STREAMKDF_HKDF_SHA256, h.KDF)
+
+	read := func(data []byte, fnKey StreamKeyFunc) error {
+		sr, err := NewStreamReader(bytes.NewReader(data), fnKey)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(sr)
+		return err
+	}
+
+	// Cut at a chunk boundary: every remaining chunk authenticates, but none is last.
+	err = read(enc[:headerLen+3*chunk], StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamTruncated), "%v", err)
+	// Only the header.
+	err = read(enc[:headerLen], StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamTruncated), "%v", err)
+	// Cut inside a chunk.
+	err = read(enc[:headerLen+3*chunk+50], StreamKeyFromBytes(key))
+	assert.Error(t, err)
+
+	flipped := append([]byte{}, enc...)
+	flipped[headerLen+2*chunk+5] ^= 1
+	err = read(flipped, StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+
+	// Swapping two chunks breaks the counter.
+	swapped := append([]byte{}, enc...)
+	copy(swapped[headerLen:headerLen+chunk], enc[headerLen+chunk:headerLen+2*chunk])
+	copy(swapped[headerLen+chunk:headerLen+2*chunk], enc[headerLen:headerLen+chunk])
+	err = read(swapped, StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+
+	// The header is authenticated with every chunk.
+	header := append([]byte{}, enc...)
+	header[len(streamMagic)+4+saltSize] ^= 1 // Inside the salt.
+	err = read(header, StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+
+	err = read(enc, StreamKeyFromBytes(newTestStreamKey(t)))
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+	err = read(enc, StreamKeyFromMap(map[string][]byte{"other": key}))
+	assert.Error(t, err)
+	err = read([]byte("ACS2garbage-garbage-garbage-garbage-garbage"), StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamHeaderInvalid), "%v", err)
+
+	// A writer that is never closed leaves a truncated stream.
+	buf := &bytes.Buffer{}
+	sw, err := NewStreamWriter(buf, key, &StreamOptions{ChunkSize: 100})
+	assert.NoError(t, err)
+	_, _ = sw.Write(plain)
+	err = read(buf.Bytes(), StreamKeyFromBytes(key))
+	assert.True(t, errors.Is(err, ErrStreamTruncated), "%v", err)
+}
+
+func TestStreamReaderAtRanges(t *testing.T) {
+	key := newTestStreamKey(t)
+	plain := make([]byte, 1050)
+	_, _ = rand.Read(plain)
+	enc := encryptTestStream(t, key, plain, &StreamOptions{ChunkSize: 100})
+
+	sra, err := NewStreamReaderAt(bytes.NewReader(enc), int64(len(enc)), StreamKeyFromBytes(key))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(plain)), sra.Size())
+
+	for _, rng := range [][2]int{{0, 10}, {95, 110}, {250, 700}, {1000, 1050}, {1049, 1050}} {
+		buf := make([]byte, rng[1]-rng[0])
+		n, err := sra.ReadAt(buf, int64(rng[0]))
+		assert.NoError(t, err)
+		assert.Equal(t, len(buf), n)
+		assert.Equal(t, plain[rng[0]:rng[1]], buf)
+	}
+	n, err := sra.ReadAt(make([]byte, 20), 1040)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, io.EOF, err)
+
+	pos, err := sra.Seek(-300, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(750), pos)
+	rest, err := io.ReadAll(sra)
+	assert.NoError(t, err)
+	assert.Equal(t, plain[750:], rest)
+
+	// A range in an intact chunk still reads when a later chunk is corrupt,
+	// but the corrupt chunk never returns plaintext.
+	corrupt := append([]byte{}, enc...)
+	corrupt[len(corrupt)-20] ^= 1
+	sra, err = NewStreamReaderAt(bytes.NewReader(corrupt), int64(len(corrupt)), StreamKeyFromBytes(key))
+	assert.NoError(t, err)
+	_, err = sra.ReadAt(make([]byte, 10), 0)
+	assert.NoError(t, err)
+	_, err = sra.ReadAt(make([]byte, 10), 1040)
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+
+	// Cutting whole chunks off the end is detected on the new last chunk.
+	cut := enc[:len(enc)-(50+16)]
+	sra, err = NewStreamReaderAt(bytes.NewReader(cut), int64(len(cut)), StreamKeyFromBytes(key))
+	assert.NoError(t, err)
+	_, err = sra.ReadAt(make([]byte, 10), 990)
+	assert.True(t, errors.Is(err, ErrStreamTruncated), "%v", err)
+}
+
+func TestStreamReaderAtParallel(t *testing.T) {
+	key := newTestStreamKey(t)
+	plain := make([]byte, 2000)
+	_, _ = rand.Read(plain)
+	enc := encryptTestStream(t, key, plain, &StreamOptions{ChunkSize: 100})
+
+	sra, err := NewStreamReaderAt(bytes.NewReader(enc), int64(len(enc)), StreamKeyFromBytes(key))
+	assert.NoError(t, err)
+
+	// Readers share the chunk cache; run with -race.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			for off := start; off+30 <= len(plain); off += 70 {
+				buf := make([]byte, 30)
+				n, err := sra.ReadAt(buf, int64(off))
+				assert.NoError(t, err)
+				assert.Equal(t, 30, n)
+				assert.Equal(t, plain[off:off+30], buf)
+			}
+		}(i * 13)
+	}
+	wg.Wait()
+}
+
+func TestStreamEncryptDecryptFile(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "plain.bin")
+	encPath := filepath.Join(dir, "plain.bin.enc")
+	outPath := filepath.Join(dir, "plain.out")
+
+	plain := make([]byte, 200*1024+7)
+	_, _ = rand.Read(plain)
+	assert.NoError(t, os.WriteFile(inPath, plain, 0600))
+
+	opts := &StreamOptions{Iterations: 1000, KeyId: "backup-2024"}
+	assert.NoError(t, StreamEncryptFile(inPath, encPath, "passphrase", opts))
+	assert.NoError(t, StreamDecryptFile(encPath, outPath, "passphrase"))
+	out, err := os.ReadFile(outPath)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(plain, out))
+
+	f, err := os.Open(encPath)
+	assert.NoError(t, err)
+	h, _, err := ReadStreamHeader(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, STREAMKDF_PBKDF2_SHA256, h.KDF)
+	assert.Equal(t, 1000, h.Iterations)
+	assert.Equal(t, "backup-2024", h.KeyId)
+
+	err = StreamDecryptFile(encPath, outPath, "wrong")
+	assert.True(t, errors.Is(err, ErrStreamAuthFailed), "%v", err)
+	_, err = os.Stat(outPath)
+	assert.True(t, os.IsNotExist(err), "partial output should be removed")
+}
@@ -5,17 +5,25 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/anthonynsimon/bild/blur"
+	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"image"
 	"image/png"
 	"os"
 	"strings"
+	"time"
 )
 
 // TOTP struct holds the secret and image for a TOTP (Time-based One-Time Password).
+// Empty Digits, Period and Algorithm use the authenticator defaults (6, 30s, SHA1).
 type TOTP struct {
-	Secret string `json:"secret,omitempty"` // The TOTP secret key
-	Image  []byte `json:"image,omitempty"`  // PNG image bytes of the QR code
+	Secret    string       `json:"secret,omitempty"`    // The TOTP secret key
+	Image     []byte       `json:"image,omitempty"`     // PNG image bytes of the QR code
+	Digits    int          `json:"digits,omitempty"`    // 6 or 8
+	Period    int          `json:"period,omitempty"`    // Seconds per step
+	Algorithm OTPAlgorithm `json:"algorithm,omitempty"` // HMAC hash
+	LastStep  int64        `json:"lastStep,omitempty"`  // Last accepted time step; codes at or before it are replays.
+	Drift     int64        `json:"drift,omitempty"`     // Learned clock drift of the authenticator, in steps.
 }
 
 // HasSecret checks if the TOTP struct has a secret set.
@@ -105,15 +113,37 @@ func (tot *TOTP) SaveImageBlur(filepath string) error {
 
 // TOTPGenerate generates a new TOTP object including the secret and QR code image.
 func TOTPGenerate(issuer string, account string, imageDimension int) (*TOTP, error) {
+	return TOTPGenerateWithOptions(issuer, account, imageDimension, 0, 0, "")
+}
+
+// TOTPGenerateWithOptions generates a TOTP with the given digits, period and
+// algorithm. Zero values use the defaults; note that some authenticator
+// apps ignore non-default settings.
+func TOTPGenerateWithOptions(issuer string, account string, imageDimension int, digits int, period int, alg OTPAlgorithm) (*TOTP, error) {
+	if digits = otpDigits(digits); digits != 6 && digits != 8 {
+		return nil, fmt.Errorf("unsupported totp digits %d", digits)
+	}
+	if period <= 0 {
+		period = TOTP_PERIOD_DEFAULT
+	}
+	if !alg.IsValid() {
+		return nil, fmt.Errorf("unsupported totp algorithm '%s'", alg)
+	}
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      issuer,
 		AccountName: account,
+		Period:      uint(period),
+		Digits:      otp.Digits(digits),
+		Algorithm:   alg.otp(),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	ud := &TOTP{Secret: key.Secret()}
+	if digits != OTP_DIGITS_DEFAULT || period != TOTP_PERIOD_DEFAULT || alg.String() != string(OTPALG_SHA1) {
+		ud.Digits, ud.Period, ud.Algorithm = digits, period, alg
+	}
 
 	if imageDimension < 100 {
 		imageDimension = 400
@@ -134,9 +164,116 @@ func TOTPGenerate(issuer string, account string, imageDimension int) (*TOTP, err
 }
 
 // TOTPValidate validates a submitted TOTP against the system's secret.
+// It has no replay protection; prefer TOTP.Validate for logins.
 func TOTPValidate(submittedSecret string, systemSecret string) bool {
 	if submittedSecret == "" || systemSecret == "" {
 		return false
 	}
 	return totp.Validate(submittedSecret, systemSecret)
 }
+
+// period returns the step length in seconds.
+func (tot *TOTP) period() int64 {
+	if tot.Period <= 0 {
+		return TOTP_PERIOD_DEFAULT
+	}
+	return int64(tot.Period)
+}
+
+// step returns the time step of at, corrected by the learned drift.
+func (tot *TOTP) step(at time.Time) int64 {
+	return at.Unix()/tot.period() + tot.Drift
+}
+
+// GenerateCodeAt returns the code for the step at the given time, ignoring drift.
+func (tot *TOTP) GenerateCodeAt(at time.Time) (string, error) {
+	return OTPGenerateCode(tot.Secret, uint64(at.Unix()/tot.period()), tot.Digits, tot.Algorithm)
+}
+
+// Validate checks code against the current step and skew steps either side
+// (negative uses TOTP_SKEW_DEFAULT). An accepted step is recorded in
+// LastStep, so a code cannot be reused and older codes are rejected with
+// ErrOTPReplayed. Matches off the current step adjust Drift, within the
+// resync window. Persist the TOTP after a successful validation.
+func (tot *TOTP) Validate(code string, skew int) (bool, error) {
+	return tot.ValidateAt(code, time.Now(), skew)
+}
+
+// ValidateAt is Validate at the given time.
+func (tot *TOTP) ValidateAt(code string, at time.Time, skew int) (bool, error) {
+	if !tot.HasSecret() || strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+	if skew < 0 {
+		skew = TOTP_SKEW_DEFAULT
+	}
+	current := tot.step(at)
+	for _, offset := range totpOffsets(skew) {
+		step := current + int64(offset)
+		if step < 0 {
+			continue
+		}
+		ok, err := otpMatch(tot.Secret, uint64(step), tot.Digits, tot.Algorithm, code)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		if step <= tot.LastStep {
+			return false, ErrOTPReplayed
+		}
+		tot.LastStep = step
+		if drift := tot.Drift + int64(offset); drift >= -TOTP_RESYNC_WINDOW_DEFAULT && drift <= TOTP_RESYNC_WINDOW_DEFAULT {
+			tot.Drift = drift
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// totpOffsets returns 0, -1, 1, -2, 2 ... so the closest step is tried first.
+func totpOffsets(skew int) []int {
+	offsets := []int{0}
+	for ii := 1; ii <= skew; ii++ {
+		offsets = append(offsets, -ii, ii)
+	}
+	return offsets
+}
+
+// Resync recovers from a large clock drift using two consecutive codes from
+// the authenticator, searching window steps either side (0 uses
+// TOTP_RESYNC_WINDOW_DEFAULT). On success Drift and LastStep are updated.
+func (tot *TOTP) Resync(code1 string, code2 string, window int) (bool, error) {
+	return tot.ResyncAt(code1, code2, time.Now(), window)
+}
+
+// ResyncAt is Resync at the given time.
+func (tot *TOTP) ResyncAt(code1 string, code2 string, at time.Time, window int) (bool, error) {
+	if !tot.HasSecret() || strings.TrimSpace(code1) == "" || strings.TrimSpace(code2) == "" {
+		return false, nil
+	}
+	if window <= 0 {
+		window = TOTP_RESYNC_WINDOW_DEFAULT
+	}
+	base := at.Unix() / tot.period()
+	for _, offset := range totpOffsets(window) {
+		step := base + int64(offset)
+		if step < 0 || step+1 <= tot.LastStep {
+			continue
+		}
+		ok1, err := otpMatch(tot.Secret, uint64(step), tot.Digits, tot.Algorithm, code1)
+		if err != nil {
+			return false, err
+		}
+		if !ok1 {
+			continue
+		}
+		if ok2, _ := otpMatch(tot.Secret, uint64(step+1), tot.Digits, tot.Algorithm, code2); ok2 {
+			tot.Drift = int64(offset) + 1 // The authenticator is showing the second code now.
+			tot.LastStep = step + 1
+			return true, nil
+		}
+	}
+	return false, nil
+}
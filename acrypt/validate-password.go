@@ -70,6 +70,11 @@ func ValidatePasswordComplex(password string) error {
 	return nil
 }
 
+// ValidatePasswordCharacterTypes checks for at least one lowercase, uppercase, digit, and special character.
+func ValidatePasswordCharacterTypes(password string) error {
+	return validateCustomCharacterTypes(password)
+}
+
 // validateCustomCharacterTypes checks for at least one lowercase, uppercase, digit, and special character.
 func validateCustomCharacterTypes(password string) error {
 	var hasLower, hasUpper, hasNumber, hasSpecial bool
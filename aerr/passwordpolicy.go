@@ -0,0 +1,162 @@
+package aerr
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+const (
+	PASSWORDPOLICY_MIN_LENGTH_DEFAULT    = 8
+	PASSWORDPOLICY_MAX_LENGTH_DEFAULT    = 100
+	PASSWORDPOLICY_MIN_SCORE_DEFAULT     = 3
+	PASSWORDPOLICY_HISTORY_COUNT_DEFAULT = 5
+)
+
+// Validation tags set by PasswordPolicy.
+const (
+	PASSWORDPOLICY_TAG_EMPTY      = "empty_password"
+	PASSWORDPOLICY_TAG_LENGTH     = "length"
+	PASSWORDPOLICY_TAG_COMPLEXITY = "complexity"
+	PASSWORDPOLICY_TAG_STRENGTH   = "strength"
+	PASSWORDPOLICY_TAG_BREACHED   = "breached"
+	PASSWORDPOLICY_TAG_REUSED     = "reused"
+	PASSWORDPOLICY_TAG_CUSTOM     = "custom"
+)
+
+// PasswordPolicy is a configurable set of password rules. Unlike
+// EvaluatePasswordStrengthAndErrors, it can reject passwords found in a breach
+// corpus or reused from the account's password history.
+type PasswordPolicy struct {
+	MinLength int `json:"minLength,omitempty"`
+	MaxLength int `json:"maxLength,omitempty"`
+
+	// MinScore is the minimum zxcvbn score (0-4); 0 disables the check.
+	MinScore int `json:"minScore,omitempty"`
+
+	// RequireCharacterTypes requires lowercase, uppercase, digit and special characters.
+	RequireCharacterTypes bool `json:"requireCharacterTypes,omitempty"`
+
+	// HistoryCount rejects reuse of the last N password hashes; 0 disables the check.
+	HistoryCount int `json:"historyCount,omitempty"`
+
+	// MaxAge is how long a password may be used before rotation; 0 never expires.
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+
+	// Breached is an optional breach corpus, such as an acrypt.BreachedPrefixFS
+	// or acrypt.BreachedBloomFilter. If the corpus cannot be read, the
+	// password is rejected.
+	Breached acrypt.IBreachedPasswords `json:"-"`
+
+	// CustomValidator runs after the built-in rules.
+	CustomValidator acrypt.FNValidatePassword `json:"-"`
+}
+
+// NewPasswordPolicy returns a policy with the defaults.
+func NewPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:             PASSWORDPOLICY_MIN_LENGTH_DEFAULT,
+		MaxLength:             PASSWORDPOLICY_MAX_LENGTH_DEFAULT,
+		MinScore:              PASSWORDPOLICY_MIN_SCORE_DEFAULT,
+		RequireCharacterTypes: true,
+		HistoryCount:          PASSWORDPOLICY_HISTORY_COUNT_DEFAULT,
+	}
+}
+
+// Evaluate checks password against the policy and returns its zxcvbn score
+// and any validation errors. history holds the account's previous password
+// hashes, newest first. userInputs, such as the username and email, are
+// penalized by the strength check.
+func (pp *PasswordPolicy) Evaluate(password string, history []string, userInputs ...string) (int, *ValidationErrors) {
+	validationErrors := ValidationErrors{}
+	add := func(tag string, message string, sysErr error) {
+		validationErrors.Add(&ValidationError{Message: message, Field: "password", Tag: tag, SysError: sysErr})
+	}
+
+	if password == "" {
+		add(PASSWORDPOLICY_TAG_EMPTY, "Password is empty.", nil)
+		return 0, &validationErrors
+	}
+
+	length := utf8.RuneCountInString(password)
+	if pp.MinLength > 0 && length < pp.MinLength {
+		add(PASSWORDPOLICY_TAG_LENGTH, fmt.Sprintf("Password must be at least %d characters.", pp.MinLength), nil)
+	}
+	if pp.MaxLength > 0 && length > pp.MaxLength {
+		add(PASSWORDPOLICY_TAG_LENGTH, fmt.Sprintf("Password must be at most %d characters.", pp.MaxLength), nil)
+	}
+
+	if pp.RequireCharacterTypes {
+		if err := acrypt.ValidatePasswordCharacterTypes(password); err != nil {
+			add(PASSWORDPOLICY_TAG_COMPLEXITY, err.Error(), nil)
+		}
+	}
+
+	strength := zxcvbn.PasswordStrength(password, userInputs)
+	if pp.MinScore > 0 && strength.Score < pp.MinScore {
+		add(PASSWORDPOLICY_TAG_STRENGTH, fmt.Sprintf("Password strength is too weak: score %d (minimum required score is %d)", strength.Score, pp.MinScore), nil)
+	}
+
+	if pp.Breached != nil {
+		if ok, err := pp.Breached.IsBreached(password); err != nil {
+			add(PASSWORDPOLICY_TAG_BREACHED, "Password could not be checked against known breaches.", fmt.Errorf("failed breached password check; %v", err))
+		} else if ok {
+			add(PASSWORDPOLICY_TAG_BREACHED, "Password has appeared in a data breach and cannot be used.", nil)
+		}
+	}
+
+	if pp.IsReused(password, history) {
+		add(PASSWORDPOLICY_TAG_REUSED, fmt.Sprintf("Password cannot match any of the last %d passwords.", pp.HistoryCount), nil)
+	}
+
+	if pp.CustomValidator != nil {
+		if err := pp.CustomValidator(password); err != nil {
+			add(PASSWORDPOLICY_TAG_CUSTOM, err.Error(), nil)
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return strength.Score, &validationErrors
+	}
+	return strength.Score, nil
+}
+
+// IsReused returns true if password matches one of the last HistoryCount hashes.
+// Hashes in an unsupported format are skipped.
+func (pp *PasswordPolicy) IsReused(password string, history []string) bool {
+	if pp.HistoryCount <= 0 || password == "" {
+		return false
+	}
+	for ii, hash := range history {
+		if ii >= pp.HistoryCount {
+			break
+		}
+		if ok, err := acrypt.MatchPassword(hash, password); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PushHistory prepends hash to history and trims it to HistoryCount.
+func (pp *PasswordPolicy) PushHistory(history []string, hash string) []string {
+	if pp.HistoryCount <= 0 {
+		return nil
+	}
+	history = append([]string{hash}, history...)
+	if len(history) > pp.HistoryCount {
+		history = history[:pp.HistoryCount]
+	}
+	return history
+}
+
+// IsExpired returns true if a password changed at changedAt is due for rotation at now.
+func (pp *PasswordPolicy) IsExpired(changedAt time.Time, now time.Time) bool {
+	if pp.MaxAge <= 0 || changedAt.IsZero() {
+		return false
+	}
+	return !now.Before(changedAt.Add(pp.MaxAge))
+}
@@ -0,0 +1,118 @@
+package aerr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passwordPolicyTags returns the tags of ves.
+func passwordPolicyTags(ves *ValidationErrors) []string {
+	if ves == nil {
+		return nil
+	}
+	var tags []string
+	for _, ve := range *ves {
+		tags = append(tags, ve.Tag)
+	}
+	return tags
+}
+
+func TestPasswordPolicy_Evaluate(t *testing.T) {
+	hash := acrypt.BreachedSHA1("Tr0ub4dor&3xyz")
+	pp := NewPasswordPolicy()
+	pp.Breached = &acrypt.BreachedPrefixFS{FS: fstest.MapFS{
+		hash[:5]: {Data: []byte(hash[5:] + ":12\n")},
+	}}
+
+	score, ves := pp.Evaluate("Correct-Horse-Battery-9", nil)
+	assert.Nil(t, ves)
+	assert.GreaterOrEqual(t, score, 3)
+
+	_, ves = pp.Evaluate("", nil)
+	assert.Equal(t, []string{PASSWORDPOLICY_TAG_EMPTY}, passwordPolicyTags(ves))
+
+	_, ves = pp.Evaluate("aB1!", nil)
+	assert.Contains(t, passwordPolicyTags(ves), PASSWORDPOLICY_TAG_LENGTH)
+	assert.Contains(t, passwordPolicyTags(ves), PASSWORDPOLICY_TAG_STRENGTH)
+
+	_, ves = pp.Evaluate("correct-horse-battery-staple", nil)
+	assert.Equal(t, []string{PASSWORDPOLICY_TAG_COMPLEXITY}, passwordPolicyTags(ves))
+
+	_, ves = pp.Evaluate("Tr0ub4dor&3xyz", nil)
+	assert.Contains(t, passwordPolicyTags(ves), PASSWORDPOLICY_TAG_BREACHED)
+
+	// User inputs lower the score.
+	pp.RequireCharacterTypes = false
+	_, ves = pp.Evaluate("alicesmithson", nil, "alicesmithson")
+	assert.Contains(t, passwordPolicyTags(ves), PASSWORDPOLICY_TAG_STRENGTH)
+
+	pp.CustomValidator = func(target string) error { return errors.New("no horses") }
+	_, ves = pp.Evaluate("Correct-Horse-Battery-9", nil)
+	assert.Equal(t, []string{PASSWORDPOLICY_TAG_CUSTOM}, passwordPolicyTags(ves))
+}
+
+// passwordPolicyFailingCorpus cannot be read.
+type passwordPolicyFailingCorpus struct{}
+
+func (passwordPolicyFailingCorpus) IsBreached(password string) (bool, error) {
+	return false, errors.New("corpus unavailable")
+}
+
+func TestPasswordPolicy_BreachedUnavailable(t *testing.T) {
+	pp := NewPasswordPolicy()
+	pp.Breached = passwordPolicyFailingCorpus{}
+	_, ves := pp.Evaluate("Correct-Horse-Battery-9", nil)
+	require.NotNil(t, ves)
+	assert.Equal(t, PASSWORDPOLICY_TAG_BREACHED, (*ves)[0].Tag)
+	assert.Contains(t, (*ves)[0].GetSysError().Error(), "corpus unavailable")
+}
+
+func TestPasswordPolicy_History(t *testing.T) {
+	pp := NewPasswordPolicy()
+	pp.HistoryCount = 2
+
+	var history []string
+	for _, pw := range []string{"Oldest-Horse-Battery-1", "Older-Horse-Battery-2", "Newest-Horse-Battery-3"} {
+		hash, err := acrypt.HashPassword(pw, nil)
+		require.NoError(t, err)
+		history = pp.PushHistory(history, hash)
+	}
+	require.Len(t, history, 2)
+
+	_, ves := pp.Evaluate("Newest-Horse-Battery-3", history)
+	assert.Equal(t, []string{PASSWORDPOLICY_TAG_REUSED}, passwordPolicyTags(ves))
+	assert.True(t, pp.IsReused("Older-Horse-Battery-2", history))
+	assert.False(t, pp.IsReused("Oldest-Horse-Battery-1", history), "dropped from history")
+	assert.False(t, pp.IsReused("Newest-Horse-Battery-3", []string{"not-a-hash"}))
+
+	pp.HistoryCount = 0
+	assert.False(t, pp.IsReused("Newest-Horse-Battery-3", history))
+	assert.Nil(t, pp.PushHistory(history, "hash"))
+}
+
+func TestPasswordPolicy_IsExpired(t *testing.T) {
+	pp := NewPasswordPolicy()
+	changed := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.False(t, pp.IsExpired(changed, changed.AddDate(10, 0, 0)), "no max age")
+
+	pp.MaxAge = 90 * 24 * time.Hour
+	assert.False(t, pp.IsExpired(changed, changed.Add(pp.MaxAge-time.Second)))
+	assert.True(t, pp.IsExpired(changed, changed.Add(pp.MaxAge)))
+	assert.False(t, pp.IsExpired(time.Time{}, changed))
+}
+
+func TestPasswordPolicy_ValidationErrorsJSON(t *testing.T) {
+	pp := NewPasswordPolicy()
+	_, ves := pp.Evaluate("short", nil)
+	require.NotNil(t, ves)
+	b, err := ves.MarshalJSON()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(b), `[{"message":`))
+}
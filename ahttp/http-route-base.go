@@ -19,6 +19,11 @@ type IRoute interface {
 	GetWhitelist() string
 }
 
+// IRouteStepUp is implemented by routes that can require step-up authentication.
+type IRouteStepUp interface {
+	GetStepUp() asessions.StepUpRequirement // Returns the max auth age and level required to access the route.
+}
+
 // IRoutes is a slice of IRoute interfaces.
 type IRoutes []IRoute
 
@@ -45,6 +50,8 @@ type RouteBase struct {
 	Perms           asessions.PermSet // Permissions required to access the route.
 	RouteNotFoundId HttpRouteId       // Identifier of the route to use when the current route is not found.
 
+	StepUp asessions.StepUpRequirement // Step-up authentication required to access the route, if any.
+
 	Whitelist string // whitelist is needed when using actions or maintenance mode.
 
 	indexAdded int          // The order in which the route was added.
@@ -86,6 +93,13 @@ func (rb *RouteBase) GetPerms() asessions.PermSet {
 	return rb.Perms
 }
 
+// GetStepUp returns the step-up authentication required to access the route.
+func (rb *RouteBase) GetStepUp() asessions.StepUpRequirement {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.StepUp
+}
+
 // GetRouteNotFoundId returns the identifier of the route to use when the current route is not found.
 func (rb *RouteBase) GetRouteNotFoundId() HttpRouteId {
 	rb.mu.RLock()
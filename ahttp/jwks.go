@@ -0,0 +1,38 @@
+package ahttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// MIMETYPE_JWKS is the media type of a JWKS document (RFC 7517).
+const MIMETYPE_JWKS = `application/jwk-set+json`
+
+const (
+	JWKS_PATH_WELLKNOWN = "/.well-known/jwks.json"
+	JWKS_MAXAGE_DEFAULT = 300 // Seconds.
+)
+
+// JWKSHandler serves the public keys of publisher (eg an *acrypt.JWTKeyring)
+// as a JWKS document. maxAgeSeconds sets Cache-Control so remote verifiers
+// refetch after a rotation; keep it well under the keyring overlap.
+func JWKSHandler(publisher acrypt.IJWKSPublisher, maxAgeSeconds int) echo.HandlerFunc {
+	if maxAgeSeconds <= 0 {
+		maxAgeSeconds = JWKS_MAXAGE_DEFAULT
+	}
+	cacheControl := fmt.Sprintf("public, max-age=%d", maxAgeSeconds)
+	return func(c echo.Context) error {
+		if publisher == nil {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		b, err := json.Marshal(publisher.JWKS())
+		if err != nil {
+			return fmt.Errorf("failed to marshal jwks; %v", err)
+		}
+		c.Response().Header().Set(echo.HeaderCacheControl, cacheControl)
+		return c.Blob(http.StatusOK, MIMETYPE_JWKS, b)
+	}
+}
@@ -0,0 +1,59 @@
+package ahttp
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestJWKSHandler publishes a keyring and verifies its tokens remotely.
+func TestJWKSHandler(t *testing.T) {
+	kr, err := acrypt.NewJWTKeyring(acrypt.JWTALG_EDDSA, 24, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.GET(JWKS_PATH_WELLKNOWN, JWKSHandler(kr, 60))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + JWKS_PATH_WELLKNOWN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(echo.HeaderContentType) != MIMETYPE_JWKS || resp.Header.Get(echo.HeaderCacheControl) != "public, max-age=60" {
+		t.Errorf("unexpected headers %v", resp.Header)
+	}
+
+	remote, err := acrypt.NewJWKSRemote(srv.URL+JWKS_PATH_WELLKNOWN, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := kr.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, err = remote.Verify(signed, claims); err != nil || claims.Subject != "alice" {
+		t.Fatalf("remote verify failed: %v", err)
+	}
+
+	// Tokens signed before a rotation still verify during the overlap.
+	if _, err = kr.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = remote.Verify(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("old token should verify during overlap: %v", err)
+	}
+	rotated, _ := kr.Sign(jwt.RegisteredClaims{Subject: "bob"})
+	if _, err = remote.Verify(rotated, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("new token should verify: %v", err)
+	}
+}
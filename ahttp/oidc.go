@@ -0,0 +1,110 @@
+package ahttp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/jpfluger/alibs-slim/amidware"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// OIDC_COOKIE_STATE names the cookie that binds the OIDC state to the
+// browser that started the login, which prevents login CSRF.
+const OIDC_COOKIE_STATE = "oidc_state"
+
+// IOIDCLogin connects the OIDC handlers to the site's sessions.
+type IOIDCLogin interface {
+	// LoginOIDC signs in the resolved account. Return an error (eg a 403) to
+	// refuse the login, such as for a deactivated account.
+	LoginOIDC(c echo.Context, ua *anode.UserAccount, identity *anode.OIDCIdentity) error
+}
+
+// OIDCHandlers serves OIDC relying-party login for one provider.
+type OIDCHandlers struct {
+	RelyingParty *anode.OIDCRelyingParty
+	Accounts     anode.IOIDCAccountStore
+	Login        IOIDCLogin
+
+	// UrlSuccess is where Callback redirects when the login had no local return url; "/" if empty.
+	UrlSuccess string
+}
+
+// NewOIDCHandlers creates the handlers.
+func NewOIDCHandlers(rp *anode.OIDCRelyingParty, accounts anode.IOIDCAccountStore, login IOIDCLogin, urlSuccess string) (*OIDCHandlers, error) {
+	if rp == nil || accounts == nil || login == nil {
+		return nil, fmt.Errorf("relying party, accounts and login are required")
+	}
+	if urlSuccess == "" {
+		urlSuccess = "/"
+	}
+	return &OIDCHandlers{RelyingParty: rp, Accounts: accounts, Login: login, UrlSuccess: urlSuccess}, nil
+}
+
+// Begin redirects the browser to the provider. A local "return" parameter
+// is kept for the callback; otherwise the callback returns to UrlSuccess.
+func (oh *OIDCHandlers) Begin(c echo.Context) error {
+	authUrl, req, err := oh.RelyingParty.AuthCodeURL(c.Request().Context(), amidware.StepUpReturnUrl(c, oh.UrlSuccess))
+	if err != nil {
+		return err
+	}
+	// Lax, so the cookie is sent on the top-level redirect back from the provider.
+	c.SetCookie(&http.Cookie{
+		Name:     OIDC_COOKIE_STATE,
+		Value:    req.State,
+		Path:     "/",
+		MaxAge:   int(oh.RelyingParty.Config.AuthRequestTTL / time.Second),
+		Secure:   c.IsTLS(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authUrl)
+}
+
+// Callback is the redirect url registered with the provider. It verifies the
+// response, resolves the account and signs in the user.
+func (oh *OIDCHandlers) Callback(c echo.Context) error {
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(OIDC_COOKIE_STATE)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(anode.ErrOIDCState)
+	}
+	c.SetCookie(&http.Cookie{Name: OIDC_COOKIE_STATE, Value: "", Path: "/", MaxAge: -1, Secure: c.IsTLS(), HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+	if errCode := c.QueryParam("error"); errCode != "" {
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(fmt.Errorf("oidc provider error '%s'; %s", errCode, c.QueryParam("error_description")))
+	}
+	identity, req, err := oh.RelyingParty.Exchange(c.Request().Context(), state, c.QueryParam("code"))
+	if err != nil {
+		return oidcHTTPError(err, http.StatusBadGateway)
+	}
+	ua, _, err := oh.RelyingParty.Resolve(identity, oh.Accounts)
+	if err != nil {
+		return oidcHTTPError(err, http.StatusInternalServerError)
+	}
+	if err = oh.Login.LoginOIDC(c, ua, identity); err != nil {
+		return err
+	}
+	if c.Response().Committed {
+		return nil
+	}
+	if req.ReturnUrl == "" {
+		return c.Redirect(http.StatusFound, oh.UrlSuccess)
+	}
+	return c.Redirect(http.StatusFound, req.ReturnUrl)
+}
+
+// oidcHTTPError maps failed logins to 401, identities without an account or
+// whose account may not sign in to 403 and other errors to status. Details are not returned to the client.
+func oidcHTTPError(err error, status int) error {
+	switch {
+	case errors.Is(err, anode.ErrOIDCAccountNotFound), errors.Is(err, anode.ErrOIDCEmailUnverified),
+		errors.Is(err, anode.ErrUserLoginNotAllowed):
+		return echo.NewHTTPError(http.StatusForbidden).SetInternal(err)
+	case errors.Is(err, anode.ErrOIDCState), errors.Is(err, anode.ErrOIDCNonce), errors.Is(err, anode.ErrOIDCIdToken):
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+	}
+	return echo.NewHTTPError(status).SetInternal(err)
+}
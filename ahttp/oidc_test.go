@@ -0,0 +1,191 @@
+package ahttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newOIDCTestProvider starts a mock OIDC provider that approves every
+// authorization request and issues ID tokens with claims.
+func newOIDCTestProvider(t *testing.T, claims jwt.MapClaims) *httptest.Server {
+	kr, err := acrypt.NewJWTKeyring(acrypt.JWTALG_ES256, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		srv   *httptest.Server
+		codes = map[string]url.Values{}
+		mu    sync.Mutex
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc(anode.OIDC_DISCOVERY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&anode.OIDCDiscovery{Issuer: srv.URL, AuthorizationEndpoint: srv.URL + "/authorize", TokenEndpoint: srv.URL + "/token", JwksUri: srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(kr.JWKS())
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		codes["code-"+q.Get("state")] = q
+		mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=code-"+q.Get("state")+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mu.Lock()
+		q, ok := codes[r.PostForm.Get("code")]
+		delete(codes, r.PostForm.Get("code"))
+		mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idClaims := jwt.MapClaims{"iss": srv.URL, "aud": q.Get("client_id"), "sub": "user-123", "nonce": q.Get("nonce"),
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+		idToken, _ := kr.Sign(idClaims)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// oidcTestAccounts holds a single existing account.
+type oidcTestAccounts struct {
+	ua *anode.UserAccount
+}
+
+func (s *oidcTestAccounts) FindUserAccountByExternalIdentity(issuer string, subject string) (*anode.UserAccount, error) {
+	if s.ua.ExternalIdentities.Find(issuer, subject) != nil {
+		return s.ua, nil
+	}
+	return nil, nil
+}
+
+func (s *oidcTestAccounts) FindUserAccountByEmail(email aemail.EmailAddress) (*anode.UserAccount, error) {
+	if s.ua.Email.Matches(email) {
+		return s.ua, nil
+	}
+	return nil, nil
+}
+
+func (s *oidcTestAccounts) SaveUserAccount(ua *anode.UserAccount, isNew bool) error {
+	return nil
+}
+
+// oidcTestLogin records the signed-in account.
+type oidcTestLogin struct {
+	ua *anode.UserAccount
+}
+
+func (l *oidcTestLogin) LoginOIDC(c echo.Context, ua *anode.UserAccount, identity *anode.OIDCIdentity) error {
+	l.ua = ua
+	return nil
+}
+
+func newOIDCTestServer(t *testing.T, claims jwt.MapClaims) (*httptest.Server, *oidcTestAccounts, *oidcTestLogin) {
+	idp := newOIDCTestProvider(t, claims)
+	e := echo.New()
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	rp, err := anode.NewOIDCRelyingParty(&anode.OIDCConfig{Name: "corp", Issuer: idp.URL, ClientId: "app", RedirectUrl: srv.URL + "/oidc/callback"}, anode.NewOIDCMemoryAuthRequestStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, login := &oidcTestAccounts{ua: &anode.UserAccount{Email: "user@example.com"}}, &oidcTestLogin{}
+	oh, err := NewOIDCHandlers(rp, accounts, login, "/dash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.GET("/oidc/login", oh.Begin)
+	e.GET("/oidc/callback", oh.Callback)
+	return srv, accounts, login
+}
+
+// oidcTestFollow runs the browser through login, the provider and the
+// callback and returns the final response.
+func oidcTestFollow(t *testing.T, browser *http.Client, loginUrl string) *http.Response {
+	target := loginUrl
+	for ii := 0; ii < 3; ii++ {
+		resp, err := browser.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if ii == 2 || resp.StatusCode != http.StatusFound {
+			return resp
+		}
+		target = resp.Header.Get("Location")
+	}
+	return nil
+}
+
+func TestOIDCHandlers_Login(t *testing.T) {
+	srv, accounts, login := newOIDCTestServer(t, jwt.MapClaims{"email": "user@example.com", "email_verified": true})
+
+	resp := oidcTestFollow(t, newPasswordlessTestClient(t), srv.URL+"/oidc/login?return=/reports")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/reports" {
+		t.Fatalf("expected redirect to /reports, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if login.ua != accounts.ua || len(accounts.ua.ExternalIdentities) != 1 || accounts.ua.ExternalIdentities[0].Subject != "user-123" {
+		t.Errorf("expected the existing account to be linked and signed in")
+	}
+
+	// An external return url is ignored.
+	resp = oidcTestFollow(t, newPasswordlessTestClient(t), srv.URL+"/oidc/login?return=https://evil.example.com")
+	if resp.Header.Get("Location") != "/dash" {
+		t.Errorf("expected redirect to /dash, got %s", resp.Header.Get("Location"))
+	}
+}
+
+func TestOIDCHandlers_CallbackState(t *testing.T) {
+	srv, _, login := newOIDCTestServer(t, jwt.MapClaims{"email": "user@example.com", "email_verified": true})
+
+	// Start the login in one browser and finish it in another (login CSRF).
+	resp, err := newPasswordlessTestClient(t).Get(srv.URL + "/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = newPasswordlessTestClient(t).Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = newPasswordlessTestClient(t).Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || login.ua != nil {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestOIDCHandlers_UnverifiedEmail(t *testing.T) {
+	srv, accounts, login := newOIDCTestServer(t, jwt.MapClaims{"email": "user@example.com", "email_verified": false})
+
+	resp := oidcTestFollow(t, newPasswordlessTestClient(t), srv.URL+"/oidc/login")
+	if resp.StatusCode != http.StatusForbidden || login.ua != nil || len(accounts.ua.ExternalIdentities) != 0 {
+		t.Errorf("expected 403 without linking, got %d", resp.StatusCode)
+	}
+}
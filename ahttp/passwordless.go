@@ -0,0 +1,204 @@
+package ahttp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// PASSWORDLESS_COOKIE_DEVICE names the cookie that binds a passwordless
+// challenge to the browser that requested it.
+const PASSWORDLESS_COOKIE_DEVICE = "pwl_device"
+
+// IPasswordlessLogin connects the passwordless handlers to the site's users and sessions.
+type IPasswordlessLogin interface {
+	// FindPasswordlessAccount returns the account of email, or nil if the
+	// address has no account; unknown addresses are not revealed when mail
+	// is requested.
+	FindPasswordlessAccount(c echo.Context, email aemail.EmailAddress) (*anode.UserAccount, error)
+	// LoginPasswordless signs in the user with email after a verified magic
+	// link or email OTP, once the account is found and allowed to sign in.
+	LoginPasswordless(c echo.Context, email aemail.EmailAddress, method asessions.AuthMethod) error
+}
+
+// PasswordlessBeginRequest is the body of PasswordlessHandlers.Begin.
+type PasswordlessBeginRequest struct {
+	Email aemail.EmailAddress    `json:"email" form:"email"`
+	Kind  anode.PasswordlessKind `json:"kind" form:"kind"`
+}
+
+// PasswordlessBeginResponse returns the challenge id of an email OTP.
+type PasswordlessBeginResponse struct {
+	Id string `json:"id,omitempty"`
+}
+
+// PasswordlessCodeRequest is the body of PasswordlessHandlers.Code.
+type PasswordlessCodeRequest struct {
+	Id   string `json:"id" form:"id"`
+	Code string `json:"code" form:"code"`
+}
+
+// PasswordlessHandlers serves magic-link and email OTP login.
+type PasswordlessHandlers struct {
+	Passwordless *anode.Passwordless
+	Login        IPasswordlessLogin
+
+	// UrlSuccess is where Link redirects after signing in; "/" if empty.
+	UrlSuccess string
+}
+
+// NewPasswordlessHandlers creates the handlers.
+func NewPasswordlessHandlers(p *anode.Passwordless, login IPasswordlessLogin, urlSuccess string) (*PasswordlessHandlers, error) {
+	if p == nil || login == nil {
+		return nil, fmt.Errorf("passwordless and login are required")
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if urlSuccess == "" {
+		urlSuccess = "/"
+	}
+	return &PasswordlessHandlers{Passwordless: p, Login: login, UrlSuccess: urlSuccess}, nil
+}
+
+// Begin emails a magic link or, for kind "email-otp", a code and returns its
+// challenge id. It responds 202 whether or not the address has an account.
+func (ph *PasswordlessHandlers) Begin(c echo.Context) error {
+	req := &PasswordlessBeginRequest{}
+	if err := c.Bind(req); err != nil || !req.Email.IsValid() {
+		return echo.NewHTTPError(http.StatusBadRequest, "a valid email is required")
+	}
+	device, err := ph.device(c, true)
+	if err != nil {
+		return err
+	}
+	resp := &PasswordlessBeginResponse{}
+	switch req.Kind {
+	case anode.PASSWORDLESS_EMAILOTP:
+		resp.Id, err = ph.Passwordless.BeginEmailOTP(req.Email, device)
+	case "", anode.PASSWORDLESS_MAGICLINK:
+		_, err = ph.Passwordless.BeginMagicLink(req.Email, device)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid passwordless kind")
+	}
+	if err != nil {
+		return passwordlessHTTPError(err)
+	}
+	return c.JSON(http.StatusAccepted, resp)
+}
+
+// Link is the target of the magic link. It signs in the user and redirects to UrlSuccess.
+func (ph *PasswordlessHandlers) Link(c echo.Context) error {
+	device, err := ph.device(c, false)
+	if err != nil {
+		return err
+	}
+	email, err := ph.Passwordless.FinishMagicLink(c.QueryParam(anode.PASSWORDLESS_PARAM_TOKEN), device)
+	if err != nil {
+		return passwordlessHTTPError(err)
+	}
+	if err = ph.login(c, email, asessions.AUTHMETHOD_MAGICLINK); err != nil {
+		return err
+	}
+	if c.Response().Committed {
+		return nil
+	}
+	return c.Redirect(http.StatusFound, ph.UrlSuccess)
+}
+
+// Code verifies an email OTP and signs in the user.
+func (ph *PasswordlessHandlers) Code(c echo.Context) error {
+	req := &PasswordlessCodeRequest{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code request")
+	}
+	device, err := ph.device(c, false)
+	if err != nil {
+		return err
+	}
+	email, err := ph.Passwordless.FinishEmailOTP(req.Id, req.Code, device)
+	if err != nil {
+		return passwordlessHTTPError(err)
+	}
+	if err = ph.login(c, email, asessions.AUTHMETHOD_EMAILOTP); err != nil {
+		return err
+	}
+	if c.Response().Committed {
+		return nil
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// login finds the account of email, refuses it with 403 if its lifecycle
+// state may not sign in and otherwise signs in the user.
+func (ph *PasswordlessHandlers) login(c echo.Context, email aemail.EmailAddress, method asessions.AuthMethod) error {
+	ua, err := ph.Login.FindPasswordlessAccount(c, email)
+	if err != nil {
+		return err
+	}
+	if ua == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if err = ua.CheckCanLogin(); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden).SetInternal(err)
+	}
+	return ph.Login.LoginPasswordless(c, email, method)
+}
+
+// device returns the device cookie value. If create is true, a missing cookie
+// is created and an existing one is extended to cover the new challenge.
+func (ph *PasswordlessHandlers) device(c echo.Context, create bool) (string, error) {
+	value := ""
+	if cookie, err := c.Cookie(PASSWORDLESS_COOKIE_DEVICE); err == nil {
+		value = cookie.Value
+	}
+	if !create {
+		if value == "" {
+			return "", echo.NewHTTPError(http.StatusUnauthorized).SetInternal(anode.ErrPasswordlessDevice)
+		}
+		return value, nil
+	}
+	if value == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate passwordless device; %v", err)
+		}
+		value = base64.RawURLEncoding.EncodeToString(b)
+	}
+	ttl := ph.Passwordless.LinkTTL
+	if ph.Passwordless.CodeTTL > ttl {
+		ttl = ph.Passwordless.CodeTTL
+	}
+	// Lax, so the cookie is sent when the link is opened from the mail client.
+	c.SetCookie(&http.Cookie{
+		Name:     PASSWORDLESS_COOKIE_DEVICE,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl / time.Second),
+		Secure:   c.IsTLS(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return value, nil
+}
+
+// passwordlessHTTPError maps failed challenges to 401 and rate limits to 429.
+// Details are not returned to the client.
+func passwordlessHTTPError(err error) error {
+	switch {
+	case errors.Is(err, anode.ErrPasswordlessRateLimited):
+		return echo.NewHTTPError(http.StatusTooManyRequests).SetInternal(err)
+	case errors.Is(err, anode.ErrPasswordlessInvalid), errors.Is(err, anode.ErrPasswordlessExpired),
+		errors.Is(err, anode.ErrPasswordlessUsed), errors.Is(err, anode.ErrPasswordlessDevice),
+		errors.Is(err, anode.ErrPasswordlessAttempts):
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package ahttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passwordlessTestMailer keeps the last link and code.
+type passwordlessTestMailer struct {
+	link string
+	code string
+}
+
+func (m *passwordlessTestMailer) SendPasswordless(email string, link string, code string, expires time.Time) error {
+	m.link, m.code = link, code
+	return nil
+}
+
+// passwordlessTestLogin records the signed-in address and method. Every
+// address has an account in the state of account.
+type passwordlessTestLogin struct {
+	email   aemail.EmailAddress
+	method  asessions.AuthMethod
+	account anode.UserAccount
+}
+
+func (l *passwordlessTestLogin) FindPasswordlessAccount(c echo.Context, email aemail.EmailAddress) (*anode.UserAccount, error) {
+	ua := l.account
+	ua.Email = email
+	return &ua, nil
+}
+
+func (l *passwordlessTestLogin) LoginPasswordless(c echo.Context, email aemail.EmailAddress, method asessions.AuthMethod) error {
+	l.email, l.method = email, method
+	return nil
+}
+
+func newPasswordlessTestServer(t *testing.T) (*httptest.Server, *passwordlessTestMailer, *passwordlessTestLogin) {
+	mailer, login := &passwordlessTestMailer{}, &passwordlessTestLogin{}
+	e := echo.New()
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	p, err := anode.NewPasswordless([]byte(strings.Repeat("k", 32)), srv.URL+"/login/link", anode.NewPasswordlessMemoryStore(), mailer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ph, err := NewPasswordlessHandlers(p, login, "/dash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.POST("/login/passwordless", ph.Begin)
+	e.GET("/login/link", ph.Link)
+	e.POST("/login/code", ph.Code)
+	return srv, mailer, login
+}
+
+func newPasswordlessTestClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func passwordlessTestPost(t *testing.T, client *http.Client, url string, body interface{}) *http.Response {
+	b, _ := json.Marshal(body)
+	resp, err := client.Post(url, echo.MIMEApplicationJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestPasswordlessHandlers_MagicLink(t *testing.T) {
+	srv, mailer, login := newPasswordlessTestServer(t)
+	browser := newPasswordlessTestClient(t)
+
+	resp := passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com"})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(mailer.link, srv.URL+"/login/link?token=") {
+		t.Fatalf("unexpected link %s", mailer.link)
+	}
+
+	// Another browser cannot use the link.
+	other, err := newPasswordlessTestClient(t).Get(mailer.link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Body.Close()
+	if other.StatusCode != http.StatusUnauthorized || login.email != "" {
+		t.Fatalf("expected 401 from another device, got %d", other.StatusCode)
+	}
+
+	resp, err = browser.Get(mailer.link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/dash" {
+		t.Fatalf("expected redirect to /dash, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if login.email != "user@example.com" || login.method != asessions.AUTHMETHOD_MAGICLINK {
+		t.Errorf("unexpected login %+v", login)
+	}
+
+	// The link is single-use.
+	resp, err = browser.Get(mailer.link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 on reuse, got %d", resp.StatusCode)
+	}
+}
+
+func TestPasswordlessHandlers_EmailOTP(t *testing.T) {
+	srv, mailer, login := newPasswordlessTestServer(t)
+	browser := newPasswordlessTestClient(t)
+
+	resp := passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com", "kind": "email-otp"})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	begin := &PasswordlessBeginResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(begin); err != nil || begin.Id == "" {
+		t.Fatalf("expected a challenge id, got %+v, %v", begin, err)
+	}
+
+	resp = passwordlessTestPost(t, browser, srv.URL+"/login/code", PasswordlessCodeRequest{Id: begin.Id, Code: mailer.code})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	if login.email != "user@example.com" || login.method != asessions.AUTHMETHOD_EMAILOTP {
+		t.Errorf("unexpected login %+v", login)
+	}
+}
+
+func TestPasswordlessHandlers_Suspended(t *testing.T) {
+	srv, mailer, login := newPasswordlessTestServer(t)
+	login.account.Lifecycle.State = anode.USERSTATE_SUSPENDED
+	browser := newPasswordlessTestClient(t)
+
+	passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com"})
+	resp, err := browser.Get(mailer.link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || login.email != "" {
+		t.Errorf("expected a suspended account to get 403, got %d", resp.StatusCode)
+	}
+
+	resp = passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com", "kind": "email-otp"})
+	begin := &PasswordlessBeginResponse{}
+	_ = json.NewDecoder(resp.Body).Decode(begin)
+	resp = passwordlessTestPost(t, browser, srv.URL+"/login/code", PasswordlessCodeRequest{Id: begin.Id, Code: mailer.code})
+	if resp.StatusCode != http.StatusForbidden || login.email != "" {
+		t.Errorf("expected a suspended account to get 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestPasswordlessHandlers_RateLimit(t *testing.T) {
+	srv, _, _ := newPasswordlessTestServer(t)
+	browser := newPasswordlessTestClient(t)
+
+	if resp := passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "not-an-email"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+	for ii := 0; ii < anode.PASSWORDLESS_RATE_LIMIT_DEFAULT; ii++ {
+		resp := passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com"})
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", resp.StatusCode)
+		}
+	}
+	resp := passwordlessTestPost(t, browser, srv.URL+"/login/passwordless", map[string]string{"email": "user@example.com"})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", resp.StatusCode)
+	}
+}
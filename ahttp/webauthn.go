@@ -0,0 +1,173 @@
+package ahttp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// IWebAuthnStore connects the WebAuthn handlers to the site's users and sessions.
+type IWebAuthnStore interface {
+	// SaveWebAuthnSession keeps the ceremony state between begin and finish,
+	// somewhere the client cannot modify it (eg the server session).
+	SaveWebAuthnSession(c echo.Context, session *anode.WebAuthnSession) error
+	// PopWebAuthnSession returns and removes the ceremony state.
+	PopWebAuthnSession(c echo.Context) (*anode.WebAuthnSession, error)
+	// GetWebAuthnUser returns the current user and their credentials. The user
+	// is nil when nobody is identified yet, which starts a passkey login.
+	GetWebAuthnUser(c echo.Context) (*anode.WebAuthnUser, anode.WebAuthnCredentials, error)
+	// AddWebAuthnCredential saves a newly registered credential for user.
+	AddWebAuthnCredential(c echo.Context, user *anode.WebAuthnUser, credential *anode.WebAuthnCredential) error
+	// FindWebAuthnCredential returns the credential with credentialId owned by
+	// the user with userHandle, or anode.ErrWebAuthnCredentialUnknown.
+	FindWebAuthnCredential(c echo.Context, userHandle []byte, credentialId []byte) (*anode.WebAuthnCredential, error)
+	// FindWebAuthnAccount returns the account of the user with userHandle, or nil.
+	FindWebAuthnAccount(c echo.Context, userHandle []byte) (*anode.UserAccount, error)
+	// LoginWebAuthn saves the updated credential and signs in the user (or
+	// completes their second factor) after a verified assertion.
+	LoginWebAuthn(c echo.Context, userHandle []byte, credential *anode.WebAuthnCredential) error
+}
+
+// WebAuthnHandlers serves the JSON endpoints of the WebAuthn ceremonies.
+// The begin handlers return options for navigator.credentials.create() or
+// get(); the finish handlers take the PublicKeyCredential as JSON.
+type WebAuthnHandlers struct {
+	RP    *anode.WebAuthnRP
+	Store IWebAuthnStore
+}
+
+// NewWebAuthnHandlers creates the handlers.
+func NewWebAuthnHandlers(rp *anode.WebAuthnRP, store IWebAuthnStore) (*WebAuthnHandlers, error) {
+	if rp == nil || store == nil {
+		return nil, fmt.Errorf("webauthn rp and store are required")
+	}
+	if err := rp.Validate(); err != nil {
+		return nil, err
+	}
+	return &WebAuthnHandlers{RP: rp, Store: store}, nil
+}
+
+// RegisterBegin starts registering a credential for the signed-in user.
+func (wh *WebAuthnHandlers) RegisterBegin(c echo.Context) error {
+	user, credentials, err := wh.Store.GetWebAuthnUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	opts, session, err := wh.RP.BeginRegistration(user, credentials)
+	if err != nil {
+		return err
+	}
+	if err = wh.Store.SaveWebAuthnSession(c, session); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, opts)
+}
+
+// RegisterFinish verifies the new credential and saves it. The optional
+// "nickname" query parameter labels the credential.
+func (wh *WebAuthnHandlers) RegisterFinish(c echo.Context) error {
+	user, credentials, err := wh.Store.GetWebAuthnUser(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	session, err := wh.Store.PopWebAuthnSession(c)
+	if err != nil {
+		return err
+	}
+	resp := &anode.WebAuthnRegistrationResponse{}
+	if err = c.Bind(resp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webauthn response")
+	}
+	credential, err := wh.RP.FinishRegistration(session, resp, credentials, c.QueryParam("nickname"))
+	if err != nil {
+		return webAuthnHTTPError(err)
+	}
+	if err = wh.Store.AddWebAuthnCredential(c, user, credential); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, credential)
+}
+
+// LoginBegin starts a login. Without a current user it is a passkey login.
+func (wh *WebAuthnHandlers) LoginBegin(c echo.Context) error {
+	user, credentials, err := wh.Store.GetWebAuthnUser(c)
+	if err != nil {
+		return err
+	}
+	opts, session, err := wh.RP.BeginLogin(user, credentials)
+	if err != nil {
+		return webAuthnHTTPError(err)
+	}
+	if err = wh.Store.SaveWebAuthnSession(c, session); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, opts)
+}
+
+// LoginFinish verifies the assertion and signs in the credential's owner,
+// unless the owner's lifecycle state may not sign in (403).
+func (wh *WebAuthnHandlers) LoginFinish(c echo.Context) error {
+	session, err := wh.Store.PopWebAuthnSession(c)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	resp := &anode.WebAuthnLoginResponse{}
+	if err = c.Bind(resp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid webauthn response")
+	}
+	userHandle := []byte(session.UserId)
+	if session.IsPasskeyLogin() {
+		userHandle = resp.Response.UserHandle
+	}
+	if len(userHandle) == 0 {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	credential, err := wh.Store.FindWebAuthnCredential(c, userHandle, resp.RawId)
+	if err != nil {
+		return webAuthnHTTPError(err)
+	}
+	if err = wh.RP.FinishLogin(session, resp, credential); err != nil {
+		return webAuthnHTTPError(err)
+	}
+	ua, err := wh.Store.FindWebAuthnAccount(c, userHandle)
+	if err != nil {
+		return err
+	}
+	if ua == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if err = ua.CheckCanLogin(); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden).SetInternal(err)
+	}
+	if err = wh.Store.LoginWebAuthn(c, userHandle, credential); err != nil {
+		return err
+	}
+	if c.Response().Committed {
+		return nil
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// webAuthnHTTPError maps ceremony failures to 401 and duplicates to 409.
+// Details are not returned to the client.
+func webAuthnHTTPError(err error) error {
+	switch {
+	case errors.Is(err, anode.ErrWebAuthnCredentialExists):
+		return echo.NewHTTPError(http.StatusConflict, "credential already registered").SetInternal(err)
+	case errors.Is(err, anode.ErrWebAuthnVerify), errors.Is(err, anode.ErrWebAuthnSessionExpired),
+		errors.Is(err, anode.ErrWebAuthnSignCount), errors.Is(err, anode.ErrWebAuthnCredentialUnknown):
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+	}
+	return err
+}
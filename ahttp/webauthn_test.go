@@ -0,0 +1,205 @@
+package ahttp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/jpfluger/alibs-slim/anode"
+	"github.com/jpfluger/alibs-slim/auser"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// webAuthnTestStore holds one user and the last ceremony session.
+type webAuthnTestStore struct {
+	user     *anode.WebAuthnUser
+	vault    anode.UserVault
+	signedIn bool
+	loggedIn []byte
+	session  *anode.WebAuthnSession
+	account  anode.UserAccount
+}
+
+func (s *webAuthnTestStore) FindWebAuthnAccount(c echo.Context, userHandle []byte) (*anode.UserAccount, error) {
+	if !bytes.Equal(userHandle, s.user.Id) {
+		return nil, nil
+	}
+	return &s.account, nil
+}
+
+func (s *webAuthnTestStore) SaveWebAuthnSession(c echo.Context, session *anode.WebAuthnSession) error {
+	s.session = session
+	return nil
+}
+
+func (s *webAuthnTestStore) PopWebAuthnSession(c echo.Context) (*anode.WebAuthnSession, error) {
+	session := s.session
+	s.session = nil
+	return session, nil
+}
+
+func (s *webAuthnTestStore) GetWebAuthnUser(c echo.Context) (*anode.WebAuthnUser, anode.WebAuthnCredentials, error) {
+	if !s.signedIn {
+		return nil, nil, nil
+	}
+	return s.user, s.vault.WebAuthn, nil
+}
+
+func (s *webAuthnTestStore) AddWebAuthnCredential(c echo.Context, user *anode.WebAuthnUser, credential *anode.WebAuthnCredential) error {
+	s.vault.WebAuthn = append(s.vault.WebAuthn, credential)
+	return nil
+}
+
+func (s *webAuthnTestStore) FindWebAuthnCredential(c echo.Context, userHandle []byte, credentialId []byte) (*anode.WebAuthnCredential, error) {
+	if !bytes.Equal(userHandle, s.user.Id) || s.vault.WebAuthn.Find(credentialId) == nil {
+		return nil, anode.ErrWebAuthnCredentialUnknown
+	}
+	return s.vault.WebAuthn.Find(credentialId), nil
+}
+
+func (s *webAuthnTestStore) LoginWebAuthn(c echo.Context, userHandle []byte, credential *anode.WebAuthnCredential) error {
+	s.loggedIn = userHandle
+	return nil
+}
+
+// webAuthnTestCBOR encodes the few CBOR shapes a "none" attestation needs.
+func webAuthnTestCBOR(major byte, n int, payload []byte) []byte {
+	var out []byte
+	switch {
+	case n < 24:
+		out = []byte{major<<5 | byte(n)}
+	case n < 256:
+		out = []byte{major<<5 | 24, byte(n)}
+	default:
+		out = []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+	return append(out, payload...)
+}
+
+func webAuthnTestPost(t *testing.T, url string, body interface{}) *http.Response {
+	b, _ := json.Marshal(body)
+	resp, err := http.Post(url, echo.MIMEApplicationJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestWebAuthnHandlers registers a software ES256 authenticator and then logs in with it as a passkey.
+func TestWebAuthnHandlers(t *testing.T) {
+	store := &webAuthnTestStore{user: anode.NewWebAuthnUser(auser.NewUID(), "alice", "Alice"), signedIn: true}
+	rp, err := anode.NewWebAuthnRP("localhost", "Test", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh, err := NewWebAuthnHandlers(rp, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.POST("/webauthn/register/begin", wh.RegisterBegin)
+	e.POST("/webauthn/register/finish", wh.RegisterFinish)
+	e.POST("/webauthn/login/begin", wh.LoginBegin)
+	e.POST("/webauthn/login/finish", wh.LoginFinish)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := key.PublicKey.Bytes()
+	credId := []byte("soft-credential-1")
+	rpIdHash := sha256.Sum256([]byte(rp.RPID))
+	clientData := func(typ string, challenge anode.WebAuthnBytes) []byte {
+		b, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge.String(), "origin": "http://localhost"})
+		return b
+	}
+
+	// Registration.
+	resp := webAuthnTestPost(t, srv.URL+"/webauthn/register/begin", nil)
+	creation := &anode.WebAuthnCreationOptions{}
+	_ = json.NewDecoder(resp.Body).Decode(creation)
+	resp.Body.Close()
+
+	coseKey := webAuthnTestCBOR(5, 5, nil)
+	coseKey = append(coseKey, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01) // kty: EC2, alg: ES256, crv: P-256
+	coseKey = append(coseKey, webAuthnTestCBOR(1, 1, webAuthnTestCBOR(2, 32, pub[1:33]))...)
+	coseKey = append(coseKey, webAuthnTestCBOR(1, 2, webAuthnTestCBOR(2, 32, pub[33:]))...)
+	authData := append(append([]byte{}, rpIdHash[:]...), 0x45, 0, 0, 0, 0) // UP, UV, AT
+	authData = append(authData, make([]byte, 16)...)
+	authData = append(authData, 0, byte(len(credId)))
+	authData = append(append(authData, credId...), coseKey...)
+	attObj := webAuthnTestCBOR(5, 3, nil)
+	attObj = append(attObj, webAuthnTestCBOR(3, 3, []byte("fmt"))...)
+	attObj = append(attObj, webAuthnTestCBOR(3, 4, []byte("none"))...)
+	attObj = append(attObj, webAuthnTestCBOR(3, 7, []byte("attStmt"))...)
+	attObj = append(attObj, webAuthnTestCBOR(5, 0, nil)...)
+	attObj = append(attObj, webAuthnTestCBOR(3, 8, []byte("authData"))...)
+	attObj = append(attObj, webAuthnTestCBOR(2, len(authData), authData)...)
+
+	reg := &anode.WebAuthnRegistrationResponse{RawId: credId, Type: "public-key"}
+	reg.Response.ClientDataJSON = clientData("webauthn.create", creation.Challenge)
+	reg.Response.AttestationObject = attObj
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/register/finish?nickname=Phone", reg)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(store.vault.WebAuthn) != 1 || store.vault.WebAuthn[0].Nickname != "Phone" {
+		t.Fatalf("registration failed with status %d", resp.StatusCode)
+	}
+
+	// Passkey login.
+	store.signedIn = false
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/login/begin", nil)
+	request := &anode.WebAuthnRequestOptions{}
+	_ = json.NewDecoder(resp.Body).Decode(request)
+	resp.Body.Close()
+	if len(request.AllowCredentials) != 0 {
+		t.Errorf("expected a passkey login without allowCredentials")
+	}
+
+	assertData := append(append([]byte{}, rpIdHash[:]...), 0x05, 0, 0, 0, 1) // UP, UV
+	login := &anode.WebAuthnLoginResponse{RawId: credId, Type: "public-key"}
+	login.Response.ClientDataJSON = clientData("webauthn.get", request.Challenge)
+	login.Response.AuthenticatorData = assertData
+	login.Response.UserHandle = store.user.Id
+	hash := sha256.Sum256(login.Response.ClientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, assertData...), hash[:]...))
+	login.Response.Signature, _ = ecdsa.SignASN1(rand.Reader, key, digest[:])
+
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/login/finish", login)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || !bytes.Equal(store.loggedIn, store.user.Id) {
+		t.Fatalf("login failed with status %d", resp.StatusCode)
+	}
+	if store.vault.WebAuthn[0].SignCount != 1 {
+		t.Errorf("expected sign count 1, got %d", store.vault.WebAuthn[0].SignCount)
+	}
+
+	// The session is single use, so a replay is rejected.
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/login/finish", login)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected replay to be unauthorized, got %d", resp.StatusCode)
+	}
+
+	// A suspended account is refused after a valid assertion.
+	store.account.Lifecycle.State = anode.USERSTATE_SUSPENDED
+	store.loggedIn = nil
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/login/begin", nil)
+	request = &anode.WebAuthnRequestOptions{}
+	_ = json.NewDecoder(resp.Body).Decode(request)
+	resp.Body.Close()
+	assertData = append(append([]byte{}, rpIdHash[:]...), 0x05, 0, 0, 0, 2)
+	login.Response.ClientDataJSON = clientData("webauthn.get", request.Challenge)
+	login.Response.AuthenticatorData = assertData
+	hash = sha256.Sum256(login.Response.ClientDataJSON)
+	digest = sha256.Sum256(append(append([]byte{}, assertData...), hash[:]...))
+	login.Response.Signature, _ = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	resp = webAuthnTestPost(t, srv.URL+"/webauthn/login/finish", login)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || store.loggedIn != nil {
+		t.Errorf("expected a suspended account to get 403, got %d", resp.StatusCode)
+	}
+}
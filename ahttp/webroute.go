@@ -34,6 +34,14 @@ func (wr *WebRoute) CreateHandler() echo.HandlerFunc {
 	return wr.handler
 }
 
+// WithStepUp sets the step-up authentication required to access the route and returns the route.
+func (wr *WebRoute) WithStepUp(req asessions.StepUpRequirement) *WebRoute {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.StepUp = req
+	return wr
+}
+
 // NewWRPermSetEH creates a new WebRoute with permission sets based on the specified handler.
 func NewWRPermSetEH(httpRouteId HttpRouteId, method HttpMethod, url string, permSet asessions.PermSet, handler echo.HandlerFunc) *WebRoute {
 	return NewWRPermSet(httpRouteId, method, url, permSet, CreateRouteHandlerByEchoHandlerFunc(handler))
@@ -13,6 +13,8 @@ import (
 type IWebRouteManager interface {
 	GetAuthenticateProvisioner() amidware.IAuthenticateProvisioner
 	SetAuthenticateProvisioner(provisioner amidware.IAuthenticateProvisioner)
+	GetStepUpProvisioner() amidware.IStepUpProvisioner
+	SetStepUpProvisioner(provisioner amidware.IStepUpProvisioner)
 	AddRoute(e *echo.Echo, route IRoute) error
 	SetRouteSpecials(rHome HttpRouteId, rDash HttpRouteId, rNoLogin HttpRouteId, rInvalidPerms HttpRouteId) error
 	InitRoutesWithEcho(e *echo.Echo) error
@@ -40,6 +42,7 @@ type WebRouteManager struct {
 	HttpRouteMap                                              // Embedding HttpRouteMap for route management.
 	allowedActionPaths      []string                          // List of paths that are whitelisted.
 	authenticateProvisioner amidware.IAuthenticateProvisioner // Provisioner for authentication.
+	stepUpProvisioner       amidware.IStepUpProvisioner       // Provisioner for step-up authentication.
 	mu                      sync.RWMutex                      // Mutex for concurrent access control.
 }
 
@@ -65,6 +68,16 @@ func (wrm *WebRouteManager) SetAuthenticateProvisioner(provisioner amidware.IAut
 	wrm.authenticateProvisioner = provisioner
 }
 
+// GetStepUpProvisioner retrieves the provisioner for step-up authentication.
+func (wrm *WebRouteManager) GetStepUpProvisioner() amidware.IStepUpProvisioner {
+	return wrm.stepUpProvisioner
+}
+
+// SetStepUpProvisioner sets the provisioner for step-up authentication, required by routes with a step-up requirement.
+func (wrm *WebRouteManager) SetStepUpProvisioner(provisioner amidware.IStepUpProvisioner) {
+	wrm.stepUpProvisioner = provisioner
+}
+
 // AddRoute adds a new route to the manager's route map.
 func (wrm *WebRouteManager) AddRoute(route IRoute) error {
 	// Validate route.
@@ -125,6 +138,14 @@ func (wrm *WebRouteManager) InitRoutesWithEcho(e *echo.Echo) error {
 			middlewares = append(middlewares, mwAuth)
 		}
 
+		// Step-up runs after the permission check so users without access are not challenged.
+		if rsu, ok := route.(IRouteStepUp); ok && !rsu.GetStepUp().IsEmpty() {
+			if wrm.stepUpProvisioner == nil {
+				return fmt.Errorf("step-up provisioner is nil for route '%s'", route.GetRouteId().String())
+			}
+			middlewares = append(middlewares, amidware.NewStepUp(rsu.GetStepUp(), wrm.stepUpProvisioner))
+		}
+
 		// Trim spaces from the URL path.
 		url := strings.TrimSpace(route.GetPath())
 
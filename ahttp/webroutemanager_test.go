@@ -2,12 +2,14 @@ package ahttp
 
 import (
 	"fmt"
+	"github.com/jpfluger/alibs-slim/asessions"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 )
 
 //// MockRoute is a mock implementation of the IRoute interface for testing.
@@ -104,6 +106,50 @@ func TestWebRouteManager_InitRoutesWithEcho(t *testing.T) {
 	assert.True(t, found)
 }
 
+// mockStepUpProvisioner implements amidware.IStepUpProvisioner for testing.
+type mockStepUpProvisioner struct{}
+
+func (m *mockStepUpProvisioner) GetUrlNoLogin() string                  { return "/login" }
+func (m *mockStepUpProvisioner) GetUrlReauth() string                   { return "/reauth" }
+func (m *mockStepUpProvisioner) GetUrlMFAChallenge() string             { return "/mfa" }
+func (m *mockStepUpProvisioner) LogAuthError(c echo.Context, err error) {}
+
+func TestWebRouteManager_InitRoutesWithEcho_StepUp(t *testing.T) {
+	route := NewWRPermSetEH("billing", HTTPMETHOD_GET, "/billing", nil, func(c echo.Context) error {
+		return c.String(http.StatusOK, "billing")
+	}).WithStepUp(asessions.StepUpRequirement{MinLevel: asessions.AUTHLEVEL_MFA})
+
+	wrm := setupWebRouteManager()
+	assert.NoError(t, wrm.AddRoute(route))
+	assert.Error(t, wrm.InitRoutesWithEcho(echo.New()), "a step-up provisioner is required")
+
+	var us *asessions.UserSessionPerm
+	e := echo.New()
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(asessions.ECHOSCS_OBJECTKEY_USER_SESSION, us)
+			return next(c)
+		}
+	})
+	wrm.SetStepUpProvisioner(&mockStepUpProvisioner{})
+	assert.NoError(t, wrm.InitRoutesWithEcho(e))
+
+	send := func(method asessions.AuthMethod) *httptest.ResponseRecorder {
+		us = asessions.NewUserSessionPermWithLoginStatus(asessions.LOGIN_SESSION_STATUS_OK)
+		us.Auth.SetAuthenticated(method, time.Now().UTC())
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing", nil))
+		return rec
+	}
+
+	rec := send(asessions.AUTHMETHOD_PASSWORD)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/mfa?return=%2Fbilling", rec.Header().Get(echo.HeaderLocation))
+
+	rec = send(asessions.AUTHMETHOD_TOTP)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
 func TestWebRouteManager_AddWhitelistActionPath(t *testing.T) {
 	wrm := setupWebRouteManager()
 	wrm.AddWhitelistActionPath("/whitelist")
@@ -0,0 +1,73 @@
+package amidware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// HTTPSIGNATURE_OBJECTKEY_KEYID is the echo context key of the keyid that signed the request.
+const HTTPSIGNATURE_OBJECTKEY_KEYID = "httpSigKeyId"
+
+// HTTPSignatureConfig holds the configuration for HTTP message signature middleware.
+type HTTPSignatureConfig struct {
+	Skipper      middleware.Skipper              // Function to skip middleware.
+	Verifier     *acrypt.HTTPSignatureVerifier   // Verifies the request signature.
+	LogAuthError func(c echo.Context, err error) // Optional; called when verification fails.
+}
+
+// NewHTTPSignature creates a middleware that rejects requests without a
+// valid RFC 9421 signature by a key from keys (eg a RoboCredential or a
+// JWKSRemote of the calling service).
+func NewHTTPSignature(keys acrypt.IHTTPSignatureKeyResolver) echo.MiddlewareFunc {
+	return HTTPSignatureWithConfig(&HTTPSignatureConfig{
+		Skipper:  middleware.DefaultSkipper,
+		Verifier: acrypt.NewHTTPSignatureVerifier(keys),
+	})
+}
+
+// HTTPSignatureWithConfig returns a middleware function that verifies the
+// request signature and stores the keyid under HTTPSIGNATURE_OBJECTKEY_KEYID.
+// Failures return 401 without details.
+func HTTPSignatureWithConfig(config *HTTPSignatureConfig) echo.MiddlewareFunc {
+	if config == nil {
+		panic("HTTPSignatureWithConfig: config cannot be nil")
+	}
+	if config.Verifier == nil || config.Verifier.Keys == nil {
+		panic("HTTPSignatureWithConfig: verifier keys cannot be nil")
+	}
+	if config.Skipper == nil {
+		config.Skipper = middleware.DefaultSkipper
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+			keyId, err := config.Verifier.Verify(c.Request())
+			if err != nil {
+				if config.LogAuthError != nil {
+					config.LogAuthError(c, err)
+				}
+				status := http.StatusUnauthorized
+				if !errors.Is(err, acrypt.ErrHTTPSignatureMissing) && !errors.Is(err, acrypt.ErrHTTPSignatureInvalid) &&
+					!errors.Is(err, acrypt.ErrHTTPSignatureExpired) && !errors.Is(err, acrypt.ErrHTTPSignatureKeyNotFound) {
+					status = http.StatusBadRequest
+				}
+				return echo.NewHTTPError(status).SetInternal(err)
+			}
+			c.Set(HTTPSIGNATURE_OBJECTKEY_KEYID, keyId)
+			return next(c)
+		}
+	}
+}
+
+// HTTPSignatureKeyId returns the keyid that signed the request or an empty string.
+func HTTPSignatureKeyId(c echo.Context) string {
+	keyId, _ := c.Get(HTTPSIGNATURE_OBJECTKEY_KEYID).(string)
+	return keyId
+}
@@ -0,0 +1,56 @@
+package amidware
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	jwk, err := acrypt.NewJWK(pub, acrypt.JWTALG_EDDSA, "svc-a")
+	require.NoError(t, err)
+	signer, err := acrypt.NewHTTPSignatureSigner("svc-a", priv)
+	require.NoError(t, err)
+
+	var logged error
+	e := echo.New()
+	e.Use(HTTPSignatureWithConfig(&HTTPSignatureConfig{
+		Verifier:     acrypt.NewHTTPSignatureVerifier(&acrypt.JWKSet{Keys: []*acrypt.JWK{jwk}}),
+		LogAuthError: func(c echo.Context, err error) { logged = err },
+	}))
+	e.POST("/jobs", func(c echo.Context) error {
+		return c.String(http.StatusOK, HTTPSignatureKeyId(c))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://svc-b.example.com/jobs", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	require.NoError(t, signer.Sign(req))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "svc-a", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "http://svc-b.example.com/jobs", strings.NewReader(`{"id":1}`))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.ErrorIs(t, logged, acrypt.ErrHTTPSignatureMissing)
+
+	// The body changed after signing.
+	req = httptest.NewRequest(http.MethodPost, "http://svc-b.example.com/jobs", strings.NewReader(`{"id":1}`))
+	require.NoError(t, signer.Sign(req))
+	req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id":2}`)).Body
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.ErrorIs(t, logged, acrypt.ErrHTTPSignatureInvalid)
+}
@@ -0,0 +1,211 @@
+package amidware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// STEPUP_PARAM_RETURN is the query parameter holding the url to return to after a challenge.
+	STEPUP_PARAM_RETURN = "return"
+
+	// STEPUP_WINDOW_DEFAULT is how long a step-up lasts when no window is given.
+	STEPUP_WINDOW_DEFAULT = 15 * time.Minute
+)
+
+// IStepUpProvisioner defines the interface for step-up provisioning.
+type IStepUpProvisioner interface {
+	GetUrlNoLogin() string
+	GetUrlReauth() string
+	GetUrlMFAChallenge() string
+	LogAuthError(c echo.Context, err error)
+}
+
+// StepUpConfig holds the configuration for step-up authentication middleware.
+type StepUpConfig struct {
+	Skipper         middleware.Skipper          // Function to skip middleware.
+	Requirement     asessions.StepUpRequirement // Max auth age and level required for access.
+	Provisioner     IStepUpProvisioner          // Interface for provisioning URLs and logging.
+	UrlNoLogin      string                      // URL to redirect to when no login is detected.
+	UrlReauth       string                      // URL of the re-authentication challenge.
+	UrlMFAChallenge string                      // URL of the second-factor challenge; UrlReauth if empty.
+	fnNow           func() time.Time
+}
+
+// NewStepUp creates a step-up middleware that requires req of the session's authentication.
+func NewStepUp(req asessions.StepUpRequirement, provisioner IStepUpProvisioner) echo.MiddlewareFunc {
+	return StepUpWithConfig(&StepUpConfig{
+		Skipper:     middleware.DefaultSkipper,
+		Requirement: req,
+		Provisioner: provisioner,
+	})
+}
+
+// NewStepUpMaxAge creates a step-up middleware that requires a login or step-up within maxAge.
+func NewStepUpMaxAge(maxAge time.Duration, provisioner IStepUpProvisioner) echo.MiddlewareFunc {
+	return NewStepUp(asessions.StepUpRequirement{MaxAuthAge: maxAge}, provisioner)
+}
+
+// NewStepUpMFA creates a step-up middleware that requires multi-factor authentication within maxAge (0 is any age).
+func NewStepUpMFA(maxAge time.Duration, provisioner IStepUpProvisioner) echo.MiddlewareFunc {
+	return NewStepUp(asessions.StepUpRequirement{MaxAuthAge: maxAge, MinLevel: asessions.AUTHLEVEL_MFA}, provisioner)
+}
+
+// StepUpWithConfig returns a middleware function that redirects sessions not meeting
+// the requirement to a challenge, passing the url to return to in STEPUP_PARAM_RETURN.
+func StepUpWithConfig(config *StepUpConfig) echo.MiddlewareFunc {
+	if config == nil {
+		panic("StepUpWithConfig: config cannot be nil")
+	}
+	if config.Requirement.IsEmpty() {
+		panic("StepUpWithConfig: requirement cannot be empty")
+	}
+	if config.Skipper == nil {
+		config.Skipper = middleware.DefaultSkipper
+	}
+
+	// Set URLs from the provisioner if not explicitly provided.
+	if config.Provisioner != nil {
+		if config.UrlNoLogin == "" {
+			config.UrlNoLogin = config.Provisioner.GetUrlNoLogin()
+		}
+		if config.UrlReauth == "" {
+			config.UrlReauth = config.Provisioner.GetUrlReauth()
+		}
+		if config.UrlMFAChallenge == "" {
+			config.UrlMFAChallenge = config.Provisioner.GetUrlMFAChallenge()
+		}
+	}
+	if config.UrlMFAChallenge == "" {
+		config.UrlMFAChallenge = config.UrlReauth
+	}
+	if config.UrlReauth == "" {
+		panic("StepUpWithConfig: reauth url cannot be empty")
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			us := asessions.CastLoginSessionPermFromEchoContext(c)
+			if us == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "stepup: session not found")
+			}
+
+			if us.GetStatusType() == asessions.LOGIN_SESSION_STATUS_NONE {
+				config.logAuthError(c, echo.NewHTTPError(http.StatusUnauthorized, "stepup: session status is not logged-in"))
+				return c.Redirect(http.StatusFound, config.UrlNoLogin)
+			}
+
+			var auth *asessions.LoginSessionAuth
+			if usa, ok := us.(asessions.ILoginSessionAuth); ok {
+				auth = usa.GetAuth()
+			}
+			now := time.Now().UTC()
+			if config.fnNow != nil {
+				now = config.fnNow()
+			}
+			if auth.Satisfies(config.Requirement, now) {
+				return next(c)
+			}
+
+			challengeUrl := config.UrlReauth
+			if config.Requirement.MinLevel >= asessions.AUTHLEVEL_MFA {
+				challengeUrl = config.UrlMFAChallenge
+			}
+			config.logAuthError(c, echo.NewHTTPError(http.StatusUnauthorized, "stepup: authentication is too old or too weak"))
+			return c.Redirect(http.StatusFound, StepUpChallengeUrl(c, challengeUrl))
+		}
+	}
+}
+
+func (config *StepUpConfig) logAuthError(c echo.Context, err error) {
+	if config.Provisioner != nil {
+		config.Provisioner.LogAuthError(c, err)
+	}
+}
+
+// StepUpChallengeUrl returns challengeUrl with the url to return to. GET
+// requests return to themselves; other requests return to the page they were
+// sent from, since the browser cannot repeat them after a redirect.
+func StepUpChallengeUrl(c echo.Context, challengeUrl string) string {
+	returnUrl := c.Request().URL.RequestURI()
+	if c.Request().Method != http.MethodGet && c.Request().Method != http.MethodHead {
+		returnUrl = ""
+		if ref, err := url.Parse(c.Request().Referer()); err == nil && ref.Host == c.Request().Host {
+			returnUrl = ref.RequestURI()
+		}
+	}
+	if !isLocalReturnUrl(returnUrl) {
+		return challengeUrl
+	}
+	sep := "?"
+	if strings.Contains(challengeUrl, "?") {
+		sep = "&"
+	}
+	return challengeUrl + sep + STEPUP_PARAM_RETURN + "=" + url.QueryEscape(returnUrl)
+}
+
+// StepUpReturnUrl returns the url to return to from the challenge request or
+// fallback. Only local paths are returned, so the parameter cannot redirect
+// the user to another site.
+func StepUpReturnUrl(c echo.Context, fallback string) string {
+	returnUrl := c.QueryParam(STEPUP_PARAM_RETURN)
+	if returnUrl == "" {
+		returnUrl = c.FormValue(STEPUP_PARAM_RETURN)
+	}
+	if isLocalReturnUrl(returnUrl) {
+		return returnUrl
+	}
+	if fallback == "" {
+		fallback = "/"
+	}
+	return fallback
+}
+
+// isLocalReturnUrl returns true if target is a path on this site.
+func isLocalReturnUrl(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return false
+	}
+	u, err := url.Parse(target)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// StepUpComplete is called by a challenge handler after the user passed the
+// challenge. It elevates the session by method for window (0 uses
+// STEPUP_WINDOW_DEFAULT), saves it, renews the session token and redirects to
+// the return url or fallback.
+func StepUpComplete(c echo.Context, sm *scs.SessionManager, method asessions.AuthMethod, window time.Duration, fallback string) error {
+	if sm == nil {
+		return fmt.Errorf("session manager is nil")
+	}
+	if method.IsEmpty() {
+		return fmt.Errorf("step-up auth method is empty")
+	}
+	us := asessions.CastUserSessionPermFromEchoContext(c)
+	if us == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "stepup: session not found")
+	}
+	if window <= 0 {
+		window = STEPUP_WINDOW_DEFAULT
+	}
+	us.Auth.Elevate(method, time.Now().UTC(), window)
+
+	ctx := c.Request().Context()
+	if err := sm.RenewToken(ctx); err != nil {
+		return fmt.Errorf("failed to renew session token; %v", err)
+	}
+	sm.Put(ctx, asessions.ECHOSCS_OBJECTKEY_USER_SESSION, *us)
+	return c.Redirect(http.StatusFound, StepUpReturnUrl(c, fallback))
+}
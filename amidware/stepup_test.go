@@ -0,0 +1,193 @@
+package amidware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockStepUpProvisioner implements the IStepUpProvisioner interface for testing.
+type mockStepUpProvisioner struct{}
+
+func (m *mockStepUpProvisioner) GetUrlNoLogin() string {
+	return "/no-login"
+}
+
+func (m *mockStepUpProvisioner) GetUrlReauth() string {
+	return "/reauth"
+}
+
+func (m *mockStepUpProvisioner) GetUrlMFAChallenge() string {
+	return "/mfa"
+}
+
+func (m *mockStepUpProvisioner) LogAuthError(c echo.Context, err error) {}
+
+func TestStepUp(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name      string
+		req       asessions.StepUpRequirement
+		method    string
+		target    string
+		auth      func(a *asessions.LoginSessionAuth)
+		loggedOut bool
+		code      int
+		location  string
+	}{
+		{
+			name:   "fresh login",
+			req:    asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute},
+			target: "/account/delete",
+			auth: func(a *asessions.LoginSessionAuth) {
+				a.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, now.Add(-time.Minute))
+			},
+			code: http.StatusOK,
+		},
+		{
+			name:   "stale login",
+			req:    asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute},
+			target: "/account/delete?confirm=1",
+			auth: func(a *asessions.LoginSessionAuth) {
+				a.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, now.Add(-time.Hour))
+			},
+			code:     http.StatusFound,
+			location: "/reauth?return=%2Faccount%2Fdelete%3Fconfirm%3D1",
+		},
+		{
+			name:   "mfa required",
+			req:    asessions.StepUpRequirement{MinLevel: asessions.AUTHLEVEL_MFA},
+			target: "/billing",
+			auth: func(a *asessions.LoginSessionAuth) {
+				a.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, now)
+			},
+			code:     http.StatusFound,
+			location: "/mfa?return=%2Fbilling",
+		},
+		{
+			name:   "elevated by totp",
+			req:    asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute, MinLevel: asessions.AUTHLEVEL_MFA},
+			target: "/billing",
+			auth: func(a *asessions.LoginSessionAuth) {
+				a.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, now.Add(-time.Hour))
+				a.Elevate(asessions.AUTHMETHOD_TOTP, now.Add(-time.Minute), 15*time.Minute)
+			},
+			code: http.StatusOK,
+		},
+		{
+			name:   "elevation expired",
+			req:    asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute},
+			target: "/billing",
+			auth: func(a *asessions.LoginSessionAuth) {
+				a.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, now.Add(-time.Hour))
+				a.Elevate(asessions.AUTHMETHOD_PASSWORD, now.Add(-20*time.Minute), 15*time.Minute)
+			},
+			code:     http.StatusFound,
+			location: "/reauth?return=%2Fbilling",
+		},
+		{
+			name:      "not logged in",
+			req:       asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute},
+			target:    "/billing",
+			loggedOut: true,
+			code:      http.StatusFound,
+			location:  "/no-login",
+		},
+		{
+			name:   "post returns to referer",
+			req:    asessions.StepUpRequirement{MaxAuthAge: 10 * time.Minute},
+			method: http.MethodPost,
+			target: "/billing/card",
+			code:   http.StatusFound,
+			// The referer is set below.
+			location: "/reauth?return=%2Fbilling%3Ftab%3Dcard",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			if method == http.MethodPost {
+				req.Header.Set("Referer", "http://example.com/billing?tab=card")
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			status := asessions.LOGIN_SESSION_STATUS_OK
+			if tt.loggedOut {
+				status = asessions.LOGIN_SESSION_STATUS_NONE
+			}
+			session := asessions.NewUserSessionPermWithLoginStatus(status)
+			if tt.auth != nil {
+				tt.auth(&session.Auth)
+			}
+			c.Set(asessions.ECHOSCS_OBJECTKEY_USER_SESSION, session)
+
+			h := NewStepUp(tt.req, &mockStepUpProvisioner{})(func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+			assert.NoError(t, h(c))
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Equal(t, tt.location, rec.Header().Get(echo.HeaderLocation))
+		})
+	}
+}
+
+func TestStepUpReturnUrl(t *testing.T) {
+	tests := map[string]string{
+		"":                          "/home",
+		"/billing?tab=card":         "/billing?tab=card",
+		"//evil.example.com/":       "/home",
+		"/\\evil.example.com":       "/home",
+		"https://evil.example.com/": "/home",
+		"billing":                   "/home",
+	}
+	e := echo.New()
+	for value, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/reauth?return="+url.QueryEscape(value), nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		assert.Equal(t, expected, StepUpReturnUrl(c, "/home"), value)
+	}
+}
+
+func TestStepUpComplete(t *testing.T) {
+	sm := scs.New()
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/mfa?return=%2Fbilling", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	var elevated *asessions.UserSessionPerm
+	h := SCSLoadAndSave(sm, true)(func(c echo.Context) error {
+		us := asessions.CastUserSessionPermFromEchoContext(c)
+		us.Status = asessions.LOGIN_SESSION_STATUS_OK
+		us.Auth.SetAuthenticated(asessions.AUTHMETHOD_PASSWORD, time.Now().UTC().Add(-time.Hour))
+		if err := StepUpComplete(c, sm, asessions.AUTHMETHOD_TOTP, 0, "/"); err != nil {
+			return err
+		}
+		stored := sm.Get(c.Request().Context(), asessions.ECHOSCS_OBJECTKEY_USER_SESSION).(asessions.UserSessionPerm)
+		elevated = &stored
+		return nil
+	})
+	assert.NoError(t, h(c))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/billing", rec.Header().Get(echo.HeaderLocation))
+	if assert.NotNil(t, elevated) {
+		now := time.Now().UTC()
+		assert.True(t, elevated.Auth.IsElevated(now))
+		assert.True(t, elevated.Auth.Satisfies(asessions.StepUpRequirement{MaxAuthAge: time.Minute, MinLevel: asessions.AUTHLEVEL_MFA}, now))
+		assert.False(t, elevated.Auth.IsElevated(now.Add(STEPUP_WINDOW_DEFAULT)))
+	}
+}
@@ -0,0 +1,103 @@
+package anode
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jpfluger/alibs-slim/acron"
+	"github.com/jpfluger/alibs-slim/acrypt"
+)
+
+// TASKTYPE_JWTKEYROTATION rotates the app JWTKeyring (see
+// acrypt.SetAppJWTKeyring) that signs user tokens when scheduled as an
+// acron job plan.
+const TASKTYPE_JWTKEYROTATION acron.TaskType = "jwt-key-rotation"
+
+// IJWTKeyringStore persists the JWT keyring after a rotation.
+type IJWTKeyringStore interface {
+	// SaveJWTKeyring saves kr, eg encrypted as a secret. It is called before
+	// the rotated keyring is used to sign, so every instance that loads it
+	// can verify tokens signed by the new key.
+	SaveJWTKeyring(kr *acrypt.JWTKeyring) error
+}
+
+var (
+	jwtKeyringStore   IJWTKeyringStore
+	muJWTKeyringStore sync.RWMutex
+)
+
+// SetJWTKeyringStore sets the global store used by TaskJWTKeyRotation.
+func SetJWTKeyringStore(store IJWTKeyringStore) {
+	muJWTKeyringStore.Lock()
+	defer muJWTKeyringStore.Unlock()
+	jwtKeyringStore = store
+}
+
+// JWTKEYRINGSTORE returns the global store.
+func JWTKEYRINGSTORE() IJWTKeyringStore {
+	muJWTKeyringStore.RLock()
+	defer muJWTKeyringStore.RUnlock()
+	return jwtKeyringStore
+}
+
+// RunJWTKeyRotationDue rotates kr when its active key is older than
+// RotateHours and returns true if it rotated. The rotation is made on a
+// copy that is saved first and only then swapped into kr, so kr never signs
+// with a key that was not saved.
+func RunJWTKeyRotationDue(store IJWTKeyringStore, kr *acrypt.JWTKeyring) (bool, error) {
+	if store == nil {
+		return false, fmt.Errorf("jwt keyring store is not set")
+	}
+	if kr == nil {
+		return false, fmt.Errorf("jwt keyring is not set")
+	}
+	next := kr.Clone()
+	rotated, err := next.RotateIfDue()
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate jwt keyring; %v", err)
+	}
+	if !rotated {
+		return false, nil
+	}
+	if err = store.SaveJWTKeyring(next); err != nil {
+		return false, fmt.Errorf("failed to save jwt keyring; %v", err)
+	}
+	kr.Set(next)
+	return true, nil
+}
+
+// TaskJWTKeyRotation is an acron task that runs RunJWTKeyRotationDue with
+// the global store and app keyring. The rotation and overlap periods are
+// those of the keyring.
+type TaskJWTKeyRotation struct {
+	Type acron.TaskType `json:"type"`
+}
+
+// GetType returns the type of the task.
+func (t *TaskJWTKeyRotation) GetType() acron.TaskType {
+	return t.Type
+}
+
+// Validate ensures quality control on this struct.
+func (t *TaskJWTKeyRotation) Validate() error {
+	if t.Type.IsEmpty() {
+		t.Type = TASKTYPE_JWTKEYROTATION
+	}
+	if t.Type != TASKTYPE_JWTKEYROTATION {
+		return fmt.Errorf("invalid task type '%s'", t.Type)
+	}
+	return nil
+}
+
+// Run rotates the app keyring if due.
+func (t *TaskJWTKeyRotation) Run(ccc acron.ICronControlCenter) error {
+	if ccc == nil || ccc.GetJRun() == nil {
+		return fmt.Errorf("nil cronControlCenter")
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	rotated, err := RunJWTKeyRotationDue(JWTKEYRINGSTORE(), acrypt.APPJWTKEYRING())
+	ccc.GetJRun().Logger().Info().Msgf("jwt key rotation: rotated=%t", rotated)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpfluger/alibs-slim/acrypt"
 	"github.com/jpfluger/alibs-slim/auser"
 	"strings"
 	"sync"
@@ -26,27 +27,84 @@ func NewClaims(username auser.Username, expirationTime time.Time) jwt.MapClaims
 	}
 }
 
+// jwtUserTokenKey signs and verifies user tokens with either an HS256
+// secret key or, when keyring is set, the asymmetric keys of a JWTKeyring.
+type jwtUserTokenKey struct {
+	secretKey []byte
+	keyring   *acrypt.JWTKeyring
+}
+
+// sign signs claims with the keyring's active key or the secret key.
+func (k jwtUserTokenKey) sign(claims jwt.MapClaims) (string, error) {
+	if k.keyring != nil {
+		return k.keyring.Sign(claims)
+	}
+	if len(k.secretKey) == 0 {
+		return "", errors.New("secretKey is nil")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secretKey)
+}
+
+// parse verifies tokenString. With a keyring the key is selected by kid and
+// HS256 tokens are rejected; otherwise only HMAC tokens are accepted.
+func (k jwtUserTokenKey) parse(tokenString string) (*jwt.Token, error) {
+	if k.keyring != nil {
+		return k.keyring.Verify(tokenString, jwt.MapClaims{})
+	}
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return k.secretKey, nil
+	})
+}
+
+func (k jwtUserTokenKey) validate() error {
+	if k.keyring == nil && len(k.secretKey) == 0 {
+		return errors.New("secretKey is nil")
+	}
+	return nil
+}
+
 func JWTMapClaimSignedString(claims jwt.MapClaims, secretKey []byte) (tokenString string, err error) {
+	return jwtMapClaimSignedString(claims, jwtUserTokenKey{secretKey: secretKey})
+}
+
+// JWTMapClaimSignedStringWithKeyring signs claims with the active key of kr.
+func JWTMapClaimSignedStringWithKeyring(claims jwt.MapClaims, kr *acrypt.JWTKeyring) (tokenString string, err error) {
+	if kr == nil {
+		return "", errors.New("keyring is nil")
+	}
+	return jwtMapClaimSignedString(claims, jwtUserTokenKey{keyring: kr})
+}
+
+func jwtMapClaimSignedString(claims jwt.MapClaims, key jwtUserTokenKey) (tokenString string, err error) {
 	if claims == nil {
 		return "", errors.New("claims is nil")
 	}
-	if secretKey == nil || len(secretKey) == 0 {
-		return "", errors.New("secretKey is nil")
+	if err = key.validate(); err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	return key.sign(claims)
 }
 
 // JWTMapClaimValidate validates a JWT token and matches claims against the provided mapMatch.
 // If the mapMatch values match the claims, it returns the username from the claims.
 func JWTMapClaimValidate(tokenString string, secretKey []byte, mapMatch map[string]string) (username auser.Username, isExpired bool, err error) {
+	return jwtMapClaimValidate(tokenString, jwtUserTokenKey{secretKey: secretKey}, mapMatch)
+}
+
+// JWTMapClaimValidateWithKeyring is JWTMapClaimValidate for tokens signed by kr.
+func JWTMapClaimValidateWithKeyring(tokenString string, kr *acrypt.JWTKeyring, mapMatch map[string]string) (username auser.Username, isExpired bool, err error) {
+	if kr == nil {
+		return "", false, errors.New("keyring is nil")
+	}
+	return jwtMapClaimValidate(tokenString, jwtUserTokenKey{keyring: kr}, mapMatch)
+}
+
+func jwtMapClaimValidate(tokenString string, key jwtUserTokenKey, mapMatch map[string]string) (username auser.Username, isExpired bool, err error) {
 	// Parse and validate JWT token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Method)
-		}
-		return secretKey, nil
-	})
+	token, err := key.parse(tokenString)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			isExpired = true
@@ -121,13 +179,25 @@ func (uc *UserCredential) GetRefreshToken() string {
 
 // ValidateTokenWithSecretKey validates the access and refresh tokens using the provided secret key.
 func (jt *JWTUserToken) ValidateTokenWithSecretKey(secretKey []byte) error {
+	return jt.validateToken(jwtUserTokenKey{secretKey: secretKey})
+}
+
+// ValidateTokenWithKeyring validates the access and refresh tokens using kr.
+func (jt *JWTUserToken) ValidateTokenWithKeyring(kr *acrypt.JWTKeyring) error {
+	if kr == nil {
+		return errors.New("keyring is nil")
+	}
+	return jt.validateToken(jwtUserTokenKey{keyring: kr})
+}
+
+func (jt *JWTUserToken) validateToken(key jwtUserTokenKey) error {
 	jt.mu.Lock()
 	defer jt.mu.Unlock()
 	jt.AccessToken = strings.TrimSpace(jt.AccessToken)
 	jt.RefreshToken = strings.TrimSpace(jt.RefreshToken)
 
 	if jt.AccessToken != "" {
-		isValid, expTime, _ := jt.verifyTokenTimeWithSecretKey(jt.AccessToken, secretKey)
+		isValid, expTime, _ := jt.verifyTokenTime(jt.AccessToken, key)
 		jt.isTokenValid = isValid
 		if expTime == nil {
 			jt.tokenExpires = time.Time{}
@@ -136,7 +206,7 @@ func (jt *JWTUserToken) ValidateTokenWithSecretKey(secretKey []byte) error {
 		}
 	}
 	if jt.RefreshToken != "" {
-		isValid, expTime, _ := jt.verifyTokenTimeWithSecretKey(jt.RefreshToken, secretKey)
+		isValid, expTime, _ := jt.verifyTokenTime(jt.RefreshToken, key)
 		if !isValid || expTime == nil || expTime.IsZero() {
 			jt.refreshExpires = time.Time{}
 		} else {
@@ -159,21 +229,31 @@ func (jt *JWTUserToken) ClearToken() {
 
 // GenerateTokensWithUsername generates an access token and a refresh token for the user.
 func (jt *JWTUserToken) GenerateTokensWithUsername(username auser.Username, accessExpiresInHours, refreshExpiresInDays int, secretKey []byte) (string, string, error) {
+	return jt.generateTokens(username, accessExpiresInHours, refreshExpiresInDays, jwtUserTokenKey{secretKey: secretKey})
+}
+
+// GenerateTokensWithUsernameKeyring generates an access token and a refresh
+// token for the user, signed by the active key of kr.
+func (jt *JWTUserToken) GenerateTokensWithUsernameKeyring(username auser.Username, accessExpiresInHours, refreshExpiresInDays int, kr *acrypt.JWTKeyring) (string, string, error) {
+	if kr == nil {
+		return "", "", errors.New("keyring is nil")
+	}
+	return jt.generateTokens(username, accessExpiresInHours, refreshExpiresInDays, jwtUserTokenKey{keyring: kr})
+}
+
+func (jt *JWTUserToken) generateTokens(username auser.Username, accessExpiresInHours, refreshExpiresInDays int, key jwtUserTokenKey) (string, string, error) {
 	jt.mu.Lock()
 	defer jt.mu.Unlock()
 
 	accessExpirationTime := time.Now().Add(time.Duration(accessExpiresInHours) * time.Hour)
 	refreshExpirationTime := time.Now().Add(time.Duration(refreshExpiresInDays) * 24 * time.Hour)
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, NewClaims(username, accessExpirationTime))
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, NewClaims(username, refreshExpirationTime))
-
-	accessTokenString, err := accessToken.SignedString(secretKey)
+	accessTokenString, err := key.sign(NewClaims(username, accessExpirationTime))
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate access token: %v", err)
 	}
 
-	refreshTokenString, err := refreshToken.SignedString(secretKey)
+	refreshTokenString, err := key.sign(NewClaims(username, refreshExpirationTime))
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
 	}
@@ -190,7 +270,18 @@ func (jt *JWTUserToken) GenerateTokensWithUsername(username auser.Username, acce
 func (jt *JWTUserToken) VerifyTokenWithSecretKey(secretKey []byte) (bool, error) {
 	jt.mu.RLock()
 	defer jt.mu.RUnlock()
-	isValid, _, err := jt.verifyTokenTimeWithSecretKey(jt.AccessToken, secretKey)
+	isValid, _, err := jt.verifyTokenTime(jt.AccessToken, jwtUserTokenKey{secretKey: secretKey})
+	return isValid, err
+}
+
+// VerifyTokenWithKeyring verifies the JWT token stored in the AccessToken field using kr.
+func (jt *JWTUserToken) VerifyTokenWithKeyring(kr *acrypt.JWTKeyring) (bool, error) {
+	if kr == nil {
+		return false, errors.New("keyring is nil")
+	}
+	jt.mu.RLock()
+	defer jt.mu.RUnlock()
+	isValid, _, err := jt.verifyTokenTime(jt.AccessToken, jwtUserTokenKey{keyring: kr})
 	return isValid, err
 }
 
@@ -198,17 +289,22 @@ func (jt *JWTUserToken) VerifyTokenWithSecretKey(secretKey []byte) (bool, error)
 func (jt *JWTUserToken) VerifyTokenTimeWithSecretKey(tokenString string, secretKey []byte) (bool, *time.Time, error) {
 	jt.mu.RLock()
 	defer jt.mu.RUnlock()
-	return jt.verifyTokenTimeWithSecretKey(tokenString, secretKey)
+	return jt.verifyTokenTime(tokenString, jwtUserTokenKey{secretKey: secretKey})
 }
 
-// verifyTokenTimeWithSecretKey verifies the JWT token and returns the expiration time.
-func (jt *JWTUserToken) verifyTokenTimeWithSecretKey(tokenString string, secretKey []byte) (bool, *time.Time, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+// VerifyTokenTimeWithKeyring verifies the JWT token using kr and returns the expiration time.
+func (jt *JWTUserToken) VerifyTokenTimeWithKeyring(tokenString string, kr *acrypt.JWTKeyring) (bool, *time.Time, error) {
+	if kr == nil {
+		return false, nil, errors.New("keyring is nil")
+	}
+	jt.mu.RLock()
+	defer jt.mu.RUnlock()
+	return jt.verifyTokenTime(tokenString, jwtUserTokenKey{keyring: kr})
+}
+
+// verifyTokenTime verifies the JWT token and returns the expiration time.
+func (jt *JWTUserToken) verifyTokenTime(tokenString string, key jwtUserTokenKey) (bool, *time.Time, error) {
+	token, err := key.parse(tokenString)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -233,18 +329,29 @@ func (jt *JWTUserToken) verifyTokenTimeWithSecretKey(tokenString string, secretK
 
 // RefreshAccessTokenWithSecretKey generates a new access token using the refresh token.
 func (jt *JWTUserToken) RefreshAccessTokenWithSecretKey(username auser.Username, secretKey []byte) (string, error) {
+	return jt.refreshAccessToken(username, jwtUserTokenKey{secretKey: secretKey})
+}
+
+// RefreshAccessTokenWithKeyring generates a new access token using the
+// refresh token, both verified and signed by kr.
+func (jt *JWTUserToken) RefreshAccessTokenWithKeyring(username auser.Username, kr *acrypt.JWTKeyring) (string, error) {
+	if kr == nil {
+		return "", errors.New("keyring is nil")
+	}
+	return jt.refreshAccessToken(username, jwtUserTokenKey{keyring: kr})
+}
+
+func (jt *JWTUserToken) refreshAccessToken(username auser.Username, key jwtUserTokenKey) (string, error) {
 	jt.mu.Lock()
 	defer jt.mu.Unlock()
 
-	isValid, expTime, err := jt.verifyTokenTimeWithSecretKey(jt.RefreshToken, secretKey)
+	isValid, expTime, err := jt.verifyTokenTime(jt.RefreshToken, key)
 	if err != nil || !isValid || expTime.Before(time.Now()) {
 		return "", fmt.Errorf("invalid or expired refresh token")
 	}
 
 	accessExpirationTime := time.Now().Add(1 * time.Hour) // Example: 1 hour expiration for access token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, NewClaims(username, accessExpirationTime))
-
-	accessTokenString, err := accessToken.SignedString(secretKey)
+	accessTokenString, err := key.sign(NewClaims(username, accessExpirationTime))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate access token: %v", err)
 	}
@@ -1,6 +1,10 @@
 package anode
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/jpfluger/alibs-slim/acron"
+	"github.com/jpfluger/alibs-slim/acrypt"
 	"github.com/jpfluger/alibs-slim/auser"
 	"testing"
 	"time"
@@ -129,3 +133,97 @@ func TestClearToken(t *testing.T) {
 	assert.True(t, jt.tokenExpires.IsZero())
 	assert.True(t, jt.refreshExpires.IsZero())
 }
+
+func TestJWTUserToken_Keyring(t *testing.T) {
+	kr, err := acrypt.NewJWTKeyring(acrypt.JWTALG_ES256, 24, 1)
+	assert.NoError(t, err)
+
+	uc := &UserCredential{Username: "testuser"}
+	accessToken, _, err := uc.GenerateTokensWithKeyring(1, 7, kr)
+	assert.NoError(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(accessToken, jwt.MapClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ES256", token.Method.Alg())
+	assert.Equal(t, kr.Active().Kid, token.Header["kid"])
+
+	isValid, err := uc.VerifyTokenWithKeyring(kr)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	ok, err := uc.CheckAuthorizationHeaderWithKeyring("Bearer "+accessToken, kr)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	username, _, err := JWTMapClaimValidateWithKeyring(accessToken, kr, map[string]string{"username": "testuser"})
+	assert.NoError(t, err)
+	assert.Equal(t, auser.Username("testuser"), username)
+
+	// Tokens from the HS256 secret are not accepted by the keyring and vice versa.
+	hsToken, _, err := (&JWTUserToken{}).GenerateTokensWithUsername("testuser", 1, 7, secretKey)
+	assert.NoError(t, err)
+	isValid, _, _ = uc.VerifyTokenTimeWithKeyring(hsToken, kr)
+	assert.False(t, isValid)
+	isValid, _, _ = uc.VerifyTokenTimeWithSecretKey(accessToken, secretKey)
+	assert.False(t, isValid)
+
+	// Tokens keep verifying after a rotation, and refresh signs with the new key.
+	prevKid := kr.Active().Kid
+	_, err = kr.Rotate()
+	assert.NoError(t, err)
+	assert.NoError(t, uc.ValidateWithKeyring(kr))
+	assert.True(t, uc.GetIsTokenValid())
+	newAccess, err := uc.RefreshAccessTokenWithKeyring("testuser", kr)
+	assert.NoError(t, err)
+	token, _, _ = jwt.NewParser().ParseUnverified(newAccess, jwt.MapClaims{})
+	assert.NotEqual(t, prevKid, token.Header["kid"])
+}
+
+type jwtKeyringTestStore struct {
+	saved    *acrypt.JWTKeyring
+	failSave bool
+}
+
+func (s *jwtKeyringTestStore) SaveJWTKeyring(kr *acrypt.JWTKeyring) error {
+	if s.failSave {
+		return fmt.Errorf("save failed")
+	}
+	s.saved = kr
+	return nil
+}
+
+func TestTaskJWTKeyRotation(t *testing.T) {
+	kr, err := acrypt.NewJWTKeyring(acrypt.JWTALG_ES256, 24, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, acrypt.SetAppJWTKeyring(kr, true))
+	store := &jwtKeyringTestStore{}
+	SetJWTKeyringStore(store)
+	defer SetJWTKeyringStore(nil)
+
+	// Not due yet.
+	rotated, err := RunJWTKeyRotationDue(store, kr)
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+	assert.Nil(t, store.saved)
+
+	// A failed save leaves the live keyring on its current key.
+	oldKid := kr.Active().Kid
+	kr.Active().CreatedAt = time.Now().UTC().Add(-25 * time.Hour)
+	store.failSave = true
+	rotated, err = RunJWTKeyRotationDue(store, kr)
+	assert.Error(t, err)
+	assert.False(t, rotated)
+	assert.Equal(t, oldKid, kr.Active().Kid)
+
+	// The task loads from job plan JSON through the acron type manager.
+	store.failSave = false
+	jp := &acron.JobPlan{}
+	assert.NoError(t, jp.UnmarshalJSONTask(json.RawMessage(`{"type":"jwt-key-rotation"}`)))
+	task := jp.GetTask()
+	assert.NoError(t, task.Validate())
+	ccc := &acron.CronControlCenter{}
+	ccc.SetJRun(acron.NewJRun())
+	assert.NoError(t, task.Run(ccc))
+
+	assert.NotNil(t, store.saved)
+	assert.NotEqual(t, oldKid, kr.Active().Kid)
+	assert.Equal(t, store.saved.Active().Kid, kr.Active().Kid)
+	assert.NotNil(t, kr.JWKS().Find(oldKid), "old key published during the overlap")
+}
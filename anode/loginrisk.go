@@ -0,0 +1,354 @@
+package anode
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/ageo"
+	"github.com/jpfluger/alibs-slim/asessions"
+)
+
+// LoginRiskDecision is the action a login risk evaluation maps to.
+type LoginRiskDecision string
+
+const (
+	LOGINRISK_ALLOW  LoginRiskDecision = "allow"
+	LOGINRISK_STEPUP LoginRiskDecision = "step-up" // Require MFA before completing the login.
+	LOGINRISK_BLOCK  LoginRiskDecision = "block"
+)
+
+// LoginRiskSignal identifies a risk signal.
+type LoginRiskSignal string
+
+const (
+	LOGINRISK_SIGNAL_NEWDEVICE        LoginRiskSignal = "new-device"
+	LOGINRISK_SIGNAL_NEWCOUNTRY       LoginRiskSignal = "new-country"
+	LOGINRISK_SIGNAL_IMPOSSIBLETRAVEL LoginRiskSignal = "impossible-travel"
+	LOGINRISK_SIGNAL_FAILEDVELOCITY   LoginRiskSignal = "failed-velocity"
+	LOGINRISK_SIGNAL_BADIP            LoginRiskSignal = "bad-ip"
+)
+
+// LoginRiskConfig holds the signal scores and decision thresholds. Zero
+// values use the defaults from NewLoginRiskConfig.
+type LoginRiskConfig struct {
+	NewDeviceScore        int `json:"newDeviceScore,omitempty"`
+	NewCountryScore       int `json:"newCountryScore,omitempty"`
+	ImpossibleTravelScore int `json:"impossibleTravelScore,omitempty"`
+	FailedVelocityScore   int `json:"failedVelocityScore,omitempty"` // Added per failed attempt at or above FailedThreshold.
+	BadIPScore            int `json:"badIPScore,omitempty"`
+
+	FailedThreshold int     `json:"failedThreshold,omitempty"` // Recent failed attempts before velocity counts.
+	MaxTravelKMH    float64 `json:"maxTravelKMH,omitempty"`    // Faster travel between logins is impossible.
+	MinTravelKM     float64 `json:"minTravelKM,omitempty"`     // Ignore shorter distances, which are within geo-IP accuracy.
+
+	StepUpAt int `json:"stepUpAt,omitempty"` // Scores at or above require MFA.
+	BlockAt  int `json:"blockAt,omitempty"`  // Scores at or above are blocked.
+
+	// BadIPs are IPs or CIDRs of known-bad networks (eg Tor exits, abusive hosts).
+	BadIPs []string `json:"badIPs,omitempty"`
+}
+
+// NewLoginRiskConfig returns the default configuration.
+func NewLoginRiskConfig() *LoginRiskConfig {
+	return &LoginRiskConfig{
+		NewDeviceScore:        20,
+		NewCountryScore:       30,
+		ImpossibleTravelScore: 60,
+		FailedVelocityScore:   10,
+		BadIPScore:            100,
+		FailedThreshold:       3,
+		MaxTravelKMH:          900,
+		MinTravelKM:           100,
+		StepUpAt:              30,
+		BlockAt:               80,
+	}
+}
+
+// applyDefaults fills zero values from the defaults.
+func (cfg *LoginRiskConfig) applyDefaults() {
+	def := NewLoginRiskConfig()
+	setInt := func(v *int, d int) {
+		if *v <= 0 {
+			*v = d
+		}
+	}
+	setInt(&cfg.NewDeviceScore, def.NewDeviceScore)
+	setInt(&cfg.NewCountryScore, def.NewCountryScore)
+	setInt(&cfg.ImpossibleTravelScore, def.ImpossibleTravelScore)
+	setInt(&cfg.FailedVelocityScore, def.FailedVelocityScore)
+	setInt(&cfg.BadIPScore, def.BadIPScore)
+	setInt(&cfg.FailedThreshold, def.FailedThreshold)
+	setInt(&cfg.StepUpAt, def.StepUpAt)
+	setInt(&cfg.BlockAt, def.BlockAt)
+	if cfg.MaxTravelKMH <= 0 {
+		cfg.MaxTravelKMH = def.MaxTravelKMH
+	}
+	if cfg.MinTravelKM <= 0 {
+		cfg.MinTravelKM = def.MinTravelKM
+	}
+}
+
+// LoginRiskAttempt describes a login attempt to evaluate.
+type LoginRiskAttempt struct {
+	Device string    // Device name or fingerprint, as stored in UserAccount.Logins.
+	IP     string    // Client IP.
+	Time   time.Time // Zero uses now.
+
+	// Geo of IP; when nil it is looked up with ageo.LookupGeoInfoForIP.
+	Geo *ageo.GeoInfo
+
+	// FailedAttempts is the number of recent failed attempts for the account,
+	// eg counted from a JWTUserAttemptCounter.
+	FailedAttempts int
+}
+
+// LoginRiskReason explains one signal that contributed to a score.
+type LoginRiskReason struct {
+	Signal LoginRiskSignal `json:"signal"`
+	Score  int             `json:"score"`
+	Detail string          `json:"detail"`
+}
+
+// LoginRiskResult is the outcome of an evaluation.
+type LoginRiskResult struct {
+	Score    int               `json:"score"`
+	Decision LoginRiskDecision `json:"decision"`
+	Reasons  []LoginRiskReason `json:"reasons,omitempty"`
+}
+
+// HasSignal returns true if signal contributed to the score.
+func (r *LoginRiskResult) HasSignal(signal LoginRiskSignal) bool {
+	for _, reason := range r.Reasons {
+		if reason.Signal == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// Explain returns a one-line, human-readable explanation for logs and audits.
+func (r *LoginRiskResult) Explain() string {
+	if len(r.Reasons) == 0 {
+		return fmt.Sprintf("%s (score %d): no risk signals", r.Decision, r.Score)
+	}
+	parts := make([]string, 0, len(r.Reasons))
+	for _, reason := range r.Reasons {
+		parts = append(parts, fmt.Sprintf("%s +%d (%s)", reason.Signal, reason.Score, reason.Detail))
+	}
+	return fmt.Sprintf("%s (score %d): %s", r.Decision, r.Score, strings.Join(parts, "; "))
+}
+
+func (r *LoginRiskResult) add(signal LoginRiskSignal, score int, detail string) {
+	r.Score += score
+	r.Reasons = append(r.Reasons, LoginRiskReason{Signal: signal, Score: score, Detail: detail})
+}
+
+// LoginRiskEngine scores login attempts against the account's login history.
+type LoginRiskEngine struct {
+	config  LoginRiskConfig
+	badNets []*net.IPNet
+	fnGeo   func(ip string) *ageo.GeoInfo
+	fnNow   func() time.Time
+	mu      sync.RWMutex
+}
+
+// NewLoginRiskEngine creates an engine. A nil config uses the defaults.
+func NewLoginRiskEngine(cfg *LoginRiskConfig) (*LoginRiskEngine, error) {
+	if cfg == nil {
+		cfg = NewLoginRiskConfig()
+	}
+	e := &LoginRiskEngine{config: *cfg, fnGeo: ageo.LookupGeoInfoForIP}
+	e.config.applyDefaults()
+	if e.config.StepUpAt > e.config.BlockAt {
+		return nil, fmt.Errorf("stepUpAt (%d) must not exceed blockAt (%d)", e.config.StepUpAt, e.config.BlockAt)
+	}
+	if err := e.AddBadIPs(cfg.BadIPs...); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *LoginRiskEngine) now() time.Time {
+	if e.fnNow != nil {
+		return e.fnNow()
+	}
+	return time.Now().UTC()
+}
+
+// AddBadIPs adds IPs or CIDRs to the known-bad list.
+func (e *LoginRiskEngine) AddBadIPs(entries ...string) error {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid bad ip entry '%s'; %v", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	e.mu.Lock()
+	e.badNets = append(e.badNets, nets...)
+	e.mu.Unlock()
+	return nil
+}
+
+// LoadBadIPsFile adds the IPs or CIDRs in a file, one per line. Blank lines
+// and lines starting with # are ignored, as are comments after an entry.
+func (e *LoginRiskEngine) LoadBadIPsFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open bad ip file; %v", err)
+	}
+	defer f.Close()
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		entries = append(entries, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read bad ip file; %v", err)
+	}
+	return e.AddBadIPs(entries...)
+}
+
+// isBadIP checks the ip against the known-bad list.
+func (e *LoginRiskEngine) isBadIP(ip string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.badNets) == 0 {
+		return false
+	}
+	ok, _ := (&asessions.LoginSessionDeviceDate{IP: ip}).IsIPInSubnetList(e.badNets)
+	return ok
+}
+
+func (e *LoginRiskEngine) geo(ip string) *ageo.GeoInfo {
+	if e.fnGeo == nil || ip == "" {
+		return nil
+	}
+	return e.fnGeo(ip)
+}
+
+// Evaluate scores attempt against history (eg UserAccount.Logins, newest
+// first) and maps the score to a decision.
+func (e *LoginRiskEngine) Evaluate(history asessions.LoginSessionDeviceDates, attempt *LoginRiskAttempt) *LoginRiskResult {
+	result := &LoginRiskResult{}
+	if attempt == nil {
+		attempt = &LoginRiskAttempt{}
+	}
+	at := attempt.Time
+	if at.IsZero() {
+		at = e.now()
+	}
+	cfg := e.config
+
+	if attempt.IP != "" && e.isBadIP(attempt.IP) {
+		result.add(LOGINRISK_SIGNAL_BADIP, cfg.BadIPScore, fmt.Sprintf("ip %s is on a known-bad list", attempt.IP))
+	}
+
+	if attempt.FailedAttempts >= cfg.FailedThreshold {
+		over := attempt.FailedAttempts - cfg.FailedThreshold + 1
+		result.add(LOGINRISK_SIGNAL_FAILEDVELOCITY, over*cfg.FailedVelocityScore, fmt.Sprintf("%d recent failed attempts", attempt.FailedAttempts))
+	}
+
+	// The remaining signals compare against previous logins, so a first login has none.
+	var logins asessions.LoginSessionDeviceDates
+	for _, login := range history {
+		if login != nil {
+			logins = append(logins, login)
+		}
+	}
+	if len(logins) > 0 {
+		sort.SliceStable(logins, func(i, j int) bool { return logins[i].Date.After(logins[j].Date) })
+
+		device := strings.TrimSpace(attempt.Device)
+		knownDevice := false
+		for _, login := range logins {
+			knownDevice = knownDevice || (device != "" && strings.EqualFold(login.Device, device))
+		}
+		if !knownDevice {
+			result.add(LOGINRISK_SIGNAL_NEWDEVICE, cfg.NewDeviceScore, fmt.Sprintf("device '%s' not seen before", device))
+		}
+
+		geo := attempt.Geo
+		if geo == nil {
+			geo = e.geo(attempt.IP)
+		}
+		if geo != nil && geo.CountryCode != "" {
+			e.evaluateGeo(result, logins, geo, at)
+		}
+	}
+
+	switch {
+	case result.Score >= cfg.BlockAt:
+		result.Decision = LOGINRISK_BLOCK
+	case result.Score >= cfg.StepUpAt:
+		result.Decision = LOGINRISK_STEPUP
+	default:
+		result.Decision = LOGINRISK_ALLOW
+	}
+	return result
+}
+
+// evaluateGeo adds the new-country and impossible-travel signals.
+func (e *LoginRiskEngine) evaluateGeo(result *LoginRiskResult, logins asessions.LoginSessionDeviceDates, geo *ageo.GeoInfo, at time.Time) {
+	cfg := e.config
+	knownCountry, anyCountry := false, false
+	var last *ageo.GeoInfo
+	var lastDate time.Time
+	for _, login := range logins {
+		prev := e.geo(login.IP)
+		if prev == nil || prev.CountryCode == "" {
+			continue
+		}
+		anyCountry = true
+		knownCountry = knownCountry || strings.EqualFold(prev.CountryCode, geo.CountryCode)
+		if last == nil && prev.IsValid() {
+			last, lastDate = prev, login.Date
+		}
+	}
+	if anyCountry && !knownCountry {
+		result.add(LOGINRISK_SIGNAL_NEWCOUNTRY, cfg.NewCountryScore, fmt.Sprintf("country %s not seen before", geo.CountryCode))
+	}
+
+	if last == nil || !geo.IsValid() {
+		return
+	}
+	km := geo.DistanceToKM(*last)
+	if km < cfg.MinTravelKM {
+		return
+	}
+	hours := at.Sub(lastDate).Hours()
+	if hours < 1.0/60 {
+		hours = 1.0 / 60 // Treat simultaneous logins as one minute apart.
+	}
+	if speed := km / hours; speed > cfg.MaxTravelKMH {
+		result.add(LOGINRISK_SIGNAL_IMPOSSIBLETRAVEL, cfg.ImpossibleTravelScore,
+			fmt.Sprintf("%.0f km from %s in %.1f h (%.0f km/h)", km, last.CountryCode, hours, speed))
+	}
+}
+
+// EvaluateLoginRisk scores attempt against the account's login history.
+func (ua *UserAccount) EvaluateLoginRisk(e *LoginRiskEngine, attempt *LoginRiskAttempt) (*LoginRiskResult, error) {
+	if e == nil {
+		return nil, fmt.Errorf("login risk engine is nil")
+	}
+	return e.Evaluate(ua.Logins, attempt), nil
+}
@@ -0,0 +1,96 @@
+package anode
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/ageo"
+	"github.com/jpfluger/alibs-slim/asessions"
+)
+
+var loginRiskTestGeo = map[string]*ageo.GeoInfo{
+	"198.51.100.1": {CountryCode: "US", City: "New York", GISPoint: ageo.GISPoint{Latitude: 40.7128, Longitude: -74.0060}},
+	"198.51.100.2": {CountryCode: "US", City: "Newark", GISPoint: ageo.GISPoint{Latitude: 40.7357, Longitude: -74.1724}},
+	"203.0.113.9":  {CountryCode: "JP", City: "Tokyo", GISPoint: ageo.GISPoint{Latitude: 35.6762, Longitude: 139.6503}},
+}
+
+func newLoginRiskTestEngine(t *testing.T, cfg *LoginRiskConfig) *LoginRiskEngine {
+	e, err := NewLoginRiskEngine(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.fnGeo = func(ip string) *ageo.GeoInfo { return loginRiskTestGeo[ip] }
+	return e
+}
+
+func TestLoginRiskEngine_Evaluate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	history := asessions.LoginSessionDeviceDates{
+		{Device: "laptop", IP: "198.51.100.1", Date: now.Add(-2 * time.Hour)},
+		{Device: "phone", IP: "198.51.100.1", Date: now.Add(-48 * time.Hour)},
+	}
+	e := newLoginRiskTestEngine(t, nil)
+
+	tests := []struct {
+		name     string
+		attempt  *LoginRiskAttempt
+		history  asessions.LoginSessionDeviceDates
+		decision LoginRiskDecision
+		signals  []LoginRiskSignal
+	}{
+		{"known device nearby", &LoginRiskAttempt{Device: "Laptop", IP: "198.51.100.2", Time: now}, history, LOGINRISK_ALLOW, nil},
+		{"first login", &LoginRiskAttempt{Device: "laptop", IP: "203.0.113.9", Time: now}, nil, LOGINRISK_ALLOW, nil},
+		{"new device", &LoginRiskAttempt{Device: "tablet", IP: "198.51.100.1", Time: now}, history, LOGINRISK_ALLOW, []LoginRiskSignal{LOGINRISK_SIGNAL_NEWDEVICE}},
+		{"new device and country", &LoginRiskAttempt{Device: "tablet", IP: "203.0.113.9", Time: now.Add(48 * time.Hour)}, history, LOGINRISK_STEPUP, []LoginRiskSignal{LOGINRISK_SIGNAL_NEWDEVICE, LOGINRISK_SIGNAL_NEWCOUNTRY}},
+		{"impossible travel", &LoginRiskAttempt{Device: "laptop", IP: "203.0.113.9", Time: now}, history, LOGINRISK_BLOCK, []LoginRiskSignal{LOGINRISK_SIGNAL_NEWCOUNTRY, LOGINRISK_SIGNAL_IMPOSSIBLETRAVEL}},
+		{"failed velocity", &LoginRiskAttempt{Device: "laptop", IP: "198.51.100.1", Time: now, FailedAttempts: 5}, history, LOGINRISK_STEPUP, []LoginRiskSignal{LOGINRISK_SIGNAL_FAILEDVELOCITY}},
+	}
+	for _, tt := range tests {
+		result := e.Evaluate(tt.history, tt.attempt)
+		if result.Decision != tt.decision {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.decision, result.Explain())
+		}
+		if len(result.Reasons) != len(tt.signals) {
+			t.Errorf("%s: expected signals %v, got %s", tt.name, tt.signals, result.Explain())
+		}
+		for _, signal := range tt.signals {
+			if !result.HasSignal(signal) {
+				t.Errorf("%s: expected signal %s in %s", tt.name, signal, result.Explain())
+			}
+		}
+	}
+
+	result := e.Evaluate(history, &LoginRiskAttempt{Device: "laptop", IP: "203.0.113.9", Time: now})
+	if explain := result.Explain(); !strings.HasPrefix(explain, "block (score 90): ") || !strings.Contains(explain, "km/h") {
+		t.Errorf("unexpected explanation %q", explain)
+	}
+}
+
+func TestLoginRiskEngine_BadIPs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad-ips.txt")
+	if err := os.WriteFile(path, []byte("# tor exits\n192.0.2.0/24\n2001:db8::1 # single host\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := newLoginRiskTestEngine(t, &LoginRiskConfig{BadIPs: []string{"198.51.100.7"}})
+	if err := e.LoadBadIPsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.55", "2001:db8::1", "198.51.100.7"} {
+		if result := e.Evaluate(nil, &LoginRiskAttempt{IP: ip}); result.Decision != LOGINRISK_BLOCK || !result.HasSignal(LOGINRISK_SIGNAL_BADIP) {
+			t.Errorf("expected %s to be blocked, got %s", ip, result.Explain())
+		}
+	}
+	if result := e.Evaluate(nil, &LoginRiskAttempt{IP: "198.51.100.8"}); result.Decision != LOGINRISK_ALLOW {
+		t.Errorf("expected allow, got %s", result.Explain())
+	}
+
+	if err := e.AddBadIPs("not-an-ip"); err == nil {
+		t.Errorf("expected an invalid entry to fail")
+	}
+	if _, err := NewLoginRiskEngine(&LoginRiskConfig{StepUpAt: 90, BlockAt: 50}); err == nil {
+		t.Errorf("expected stepUpAt above blockAt to fail")
+	}
+}
@@ -0,0 +1,599 @@
+package anode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/asessions"
+	"github.com/jpfluger/alibs-slim/auser"
+)
+
+var (
+	ErrOIDCState           = errors.New("oidc state is invalid or expired")
+	ErrOIDCNonce           = errors.New("oidc nonce does not match")
+	ErrOIDCIdToken         = errors.New("oidc id token is invalid")
+	ErrOIDCEmailUnverified = errors.New("oidc email is not verified")
+	ErrOIDCAccountNotFound = errors.New("no account for oidc identity")
+)
+
+const (
+	OIDC_DISCOVERY_PATH           = "/.well-known/openid-configuration"
+	OIDC_AUTH_REQUEST_TTL_DEFAULT = 10 * time.Minute
+	OIDC_CLOCK_SKEW               = time.Minute
+	OIDC_HTTP_TIMEOUT             = 10 * time.Second
+	oidcMaxResponseBytes          = 1 << 20
+)
+
+// OIDC_SCOPES_DEFAULT are requested when OIDCConfig.Scopes is empty.
+var OIDC_SCOPES_DEFAULT = []string{"openid", "email", "profile"}
+
+// OIDCLinkResult describes how Resolve found the account.
+type OIDCLinkResult string
+
+const (
+	OIDCLINK_EXISTING OIDCLinkResult = "existing" // Already linked to the identity.
+	OIDCLINK_LINKED   OIDCLinkResult = "linked"   // Linked now by verified email.
+	OIDCLINK_CREATED  OIDCLinkResult = "created"  // Created from the identity.
+)
+
+// OIDCDiscovery is the subset of the provider metadata used by the relying party.
+type OIDCDiscovery struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint,omitempty"`
+	JwksUri                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// OIDCClaimMap names the ID token claims read into an OIDCIdentity. Empty
+// fields use the standard claim names; Roles is off when empty.
+type OIDCClaimMap struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified string `json:"emailVerified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Roles         string `json:"roles,omitempty"` // eg "groups"; a string array or space-separated string.
+}
+
+// OIDCConfig configures a relying party for one provider.
+type OIDCConfig struct {
+	// Name identifies the provider on linked accounts, eg "corp".
+	Name string `json:"name"`
+
+	Issuer       string   `json:"issuer"`
+	ClientId     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret,omitempty"` // Sent with client_secret_basic; empty for public clients.
+	RedirectUrl  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	ClaimMap OIDCClaimMap `json:"claimMap,omitempty"`
+
+	// RoleMap maps role claim values to roles. Unmapped values are ignored.
+	// Roles named here are managed by the provider: they are replaced on
+	// every login, while other roles on the account are kept.
+	RoleMap map[string]asessions.RoleName `json:"roleMap,omitempty"`
+
+	// AllowCreate creates accounts for verified identities with no account.
+	AllowCreate bool `json:"allowCreate,omitempty"`
+
+	AuthRequestTTL time.Duration `json:"authRequestTTL,omitempty"`
+
+	Client *http.Client `json:"-"`
+}
+
+// Validate checks the config and applies defaults.
+func (cfg *OIDCConfig) Validate() error {
+	if cfg == nil {
+		return fmt.Errorf("oidc config is nil")
+	}
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	if cfg.Name == "" {
+		return fmt.Errorf("oidc provider name is empty")
+	}
+	cfg.Issuer = strings.TrimSpace(cfg.Issuer)
+	if u, err := url.Parse(cfg.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid oidc issuer '%s'", cfg.Issuer)
+	}
+	cfg.ClientId = strings.TrimSpace(cfg.ClientId)
+	if cfg.ClientId == "" {
+		return fmt.Errorf("oidc client id is empty")
+	}
+	if u, err := url.Parse(cfg.RedirectUrl); err != nil || !u.IsAbs() {
+		return fmt.Errorf("oidc redirect url must be absolute")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = OIDC_SCOPES_DEFAULT
+	}
+	if cfg.ClaimMap.Email == "" {
+		cfg.ClaimMap.Email = "email"
+	}
+	if cfg.ClaimMap.EmailVerified == "" {
+		cfg.ClaimMap.EmailVerified = "email_verified"
+	}
+	if cfg.ClaimMap.Name == "" {
+		cfg.ClaimMap.Name = "name"
+	}
+	if cfg.ClaimMap.Username == "" {
+		cfg.ClaimMap.Username = "preferred_username"
+	}
+	if cfg.AuthRequestTTL <= 0 {
+		cfg.AuthRequestTTL = OIDC_AUTH_REQUEST_TTL_DEFAULT
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: OIDC_HTTP_TIMEOUT}
+	}
+	return nil
+}
+
+// OIDCAuthRequest is a pending authorization request. It is stored
+// server-side and looked up by state on the callback.
+type OIDCAuthRequest struct {
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"codeVerifier"`
+	Provider     string    `json:"provider"`
+	ReturnUrl    string    `json:"returnUrl,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// IOIDCAuthRequestStore keeps pending authorization requests.
+type IOIDCAuthRequestStore interface {
+	SaveOIDCAuthRequest(req *OIDCAuthRequest) error
+	// TakeOIDCAuthRequest removes and returns the request, or nil if not found.
+	TakeOIDCAuthRequest(state string) (*OIDCAuthRequest, error)
+}
+
+// IOIDCAccountStore finds and saves the accounts linked to OIDC identities.
+// Find methods return nil, nil if there is no match.
+type IOIDCAccountStore interface {
+	// FindUserAccountByExternalIdentity finds the account linked to subject at
+	// issuer (see UserExternalIdentities.Find).
+	FindUserAccountByExternalIdentity(issuer string, subject string) (*UserAccount, error)
+	FindUserAccountByEmail(email aemail.EmailAddress) (*UserAccount, error)
+	SaveUserAccount(ua *UserAccount, isNew bool) error
+}
+
+// OIDCIdentity is a verified ID token mapped through the OIDCClaimMap.
+type OIDCIdentity struct {
+	Provider      string                 `json:"provider"`
+	Issuer        string                 `json:"issuer"`
+	Subject       string                 `json:"subject"`
+	Email         aemail.EmailAddress    `json:"email,omitempty"`
+	EmailVerified bool                   `json:"emailVerified"`
+	Name          string                 `json:"name,omitempty"`
+	Username      string                 `json:"username,omitempty"`
+	Roles         asessions.RoleNames    `json:"roles,omitempty"`
+	Claims        map[string]interface{} `json:"claims,omitempty"`
+}
+
+// OIDCRelyingParty runs the authorization code flow with PKCE against one provider.
+type OIDCRelyingParty struct {
+	Config *OIDCConfig
+	Store  IOIDCAuthRequestStore
+
+	discovery *OIDCDiscovery
+	jwks      *acrypt.JWKSRemote
+	fnNow     func() time.Time
+	mu        sync.Mutex
+}
+
+// NewOIDCRelyingParty creates a relying party. Discovery runs on first use.
+func NewOIDCRelyingParty(cfg *OIDCConfig, store IOIDCAuthRequestStore) (*OIDCRelyingParty, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, fmt.Errorf("oidc auth request store is nil")
+	}
+	return &OIDCRelyingParty{Config: cfg, Store: store}, nil
+}
+
+func (rp *OIDCRelyingParty) now() time.Time {
+	if rp.fnNow != nil {
+		return rp.fnNow()
+	}
+	return time.Now()
+}
+
+// Discover fetches the provider metadata. The metadata issuer must equal the configured issuer.
+func (rp *OIDCRelyingParty) Discover(ctx context.Context) (*OIDCDiscovery, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.discovery != nil {
+		return rp.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(rp.Config.Issuer, "/")+OIDC_DISCOVERY_PATH, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oidc discovery request; %v", err)
+	}
+	resp, err := rp.Config.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oidc discovery; %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch oidc discovery; status %d", resp.StatusCode)
+	}
+	doc := &OIDCDiscovery{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(doc); err != nil {
+		return nil, fmt.Errorf("failed to decode oidc discovery; %v", err)
+	}
+	if doc.Issuer != rp.Config.Issuer {
+		return nil, fmt.Errorf("oidc discovery issuer '%s' does not match '%s'", doc.Issuer, rp.Config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JwksUri == "" {
+		return nil, fmt.Errorf("oidc discovery is missing an endpoint")
+	}
+	if len(doc.CodeChallengeMethodsSupported) > 0 && !oidcContains(doc.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("oidc provider does not support PKCE S256")
+	}
+	jwks, err := acrypt.NewJWKSRemote(doc.JwksUri, &acrypt.JWKSRemoteOptions{Client: rp.Config.Client})
+	if err != nil {
+		return nil, err
+	}
+	rp.discovery, rp.jwks = doc, jwks
+	return doc, nil
+}
+
+// AuthCodeURL creates and stores an authorization request and returns the
+// provider URL to redirect the browser to. returnUrl is kept for the callback.
+func (rp *OIDCRelyingParty) AuthCodeURL(ctx context.Context, returnUrl string) (string, *OIDCAuthRequest, error) {
+	doc, err := rp.Discover(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	req := &OIDCAuthRequest{Provider: rp.Config.Name, ReturnUrl: returnUrl, Expires: rp.now().Add(rp.Config.AuthRequestTTL)}
+	for _, field := range []*string{&req.State, &req.Nonce, &req.CodeVerifier} {
+		if *field, err = oidcRandom(); err != nil {
+			return "", nil, err
+		}
+	}
+	if err = rp.Store.SaveOIDCAuthRequest(req); err != nil {
+		return "", nil, fmt.Errorf("failed to save oidc auth request; %v", err)
+	}
+
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	u, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid oidc authorization endpoint; %v", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.Config.ClientId)
+	q.Set("redirect_uri", rp.Config.RedirectUrl)
+	q.Set("scope", strings.Join(rp.Config.Scopes, " "))
+	q.Set("state", req.State)
+	q.Set("nonce", req.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), req, nil
+}
+
+// Exchange completes the callback: it takes the request for state, redeems
+// code with the PKCE verifier and verifies the returned ID token.
+func (rp *OIDCRelyingParty) Exchange(ctx context.Context, state string, code string) (*OIDCIdentity, *OIDCAuthRequest, error) {
+	if state == "" || code == "" {
+		return nil, nil, ErrOIDCState
+	}
+	req, err := rp.Store.TakeOIDCAuthRequest(state)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load oidc auth request; %v", err)
+	}
+	if req == nil || req.Provider != rp.Config.Name || !rp.now().Before(req.Expires) ||
+		subtle.ConstantTimeCompare([]byte(req.State), []byte(state)) != 1 {
+		return nil, nil, ErrOIDCState
+	}
+	doc, err := rp.Discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", rp.Config.RedirectUrl)
+	form.Set("code_verifier", req.CodeVerifier)
+	form.Set("client_id", rp.Config.ClientId)
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create oidc token request; %v", err)
+	}
+	hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hreq.Header.Set("Accept", "application/json")
+	if rp.Config.ClientSecret != "" {
+		hreq.SetBasicAuth(url.QueryEscape(rp.Config.ClientId), url.QueryEscape(rp.Config.ClientSecret))
+	}
+	resp, err := rp.Config.Client.Do(hreq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed oidc token request; %v", err)
+	}
+	defer resp.Body.Close()
+	tokens := &struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(tokens); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode oidc token response; status %d; %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, nil, fmt.Errorf("oidc token request failed; status %d; %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	identity, err := rp.VerifyIdToken(tokens.IdToken, req.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return identity, req, nil
+}
+
+// VerifyIdToken verifies the signature, issuer, audience, lifetime and nonce
+// of an ID token and maps its claims.
+func (rp *OIDCRelyingParty) VerifyIdToken(idToken string, nonce string) (*OIDCIdentity, error) {
+	if _, err := rp.Discover(context.Background()); err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	if _, err := rp.jwks.Verify(idToken, claims,
+		jwt.WithIssuer(rp.Config.Issuer),
+		jwt.WithAudience(rp.Config.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(OIDC_CLOCK_SKEW),
+		jwt.WithTimeFunc(rp.now),
+	); err != nil {
+		return nil, fmt.Errorf("%w; %v", ErrOIDCIdToken, err)
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, ErrOIDCNonce
+	}
+	// A token for several audiences must name this client as the authorized party.
+	aud, _ := claims.GetAudience()
+	azp, _ := claims["azp"].(string)
+	if (len(aud) > 1 || azp != "") && azp != rp.Config.ClientId {
+		return nil, fmt.Errorf("%w; authorized party '%s'", ErrOIDCIdToken, azp)
+	}
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return nil, fmt.Errorf("%w; missing subject", ErrOIDCIdToken)
+	}
+	return rp.mapClaims(sub, claims), nil
+}
+
+// mapClaims maps the verified claims to an identity.
+func (rp *OIDCRelyingParty) mapClaims(sub string, claims jwt.MapClaims) *OIDCIdentity {
+	cm := rp.Config.ClaimMap
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return strings.TrimSpace(s)
+	}
+	identity := &OIDCIdentity{
+		Provider: rp.Config.Name,
+		Issuer:   rp.Config.Issuer,
+		Subject:  sub,
+		Email:    aemail.EmailAddress(strings.ToLower(str(cm.Email))),
+		Name:     str(cm.Name),
+		Username: str(cm.Username),
+		Claims:   claims,
+	}
+	// Some providers send email_verified as a string.
+	switch v := claims[cm.EmailVerified].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = strings.EqualFold(v, "true")
+	}
+	if !identity.Email.IsValid() {
+		identity.Email, identity.EmailVerified = "", false
+	}
+
+	if cm.Roles != "" {
+		var values []string
+		switch v := claims[cm.Roles].(type) {
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					values = append(values, s)
+				}
+			}
+		case string:
+			values = strings.Fields(v)
+		}
+		for _, value := range values {
+			if role, ok := rp.Config.RoleMap[value]; ok && !oidcContainsRole(identity.Roles, role) {
+				identity.Roles = append(identity.Roles, role)
+			}
+		}
+	}
+	return identity
+}
+
+// Resolve finds or creates the account for identity. An account already
+// linked to the identity (by issuer and subject) is used first. Otherwise an
+// account with the same email is linked, but only if the provider verified
+// the email. New accounts are created only if AllowCreate is set and the
+// email is verified. New accounts take the mapped name and username claims,
+// and existing accounts take them where they have none. The link's mapped
+// claims and the provider-managed roles are synced and the account is saved. An account whose
+// lifecycle state may not sign in returns ErrUserLoginNotAllowed.
+func (rp *OIDCRelyingParty) Resolve(identity *OIDCIdentity, accounts IOIDCAccountStore) (*UserAccount, OIDCLinkResult, error) {
+	if identity == nil || accounts == nil {
+		return nil, "", fmt.Errorf("oidc identity and account store are required")
+	}
+	if identity.Issuer == "" {
+		identity.Issuer = rp.Config.Issuer
+	}
+	ua, err := accounts.FindUserAccountByExternalIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find oidc account; %v", err)
+	}
+	if ua != nil {
+		if err = ua.CheckCanLogin(); err != nil {
+			return nil, "", err
+		}
+		rp.syncLink(ua, identity)
+		rp.syncRoles(ua, identity)
+		if err = accounts.SaveUserAccount(ua, false); err != nil {
+			return nil, "", fmt.Errorf("failed to save oidc account; %v", err)
+		}
+		return ua, OIDCLINK_EXISTING, nil
+	}
+
+	if identity.Email.IsEmpty() || !identity.EmailVerified {
+		return nil, "", ErrOIDCEmailUnverified
+	}
+	result := OIDCLINK_LINKED
+	if ua, err = accounts.FindUserAccountByEmail(identity.Email); err != nil {
+		return nil, "", fmt.Errorf("failed to find oidc account by email; %v", err)
+	}
+	if ua == nil {
+		if !rp.Config.AllowCreate {
+			return nil, "", ErrOIDCAccountNotFound
+		}
+		ua, result = &UserAccount{Email: identity.Email}, OIDCLINK_CREATED
+	} else if err = ua.CheckCanLogin(); err != nil {
+		return nil, "", err
+	}
+	rp.syncLink(ua, identity)
+	rp.syncRoles(ua, identity)
+	if err = accounts.SaveUserAccount(ua, result == OIDCLINK_CREATED); err != nil {
+		return nil, "", fmt.Errorf("failed to save oidc account; %v", err)
+	}
+	return ua, result, nil
+}
+
+// syncLink links identity to the account, once per issuer and subject, and
+// updates the link with the mapped claims of identity. The name and username
+// claims are also copied to the account if it has none.
+func (rp *OIDCRelyingParty) syncLink(ua *UserAccount, identity *OIDCIdentity) {
+	if ua.Name == "" {
+		ua.Name = identity.Name
+	}
+	if ua.PreferredUsername.IsEmpty() {
+		ua.PreferredUsername = auser.Username(identity.Username)
+	}
+	link := ua.ExternalIdentities.Find(identity.Issuer, identity.Subject)
+	if link == nil {
+		link = &UserExternalIdentity{Issuer: identity.Issuer, Subject: identity.Subject, Linked: rp.now()}
+		ua.ExternalIdentities = append(ua.ExternalIdentities, link)
+	}
+	link.Provider = identity.Provider
+	if identity.EmailVerified && !identity.Email.IsEmpty() {
+		link.Email = identity.Email
+	}
+	if identity.Name != "" {
+		link.Name = identity.Name
+	}
+	if identity.Username != "" {
+		link.Username = identity.Username
+	}
+}
+
+// syncRoles replaces the roles managed by RoleMap with those of identity.
+func (rp *OIDCRelyingParty) syncRoles(ua *UserAccount, identity *OIDCIdentity) {
+	if rp.Config.ClaimMap.Roles == "" {
+		return
+	}
+	managed := asessions.RoleNames{}
+	for _, role := range rp.Config.RoleMap {
+		managed = append(managed, role)
+	}
+	roles := asessions.Roles{}
+	for _, role := range ua.Roles {
+		if role != nil && !oidcContainsRole(managed, role.Name) {
+			roles = append(roles, role)
+		}
+	}
+	for _, name := range identity.Roles {
+		roles = append(roles, &asessions.Role{Name: name})
+	}
+	ua.Roles = roles
+}
+
+func oidcRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate oidc random; %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func oidcContains(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+func oidcContainsRole(roles asessions.RoleNames, target asessions.RoleName) bool {
+	for _, role := range roles {
+		if role == target {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCMemoryAuthRequestStore is an in-memory IOIDCAuthRequestStore for a
+// single instance or tests. Expired requests are pruned on save.
+type OIDCMemoryAuthRequestStore struct {
+	requests map[string]*OIDCAuthRequest
+	mu       sync.Mutex
+}
+
+// NewOIDCMemoryAuthRequestStore creates an empty store.
+func NewOIDCMemoryAuthRequestStore() *OIDCMemoryAuthRequestStore {
+	return &OIDCMemoryAuthRequestStore{requests: map[string]*OIDCAuthRequest{}}
+}
+
+// SaveOIDCAuthRequest stores a copy of req.
+func (s *OIDCMemoryAuthRequestStore) SaveOIDCAuthRequest(req *OIDCAuthRequest) error {
+	if req == nil || req.State == "" {
+		return fmt.Errorf("oidc auth request is nil or has no state")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for state, old := range s.requests {
+		if !now.Before(old.Expires) {
+			delete(s.requests, state)
+		}
+	}
+	cp := *req
+	s.requests[req.State] = &cp
+	return nil
+}
+
+// TakeOIDCAuthRequest removes and returns the request, or nil if not found.
+func (s *OIDCMemoryAuthRequestStore) TakeOIDCAuthRequest(state string) (*OIDCAuthRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.requests, state)
+	return req, nil
+}
@@ -0,0 +1,370 @@
+package anode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/asessions"
+)
+
+// oidcMockProvider is an in-process OIDC provider with discovery, JWKS,
+// an authorize endpoint that approves immediately and a PKCE token endpoint.
+type oidcMockProvider struct {
+	srv     *httptest.Server
+	keyring *acrypt.JWTKeyring
+	claims  jwt.MapClaims // Added to the next ID tokens.
+	noAlg   bool          // Serve the JWKS without "alg", as some providers do.
+	codes   map[string]url.Values
+	mu      sync.Mutex
+}
+
+func newOIDCMockProvider(t *testing.T) *oidcMockProvider {
+	kr, err := acrypt.NewJWTKeyring(acrypt.JWTALG_ES256, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcMockProvider{keyring: kr, codes: map[string]url.Values{}, claims: jwt.MapClaims{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(OIDC_DISCOVERY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&OIDCDiscovery{
+			Issuer:                        p.srv.URL,
+			AuthorizationEndpoint:         p.srv.URL + "/authorize",
+			TokenEndpoint:                 p.srv.URL + "/token",
+			JwksUri:                       p.srv.URL + "/jwks",
+			CodeChallengeMethodsSupported: []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		set := p.keyring.JWKS()
+		if p.noAlg {
+			for _, key := range set.Keys {
+				key.Alg = ""
+			}
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		code, _ := oidcRandom()
+		p.mu.Lock()
+		p.codes[code] = q
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.mu.Lock()
+		q, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		claims := jwt.MapClaims{}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		id, secret, _ := r.BasicAuth()
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") ||
+			r.PostForm.Get("redirect_uri") != q.Get("redirect_uri") || id != q.Get("client_id") || secret != "shh" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		claims["iss"], claims["aud"], claims["iat"], claims["exp"] = p.srv.URL, q.Get("client_id"), now.Unix(), now.Add(5*time.Minute).Unix()
+		claims["nonce"] = q.Get("nonce")
+		if _, ok = claims["sub"]; !ok {
+			claims["sub"] = "user-123"
+		}
+		idToken, err := p.keyring.Sign(claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "at", "token_type": "Bearer"})
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// authorize follows authUrl to the provider and returns the callback code and state.
+func (p *oidcMockProvider) authorize(t *testing.T, authUrl string) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize failed: %d %v", resp.StatusCode, err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+// oidcTestAccounts is an in-memory IOIDCAccountStore.
+type oidcTestAccounts struct {
+	accounts []*UserAccount
+	saved    int
+}
+
+func (s *oidcTestAccounts) FindUserAccountByExternalIdentity(issuer string, subject string) (*UserAccount, error) {
+	for _, ua := range s.accounts {
+		if ua.ExternalIdentities.Find(issuer, subject) != nil {
+			return ua, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *oidcTestAccounts) FindUserAccountByEmail(email aemail.EmailAddress) (*UserAccount, error) {
+	for _, ua := range s.accounts {
+		if ua.Email.Matches(email) {
+			return ua, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *oidcTestAccounts) SaveUserAccount(ua *UserAccount, isNew bool) error {
+	if isNew {
+		s.accounts = append(s.accounts, ua)
+	}
+	s.saved++
+	return nil
+}
+
+func newOIDCTestRelyingParty(t *testing.T, p *oidcMockProvider) *OIDCRelyingParty {
+	rp, err := NewOIDCRelyingParty(&OIDCConfig{
+		Name:         "corp",
+		Issuer:       p.srv.URL,
+		ClientId:     "app",
+		ClientSecret: "shh",
+		RedirectUrl:  "https://app.example.com/oidc/callback",
+		ClaimMap:     OIDCClaimMap{Roles: "groups"},
+		RoleMap:      map[string]asessions.RoleName{"admins": "org:Admin", "staff": "org:Operator"},
+	}, NewOIDCMemoryAuthRequestStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestOIDCConfig_Validate(t *testing.T) {
+	cfg := &OIDCConfig{Name: "corp", Issuer: "https://idp.example.com", ClientId: "app", RedirectUrl: "https://app.example.com/cb"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClaimMap.Email != "email" || len(cfg.Scopes) != 3 || cfg.AuthRequestTTL != OIDC_AUTH_REQUEST_TTL_DEFAULT {
+		t.Errorf("expected defaults, got %+v", cfg)
+	}
+	for _, bad := range []*OIDCConfig{
+		{Issuer: "https://idp.example.com", ClientId: "app", RedirectUrl: "https://app.example.com/cb"},
+		{Name: "corp", Issuer: "idp.example.com", ClientId: "app", RedirectUrl: "https://app.example.com/cb"},
+		{Name: "corp", Issuer: "https://idp.example.com", RedirectUrl: "https://app.example.com/cb"},
+		{Name: "corp", Issuer: "https://idp.example.com", ClientId: "app", RedirectUrl: "/cb"},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestOIDCRelyingParty_Flow(t *testing.T) {
+	p := newOIDCMockProvider(t)
+	p.claims = jwt.MapClaims{"email": "User@Example.com", "email_verified": true, "name": "Ann User", "preferred_username": "ann", "groups": []string{"staff", "unmapped"}}
+	rp := newOIDCTestRelyingParty(t, p)
+
+	authUrl, req, err := rp.AuthCodeURL(context.Background(), "/after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := url.Parse(authUrl)
+	if q.Query().Get("code_challenge") == "" || q.Query().Get("nonce") != req.Nonce || q.Query().Get("scope") != "openid email profile" {
+		t.Fatalf("unexpected auth url %s", authUrl)
+	}
+	code, state := p.authorize(t, authUrl)
+
+	identity, req, err := rp.Exchange(context.Background(), state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ReturnUrl != "/after" || identity.Subject != "user-123" || identity.Email != "user@example.com" || !identity.EmailVerified ||
+		identity.Name != "Ann User" || identity.Username != "ann" || len(identity.Roles) != 1 || identity.Roles[0] != "org:Operator" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	// The state is single-use.
+	if _, _, err = rp.Exchange(context.Background(), state, code); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("expected ErrOIDCState, got %v", err)
+	}
+}
+
+func TestOIDCRelyingParty_ExchangeFailures(t *testing.T) {
+	p := newOIDCMockProvider(t)
+	rp := newOIDCTestRelyingParty(t, p)
+	ctx := context.Background()
+
+	if _, _, err := rp.Exchange(ctx, "unknown", "code"); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("expected ErrOIDCState, got %v", err)
+	}
+
+	// Expired request.
+	authUrl, _, _ := rp.AuthCodeURL(ctx, "")
+	code, state := p.authorize(t, authUrl)
+	rp.fnNow = func() time.Time { return time.Now().Add(OIDC_AUTH_REQUEST_TTL_DEFAULT) }
+	if _, _, err := rp.Exchange(ctx, state, code); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("expected ErrOIDCState for an expired request, got %v", err)
+	}
+	rp.fnNow = nil
+
+	// A code bound to another PKCE verifier is rejected by the provider.
+	authUrl, _, _ = rp.AuthCodeURL(ctx, "")
+	code, _ = p.authorize(t, authUrl)
+	authUrl2, _, _ := rp.AuthCodeURL(ctx, "")
+	_, state2 := p.authorize(t, authUrl2)
+	if _, _, err := rp.Exchange(ctx, state2, code); err == nil || errors.Is(err, ErrOIDCState) {
+		t.Errorf("expected invalid_grant, got %v", err)
+	}
+}
+
+func TestOIDCRelyingParty_VerifyIdToken(t *testing.T) {
+	p := newOIDCMockProvider(t)
+	rp := newOIDCTestRelyingParty(t, p)
+	now := time.Now()
+	sign := func(kr *acrypt.JWTKeyring, claims jwt.MapClaims) string {
+		base := jwt.MapClaims{"iss": p.srv.URL, "aud": "app", "sub": "user-123", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix(), "nonce": "n1"}
+		for k, v := range claims {
+			base[k] = v
+		}
+		token, err := kr.Sign(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	if _, err := rp.VerifyIdToken(sign(p.keyring, nil), "n1"); err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	if _, err := rp.VerifyIdToken(sign(p.keyring, nil), "n2"); !errors.Is(err, ErrOIDCNonce) {
+		t.Errorf("expected ErrOIDCNonce, got %v", err)
+	}
+	other, _ := acrypt.NewJWTKeyring(acrypt.JWTALG_ES256, 0, 0)
+	for name, token := range map[string]string{
+		"foreign key":    sign(other, nil),
+		"wrong issuer":   sign(p.keyring, jwt.MapClaims{"iss": "https://evil.example.com"}),
+		"wrong audience": sign(p.keyring, jwt.MapClaims{"aud": "other-app"}),
+		"expired":        sign(p.keyring, jwt.MapClaims{"exp": now.Add(-time.Hour).Unix()}),
+		"bad azp":        sign(p.keyring, jwt.MapClaims{"aud": []string{"app", "other-app"}, "azp": "other-app"}),
+		"no subject":     sign(p.keyring, jwt.MapClaims{"sub": ""}),
+	} {
+		if _, err := rp.VerifyIdToken(token, "n1"); !errors.Is(err, ErrOIDCIdToken) {
+			t.Errorf("%s: expected ErrOIDCIdToken, got %v", name, err)
+		}
+	}
+
+	identity, err := rp.VerifyIdToken(sign(p.keyring, jwt.MapClaims{"email": "a@example.com", "email_verified": "true", "groups": "admins staff"}), "n1")
+	if err != nil || !identity.EmailVerified || len(identity.Roles) != 2 {
+		t.Errorf("expected string claims to map, got %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCRelyingParty_VerifyIdTokenNoAlg(t *testing.T) {
+	p := newOIDCMockProvider(t)
+	p.noAlg = true
+	rp := newOIDCTestRelyingParty(t, p)
+	now := time.Now()
+	token, err := p.keyring.Sign(jwt.MapClaims{"iss": p.srv.URL, "aud": "app", "sub": "user-123", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix(), "nonce": "n1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := rp.VerifyIdToken(token, "n1")
+	if err != nil || identity.Subject != "user-123" || identity.Issuer != p.srv.URL {
+		t.Errorf("expected the alg to be inferred from the key, got %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCRelyingParty_Resolve(t *testing.T) {
+	p := newOIDCMockProvider(t)
+	rp := newOIDCTestRelyingParty(t, p)
+	existing := &UserAccount{Email: "user@example.com", Roles: asessions.Roles{{Name: "org:Admin"}, {Name: "domain:Local"}}}
+	accounts := &oidcTestAccounts{accounts: []*UserAccount{existing}}
+
+	// Unverified email never links.
+	identity := &OIDCIdentity{Provider: "corp", Subject: "s1", Email: "user@example.com"}
+	if _, _, err := rp.Resolve(identity, accounts); !errors.Is(err, ErrOIDCEmailUnverified) {
+		t.Errorf("expected ErrOIDCEmailUnverified, got %v", err)
+	}
+
+	// Verified email links and syncs the managed roles.
+	identity.EmailVerified, identity.Roles = true, asessions.RoleNames{"org:Operator"}
+	identity.Name, identity.Username = "Ann User", "ann"
+	ua, result, err := rp.Resolve(identity, accounts)
+	if err != nil || ua != existing || result != OIDCLINK_LINKED {
+		t.Fatalf("expected link, got %v %v", result, err)
+	}
+	if link := ua.ExternalIdentities.Find(p.srv.URL, "s1"); link == nil || link.Name != "Ann User" || link.Username != "ann" || link.Email != "user@example.com" {
+		t.Errorf("expected the identity to be linked with its claims, got %+v", link)
+	}
+	if existing.Name != "Ann User" || existing.PreferredUsername != "ann" {
+		t.Errorf("expected the linked account to take the mapped claims, got %q %q", existing.Name, existing.PreferredUsername)
+	}
+	if ua.Roles.FindRoleByName("org:Admin") != nil || ua.Roles.FindRoleByName("org:Operator") == nil || ua.Roles.FindRoleByName("domain:Local") == nil {
+		t.Errorf("unexpected roles %+v", ua.Roles)
+	}
+
+	// Later logins find the link by subject, even if the email changed, and
+	// refresh the mapped claims.
+	identity.Email, identity.EmailVerified = "renamed@example.com", false
+	identity.Username = "ann.user"
+	if ua, result, err = rp.Resolve(identity, accounts); err != nil || ua != existing || result != OIDCLINK_EXISTING {
+		t.Errorf("expected existing, got %v %v", result, err)
+	}
+	if link := ua.ExternalIdentities.Find(p.srv.URL, "s1"); link == nil || link.Username != "ann.user" || link.Email != "user@example.com" {
+		t.Errorf("expected the link claims to be refreshed, got %+v", link)
+	}
+
+	// The same issuer under another provider name reuses the link.
+	renamed := &OIDCIdentity{Provider: "corp-sso", Issuer: p.srv.URL, Subject: "s1", Email: "user@example.com", EmailVerified: true}
+	if ua, result, err = rp.Resolve(renamed, accounts); err != nil || ua != existing || result != OIDCLINK_EXISTING || len(ua.ExternalIdentities) != 1 {
+		t.Errorf("expected a single link per issuer and subject, got %v %v %+v", result, err, ua.ExternalIdentities)
+	}
+
+	// A suspended account may not sign in through its link.
+	if err = USERLIFECYCLEMACHINE().Transition(existing, &UserLifecycleTransition{To: USERSTATE_SUSPENDED, Reason: "audit"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = rp.Resolve(identity, accounts); !errors.Is(err, ErrUserLoginNotAllowed) {
+		t.Errorf("expected ErrUserLoginNotAllowed, got %v", err)
+	}
+
+	// Unknown verified identities are created only if allowed.
+	newcomer := &OIDCIdentity{Provider: "corp", Subject: "s2", Email: "new@example.com", EmailVerified: true, Name: "New User", Username: "newbie"}
+	if _, _, err = rp.Resolve(newcomer, accounts); !errors.Is(err, ErrOIDCAccountNotFound) {
+		t.Errorf("expected ErrOIDCAccountNotFound, got %v", err)
+	}
+	rp.Config.AllowCreate = true
+	if ua, result, err = rp.Resolve(newcomer, accounts); err != nil || result != OIDCLINK_CREATED || ua.Email != "new@example.com" || len(accounts.accounts) != 2 {
+		t.Errorf("expected created, got %v %v", result, err)
+	}
+	if ua.Name != "New User" || ua.PreferredUsername != "newbie" {
+		t.Errorf("expected the created account to take the mapped claims, got %q %q", ua.Name, ua.PreferredUsername)
+	}
+}
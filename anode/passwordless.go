@@ -0,0 +1,413 @@
+package anode
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/auuids"
+)
+
+var (
+	ErrPasswordlessInvalid     = errors.New("passwordless token is invalid")
+	ErrPasswordlessExpired     = errors.New("passwordless token has expired")
+	ErrPasswordlessUsed        = errors.New("passwordless token was already used")
+	ErrPasswordlessDevice      = errors.New("passwordless token was requested from another device")
+	ErrPasswordlessAttempts    = errors.New("too many passwordless code attempts")
+	ErrPasswordlessRateLimited = errors.New("too many passwordless requests for the address")
+)
+
+// PasswordlessKind is the delivery of a passwordless challenge.
+type PasswordlessKind string
+
+const (
+	PASSWORDLESS_MAGICLINK PasswordlessKind = "magic-link"
+	PASSWORDLESS_EMAILOTP  PasswordlessKind = "email-otp"
+)
+
+const (
+	PASSWORDLESS_LINK_TTL_DEFAULT     = 15 * time.Minute
+	PASSWORDLESS_CODE_TTL_DEFAULT     = 10 * time.Minute
+	PASSWORDLESS_CODE_DIGITS          = 6
+	PASSWORDLESS_MAX_ATTEMPTS_DEFAULT = 5 // Wrong codes before an email OTP is locked.
+	PASSWORDLESS_RATE_LIMIT_DEFAULT   = 5 // Challenges per address per rate window.
+	PASSWORDLESS_RATE_WINDOW_DEFAULT  = time.Hour
+	PASSWORDLESS_SECRET_MIN_LENGTH    = 32
+)
+
+// PASSWORDLESS_PARAM_TOKEN is the query parameter of the magic link holding the token.
+const PASSWORDLESS_PARAM_TOKEN = "token"
+
+// PasswordlessChallenge is a pending magic link or email OTP. Only keyed
+// hashes of the token, code and device are stored.
+type PasswordlessChallenge struct {
+	Id         string              `json:"id"`
+	Kind       PasswordlessKind    `json:"kind"`
+	Email      aemail.EmailAddress `json:"email"`
+	Hash       string              `json:"hash"`
+	DeviceHash string              `json:"deviceHash"`
+	Created    time.Time           `json:"created"`
+	Expires    time.Time           `json:"expires"`
+	Attempts   int                 `json:"attempts,omitempty"`
+	Consumed   *time.Time          `json:"consumed,omitempty"`
+}
+
+// IsConsumed returns true if the challenge has been used.
+func (ch *PasswordlessChallenge) IsConsumed() bool {
+	return ch != nil && ch.Consumed != nil
+}
+
+// IPasswordlessStore persists passwordless challenges.
+type IPasswordlessStore interface {
+	// InsertPasswordlessChallenge inserts ch unless limit or more challenges
+	// were created for its email since since, and returns false if so. The
+	// count and insert must be atomic so parallel requests cannot exceed the
+	// rate limit.
+	InsertPasswordlessChallenge(ch *PasswordlessChallenge, since time.Time, limit int) (bool, error)
+	// FindPasswordlessChallenge returns the challenge with id or nil.
+	FindPasswordlessChallenge(id string) (*PasswordlessChallenge, error)
+	// ConsumePasswordlessChallenge marks the challenge used at at and returns
+	// false if it already was. It must be atomic so a token works only once.
+	ConsumePasswordlessChallenge(id string, at time.Time) (bool, error)
+	// IncrementPasswordlessAttempts adds one to the attempts of the challenge
+	// and returns the new count. It must be atomic so parallel guesses cannot
+	// exceed the attempt limit.
+	IncrementPasswordlessAttempts(id string) (int, error)
+}
+
+// IPasswordlessMailer delivers passwordless challenges. Link is empty for an
+// email OTP and code is empty for a magic link.
+type IPasswordlessMailer interface {
+	SendPasswordless(email string, link string, code string, expires time.Time) error
+}
+
+// Passwordless issues and verifies magic links and email OTPs. Each
+// challenge is bound to the device (eg a random cookie) of the browser that
+// requested it and can only be finished from that device.
+type Passwordless struct {
+	// Secret keys the token signatures and the stored hashes.
+	Secret []byte
+
+	// LinkUrl is the page that finishes a magic link; the token is added as PASSWORDLESS_PARAM_TOKEN.
+	LinkUrl string
+
+	Store  IPasswordlessStore
+	Mailer IPasswordlessMailer
+
+	// Zero values use the defaults.
+	LinkTTL     time.Duration
+	CodeTTL     time.Duration
+	MaxAttempts int
+	RateLimit   int
+	RateWindow  time.Duration
+
+	fnNow func() time.Time
+}
+
+// NewPasswordless creates a Passwordless with the defaults.
+func NewPasswordless(secret []byte, linkUrl string, store IPasswordlessStore, mailer IPasswordlessMailer) (*Passwordless, error) {
+	p := &Passwordless{Secret: secret, LinkUrl: linkUrl, Store: store, Mailer: mailer}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the configuration and applies defaults.
+func (p *Passwordless) Validate() error {
+	if p == nil {
+		return fmt.Errorf("passwordless is nil")
+	}
+	if len(p.Secret) < PASSWORDLESS_SECRET_MIN_LENGTH {
+		return fmt.Errorf("passwordless secret must be at least %d bytes", PASSWORDLESS_SECRET_MIN_LENGTH)
+	}
+	if p.Store == nil || p.Mailer == nil {
+		return fmt.Errorf("passwordless store and mailer are required")
+	}
+	p.LinkUrl = strings.TrimSpace(p.LinkUrl)
+	if u, err := url.Parse(p.LinkUrl); err != nil || p.LinkUrl == "" || !u.IsAbs() {
+		return fmt.Errorf("passwordless link url must be absolute")
+	}
+	if p.LinkTTL <= 0 {
+		p.LinkTTL = PASSWORDLESS_LINK_TTL_DEFAULT
+	}
+	if p.CodeTTL <= 0 {
+		p.CodeTTL = PASSWORDLESS_CODE_TTL_DEFAULT
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = PASSWORDLESS_MAX_ATTEMPTS_DEFAULT
+	}
+	if p.RateLimit <= 0 {
+		p.RateLimit = PASSWORDLESS_RATE_LIMIT_DEFAULT
+	}
+	if p.RateWindow <= 0 {
+		p.RateWindow = PASSWORDLESS_RATE_WINDOW_DEFAULT
+	}
+	return nil
+}
+
+func (p *Passwordless) now() time.Time {
+	if p.fnNow != nil {
+		return p.fnNow()
+	}
+	return time.Now().UTC()
+}
+
+// mac returns the keyed hash of the parts.
+func (p *Passwordless) mac(parts ...string) string {
+	m := hmac.New(sha256.New, p.Secret)
+	m.Write([]byte(strings.Join(parts, "|")))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// BeginMagicLink emails a magic link to email and returns the challenge id.
+func (p *Passwordless) BeginMagicLink(email aemail.EmailAddress, device string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate magic link; %v", err)
+	}
+	secretB64 := base64.RawURLEncoding.EncodeToString(secret)
+	ch, err := p.begin(PASSWORDLESS_MAGICLINK, email, device, p.LinkTTL, func(id string) string {
+		return p.mac(string(PASSWORDLESS_MAGICLINK), id, secretB64)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := ch.Id + "." + secretB64 + "." + strconv.FormatInt(ch.Expires.Unix(), 10)
+	token := payload + "." + p.mac("token", payload)
+	link, _ := url.Parse(p.LinkUrl)
+	q := link.Query()
+	q.Set(PASSWORDLESS_PARAM_TOKEN, token)
+	link.RawQuery = q.Encode()
+
+	if err = p.Mailer.SendPasswordless(ch.Email.String(), link.String(), "", ch.Expires); err != nil {
+		return "", fmt.Errorf("failed to send magic link; %v", err)
+	}
+	return ch.Id, nil
+}
+
+// BeginEmailOTP emails a PASSWORDLESS_CODE_DIGITS code to email and returns
+// the challenge id, which the browser sends back with the code.
+func (p *Passwordless) BeginEmailOTP(email aemail.EmailAddress, device string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate email code; %v", err)
+	}
+	code := fmt.Sprintf("%0*d", PASSWORDLESS_CODE_DIGITS, n.Int64())
+	ch, err := p.begin(PASSWORDLESS_EMAILOTP, email, device, p.CodeTTL, func(id string) string {
+		return p.mac(string(PASSWORDLESS_EMAILOTP), id, code)
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = p.Mailer.SendPasswordless(ch.Email.String(), "", code, ch.Expires); err != nil {
+		return "", fmt.Errorf("failed to send email code; %v", err)
+	}
+	return ch.Id, nil
+}
+
+func (p *Passwordless) begin(kind PasswordlessKind, email aemail.EmailAddress, device string, ttl time.Duration, fnHash func(id string) string) (*PasswordlessChallenge, error) {
+	email = aemail.EmailAddress(email.ToStringTrimLower())
+	if err := email.Validate(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(device) == "" {
+		return nil, fmt.Errorf("passwordless device is required")
+	}
+	now := p.now()
+	ch := &PasswordlessChallenge{
+		Id:      auuids.NewUUID().String(),
+		Kind:    kind,
+		Email:   email,
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	ch.Hash = fnHash(ch.Id)
+	ch.DeviceHash = p.mac("device", device)
+	ok, err := p.Store.InsertPasswordlessChallenge(ch, now.Add(-p.RateWindow), p.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save passwordless challenge; %v", err)
+	}
+	if !ok {
+		return nil, ErrPasswordlessRateLimited
+	}
+	return ch, nil
+}
+
+// FinishMagicLink verifies token from the magic link, opened on device, and
+// returns the email it was sent to. The token cannot be used again.
+func (p *Passwordless) FinishMagicLink(token string, device string) (aemail.EmailAddress, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 4 {
+		return "", ErrPasswordlessInvalid
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.mac("token", payload))) {
+		return "", ErrPasswordlessInvalid
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", ErrPasswordlessInvalid
+	}
+	now := p.now()
+	if !now.Before(time.Unix(expires, 0)) {
+		return "", ErrPasswordlessExpired
+	}
+
+	ch, err := p.find(parts[0], PASSWORDLESS_MAGICLINK, now)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(ch.Hash), []byte(p.mac(string(PASSWORDLESS_MAGICLINK), ch.Id, parts[1]))) {
+		return "", ErrPasswordlessInvalid
+	}
+	if !hmac.Equal([]byte(ch.DeviceHash), []byte(p.mac("device", device))) {
+		return "", ErrPasswordlessDevice
+	}
+	return p.consume(ch, now)
+}
+
+// FinishEmailOTP verifies code for the challenge id, entered on device, and
+// returns the email it was sent to. Every code entered counts towards
+// MaxAttempts; the count is taken before the code is compared.
+func (p *Passwordless) FinishEmailOTP(id string, code string, device string) (aemail.EmailAddress, error) {
+	now := p.now()
+	ch, err := p.find(strings.TrimSpace(id), PASSWORDLESS_EMAILOTP, now)
+	if err != nil {
+		return "", err
+	}
+	if ch.Attempts >= p.MaxAttempts {
+		return "", ErrPasswordlessAttempts
+	}
+	if !hmac.Equal([]byte(ch.DeviceHash), []byte(p.mac("device", device))) {
+		return "", ErrPasswordlessDevice
+	}
+	attempts, err := p.Store.IncrementPasswordlessAttempts(ch.Id)
+	if err != nil {
+		return "", fmt.Errorf("failed to count passwordless attempt; %v", err)
+	}
+	if attempts > p.MaxAttempts {
+		return "", ErrPasswordlessAttempts
+	}
+	if !hmac.Equal([]byte(ch.Hash), []byte(p.mac(string(PASSWORDLESS_EMAILOTP), ch.Id, strings.TrimSpace(code)))) {
+		return "", ErrPasswordlessInvalid
+	}
+	return p.consume(ch, now)
+}
+
+func (p *Passwordless) find(id string, kind PasswordlessKind, now time.Time) (*PasswordlessChallenge, error) {
+	if id == "" {
+		return nil, ErrPasswordlessInvalid
+	}
+	ch, err := p.Store.FindPasswordlessChallenge(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find passwordless challenge; %v", err)
+	}
+	if ch == nil || ch.Kind != kind {
+		return nil, ErrPasswordlessInvalid
+	}
+	if ch.IsConsumed() {
+		return nil, ErrPasswordlessUsed
+	}
+	if !now.Before(ch.Expires) {
+		return nil, ErrPasswordlessExpired
+	}
+	return ch, nil
+}
+
+func (p *Passwordless) consume(ch *PasswordlessChallenge, now time.Time) (aemail.EmailAddress, error) {
+	ok, err := p.Store.ConsumePasswordlessChallenge(ch.Id, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to consume passwordless challenge; %v", err)
+	}
+	if !ok {
+		return "", ErrPasswordlessUsed
+	}
+	return ch.Email, nil
+}
+
+// PasswordlessMemoryStore is an in-memory IPasswordlessStore for a single
+// instance or tests. Challenges are pruned once they have expired and are
+// older than the rate window passed to InsertPasswordlessChallenge.
+type PasswordlessMemoryStore struct {
+	challenges map[string]*PasswordlessChallenge
+	mu         sync.Mutex
+}
+
+// NewPasswordlessMemoryStore creates an empty store.
+func NewPasswordlessMemoryStore() *PasswordlessMemoryStore {
+	return &PasswordlessMemoryStore{challenges: map[string]*PasswordlessChallenge{}}
+}
+
+// InsertPasswordlessChallenge stores a copy of ch unless the email reached
+// limit challenges since since.
+func (s *PasswordlessMemoryStore) InsertPasswordlessChallenge(ch *PasswordlessChallenge, since time.Time, limit int) (bool, error) {
+	if ch == nil || ch.Id == "" {
+		return false, fmt.Errorf("passwordless challenge is nil or has no id")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for id, old := range s.challenges {
+		if old.Expires.Before(since) {
+			delete(s.challenges, id)
+			continue
+		}
+		if old.Email.Matches(ch.Email) && !old.Created.Before(since) {
+			count++
+		}
+	}
+	if limit > 0 && count >= limit {
+		return false, nil
+	}
+	cp := *ch
+	s.challenges[ch.Id] = &cp
+	return true, nil
+}
+
+// FindPasswordlessChallenge returns a copy of the challenge or nil.
+func (s *PasswordlessMemoryStore) FindPasswordlessChallenge(id string) (*PasswordlessChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.challenges[id]
+	if !ok {
+		return nil, nil
+	}
+	cp := *ch
+	return &cp, nil
+}
+
+// ConsumePasswordlessChallenge marks the challenge used if it was not already.
+func (s *PasswordlessMemoryStore) ConsumePasswordlessChallenge(id string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.challenges[id]
+	if !ok || ch.IsConsumed() {
+		return false, nil
+	}
+	ch.Consumed = &at
+	return true, nil
+}
+
+// IncrementPasswordlessAttempts adds one to the attempts of the challenge.
+func (s *PasswordlessMemoryStore) IncrementPasswordlessAttempts(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.challenges[id]
+	if !ok {
+		return 0, fmt.Errorf("passwordless challenge '%s' not found", id)
+	}
+	ch.Attempts++
+	return ch.Attempts, nil
+}
@@ -0,0 +1,275 @@
+package anode
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aemail"
+)
+
+// passwordlessTestMailer records the last message.
+type passwordlessTestMailer struct {
+	email string
+	link  string
+	code  string
+	mu    sync.Mutex
+}
+
+func (m *passwordlessTestMailer) SendPasswordless(email string, link string, code string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.email, m.link, m.code = email, link, code
+	return nil
+}
+
+func (m *passwordlessTestMailer) token(t *testing.T) string {
+	u, err := url.Parse(m.link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get(PASSWORDLESS_PARAM_TOKEN)
+}
+
+func newPasswordlessTest(t *testing.T) (*Passwordless, *passwordlessTestMailer, *time.Time) {
+	mailer := &passwordlessTestMailer{}
+	p, err := NewPasswordless([]byte(strings.Repeat("k", 32)), "https://example.com/login/link", NewPasswordlessMemoryStore(), mailer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.fnNow = func() time.Time { return now }
+	return p, mailer, &now
+}
+
+func TestNewPasswordless_Validate(t *testing.T) {
+	store, mailer := NewPasswordlessMemoryStore(), &passwordlessTestMailer{}
+	if _, err := NewPasswordless([]byte("short"), "https://example.com/l", store, mailer); err == nil {
+		t.Error("expected error for a short secret")
+	}
+	if _, err := NewPasswordless([]byte(strings.Repeat("k", 32)), "/l", store, mailer); err == nil {
+		t.Error("expected error for a relative link url")
+	}
+	if _, err := NewPasswordless([]byte(strings.Repeat("k", 32)), "https://example.com/l", nil, mailer); err == nil {
+		t.Error("expected error for a nil store")
+	}
+}
+
+func TestPasswordless_MagicLink(t *testing.T) {
+	p, mailer, now := newPasswordlessTest(t)
+
+	if _, err := p.BeginMagicLink("User@Example.com", "device-1"); err != nil {
+		t.Fatal(err)
+	}
+	if mailer.email != "user@example.com" || mailer.code != "" || !strings.HasPrefix(mailer.link, "https://example.com/login/link?token=") {
+		t.Fatalf("unexpected mail %+v", mailer)
+	}
+	token := mailer.token(t)
+
+	if _, err := p.FinishMagicLink(token, "device-2"); !errors.Is(err, ErrPasswordlessDevice) {
+		t.Errorf("expected ErrPasswordlessDevice, got %v", err)
+	}
+	if _, err := p.FinishMagicLink(token[:len(token)-2]+"xx", "device-1"); !errors.Is(err, ErrPasswordlessInvalid) {
+		t.Errorf("expected ErrPasswordlessInvalid for a bad signature, got %v", err)
+	}
+	email, err := p.FinishMagicLink(token, "device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "user@example.com" {
+		t.Errorf("expected user@example.com, got %s", email)
+	}
+	if _, err = p.FinishMagicLink(token, "device-1"); !errors.Is(err, ErrPasswordlessUsed) {
+		t.Errorf("expected ErrPasswordlessUsed, got %v", err)
+	}
+
+	// Expired links fail.
+	if _, err = p.BeginMagicLink("user@example.com", "device-1"); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(PASSWORDLESS_LINK_TTL_DEFAULT)
+	if _, err = p.FinishMagicLink(mailer.token(t), "device-1"); !errors.Is(err, ErrPasswordlessExpired) {
+		t.Errorf("expected ErrPasswordlessExpired, got %v", err)
+	}
+}
+
+func TestPasswordless_MagicLinkHashedStorage(t *testing.T) {
+	p, mailer, _ := newPasswordlessTest(t)
+	id, err := p.BeginMagicLink("user@example.com", "device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := p.Store.FindPasswordlessChallenge(id)
+	secret := strings.Split(mailer.token(t), ".")[1]
+	if ch == nil || strings.Contains(ch.Hash, secret) || ch.DeviceHash == "device-1" {
+		t.Errorf("expected only hashes to be stored, got %+v", ch)
+	}
+}
+
+func TestPasswordless_EmailOTP(t *testing.T) {
+	p, mailer, _ := newPasswordlessTest(t)
+
+	id, err := p.BeginEmailOTP("user@example.com", "device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.code) != PASSWORDLESS_CODE_DIGITS || mailer.link != "" {
+		t.Fatalf("unexpected mail %+v", mailer)
+	}
+	wrong := "000000"
+	if mailer.code == wrong {
+		wrong = "111111"
+	}
+	if _, err = p.FinishEmailOTP(id, wrong, "device-1"); !errors.Is(err, ErrPasswordlessInvalid) {
+		t.Errorf("expected ErrPasswordlessInvalid, got %v", err)
+	}
+	if _, err = p.FinishEmailOTP(id, mailer.code, "device-2"); !errors.Is(err, ErrPasswordlessDevice) {
+		t.Errorf("expected ErrPasswordlessDevice, got %v", err)
+	}
+	email, err := p.FinishEmailOTP(id, mailer.code, "device-1")
+	if err != nil || email != "user@example.com" {
+		t.Fatalf("expected user@example.com, got %s, %v", email, err)
+	}
+	if _, err = p.FinishEmailOTP(id, mailer.code, "device-1"); !errors.Is(err, ErrPasswordlessUsed) {
+		t.Errorf("expected ErrPasswordlessUsed, got %v", err)
+	}
+
+	// The code locks after MaxAttempts wrong guesses, even if the next one is right.
+	if id, err = p.BeginEmailOTP("user@example.com", "device-1"); err != nil {
+		t.Fatal(err)
+	}
+	wrong = "000000"
+	if mailer.code == wrong {
+		wrong = "111111"
+	}
+	for ii := 0; ii < PASSWORDLESS_MAX_ATTEMPTS_DEFAULT; ii++ {
+		_, _ = p.FinishEmailOTP(id, wrong, "device-1")
+	}
+	if _, err = p.FinishEmailOTP(id, mailer.code, "device-1"); !errors.Is(err, ErrPasswordlessAttempts) {
+		t.Errorf("expected ErrPasswordlessAttempts, got %v", err)
+	}
+}
+
+// passwordlessSlowStore widens the window between reading a challenge and
+// counting the attempt, as a networked store would.
+type passwordlessSlowStore struct {
+	*PasswordlessMemoryStore
+}
+
+func (s passwordlessSlowStore) FindPasswordlessChallenge(id string) (*PasswordlessChallenge, error) {
+	ch, err := s.PasswordlessMemoryStore.FindPasswordlessChallenge(id)
+	time.Sleep(5 * time.Millisecond)
+	return ch, err
+}
+
+func TestPasswordless_EmailOTPConcurrentAttempts(t *testing.T) {
+	p, mailer, _ := newPasswordlessTest(t)
+	p.Store = passwordlessSlowStore{NewPasswordlessMemoryStore()}
+
+	id, err := p.BeginEmailOTP("user@example.com", "device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := mailer.code
+
+	// Parallel guesses all read the same stale challenge; only MaxAttempts
+	// of them may be compared against the code.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		compared int
+	)
+	for ii := 0; ii < 100; ii++ {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			guess := fmt.Sprintf("%06d", ii)
+			if guess == code {
+				guess = "999999"
+			}
+			if _, err := p.FinishEmailOTP(id, guess, "device-1"); errors.Is(err, ErrPasswordlessInvalid) {
+				mu.Lock()
+				compared++
+				mu.Unlock()
+			}
+		}(ii)
+	}
+	wg.Wait()
+	if compared > p.MaxAttempts {
+		t.Errorf("expected at most %d compared guesses, got %d", p.MaxAttempts, compared)
+	}
+	if _, err = p.FinishEmailOTP(id, code, "device-1"); !errors.Is(err, ErrPasswordlessAttempts) {
+		t.Errorf("expected ErrPasswordlessAttempts, got %v", err)
+	}
+}
+
+func TestPasswordless_RateLimit(t *testing.T) {
+	p, _, now := newPasswordlessTest(t)
+	p.RateLimit = 2
+
+	for ii := 0; ii < 2; ii++ {
+		if _, err := p.BeginEmailOTP("user@example.com", "device-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.BeginMagicLink("USER@example.com", "device-1"); !errors.Is(err, ErrPasswordlessRateLimited) {
+		t.Errorf("expected ErrPasswordlessRateLimited, got %v", err)
+	}
+	if _, err := p.BeginMagicLink(aemail.EmailAddress("other@example.com"), "device-1"); err != nil {
+		t.Errorf("expected other addresses to be allowed, got %v", err)
+	}
+	*now = now.Add(PASSWORDLESS_RATE_WINDOW_DEFAULT + time.Second)
+	if _, err := p.BeginMagicLink("user@example.com", "device-1"); err != nil {
+		t.Errorf("expected the limit to reset after the window, got %v", err)
+	}
+}
+
+func TestPasswordless_RateLimitConfiguredWindow(t *testing.T) {
+	p, _, now := newPasswordlessTest(t)
+	p.RateLimit, p.RateWindow = 2, 3*time.Hour
+
+	for ii := 0; ii < 2; ii++ {
+		if _, err := p.BeginEmailOTP("user@example.com", "device-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The challenges expired long ago but are still inside the configured window.
+	*now = now.Add(2 * time.Hour)
+	if _, err := p.BeginEmailOTP("user@example.com", "device-1"); !errors.Is(err, ErrPasswordlessRateLimited) {
+		t.Errorf("expected ErrPasswordlessRateLimited, got %v", err)
+	}
+	*now = now.Add(time.Hour + time.Second)
+	if _, err := p.BeginEmailOTP("user@example.com", "device-1"); err != nil {
+		t.Errorf("expected the limit to reset after the window, got %v", err)
+	}
+}
+
+func TestPasswordless_RateLimitConcurrent(t *testing.T) {
+	p, _, _ := newPasswordlessTest(t)
+	p.RateLimit = 3
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for ii := 0; ii < 50; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.BeginEmailOTP("user@example.com", "device-1"); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if created != p.RateLimit {
+		t.Errorf("expected %d challenges, got %d", p.RateLimit, created)
+	}
+}
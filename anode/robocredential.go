@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/jpfluger/alibs-slim/atime"
 	"sync"
 	"time"
 )
@@ -18,9 +19,42 @@ type RoboCredential struct {
 	TokenExpiresAt   time.Time             `json:"-"`                      // Expiration time for the access token
 	RefreshExpiresAt time.Time             `json:"-"`                      // Expiration time for the refresh token
 	IsTokenValid     bool                  `json:"-"`                      // Tracks token validity
+	KeyId            string                `json:"keyId,omitempty"`        // Thumbprint of the public key; the keyid of HTTP signatures
+	KeyCreatedAt     *time.Time            `json:"keyCreatedAt,omitempty"` // When the current key pair was generated
+	RetiredKeys      RoboPublicKeys        `json:"retiredKeys,omitempty"`  // Rotated-out public keys still accepted by verifiers
 	mu               sync.RWMutex          // Thread-safe access
 }
 
+// RoboPublicKey is a rotated-out public key that verifiers accept until RetireAt.
+type RoboPublicKey struct {
+	KeyId     string                `json:"keyId"`
+	PublicKey acrypt.CryptKeyBase64 `json:"publicKey"`
+	RetireAt  time.Time             `json:"retireAt"`
+}
+
+// RoboPublicKeys is a list of rotated-out public keys.
+type RoboPublicKeys []*RoboPublicKey
+
+// Active returns the keys that have not retired at now.
+func (keys RoboPublicKeys) Active(now time.Time) RoboPublicKeys {
+	var active RoboPublicKeys
+	for _, key := range keys {
+		if key != nil && now.Before(key.RetireAt) {
+			active = append(active, key)
+		}
+	}
+	return active
+}
+
+// roboKeyId returns the RFC 7638 thumbprint of pub.
+func roboKeyId(pub ed25519.PublicKey) (string, error) {
+	jwk, err := acrypt.NewJWK(pub, acrypt.JWTALG_EDDSA, "")
+	if err != nil {
+		return "", err
+	}
+	return jwk.Kid, nil
+}
+
 // Validate ensures that all required fields are present and valid.
 // Required fields are those needed for two peers to make a connection.
 // The PrivateKey check only ensures a value is present and doesn't try
@@ -55,11 +89,18 @@ func (rc *RoboCredential) GenerateKeyPair(masterPassword string, durationMinutes
 		return fmt.Errorf("failed to generate key pair: %v", err)
 	}
 
+	keyId, err := roboKeyId(pubKey)
+	if err != nil {
+		return fmt.Errorf("failed to create key id: %v", err)
+	}
+
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
 	// Store the public key as base64
 	rc.PublicKey = acrypt.NewCryptKeyBase64(pubKey)
+	rc.KeyId = keyId
+	rc.KeyCreatedAt = atime.GetNowUTCPointer()
 
 	// Set a default duration if the input is invalid
 	if durationMinutes < 0 {
@@ -99,7 +140,15 @@ func (rc *RoboCredential) GetDecodedPrivateKey(masterPassword string) ([]byte, e
 	return rc.PrivateKey.GetDecoded(), nil
 }
 
+// RotateKeys replaces the key pair. The old public key stops verifying at once.
 func (rc *RoboCredential) RotateKeys(masterPassword string) error {
+	return rc.RotateKeysWithOverlap(masterPassword, 0)
+}
+
+// RotateKeysWithOverlap replaces the key pair and keeps the old public key
+// in RetiredKeys for overlap, so verifiers accept requests signed before
+// the rotation. Retired keys past their window are dropped.
+func (rc *RoboCredential) RotateKeysWithOverlap(masterPassword string, overlap time.Duration) error {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
@@ -108,6 +157,27 @@ func (rc *RoboCredential) RotateKeys(masterPassword string) error {
 	if err != nil {
 		return fmt.Errorf("failed to rotate keys: %v", err)
 	}
+	keyId, err := roboKeyId(pubKey)
+	if err != nil {
+		return fmt.Errorf("failed to create key id: %v", err)
+	}
+
+	now := time.Now().UTC()
+	rc.RetiredKeys = rc.RetiredKeys.Active(now)
+	if overlap > 0 && !rc.PublicKey.IsEmpty() {
+		// Credentials created before key ids sign with the computed id.
+		oldKeyId := rc.KeyId
+		if oldKeyId == "" {
+			if oldPub, err := rc.PublicKey.Decoded(); err == nil && len(oldPub) == ed25519.PublicKeySize {
+				oldKeyId, _ = roboKeyId(oldPub)
+			}
+		}
+		if oldKeyId != "" {
+			rc.RetiredKeys = append(rc.RetiredKeys, &RoboPublicKey{KeyId: oldKeyId, PublicKey: rc.PublicKey, RetireAt: now.Add(overlap)})
+		}
+	}
+	rc.KeyId = keyId
+	rc.KeyCreatedAt = &now
 
 	// Store new public key as base64
 	rc.PublicKey = acrypt.NewCryptKeyBase64(pubKey)
@@ -172,3 +242,102 @@ func (rc *RoboCredential) RefreshAccessToken(refreshFunc func(string) (string, t
 	rc.IsTokenValid = true
 	return nil
 }
+
+// clone returns a copy of rc that can be changed without touching rc.
+func (rc *RoboCredential) clone() *RoboCredential {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	c := &RoboCredential{
+		PublicKey:        rc.PublicKey,
+		AccessToken:      rc.AccessToken,
+		RefreshToken:     rc.RefreshToken,
+		TokenExpiresAt:   rc.TokenExpiresAt,
+		RefreshExpiresAt: rc.RefreshExpiresAt,
+		IsTokenValid:     rc.IsTokenValid,
+		KeyId:            rc.KeyId,
+		KeyCreatedAt:     rc.KeyCreatedAt,
+		RetiredKeys:      append(RoboPublicKeys(nil), rc.RetiredKeys...),
+	}
+	copyRoboSecretsValue(&c.PrivateKey, &rc.PrivateKey)
+	return c
+}
+
+// copyRoboSecretsValue copies the stored fields of src to dst. The decoded
+// cache is not copied.
+func copyRoboSecretsValue(dst *acrypt.SecretsValue, src *acrypt.SecretsValue) {
+	dst.Value = src.Value
+	dst.ExpiresAt = src.ExpiresAt
+	dst.OldValue = src.OldValue
+	dst.OldValueExpiresAt = src.OldValueExpiresAt
+	dst.MaxDuration = src.MaxDuration
+}
+
+// set replaces the fields of rc with those of from.
+func (rc *RoboCredential) set(from *RoboCredential) {
+	from = from.clone()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.PublicKey = from.PublicKey
+	rc.PrivateKey = acrypt.SecretsValue{}
+	copyRoboSecretsValue(&rc.PrivateKey, &from.PrivateKey)
+	rc.AccessToken = from.AccessToken
+	rc.RefreshToken = from.RefreshToken
+	rc.TokenExpiresAt = from.TokenExpiresAt
+	rc.RefreshExpiresAt = from.RefreshExpiresAt
+	rc.IsTokenValid = from.IsTokenValid
+	rc.KeyId = from.KeyId
+	rc.KeyCreatedAt = from.KeyCreatedAt
+	rc.RetiredKeys = from.RetiredKeys
+}
+
+// JWKS returns the public keys verifiers should accept: the current key,
+// then retired keys still in their overlap window.
+func (rc *RoboCredential) JWKS() *acrypt.JWKSet {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	set := &acrypt.JWKSet{Keys: []*acrypt.JWK{}}
+	if pub, err := rc.PublicKey.Decoded(); err == nil && len(pub) == ed25519.PublicKeySize {
+		if jwk, err := acrypt.NewJWK(ed25519.PublicKey(pub), acrypt.JWTALG_EDDSA, rc.KeyId); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	for _, key := range rc.RetiredKeys.Active(time.Now().UTC()) {
+		if pub, err := key.PublicKey.Decoded(); err == nil && len(pub) == ed25519.PublicKeySize {
+			if jwk, err := acrypt.NewJWK(ed25519.PublicKey(pub), acrypt.JWTALG_EDDSA, key.KeyId); err == nil {
+				set.Keys = append(set.Keys, jwk)
+			}
+		}
+	}
+	return set
+}
+
+// HTTPSignatureKey returns the current or an unretired public key by keyid.
+func (rc *RoboCredential) HTTPSignatureKey(keyId string) (ed25519.PublicKey, error) {
+	return rc.JWKS().HTTPSignatureKey(keyId)
+}
+
+// NewHTTPSignatureSigner creates a signer with the current private key.
+// Create a new signer after the keys rotate.
+func (rc *RoboCredential) NewHTTPSignatureSigner(masterPassword string) (*acrypt.HTTPSignatureSigner, error) {
+	privKey, err := rc.GetDecodedPrivateKey(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	if len(privKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key is not ed25519")
+	}
+
+	rc.mu.RLock()
+	keyId := rc.KeyId
+	rc.mu.RUnlock()
+	if keyId == "" {
+		if keyId, err = roboKeyId(ed25519.PrivateKey(privKey).Public().(ed25519.PublicKey)); err != nil {
+			return nil, fmt.Errorf("failed to create key id: %v", err)
+		}
+	}
+	return acrypt.NewHTTPSignatureSigner(keyId, privKey)
+}
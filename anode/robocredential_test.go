@@ -10,8 +10,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/labstack/echo/v4"
+	"github.com/jpfluger/alibs-slim/acron"
 	"github.com/jpfluger/alibs-slim/acrypt"
+	"github.com/labstack/echo/v4"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -703,3 +704,150 @@ func TestRoboCredential_HybridAuthentication(t *testing.T) {
 	assert.Equal(t, "Access granted", secureResponse["message"])
 	assert.Equal(t, "Peer1", secureResponse["sender"])
 }
+
+func TestRoboCredential_RotateKeysWithOverlap(t *testing.T) {
+	rc := &RoboCredential{}
+	masterPassword := "secure-password"
+	assert.NoError(t, rc.GenerateKeyPair(masterPassword, 0))
+	assert.NotEmpty(t, rc.KeyId)
+
+	signer, err := rc.NewHTTPSignatureSigner(masterPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, rc.KeyId, signer.KeyId)
+	req := httptest.NewRequest(http.MethodPost, "https://svc.example.com/jobs", strings.NewReader(`{"id":1}`))
+	assert.NoError(t, signer.Sign(req))
+
+	oldKeyId := rc.KeyId
+	assert.NoError(t, rc.RotateKeysWithOverlap(masterPassword, time.Hour))
+	assert.NotEqual(t, oldKeyId, rc.KeyId)
+	assert.Len(t, rc.RetiredKeys, 1)
+	assert.Len(t, rc.JWKS().Keys, 2)
+	assert.Equal(t, rc.KeyId, rc.JWKS().Keys[0].Kid, "current key first")
+
+	// Requests signed with the old key verify during the overlap.
+	keyId, err := acrypt.NewHTTPSignatureVerifier(rc).Verify(req)
+	assert.NoError(t, err)
+	assert.Equal(t, oldKeyId, keyId)
+
+	// Once retired, the old key is no longer published.
+	rc.RetiredKeys[0].RetireAt = time.Now().Add(-time.Second)
+	assert.Len(t, rc.JWKS().Keys, 1)
+	_, err = rc.HTTPSignatureKey(oldKeyId)
+	assert.ErrorIs(t, err, acrypt.ErrHTTPSignatureKeyNotFound)
+
+	// Without overlap the old key is dropped at once.
+	assert.NoError(t, rc.RotateKeys(masterPassword))
+	assert.Empty(t, rc.RetiredKeys)
+
+	// A credential saved before key ids keeps its overlap under the computed id.
+	rc.KeyId = ""
+	signer, err = rc.NewHTTPSignatureSigner(masterPassword)
+	assert.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "https://svc.example.com/jobs", strings.NewReader(`{"id":2}`))
+	assert.NoError(t, signer.Sign(req))
+	assert.NoError(t, rc.RotateKeysWithOverlap(masterPassword, time.Hour))
+	if assert.Len(t, rc.RetiredKeys, 1) {
+		assert.Equal(t, signer.KeyId, rc.RetiredKeys[0].KeyId)
+	}
+	keyId, err = acrypt.NewHTTPSignatureVerifier(rc).Verify(req)
+	assert.NoError(t, err)
+	assert.Equal(t, signer.KeyId, keyId)
+}
+
+type roboKeyRotationTestStore struct {
+	credentials []*RoboCredential
+	published   map[*RoboCredential]*acrypt.JWKSet
+	failPublish bool
+	failSave    bool
+	saved       int
+}
+
+func (s *roboKeyRotationTestStore) FindRoboKeyRotationDue(createdBefore time.Time) ([]*RoboCredential, error) {
+	var due []*RoboCredential
+	for _, rc := range s.credentials {
+		if rc.KeyCreatedAt == nil || !rc.KeyCreatedAt.After(createdBefore) {
+			due = append(due, rc)
+		}
+	}
+	return due, nil
+}
+
+func (s *roboKeyRotationTestStore) GetRoboMasterPassword(rc *RoboCredential) (string, error) {
+	return "secure-password", nil
+}
+
+func (s *roboKeyRotationTestStore) PublishRoboPublicKeys(rc *RoboCredential, jwks *acrypt.JWKSet) error {
+	if s.failPublish {
+		return fmt.Errorf("publish failed")
+	}
+	s.published[rc] = jwks
+	return nil
+}
+
+func (s *roboKeyRotationTestStore) SaveRoboCredential(rc *RoboCredential) error {
+	if s.failSave {
+		return fmt.Errorf("save failed")
+	}
+	s.saved++
+	return nil
+}
+
+func TestTaskRoboKeyRotation(t *testing.T) {
+	store := &roboKeyRotationTestStore{published: map[*RoboCredential]*acrypt.JWKSet{}}
+	for ii := 0; ii < 2; ii++ {
+		rc := &RoboCredential{}
+		assert.NoError(t, rc.GenerateKeyPair("secure-password", 0))
+		store.credentials = append(store.credentials, rc)
+	}
+	past := time.Now().UTC().Add(-48 * time.Hour)
+	store.credentials[0].KeyCreatedAt = &past
+	oldKeyId := store.credentials[0].KeyId
+	SetRoboKeyRotationStore(store)
+	defer SetRoboKeyRotationStore(nil)
+
+	// The task loads from job plan JSON through the acron type manager.
+	jp := &acron.JobPlan{}
+	assert.NoError(t, jp.UnmarshalJSONTask(json.RawMessage(`{"type":"robo-key-rotation","rotateHours":24,"overlapHours":2}`)))
+	task := jp.GetTask()
+	assert.NoError(t, task.Validate())
+	ccc := &acron.CronControlCenter{}
+	ccc.SetJRun(acron.NewJRun())
+	assert.NoError(t, task.Run(ccc))
+
+	rotated := store.credentials[0]
+	assert.Equal(t, 1, store.saved)
+	assert.NotEqual(t, oldKeyId, rotated.KeyId)
+	privKey, err := rotated.GetDecodedPrivateKey("secure-password")
+	assert.NoError(t, err)
+	assert.Equal(t, rotated.PublicKey.MustDecode(), []byte(ed25519.PrivateKey(privKey).Public().(ed25519.PublicKey)), "the new private key is swapped in")
+	jwks := store.published[rotated]
+	if assert.NotNil(t, jwks) {
+		assert.NotNil(t, jwks.Find(rotated.KeyId))
+		assert.NotNil(t, jwks.Find(oldKeyId), "old key published during the overlap")
+	}
+
+	// A failed publish neither saves nor changes the live credential.
+	store.failPublish = true
+	rotated.KeyCreatedAt = &past
+	keyId, pubKey := rotated.KeyId, rotated.PublicKey
+	privKey, err = rotated.GetDecodedPrivateKey("secure-password")
+	assert.NoError(t, err)
+	n, err := RunRoboKeyRotationDue(store, 24*time.Hour, time.Hour, time.Now().UTC())
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, store.saved)
+	assert.Equal(t, keyId, rotated.KeyId)
+	assert.Equal(t, pubKey, rotated.PublicKey)
+	gotPrivKey, err := rotated.GetDecodedPrivateKey("secure-password")
+	assert.NoError(t, err)
+	assert.Equal(t, privKey, gotPrivKey)
+
+	// A failed save rolls the live credential back.
+	store.failPublish = false
+	store.failSave = true
+	n, err = RunRoboKeyRotationDue(store, 24*time.Hour, time.Hour, time.Now().UTC())
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, keyId, rotated.KeyId)
+	assert.Equal(t, pubKey, rotated.PublicKey)
+}
@@ -0,0 +1,153 @@
+package anode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/acron"
+	"github.com/jpfluger/alibs-slim/acrypt"
+)
+
+// TASKTYPE_ROBOKEYROTATION rotates RoboCredential keys and publishes the
+// new public keys when scheduled as an acron job plan.
+const TASKTYPE_ROBOKEYROTATION acron.TaskType = "robo-key-rotation"
+
+const (
+	ROBOKEYROTATION_ROTATE_HOURS_DEFAULT  = 30 * 24
+	ROBOKEYROTATION_OVERLAP_HOURS_DEFAULT = 24
+)
+
+// IRoboKeyRotationStore loads, publishes and saves credentials for key rotation.
+type IRoboKeyRotationStore interface {
+	// FindRoboKeyRotationDue returns credentials whose key was created at or
+	// before createdBefore, and those without a KeyCreatedAt.
+	FindRoboKeyRotationDue(createdBefore time.Time) ([]*RoboCredential, error)
+	// GetRoboMasterPassword returns the password that encrypts the private key of rc.
+	GetRoboMasterPassword(rc *RoboCredential) (string, error)
+	// PublishRoboPublicKeys publishes the keys verifiers accept for rc (eg to
+	// a JWKS endpoint). It is called before rc is rotated and saved, so
+	// verifiers know the new key before it signs anything; jwks holds the
+	// keys after the rotation.
+	PublishRoboPublicKeys(rc *RoboCredential, jwks *acrypt.JWKSet) error
+	// SaveRoboCredential persists the rotated credential.
+	SaveRoboCredential(rc *RoboCredential) error
+}
+
+var (
+	roboKeyRotationStore   IRoboKeyRotationStore
+	muRoboKeyRotationStore sync.RWMutex
+)
+
+// SetRoboKeyRotationStore sets the global store used by TaskRoboKeyRotation.
+func SetRoboKeyRotationStore(store IRoboKeyRotationStore) {
+	muRoboKeyRotationStore.Lock()
+	defer muRoboKeyRotationStore.Unlock()
+	roboKeyRotationStore = store
+}
+
+// ROBOKEYROTATIONSTORE returns the global store.
+func ROBOKEYROTATIONSTORE() IRoboKeyRotationStore {
+	muRoboKeyRotationStore.RLock()
+	defer muRoboKeyRotationStore.RUnlock()
+	return roboKeyRotationStore
+}
+
+// RunRoboKeyRotationDue rotates the keys of credentials older than maxAge,
+// keeping old public keys for overlap, and returns how many rotated. A
+// credential is only saved after its keys are published. A failing
+// credential does not stop the others; the first error is returned.
+func RunRoboKeyRotationDue(store IRoboKeyRotationStore, maxAge time.Duration, overlap time.Duration, now time.Time) (int, error) {
+	if store == nil {
+		return 0, fmt.Errorf("robo key rotation store is not set")
+	}
+	if maxAge <= 0 {
+		return 0, fmt.Errorf("robo key rotation max age must be greater than 0")
+	}
+	credentials, err := store.FindRoboKeyRotationDue(now.Add(-maxAge))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due robo credentials; %v", err)
+	}
+	var firstErr error
+	rotated := 0
+	for _, rc := range credentials {
+		if err = rotateRoboKeys(store, rc, overlap); err == nil {
+			rotated++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return rotated, firstErr
+}
+
+func rotateRoboKeys(store IRoboKeyRotationStore, rc *RoboCredential, overlap time.Duration) error {
+	if rc == nil {
+		return nil
+	}
+	masterPassword, err := store.GetRoboMasterPassword(rc)
+	if err != nil {
+		return fmt.Errorf("failed to get robo master password; %v", err)
+	}
+	// Rotate a copy so rc keeps signing with its published key until the
+	// new one is published, and roll back if the save fails.
+	prev := rc.clone()
+	next := rc.clone()
+	if err = next.RotateKeysWithOverlap(masterPassword, overlap); err != nil {
+		return err
+	}
+	if err = store.PublishRoboPublicKeys(rc, next.JWKS()); err != nil {
+		return fmt.Errorf("failed to publish robo public keys; %v", err)
+	}
+	rc.set(next)
+	if err = store.SaveRoboCredential(rc); err != nil {
+		rc.set(prev)
+		return fmt.Errorf("failed to save robo credential; %v", err)
+	}
+	return nil
+}
+
+// TaskRoboKeyRotation is an acron task that runs RunRoboKeyRotationDue with
+// the global store.
+type TaskRoboKeyRotation struct {
+	Type         acron.TaskType `json:"type"`
+	RotateHours  int            `json:"rotateHours,omitempty"`  // Rotate keys older than this; ROBOKEYROTATION_ROTATE_HOURS_DEFAULT if 0.
+	OverlapHours int            `json:"overlapHours,omitempty"` // Keep old public keys this long; ROBOKEYROTATION_OVERLAP_HOURS_DEFAULT if 0.
+}
+
+// GetType returns the type of the task.
+func (t *TaskRoboKeyRotation) GetType() acron.TaskType {
+	return t.Type
+}
+
+// Validate ensures quality control on this struct.
+func (t *TaskRoboKeyRotation) Validate() error {
+	if t.Type.IsEmpty() {
+		t.Type = TASKTYPE_ROBOKEYROTATION
+	}
+	if t.Type != TASKTYPE_ROBOKEYROTATION {
+		return fmt.Errorf("invalid task type '%s'", t.Type)
+	}
+	if t.RotateHours < 0 || t.OverlapHours < 0 {
+		return fmt.Errorf("rotateHours and overlapHours cannot be negative")
+	}
+	if t.RotateHours == 0 {
+		t.RotateHours = ROBOKEYROTATION_ROTATE_HOURS_DEFAULT
+	}
+	if t.OverlapHours == 0 {
+		t.OverlapHours = ROBOKEYROTATION_OVERLAP_HOURS_DEFAULT
+	}
+	return nil
+}
+
+// Run rotates the due keys.
+func (t *TaskRoboKeyRotation) Run(ccc acron.ICronControlCenter) error {
+	if ccc == nil || ccc.GetJRun() == nil {
+		return fmt.Errorf("nil cronControlCenter")
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	rotated, err := RunRoboKeyRotationDue(ROBOKEYROTATIONSTORE(), time.Duration(t.RotateHours)*time.Hour, time.Duration(t.OverlapHours)*time.Hour, time.Now().UTC())
+	ccc.GetJRun().Logger().Info().Msgf("robo key rotation: %d credentials rotated", rotated)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/jpfluger/alibs-slim/alegal"
 	"github.com/jpfluger/alibs-slim/asessions"
 	"github.com/jpfluger/alibs-slim/atime"
+	"github.com/jpfluger/alibs-slim/auser"
 	"strings"
 	"time"
 )
@@ -13,8 +14,12 @@ import (
 // UserAccount represents a user's account with various attributes and settings.
 type UserAccount struct {
 	// IsDeactivated indicates whether the user account is deactivated.
+	// It is kept in sync by lifecycle transitions.
 	IsDeactivated bool `json:"isDeactivated"`
 
+	// Lifecycle holds the account state, its scheduled transition and history.
+	Lifecycle UserLifecycle `json:"lifecycle,omitempty"`
+
 	// MFA contains Multi-Factor Authentication settings.
 	MFA struct {
 		// TOTP holds the current TOTP (Time-based One-Time Password) configuration.
@@ -22,6 +27,8 @@ type UserAccount struct {
 		// TOTPNew holds the new TOTP configuration when regenerating a TOTP key.
 		// This keeps the original TOTP in place to prevent a security leak.
 		TOTPNew *UserAccountIsOnMFA `json:"totpNew,omitempty"`
+		// WebAuthn is on when the user has a verified passkey or security key.
+		WebAuthn UserAccountIsOnMFA `json:"webauthn,omitempty"`
 	} `json:"mfa,omitempty"`
 
 	// Email is required.
@@ -29,6 +36,13 @@ type UserAccount struct {
 	// For Username, see UserVault.Credentials.Username.
 	Email aemail.EmailAddress `json:"email,omitempty"`
 
+	// Name is the display name of the user, eg mapped from an identity provider.
+	Name string `json:"name,omitempty"`
+
+	// PreferredUsername is the username the user is known by at an identity
+	// provider. It is informational; sign-in uses UserVault.Credential.Username.
+	PreferredUsername auser.Username `json:"preferredUsername,omitempty"`
+
 	// Phone is optional but could be required based on the system implementing this struct.
 	// Depending on the site operation, email could parallel the username.
 	Phone acontact.Phone `json:"phone,omitempty"`
@@ -44,6 +58,14 @@ type UserAccount struct {
 
 	// AdminLock holds the admin lock status of the account.
 	AdminLock AdminLock `json:"adminLock,omitempty"`
+
+	// ExternalIdentities links the account to external identity providers (eg OIDC).
+	ExternalIdentities UserExternalIdentities `json:"externalIdentities,omitempty"`
+}
+
+// IsOnMFA returns true if a verified second factor (TOTP or WebAuthn) is enabled.
+func (ua *UserAccount) IsOnMFA() bool {
+	return (ua.MFA.TOTP.IsOn && ua.MFA.TOTP.IsVerified()) || (ua.MFA.WebAuthn.IsOn && ua.MFA.WebAuthn.IsVerified())
 }
 
 // AddDeviceLogin adds a new device login to the user's login history.
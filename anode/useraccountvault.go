@@ -18,9 +18,13 @@ type UserVault struct {
 	// TOTPNew holds the new TOTP configuration, if any.
 	TOTPNew *acrypt.TOTP `json:"totpNew,omitempty"`
 
-	// TokenBackups holds the backup tokens for the user.
-	TokenBackupsDate *time.Time             `json:"tokenBackupsDate,omitempty"`
-	TokenBackups     acrypt.MiniRandomCodes `json:"tokenBackups,omitempty"`
+	// WebAuthn holds the user's registered passkeys and security keys.
+	WebAuthn WebAuthnCredentials `json:"webauthn,omitempty"`
+
+	// TokenBackups holds the hashed, single-use backup tokens for the user.
+	// The plaintext codes are only returned by GenerateTokenBackups.
+	TokenBackupsDate *time.Time      `json:"tokenBackupsDate,omitempty"`
+	TokenBackups     UserBackupCodes `json:"tokenBackups,omitempty"`
 
 	// Support contains the support pin used to verify a user instead of a social security number.
 	Support struct {
@@ -48,32 +52,65 @@ func (uv *UserVault) HasTOTPNew() bool {
 	return uv.TOTPNew != nil && uv.TOTPNew.HasSecret()
 }
 
-// HasTokenBackups checks if the vault has tokens that can be used for backup and recover.
+// HasWebAuthn checks if the vault has at least one WebAuthn credential.
+func (uv *UserVault) HasWebAuthn() bool {
+	return len(uv.WebAuthn) > 0
+}
+
+// HasTokenBackups checks if the vault has unused tokens that can be used for backup and recover.
 func (uv *UserVault) HasTokenBackups() bool {
-	if uv.TokenBackupsDate == nil || uv.TokenBackupsDate.IsZero() || uv.TokenBackups == nil || len(uv.TokenBackups) == 0 {
+	if uv.TokenBackupsDate == nil || uv.TokenBackupsDate.IsZero() {
 		return false
 	}
-	return true
+	return uv.TokenBackups.Remaining() > 0
+}
+
+// TokenBackupsRemaining returns the number of unused backup tokens.
+func (uv *UserVault) TokenBackupsRemaining() int {
+	return uv.TokenBackups.Remaining()
 }
 
 // GenerateTokenBackups generates backup tokens with default options.
-func (uv *UserVault) GenerateTokenBackups() error {
+// The returned plaintext codes must be shown to the user now; only their hashes are kept.
+func (uv *UserVault) GenerateTokenBackups() ([]string, error) {
 	return uv.GenerateTokenBackupsWithOptions(16, 8)
 }
 
-// GenerateTokenBackupsWithOptions generates backup tokens with specified options.
-func (uv *UserVault) GenerateTokenBackupsWithOptions(maxCount int, length int) error {
+// GenerateTokenBackupsWithOptions generates backup tokens with specified options,
+// replacing any existing tokens, and returns the plaintext codes.
+func (uv *UserVault) GenerateTokenBackupsWithOptions(maxCount int, length int) ([]string, error) {
 	if maxCount < 1 {
 		maxCount = 16
 	}
 	if length < 1 {
 		length = 8
 	}
-	codes := acrypt.MiniRandomCodes{}
-	if err := codes.GenerateWithCharSet(maxCount, length, "", "ABCDEFGHJKMNPQRSTUVWXZ0123456789"); err != nil {
-		return err
+	hashed, codes, err := newUserBackupCodes(maxCount, length)
+	if err != nil {
+		return nil, err
 	}
-	uv.TokenBackups = codes
+	uv.TokenBackups = hashed
 	uv.TokenBackupsDate = atime.GetNowUTCPointer()
-	return nil
+	return codes, nil
+}
+
+// MigrateTokenBackups hashes backup tokens still stored in the legacy
+// plaintext format and returns how many were migrated. Run it once per
+// vault, eg from a migration job, and save the vault if the count is not 0.
+func (uv *UserVault) MigrateTokenBackups() (int, error) {
+	return uv.TokenBackups.migrate()
+}
+
+// UseTokenBackup consumes the backup token matching code, recording the time
+// and client IP. It returns false if no unused token matches. When the tokens
+// run low, OnTokenBackupsLow is called.
+func (uv *UserVault) UseTokenBackup(code string, realIP string) (bool, error) {
+	ok, err := uv.TokenBackups.use(code, realIP, time.Now().UTC())
+	if err != nil || !ok {
+		return false, err
+	}
+	if remaining := uv.TokenBackups.Remaining(); remaining <= TOKENBACKUPS_LOW_THRESHOLD && OnTokenBackupsLow != nil {
+		OnTokenBackupsLow(uv, remaining)
+	}
+	return true, nil
 }
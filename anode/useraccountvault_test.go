@@ -55,11 +55,12 @@ func TestUserVault_HasTOTPNew(t *testing.T) {
 func TestUserVault_GenerateTokenBackups(t *testing.T) {
 	vault := UserVault{}
 
-	if err := vault.GenerateTokenBackups(); err != nil {
+	codes, err := vault.GenerateTokenBackups()
+	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if len(vault.TokenBackups) != 16 {
+	if len(codes) != 16 || len(vault.TokenBackups) != 16 {
 		t.Errorf("expected 16 token backups, got %d", len(vault.TokenBackups))
 	}
 }
@@ -67,11 +68,12 @@ func TestUserVault_GenerateTokenBackups(t *testing.T) {
 func TestUserVault_GenerateTokenBackupsWithOptions(t *testing.T) {
 	vault := UserVault{}
 
-	if err := vault.GenerateTokenBackupsWithOptions(10, 6); err != nil {
+	codes, err := vault.GenerateTokenBackupsWithOptions(10, 6)
+	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if len(vault.TokenBackups) != 10 {
+	if len(codes) != 10 || len(codes[0]) != 6 || len(vault.TokenBackups) != 10 {
 		t.Errorf("expected 10 token backups, got %d", len(vault.TokenBackups))
 	}
 }
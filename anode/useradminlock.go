@@ -22,3 +22,9 @@ type AdminLock struct {
 	// whether initiated by the admin or through the forgot-login process.
 	RequestResetPassword *time.Time `json:"requestResetPassword,omitempty"`
 }
+
+// IsLocked returns true if an admin locked the account. The lock is set and
+// cleared by USERSTATE_SUSPENDED lifecycle transitions.
+func (al AdminLock) IsLocked() bool {
+	return al.Date != nil
+}
@@ -0,0 +1,172 @@
+package anode
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/acrypt"
+)
+
+// TOKENBACKUPS_LOW_THRESHOLD is the remaining-code count at or below which OnTokenBackupsLow is called.
+const TOKENBACKUPS_LOW_THRESHOLD = 3
+
+// TOKENBACKUPS_CHARSET avoids characters that are easily confused (eg I, L, O, Y).
+const TOKENBACKUPS_CHARSET = "ABCDEFGHJKMNPQRSTUVWXZ0123456789"
+
+// TokenBackupsLowFunc is called after a backup code is used and the number
+// of remaining codes is at or below TOKENBACKUPS_LOW_THRESHOLD, eg to email
+// the user to generate new codes. The username is on uv.Credential.
+type TokenBackupsLowFunc func(uv *UserVault, remaining int)
+
+// OnTokenBackupsLow is the global low-codes hook (nil disables it).
+var OnTokenBackupsLow TokenBackupsLowFunc
+
+// TokenBackupsHashPresets are passed to acrypt.HashPassword when hashing
+// backup codes. Nil uses the acrypt defaults.
+var TokenBackupsHashPresets interface{}
+
+// UserBackupCode is a single-use backup code stored as a salted hash.
+type UserBackupCode struct {
+	// Hash is the acrypt password hash of the normalized code.
+	Hash string `json:"hash"`
+
+	// Consumed is when the code was used; nil if unused.
+	Consumed *time.Time `json:"consumed,omitempty"`
+
+	// ConsumedIP is the client IP that used the code.
+	ConsumedIP string `json:"consumedIP,omitempty"`
+
+	// legacy holds a code loaded from the legacy plaintext format until
+	// UserVault.MigrateTokenBackups hashes it.
+	legacy string
+}
+
+// IsConsumed returns true if the code has been used.
+func (bc *UserBackupCode) IsConsumed() bool {
+	return bc != nil && bc.Consumed != nil && !bc.Consumed.IsZero()
+}
+
+// IsLegacy returns true if the code is still in the legacy plaintext format.
+func (bc *UserBackupCode) IsLegacy() bool {
+	return bc != nil && bc.Hash == "" && bc.legacy != ""
+}
+
+// UnmarshalJSON also accepts the legacy format, where codes were stored as
+// plaintext strings. Those are kept as is until UserVault.MigrateTokenBackups
+// hashes them, so loading an account stays cheap.
+func (bc *UserBackupCode) UnmarshalJSON(data []byte) error {
+	var plain string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*bc = UserBackupCode{legacy: plain}
+		return nil
+	}
+	type alias UserBackupCode
+	return json.Unmarshal(data, (*alias)(bc))
+}
+
+// MarshalJSON writes legacy codes back in the legacy format so saving an
+// account that has not been migrated does not lose them.
+func (bc *UserBackupCode) MarshalJSON() ([]byte, error) {
+	if bc.IsLegacy() {
+		return json.Marshal(bc.legacy)
+	}
+	type alias UserBackupCode
+	return json.Marshal((*alias)(bc))
+}
+
+// UserBackupCodes is the list of backup codes of a vault.
+type UserBackupCodes []*UserBackupCode
+
+// Remaining returns the number of unused codes.
+func (bcs UserBackupCodes) Remaining() int {
+	count := 0
+	for _, bc := range bcs {
+		if bc != nil && (bc.Hash != "" || bc.legacy != "") && !bc.IsConsumed() {
+			count++
+		}
+	}
+	return count
+}
+
+// normalizeBackupCode uppercases the code and removes spaces and dashes users may type.
+func normalizeBackupCode(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+func hashBackupCode(code string) (string, error) {
+	code = normalizeBackupCode(code)
+	if code == "" {
+		return "", fmt.Errorf("backup code is empty")
+	}
+	hash, err := acrypt.HashPassword(code, TokenBackupsHashPresets)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash backup code; %v", err)
+	}
+	return hash, nil
+}
+
+// newUserBackupCodes generates count codes and returns the hashed list with
+// the plaintext codes.
+func newUserBackupCodes(count int, length int) (UserBackupCodes, []string, error) {
+	codes := acrypt.MiniRandomCodes{}
+	if err := codes.GenerateWithCharSet(count, length, "", TOKENBACKUPS_CHARSET); err != nil {
+		return nil, nil, err
+	}
+	hashed := make(UserBackupCodes, 0, len(codes))
+	for _, code := range codes {
+		hash, err := hashBackupCode(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashed = append(hashed, &UserBackupCode{Hash: hash})
+	}
+	return hashed, codes.ToStringArray(), nil
+}
+
+// migrate hashes legacy plaintext codes and returns how many changed.
+func (bcs UserBackupCodes) migrate() (int, error) {
+	count := 0
+	for _, bc := range bcs {
+		if !bc.IsLegacy() {
+			continue
+		}
+		hash, err := hashBackupCode(bc.legacy)
+		if err != nil {
+			return count, err
+		}
+		bc.Hash, bc.legacy = hash, ""
+		count++
+	}
+	return count, nil
+}
+
+// use finds the unused code matching code and marks it consumed.
+func (bcs UserBackupCodes) use(code string, ip string, now time.Time) (bool, error) {
+	code = normalizeBackupCode(code)
+	if code == "" {
+		return false, nil
+	}
+	for _, bc := range bcs {
+		if bc == nil || bc.IsConsumed() {
+			continue
+		}
+		var ok bool
+		if bc.IsLegacy() {
+			ok = subtle.ConstantTimeCompare([]byte(normalizeBackupCode(bc.legacy)), []byte(code)) == 1
+		} else if bc.Hash != "" {
+			var err error
+			if ok, err = acrypt.MatchPassword(bc.Hash, code); err != nil {
+				return false, fmt.Errorf("failed to verify backup code; %v", err)
+			}
+		}
+		if ok {
+			bc.Consumed = &now
+			bc.ConsumedIP = strings.TrimSpace(ip)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,100 @@
+package anode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserVault_UseTokenBackup(t *testing.T) {
+	var lowCalls []int
+	OnTokenBackupsLow = func(uv *UserVault, remaining int) { lowCalls = append(lowCalls, remaining) }
+	defer func() { OnTokenBackupsLow = nil }()
+
+	vault := UserVault{}
+	codes, err := vault.GenerateTokenBackupsWithOptions(5, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ii, bc := range vault.TokenBackups {
+		if strings.Contains(bc.Hash, codes[ii]) {
+			t.Errorf("expected code %d to be stored hashed", ii)
+		}
+	}
+	// Hashes are salted, so the same code never hashes the same twice.
+	if h1, _ := hashBackupCode(codes[0]); h1 == vault.TokenBackups[0].Hash {
+		t.Errorf("expected a salted hash")
+	}
+
+	b, _ := json.Marshal(&vault)
+	if strings.Contains(string(b), codes[0]) {
+		t.Errorf("expected no plaintext codes in the vault JSON")
+	}
+
+	// Codes match case-insensitively and ignore dashes.
+	typed := strings.ToLower(codes[0][:4] + "-" + codes[0][4:])
+	if ok, err := vault.UseTokenBackup(typed, "10.0.0.1"); err != nil || !ok {
+		t.Fatalf("expected code to match; %v", err)
+	}
+	if !vault.TokenBackups[0].IsConsumed() || vault.TokenBackups[0].ConsumedIP != "10.0.0.1" {
+		t.Errorf("expected code to be consumed with ip, got %+v", vault.TokenBackups[0])
+	}
+	if ok, _ := vault.UseTokenBackup(codes[0], "10.0.0.1"); ok {
+		t.Errorf("expected a used code to be rejected")
+	}
+	if ok, _ := vault.UseTokenBackup("NOTACODE", ""); ok {
+		t.Errorf("expected an unknown code to be rejected")
+	}
+	if vault.TokenBackupsRemaining() != 4 || len(lowCalls) != 0 {
+		t.Errorf("expected 4 remaining and no hook, got %d %v", vault.TokenBackupsRemaining(), lowCalls)
+	}
+
+	for _, code := range codes[1:] {
+		if ok, _ := vault.UseTokenBackup(code, ""); !ok {
+			t.Fatalf("expected code %s to match", code)
+		}
+	}
+	if vault.HasTokenBackups() {
+		t.Errorf("expected no usable backups")
+	}
+	if len(lowCalls) != 4 || lowCalls[0] != 3 || lowCalls[3] != 0 {
+		t.Errorf("unexpected low hook calls %v", lowCalls)
+	}
+}
+
+func TestUserBackupCodes_LegacyPlaintext(t *testing.T) {
+	vault := UserVault{}
+	if err := json.Unmarshal([]byte(`{"tokenBackupsDate":"2024-01-01T00:00:00Z","tokenBackups":["ABCD1234","WXYZ9876","JKLM5555"]}`), &vault); err != nil {
+		t.Fatal(err)
+	}
+	if vault.TokenBackupsRemaining() != 3 || !vault.TokenBackups[0].IsLegacy() {
+		t.Fatalf("expected legacy codes to load unhashed, got %+v", vault.TokenBackups[0])
+	}
+	if ok, err := vault.UseTokenBackup("wxyz9876", "127.0.0.1"); err != nil || !ok {
+		t.Errorf("expected legacy code to match; %v", err)
+	}
+
+	// Saving before the migration keeps the legacy codes.
+	b, _ := json.Marshal(&vault)
+	if !strings.Contains(string(b), `"ABCD1234"`) {
+		t.Errorf("expected unmigrated codes to be saved, got %s", b)
+	}
+
+	n, err := vault.MigrateTokenBackups()
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 codes migrated, got %d, %v", n, err)
+	}
+	if vault.TokenBackups[0].IsLegacy() || vault.TokenBackups[0].Hash == "" {
+		t.Errorf("expected the code to be hashed, got %+v", vault.TokenBackups[0])
+	}
+	b, _ = json.Marshal(&vault)
+	if strings.Contains(string(b), "ABCD1234") {
+		t.Errorf("expected no plaintext codes after the migration")
+	}
+	if n, _ = vault.MigrateTokenBackups(); n != 0 {
+		t.Errorf("expected a second migration to do nothing, got %d", n)
+	}
+	if ok, err := vault.UseTokenBackup("abcd-1234", ""); err != nil || !ok {
+		t.Errorf("expected migrated code to match; %v", err)
+	}
+}
@@ -89,8 +89,34 @@ func (uc *UserCredential) MatchUsernamePasswordWithCaseSensitive(username auser.
 	return true
 }
 
+// MatchAccountLogin is MatchUsernamePassword for a sign-in to ua. A matching
+// password returns ErrUserLoginNotAllowed if the lifecycle state of ua may
+// not sign in; a wrong one returns false without revealing that state.
+func (uc *UserCredential) MatchAccountLogin(ua *UserAccount, username auser.Username, password string) (bool, error) {
+	if !uc.MatchUsernamePassword(username, password) {
+		return false, nil
+	}
+	if err := ua.CheckCanLogin(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // CheckAuthorizationHeaderWithSecretKey processes the Authorization header for Basic or Bearer token authentication.
 func (uc *UserCredential) CheckAuthorizationHeaderWithSecretKey(authHeader string, secretKey []byte) (bool, error) {
+	return uc.checkAuthorizationHeader(authHeader, jwtUserTokenKey{secretKey: secretKey})
+}
+
+// CheckAuthorizationHeaderWithKeyring is CheckAuthorizationHeaderWithSecretKey
+// for Bearer tokens signed by kr.
+func (uc *UserCredential) CheckAuthorizationHeaderWithKeyring(authHeader string, kr *acrypt.JWTKeyring) (bool, error) {
+	if kr == nil {
+		return false, fmt.Errorf("keyring is nil")
+	}
+	return uc.checkAuthorizationHeader(authHeader, jwtUserTokenKey{keyring: kr})
+}
+
+func (uc *UserCredential) checkAuthorizationHeader(authHeader string, key jwtUserTokenKey) (bool, error) {
 	if strings.HasPrefix(authHeader, "Basic ") {
 		payload, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Basic "))
 		pair := strings.SplitN(string(payload), ":", 2)
@@ -99,7 +125,9 @@ func (uc *UserCredential) CheckAuthorizationHeaderWithSecretKey(authHeader strin
 		}
 	} else if strings.HasPrefix(authHeader, "Bearer ") {
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		isValid, _, err := uc.VerifyTokenTimeWithSecretKey(token, secretKey)
+		uc.JWTUserToken.mu.RLock()
+		isValid, _, err := uc.JWTUserToken.verifyTokenTime(token, key)
+		uc.JWTUserToken.mu.RUnlock()
 		if err != nil || !isValid {
 			return false, fmt.Errorf("unauthorized")
 		}
@@ -162,6 +190,18 @@ func (uc *UserCredential) CheckPassword(secret string) (bool, error) {
 
 // ValidateWithSecretKey validates the UserCredential.
 func (uc *UserCredential) ValidateWithSecretKey(secretKey []byte) error {
+	return uc.validate(jwtUserTokenKey{secretKey: secretKey})
+}
+
+// ValidateWithKeyring validates the UserCredential, verifying its tokens with kr.
+func (uc *UserCredential) ValidateWithKeyring(kr *acrypt.JWTKeyring) error {
+	if kr == nil {
+		return fmt.Errorf("keyring is nil")
+	}
+	return uc.validate(jwtUserTokenKey{keyring: kr})
+}
+
+func (uc *UserCredential) validate(key jwtUserTokenKey) error {
 	uc.mu.Lock()
 	defer uc.mu.Unlock()
 
@@ -193,7 +233,7 @@ func (uc *UserCredential) ValidateWithSecretKey(secretKey []byte) error {
 	if uc.Password != "" {
 		uc.ClearToken()
 	} else {
-		if err := uc.JWTUserToken.ValidateTokenWithSecretKey(secretKey); err != nil {
+		if err := uc.JWTUserToken.validateToken(key); err != nil {
 			return fmt.Errorf("failed validating JWTUserToken: %v", err)
 		}
 	}
@@ -203,7 +243,19 @@ func (uc *UserCredential) ValidateWithSecretKey(secretKey []byte) error {
 
 // GenerateTokensWithSecretKey generates an access token and a refresh token using the Credential's Username and passed-in secretKey.
 func (uc *UserCredential) GenerateTokensWithSecretKey(accessExpiresInHours, refreshExpiresInDays int, secretKey []byte) (string, string, error) {
-	accessToken, refreshToken, err := uc.GenerateTokensWithUsername(uc.GetUsername(), accessExpiresInHours, refreshExpiresInDays, secretKey)
+	return uc.generateTokens(accessExpiresInHours, refreshExpiresInDays, jwtUserTokenKey{secretKey: secretKey})
+}
+
+// GenerateTokensWithKeyring generates an access token and a refresh token using the Credential's Username, signed by the active key of kr.
+func (uc *UserCredential) GenerateTokensWithKeyring(accessExpiresInHours, refreshExpiresInDays int, kr *acrypt.JWTKeyring) (string, string, error) {
+	if kr == nil {
+		return "", "", fmt.Errorf("keyring is nil")
+	}
+	return uc.generateTokens(accessExpiresInHours, refreshExpiresInDays, jwtUserTokenKey{keyring: kr})
+}
+
+func (uc *UserCredential) generateTokens(accessExpiresInHours, refreshExpiresInDays int, key jwtUserTokenKey) (string, string, error) {
+	accessToken, refreshToken, err := uc.JWTUserToken.generateTokens(uc.GetUsername(), accessExpiresInHours, refreshExpiresInDays, key)
 	if err != nil {
 		return "", "", err
 	}
@@ -32,6 +32,26 @@ func TestMatchUsernamePassword(t *testing.T) {
 	assert.False(t, uc.MatchUsernamePasswordWithCaseSensitive("wronguser", "password123", true))
 }
 
+func TestMatchAccountLogin(t *testing.T) {
+	uc := &UserCredential{Username: "testuser"}
+	assert.NoError(t, uc.EncryptPassword("password123"))
+	ua := &UserAccount{}
+
+	ok, err := uc.MatchAccountLogin(ua, "testuser", "password123")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ua.Lifecycle.State = USERSTATE_SUSPENDED
+	ok, err = uc.MatchAccountLogin(ua, "testuser", "password123")
+	assert.ErrorIs(t, err, ErrUserLoginNotAllowed)
+	assert.False(t, ok)
+
+	// A wrong password does not reveal the account state.
+	ok, err = uc.MatchAccountLogin(ua, "testuser", "wrongpassword")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
 func TestCheckAuthorizationHeaderWithSecretKey(t *testing.T) {
 	uc := &UserCredential{
 		Username: "testuser",
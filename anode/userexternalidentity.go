@@ -0,0 +1,49 @@
+package anode
+
+import (
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aemail"
+)
+
+// UserExternalIdentity links an account to a subject at an external identity
+// provider. A link is identified by Issuer and Subject.
+type UserExternalIdentity struct {
+	// Provider is the configured provider name, eg "corp".
+	Provider string `json:"provider"`
+	// Issuer is the provider's issuer url (the OIDC "iss" claim).
+	Issuer string `json:"issuer"`
+	// Subject is the provider's stable user id (the OIDC "sub" claim).
+	Subject string `json:"subject"`
+	// Email is the last verified email from the provider.
+	Email aemail.EmailAddress `json:"email,omitempty"`
+	// Name and Username are the mapped claims of the last login.
+	Name     string `json:"name,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Linked is when the identity was linked.
+	Linked time.Time `json:"linked"`
+}
+
+// UserExternalIdentities is the list of identities linked to an account.
+type UserExternalIdentities []*UserExternalIdentity
+
+// Find returns the identity for issuer and subject or nil.
+func (ids UserExternalIdentities) Find(issuer string, subject string) *UserExternalIdentity {
+	for _, id := range ids {
+		if id != nil && id.Issuer == issuer && id.Subject == subject {
+			return id
+		}
+	}
+	return nil
+}
+
+// Remove unlinks the identity for issuer and subject and returns true if it was found.
+func (ids *UserExternalIdentities) Remove(issuer string, subject string) bool {
+	for ii, id := range *ids {
+		if id != nil && id.Issuer == issuer && id.Subject == subject {
+			*ids = append((*ids)[:ii], (*ids)[ii+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package anode
+
+import "testing"
+
+func TestUserExternalIdentities(t *testing.T) {
+	ids := UserExternalIdentities{
+		{Provider: "corp", Issuer: "https://corp.example.com", Subject: "s1"},
+		{Provider: "partner", Issuer: "https://partner.example.com", Subject: "s1"},
+	}
+	if ids.Find("https://corp.example.com", "s1") != ids[0] || ids.Find("https://corp.example.com", "s2") != nil || ids.Find("corp", "s1") != nil {
+		t.Error("unexpected Find result")
+	}
+	if !ids.Remove("https://corp.example.com", "s1") || ids.Remove("https://corp.example.com", "s1") {
+		t.Error("expected Remove to succeed once")
+	}
+	if len(ids) != 1 || ids.Find("https://partner.example.com", "s1") == nil {
+		t.Errorf("unexpected identities %+v", ids)
+	}
+}
@@ -0,0 +1,287 @@
+package anode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/auser"
+)
+
+var (
+	ErrUserLifecycleTransition = errors.New("user lifecycle transition not allowed")
+	ErrUserLoginNotAllowed     = errors.New("user account may not sign in")
+)
+
+// UserLifecycleState is the lifecycle state of a user account.
+type UserLifecycleState string
+
+const (
+	USERSTATE_INVITED              UserLifecycleState = "invited"              // Created by an admin; the user has not accepted yet.
+	USERSTATE_PENDING_VERIFICATION UserLifecycleState = "pending-verification" // Signed up; the email is not verified yet.
+	USERSTATE_ACTIVE               UserLifecycleState = "active"
+	USERSTATE_SUSPENDED            UserLifecycleState = "suspended"        // Temporarily blocked, optionally until a date.
+	USERSTATE_DEACTIVATED          UserLifecycleState = "deactivated"      // Closed, but can be reactivated.
+	USERSTATE_PENDING_DELETION     UserLifecycleState = "pending-deletion" // Deleted after a grace period unless restored.
+	USERSTATE_DELETED              UserLifecycleState = "deleted"          // Final; purge the account data.
+)
+
+// USERLIFECYCLE_DELETION_GRACE is the default grace period before a pending deletion completes.
+const USERLIFECYCLE_DELETION_GRACE = 30 * 24 * time.Hour
+
+// IsEmpty checks if the state is empty after trimming whitespace.
+func (s UserLifecycleState) IsEmpty() bool {
+	return strings.TrimSpace(string(s)) == ""
+}
+
+// String returns the state as a string.
+func (s UserLifecycleState) String() string {
+	return string(s)
+}
+
+// CanLogin returns true if an account in this state may sign in.
+func (s UserLifecycleState) CanLogin() bool {
+	return s == USERSTATE_ACTIVE
+}
+
+// UserLifecycle holds the lifecycle state of an account and its scheduled
+// timed transition, if any.
+type UserLifecycle struct {
+	// State is the current state. Empty is treated as active, or deactivated
+	// when UserAccount.IsDeactivated is set (accounts saved before lifecycles).
+	State UserLifecycleState `json:"state,omitempty"`
+
+	// Until is when the timed transition to Next is due.
+	Until *time.Time         `json:"until,omitempty"`
+	Next  UserLifecycleState `json:"next,omitempty"`
+
+	// History records every transition, oldest first. The Action of an entry
+	// is the state moved to and its Event the reason; an empty User is the
+	// system (eg a timed transition).
+	History aconns.RecordSecurityHistoryTimes `json:"history,omitempty"`
+}
+
+// IsDue returns true if a timed transition is due at now.
+func (ul *UserLifecycle) IsDue(now time.Time) bool {
+	return ul.Until != nil && !ul.Next.IsEmpty() && !now.Before(*ul.Until)
+}
+
+// GetLifecycleState returns the lifecycle state of the account. Accounts
+// saved before lifecycles map IsDeactivated to deactivated and a locked
+// AdminLock to suspended.
+func (ua *UserAccount) GetLifecycleState() UserLifecycleState {
+	if !ua.Lifecycle.State.IsEmpty() {
+		return ua.Lifecycle.State
+	}
+	if ua.IsDeactivated {
+		return USERSTATE_DEACTIVATED
+	}
+	if ua.AdminLock.IsLocked() {
+		return USERSTATE_SUSPENDED
+	}
+	return USERSTATE_ACTIVE
+}
+
+// CheckCanLogin returns ErrUserLoginNotAllowed unless the lifecycle state of
+// the account allows it to sign in. The OIDC, passwordless, WebAuthn and
+// password (UserCredential.MatchAccountLogin) logins call it; call it on any
+// other login path.
+func (ua *UserAccount) CheckCanLogin() error {
+	if ua == nil {
+		return fmt.Errorf("%w; user account is nil", ErrUserLoginNotAllowed)
+	}
+	if state := ua.GetLifecycleState(); !state.CanLogin() {
+		return fmt.Errorf("%w; account is '%s'", ErrUserLoginNotAllowed, state)
+	}
+	return nil
+}
+
+// UserLifecycleTransition is a request to move an account to a new state.
+type UserLifecycleTransition struct {
+	To     UserLifecycleState
+	Actor  auser.RecordUserIdentity // Who requested the transition; empty for the system.
+	Reason string
+	Until  *time.Time // Suspended: when to unlock (nil is indefinite). Pending deletion: when to delete (nil uses the grace period).
+}
+
+// UserLifecycleGuard can veto a transition by returning an error.
+type UserLifecycleGuard func(ua *UserAccount, from UserLifecycleState, tr *UserLifecycleTransition, now time.Time) error
+
+// UserLifecycleMachine defines the allowed transitions between states and
+// the guards that must pass before a transition is applied.
+type UserLifecycleMachine struct {
+	transitions map[UserLifecycleState][]UserLifecycleState
+	guards      []UserLifecycleGuard
+	fnNow       func() time.Time
+	mu          sync.RWMutex
+}
+
+// NewUserLifecycleMachine creates a machine with the default transitions and guards.
+func NewUserLifecycleMachine() *UserLifecycleMachine {
+	return &UserLifecycleMachine{
+		transitions: map[UserLifecycleState][]UserLifecycleState{
+			USERSTATE_INVITED:              {USERSTATE_PENDING_VERIFICATION, USERSTATE_ACTIVE, USERSTATE_DELETED},
+			USERSTATE_PENDING_VERIFICATION: {USERSTATE_ACTIVE, USERSTATE_DELETED},
+			USERSTATE_ACTIVE:               {USERSTATE_SUSPENDED, USERSTATE_DEACTIVATED, USERSTATE_PENDING_DELETION},
+			USERSTATE_SUSPENDED:            {USERSTATE_ACTIVE, USERSTATE_DEACTIVATED, USERSTATE_PENDING_DELETION},
+			USERSTATE_DEACTIVATED:          {USERSTATE_ACTIVE, USERSTATE_PENDING_DELETION},
+			USERSTATE_PENDING_DELETION:     {USERSTATE_ACTIVE, USERSTATE_DELETED},
+		},
+		guards: []UserLifecycleGuard{guardUserLifecycleEmail, guardUserLifecycleUntil},
+	}
+}
+
+func (m *UserLifecycleMachine) now() time.Time {
+	if m.fnNow != nil {
+		return m.fnNow()
+	}
+	return time.Now().UTC()
+}
+
+// AllowTransition adds an allowed transition.
+func (m *UserLifecycleMachine) AllowTransition(from UserLifecycleState, to UserLifecycleState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.canTransition(from, to) {
+		m.transitions[from] = append(m.transitions[from], to)
+	}
+}
+
+// AddGuard adds a guard that runs before every transition.
+func (m *UserLifecycleMachine) AddGuard(guard UserLifecycleGuard) {
+	if guard == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.guards = append(m.guards, guard)
+}
+
+// CanTransition returns true if from may move to to, ignoring guards.
+func (m *UserLifecycleMachine) CanTransition(from UserLifecycleState, to UserLifecycleState) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.canTransition(from, to)
+}
+
+func (m *UserLifecycleMachine) canTransition(from UserLifecycleState, to UserLifecycleState) bool {
+	for _, allowed := range m.transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the account to tr.To if the transition is allowed and
+// every guard passes, records it in the history and schedules any timed
+// transition: a suspension with Until unlocks at Until, and a pending
+// deletion completes at Until or after USERLIFECYCLE_DELETION_GRACE. A
+// suspension also locks the AdminLock with the reason; leaving it unlocks.
+func (m *UserLifecycleMachine) Transition(ua *UserAccount, tr *UserLifecycleTransition) error {
+	if ua == nil || tr == nil {
+		return fmt.Errorf("user account and transition are required")
+	}
+	return m.transition(ua, tr, m.now())
+}
+
+func (m *UserLifecycleMachine) transition(ua *UserAccount, tr *UserLifecycleTransition, now time.Time) error {
+	from := ua.GetLifecycleState()
+	m.mu.RLock()
+	allowed := m.canTransition(from, tr.To)
+	guards := m.guards
+	m.mu.RUnlock()
+	if !allowed {
+		return fmt.Errorf("%w; from '%s' to '%s'", ErrUserLifecycleTransition, from, tr.To)
+	}
+
+	if tr.To == USERSTATE_PENDING_DELETION && tr.Until == nil {
+		until := now.Add(USERLIFECYCLE_DELETION_GRACE)
+		tr.Until = &until
+	}
+	for _, guard := range guards {
+		if err := guard(ua, from, tr, now); err != nil {
+			return fmt.Errorf("%w; %v", ErrUserLifecycleTransition, err)
+		}
+	}
+
+	ua.Lifecycle.State = tr.To
+	ua.Lifecycle.Until, ua.Lifecycle.Next = nil, ""
+	switch {
+	case tr.To == USERSTATE_SUSPENDED && tr.Until != nil:
+		ua.Lifecycle.Until, ua.Lifecycle.Next = tr.Until, USERSTATE_ACTIVE
+	case tr.To == USERSTATE_PENDING_DELETION:
+		ua.Lifecycle.Until, ua.Lifecycle.Next = tr.Until, USERSTATE_DELETED
+	}
+	ua.IsDeactivated = tr.To == USERSTATE_DEACTIVATED || tr.To == USERSTATE_PENDING_DELETION || tr.To == USERSTATE_DELETED
+	if tr.To == USERSTATE_SUSPENDED {
+		lockedAt := now
+		ua.AdminLock.Date, ua.AdminLock.Message = &lockedAt, strings.TrimSpace(tr.Reason)
+	} else if from == USERSTATE_SUSPENDED {
+		ua.AdminLock.Date, ua.AdminLock.Message = nil, ""
+	}
+
+	ua.Lifecycle.History.AddEntry(tr.Actor, aconns.RecordActionType(tr.To), strings.TrimSpace(tr.Reason), now)
+	return nil
+}
+
+// ApplyDue applies the account's timed transition if it is due at now and
+// returns true if the account changed.
+func (m *UserLifecycleMachine) ApplyDue(ua *UserAccount, now time.Time) (bool, error) {
+	if ua == nil || !ua.Lifecycle.IsDue(now) {
+		return false, nil
+	}
+	reason := "scheduled transition"
+	switch ua.Lifecycle.Next {
+	case USERSTATE_ACTIVE:
+		reason = "suspension ended"
+	case USERSTATE_DELETED:
+		reason = "deletion grace period ended"
+	}
+	if err := m.transition(ua, &UserLifecycleTransition{To: ua.Lifecycle.Next, Reason: reason}, now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// guardUserLifecycleEmail requires an email before an account becomes active
+// for the first time.
+func guardUserLifecycleEmail(ua *UserAccount, from UserLifecycleState, tr *UserLifecycleTransition, now time.Time) error {
+	if tr.To == USERSTATE_ACTIVE && (from == USERSTATE_INVITED || from == USERSTATE_PENDING_VERIFICATION) && ua.Email.IsEmpty() {
+		return fmt.Errorf("an email is required to activate the account")
+	}
+	return nil
+}
+
+// guardUserLifecycleUntil requires scheduled dates to be in the future.
+func guardUserLifecycleUntil(ua *UserAccount, from UserLifecycleState, tr *UserLifecycleTransition, now time.Time) error {
+	if tr.Until != nil && !tr.Until.After(now) && (tr.To == USERSTATE_SUSPENDED || tr.To == USERSTATE_PENDING_DELETION) {
+		return fmt.Errorf("until must be in the future")
+	}
+	return nil
+}
+
+var (
+	userLifecycleMachine   *UserLifecycleMachine
+	muUserLifecycleMachine sync.RWMutex
+)
+
+// SetUserLifecycleMachine replaces the global machine, eg to add guards.
+func SetUserLifecycleMachine(m *UserLifecycleMachine) {
+	muUserLifecycleMachine.Lock()
+	defer muUserLifecycleMachine.Unlock()
+	userLifecycleMachine = m
+}
+
+// USERLIFECYCLEMACHINE returns the global machine, creating the default if unset.
+func USERLIFECYCLEMACHINE() *UserLifecycleMachine {
+	muUserLifecycleMachine.Lock()
+	defer muUserLifecycleMachine.Unlock()
+	if userLifecycleMachine == nil {
+		userLifecycleMachine = NewUserLifecycleMachine()
+	}
+	return userLifecycleMachine
+}
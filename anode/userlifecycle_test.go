@@ -0,0 +1,164 @@
+package anode
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/aconns"
+	"github.com/jpfluger/alibs-slim/acron"
+	"github.com/jpfluger/alibs-slim/aemail"
+	"github.com/jpfluger/alibs-slim/auser"
+)
+
+func TestUserLifecycle_Transitions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewUserLifecycleMachine()
+	m.fnNow = func() time.Time { return now }
+	admin := auser.NewRecordUserIdentityByEmail("admin@example.com")
+
+	ua := &UserAccount{Lifecycle: UserLifecycle{State: USERSTATE_INVITED}}
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_ACTIVE}); !errors.Is(err, ErrUserLifecycleTransition) {
+		t.Errorf("expected the email guard to block activation, got %v", err)
+	}
+	ua.Email = aemail.EmailAddress("alice@example.com")
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_ACTIVE, Reason: "accepted invite"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_INVITED}); !errors.Is(err, ErrUserLifecycleTransition) {
+		t.Errorf("expected active to invited to be disallowed, got %v", err)
+	}
+
+	// Suspension with auto-unlock.
+	until := now.Add(time.Hour)
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_SUSPENDED, Actor: admin, Reason: "abuse report", Until: &until}); err != nil {
+		t.Fatal(err)
+	}
+	if ua.GetLifecycleState().CanLogin() || ua.Lifecycle.Next != USERSTATE_ACTIVE {
+		t.Errorf("unexpected suspended lifecycle %+v", ua.Lifecycle)
+	}
+	if err := ua.CheckCanLogin(); !errors.Is(err, ErrUserLoginNotAllowed) {
+		t.Errorf("expected a suspended account to be refused login, got %v", err)
+	}
+	if !ua.AdminLock.IsLocked() || ua.AdminLock.Message != "abuse report" {
+		t.Errorf("expected suspension to lock the admin lock, got %+v", ua.AdminLock)
+	}
+	if ok, _ := m.ApplyDue(ua, now.Add(30*time.Minute)); ok {
+		t.Errorf("expected suspension to still be in effect")
+	}
+	if ok, err := m.ApplyDue(ua, until); err != nil || !ok || ua.GetLifecycleState() != USERSTATE_ACTIVE {
+		t.Errorf("expected auto-unlock, got %v %s", err, ua.GetLifecycleState())
+	}
+	if ua.AdminLock.IsLocked() || ua.CheckCanLogin() != nil {
+		t.Errorf("expected auto-unlock to clear the admin lock, got %+v", ua.AdminLock)
+	}
+
+	// Deletion uses the default grace period and can be restored.
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_PENDING_DELETION, Actor: admin, Reason: "user request"}); err != nil {
+		t.Fatal(err)
+	}
+	if !ua.IsDeactivated || !ua.Lifecycle.Until.Equal(now.Add(USERLIFECYCLE_DELETION_GRACE)) {
+		t.Errorf("unexpected pending deletion %+v", ua.Lifecycle)
+	}
+
+	history := ua.Lifecycle.History
+	if len(history) != 4 {
+		t.Fatalf("expected 4 history entries, got %d", len(history))
+	}
+	if history[1].Action != aconns.RecordActionType(USERSTATE_SUSPENDED) || !history[1].User.HasMatch(admin) || history[1].Event != "abuse report" {
+		t.Errorf("unexpected history entry %+v", history[1])
+	}
+	if !history[2].User.IsEmpty() || history[2].Event != "suspension ended" {
+		t.Errorf("expected a system entry for auto-unlock, got %+v", history[2])
+	}
+
+	// Past dates are rejected by the guard.
+	ua2 := &UserAccount{}
+	past := now.Add(-time.Minute)
+	if err := m.Transition(ua2, &UserLifecycleTransition{To: USERSTATE_SUSPENDED, Until: &past}); !errors.Is(err, ErrUserLifecycleTransition) {
+		t.Errorf("expected past until to be rejected, got %v", err)
+	}
+
+	// Legacy accounts derive their state from IsDeactivated.
+	if (&UserAccount{IsDeactivated: true}).GetLifecycleState() != USERSTATE_DEACTIVATED {
+		t.Errorf("expected legacy deactivated state")
+	}
+	if (&UserAccount{AdminLock: AdminLock{Date: &now}}).GetLifecycleState() != USERSTATE_SUSPENDED {
+		t.Errorf("expected legacy admin lock to be suspended")
+	}
+}
+
+func TestUserLifecycle_CustomGuard(t *testing.T) {
+	m := NewUserLifecycleMachine()
+	m.AddGuard(func(ua *UserAccount, from UserLifecycleState, tr *UserLifecycleTransition, now time.Time) error {
+		if tr.To == USERSTATE_DEACTIVATED && tr.Reason == "" {
+			return errors.New("a reason is required")
+		}
+		return nil
+	})
+	ua := &UserAccount{}
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_DEACTIVATED}); err == nil {
+		t.Errorf("expected the custom guard to block")
+	}
+	if err := m.Transition(ua, &UserLifecycleTransition{To: USERSTATE_DEACTIVATED, Reason: "closed"}); err != nil || !ua.IsDeactivated {
+		t.Errorf("expected deactivation, got %v", err)
+	}
+	m.AllowTransition(USERSTATE_DEACTIVATED, USERSTATE_DELETED)
+	if !m.CanTransition(USERSTATE_DEACTIVATED, USERSTATE_DELETED) {
+		t.Errorf("expected the added transition")
+	}
+}
+
+type userLifecycleTestStore struct {
+	accounts []*UserAccount
+	saved    int
+}
+
+func (s *userLifecycleTestStore) FindUserLifecycleDue(now time.Time) ([]*UserAccount, error) {
+	var due []*UserAccount
+	for _, ua := range s.accounts {
+		if ua.Lifecycle.IsDue(now) {
+			due = append(due, ua)
+		}
+	}
+	return due, nil
+}
+
+func (s *userLifecycleTestStore) SaveUserLifecycle(ua *UserAccount) error {
+	s.saved++
+	return nil
+}
+
+func TestTaskUserLifecycle(t *testing.T) {
+	past := time.Now().UTC().Add(-time.Minute)
+	future := time.Now().UTC().Add(time.Hour)
+	store := &userLifecycleTestStore{accounts: []*UserAccount{
+		{Lifecycle: UserLifecycle{State: USERSTATE_PENDING_DELETION, Until: &past, Next: USERSTATE_DELETED}},
+		{Lifecycle: UserLifecycle{State: USERSTATE_SUSPENDED, Until: &past, Next: USERSTATE_ACTIVE}},
+		{Lifecycle: UserLifecycle{State: USERSTATE_SUSPENDED, Until: &future, Next: USERSTATE_ACTIVE}},
+	}}
+	SetUserLifecycleStore(store)
+	defer SetUserLifecycleStore(nil)
+
+	// The task loads from job plan JSON through the acron type manager.
+	jp := &acron.JobPlan{}
+	if err := jp.UnmarshalJSONTask(json.RawMessage(`{"type":"user-lifecycle"}`)); err != nil {
+		t.Fatal(err)
+	}
+	task := jp.GetTask()
+	if err := task.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ccc := &acron.CronControlCenter{}
+	ccc.SetJRun(acron.NewJRun())
+	if err := task.Run(ccc); err != nil {
+		t.Fatal(err)
+	}
+	if store.saved != 2 || store.accounts[0].GetLifecycleState() != USERSTATE_DELETED || store.accounts[1].GetLifecycleState() != USERSTATE_ACTIVE {
+		t.Errorf("unexpected results: saved %d", store.saved)
+	}
+	if store.accounts[2].GetLifecycleState() != USERSTATE_SUSPENDED {
+		t.Errorf("expected the future suspension to remain")
+	}
+}
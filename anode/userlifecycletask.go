@@ -0,0 +1,122 @@
+package anode
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/acron"
+	"github.com/jpfluger/alibs-slim/areflect"
+)
+
+// TASKTYPE_USERLIFECYCLE applies due timed lifecycle transitions (eg
+// auto-unlock, grace-period deletion) when scheduled as an acron job plan.
+const TASKTYPE_USERLIFECYCLE acron.TaskType = "user-lifecycle"
+
+func init() {
+	_ = areflect.TypeManager().Register(acron.TYPEMANAGER_CRONTASKDATA, "anode", returnTypeManagerCronTaskData)
+}
+
+func returnTypeManagerCronTaskData(typeName string) (reflect.Type, error) {
+	var rtype reflect.Type
+	switch acron.TaskType(typeName) {
+	case TASKTYPE_USERLIFECYCLE:
+		rtype = reflect.TypeOf(TaskUserLifecycle{})
+	case TASKTYPE_ROBOKEYROTATION:
+		rtype = reflect.TypeOf(TaskRoboKeyRotation{})
+	case TASKTYPE_JWTKEYROTATION:
+		rtype = reflect.TypeOf(TaskJWTKeyRotation{})
+	}
+	return rtype, nil
+}
+
+// IUserLifecycleStore loads and saves accounts for timed lifecycle transitions.
+type IUserLifecycleStore interface {
+	// FindUserLifecycleDue returns accounts whose Lifecycle.Until is at or before now.
+	FindUserLifecycleDue(now time.Time) ([]*UserAccount, error)
+	// SaveUserLifecycle persists an account after a transition. Accounts that
+	// reach USERSTATE_DELETED should be purged.
+	SaveUserLifecycle(ua *UserAccount) error
+}
+
+var (
+	userLifecycleStore   IUserLifecycleStore
+	muUserLifecycleStore sync.RWMutex
+)
+
+// SetUserLifecycleStore sets the global store used by TaskUserLifecycle.
+func SetUserLifecycleStore(store IUserLifecycleStore) {
+	muUserLifecycleStore.Lock()
+	defer muUserLifecycleStore.Unlock()
+	userLifecycleStore = store
+}
+
+// USERLIFECYCLESTORE returns the global store.
+func USERLIFECYCLESTORE() IUserLifecycleStore {
+	muUserLifecycleStore.RLock()
+	defer muUserLifecycleStore.RUnlock()
+	return userLifecycleStore
+}
+
+// RunUserLifecycleDue applies due transitions to the accounts in store and
+// returns how many changed. A failing account does not stop the others; the
+// first error is returned.
+func RunUserLifecycleDue(store IUserLifecycleStore, m *UserLifecycleMachine, now time.Time) (int, error) {
+	if store == nil {
+		return 0, fmt.Errorf("user lifecycle store is not set")
+	}
+	if m == nil {
+		m = USERLIFECYCLEMACHINE()
+	}
+	accounts, err := store.FindUserLifecycleDue(now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due user lifecycles; %v", err)
+	}
+	var firstErr error
+	changed := 0
+	for _, ua := range accounts {
+		ok, err := m.ApplyDue(ua, now)
+		if err == nil && ok {
+			if err = store.SaveUserLifecycle(ua); err == nil {
+				changed++
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return changed, firstErr
+}
+
+// TaskUserLifecycle is an acron task that runs RunUserLifecycleDue with the
+// global store and machine.
+type TaskUserLifecycle struct {
+	Type acron.TaskType `json:"type"`
+}
+
+// GetType returns the type of the task.
+func (t *TaskUserLifecycle) GetType() acron.TaskType {
+	return t.Type
+}
+
+// Validate ensures quality control on this struct.
+func (t *TaskUserLifecycle) Validate() error {
+	if t.Type.IsEmpty() {
+		t.Type = TASKTYPE_USERLIFECYCLE
+	}
+	if t.Type != TASKTYPE_USERLIFECYCLE {
+		return fmt.Errorf("invalid task type '%s'", t.Type)
+	}
+	return nil
+}
+
+// Run applies the due transitions.
+func (t *TaskUserLifecycle) Run(ccc acron.ICronControlCenter) error {
+	if ccc == nil || ccc.GetJRun() == nil {
+		return fmt.Errorf("nil cronControlCenter")
+	}
+	changed, err := RunUserLifecycleDue(USERLIFECYCLESTORE(), nil, time.Now().UTC())
+	ccc.GetJRun().Logger().Info().Msgf("user lifecycle: %d accounts transitioned", changed)
+	return err
+}
@@ -0,0 +1,497 @@
+package anode
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWebAuthnVerify            = errors.New("webauthn verification failed")
+	ErrWebAuthnSessionExpired    = errors.New("webauthn session expired")
+	ErrWebAuthnSignCount         = errors.New("webauthn sign count did not increase; the authenticator may be cloned")
+	ErrWebAuthnCredentialExists  = errors.New("webauthn credential already registered")
+	ErrWebAuthnCredentialUnknown = errors.New("webauthn credential not found")
+)
+
+const (
+	WEBAUTHN_CEREMONY_REGISTRATION = "registration"
+	WEBAUTHN_CEREMONY_LOGIN        = "login"
+
+	WEBAUTHN_UV_REQUIRED    = "required"
+	WEBAUTHN_UV_PREFERRED   = "preferred"
+	WEBAUTHN_UV_DISCOURAGED = "discouraged"
+
+	WEBAUTHN_CREDTYPE_PUBLICKEY = "public-key"
+
+	WEBAUTHN_TIMEOUT_DEFAULT = 300 // Seconds.
+	webauthnChallengeSize    = 32
+)
+
+// Authenticator data flags (WebAuthn §6.1).
+const (
+	webauthnFlagUP = 0x01 // User present.
+	webauthnFlagUV = 0x04 // User verified.
+	webauthnFlagBE = 0x08 // Backup eligible.
+	webauthnFlagBS = 0x10 // Backed up.
+	webauthnFlagAT = 0x40 // Attested credential data included.
+)
+
+// WebAuthnRP is a WebAuthn relying party. RPID is the registrable domain the
+// credentials are scoped to (eg "example.com") and Origins are the exact
+// origins allowed to run ceremonies (eg "https://login.example.com").
+type WebAuthnRP struct {
+	RPID             string   `json:"rpId"`
+	RPName           string   `json:"rpName"`
+	Origins          []string `json:"origins"`
+	TimeoutSeconds   int      `json:"timeoutSeconds,omitempty"`
+	UserVerification string   `json:"userVerification,omitempty"` // Applies to registration and second-factor logins; passkey logins always require it.
+	fnNow            func() time.Time
+}
+
+// NewWebAuthnRP creates a relying party. When no origins are given, the
+// origin defaults to https://rpId.
+func NewWebAuthnRP(rpId string, rpName string, origins ...string) (*WebAuthnRP, error) {
+	rp := &WebAuthnRP{RPID: rpId, RPName: rpName, Origins: origins}
+	if err := rp.Validate(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Validate checks the configuration and applies defaults.
+func (rp *WebAuthnRP) Validate() error {
+	rp.RPID = strings.ToLower(strings.TrimSpace(rp.RPID))
+	if rp.RPID == "" || strings.Contains(rp.RPID, "/") || strings.Contains(rp.RPID, ":") {
+		return fmt.Errorf("invalid webauthn rpId '%s'", rp.RPID)
+	}
+	rp.RPName = strings.TrimSpace(rp.RPName)
+	if rp.RPName == "" {
+		rp.RPName = rp.RPID
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{"https://" + rp.RPID}
+	}
+	for ii, origin := range rp.Origins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if !strings.HasPrefix(origin, "https://") && !strings.HasPrefix(origin, "http://localhost") {
+			return fmt.Errorf("invalid webauthn origin '%s'", origin)
+		}
+		rp.Origins[ii] = origin
+	}
+	if rp.TimeoutSeconds <= 0 {
+		rp.TimeoutSeconds = WEBAUTHN_TIMEOUT_DEFAULT
+	}
+	switch rp.UserVerification {
+	case "":
+		rp.UserVerification = WEBAUTHN_UV_PREFERRED
+	case WEBAUTHN_UV_REQUIRED, WEBAUTHN_UV_PREFERRED, WEBAUTHN_UV_DISCOURAGED:
+	default:
+		return fmt.Errorf("invalid webauthn userVerification '%s'", rp.UserVerification)
+	}
+	return nil
+}
+
+func (rp *WebAuthnRP) now() time.Time {
+	if rp.fnNow != nil {
+		return rp.fnNow()
+	}
+	return time.Now().UTC()
+}
+
+// WebAuthnSession is the server-side state of a ceremony between its begin
+// and finish steps. Store it where the client cannot modify it (eg the
+// server session) and use it once.
+type WebAuthnSession struct {
+	Ceremony         string          `json:"ceremony"`
+	Challenge        WebAuthnBytes   `json:"challenge"`
+	UserId           WebAuthnBytes   `json:"userId,omitempty"` // Empty for passkey logins, where the user is not known yet.
+	AllowIds         []WebAuthnBytes `json:"allowIds,omitempty"`
+	UserVerification string          `json:"userVerification"`
+	ExpiresAt        time.Time       `json:"expiresAt"`
+}
+
+// IsPasskeyLogin returns true if the session is a login without a known user.
+func (ws *WebAuthnSession) IsPasskeyLogin() bool {
+	return ws != nil && ws.Ceremony == WEBAUTHN_CEREMONY_LOGIN && len(ws.UserId) == 0
+}
+
+// WebAuthnCredentialDescriptor references a credential in allow and exclude lists.
+type WebAuthnCredentialDescriptor struct {
+	Type       string        `json:"type"`
+	Id         WebAuthnBytes `json:"id"`
+	Transports []string      `json:"transports,omitempty"`
+}
+
+// WebAuthnCredentialParam is an acceptable credential algorithm.
+type WebAuthnCredentialParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// WebAuthnCreationOptions is the JSON form of PublicKeyCredentialCreationOptions.
+type WebAuthnCreationOptions struct {
+	Challenge WebAuthnBytes `json:"challenge"`
+	RP        struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParam      `json:"pubKeyCredParams"`
+	Timeout                int                            `json:"timeout"` // Milliseconds.
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// WebAuthnRequestOptions is the JSON form of PublicKeyCredentialRequestOptions.
+type WebAuthnRequestOptions struct {
+	Challenge        WebAuthnBytes                  `json:"challenge"`
+	Timeout          int                            `json:"timeout"` // Milliseconds.
+	RPId             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnRegistrationResponse is the JSON form of a PublicKeyCredential
+// returned by navigator.credentials.create().
+type WebAuthnRegistrationResponse struct {
+	Id       string        `json:"id"`
+	RawId    WebAuthnBytes `json:"rawId"`
+	Type     string        `json:"type"`
+	Response struct {
+		ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+		AttestationObject WebAuthnBytes `json:"attestationObject"`
+		Transports        []string      `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// WebAuthnLoginResponse is the JSON form of a PublicKeyCredential returned
+// by navigator.credentials.get().
+type WebAuthnLoginResponse struct {
+	Id       string        `json:"id"`
+	RawId    WebAuthnBytes `json:"rawId"`
+	Type     string        `json:"type"`
+	Response struct {
+		ClientDataJSON    WebAuthnBytes `json:"clientDataJSON"`
+		AuthenticatorData WebAuthnBytes `json:"authenticatorData"`
+		Signature         WebAuthnBytes `json:"signature"`
+		UserHandle        WebAuthnBytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// newWebAuthnSession creates the session for a new ceremony.
+func (rp *WebAuthnRP) newWebAuthnSession(ceremony string, userId []byte, userVerification string) (*WebAuthnSession, error) {
+	challenge := make([]byte, webauthnChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to create webauthn challenge; %v", err)
+	}
+	return &WebAuthnSession{
+		Ceremony:         ceremony,
+		Challenge:        challenge,
+		UserId:           userId,
+		UserVerification: userVerification,
+		ExpiresAt:        rp.now().Add(time.Duration(rp.TimeoutSeconds) * time.Second),
+	}, nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create()
+// and the session to keep until FinishRegistration. Existing credentials
+// are excluded so an authenticator is not registered twice. Credentials are
+// requested as discoverable so they can be used for passkey logins.
+func (rp *WebAuthnRP) BeginRegistration(user *WebAuthnUser, existing WebAuthnCredentials) (*WebAuthnCreationOptions, *WebAuthnSession, error) {
+	if user == nil || len(user.Id) == 0 || len(user.Id) > 64 || user.Name == "" {
+		return nil, nil, fmt.Errorf("invalid webauthn user")
+	}
+	session, err := rp.newWebAuthnSession(WEBAUTHN_CEREMONY_REGISTRATION, user.Id, rp.UserVerification)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &WebAuthnCreationOptions{
+		Challenge: session.Challenge,
+		User:      *user,
+		PubKeyCredParams: []WebAuthnCredentialParam{
+			{Type: WEBAUTHN_CREDTYPE_PUBLICKEY, Alg: COSEALG_ES256},
+			{Type: WEBAUTHN_CREDTYPE_PUBLICKEY, Alg: COSEALG_EDDSA},
+			{Type: WEBAUTHN_CREDTYPE_PUBLICKEY, Alg: COSEALG_RS256},
+		},
+		Timeout:            rp.TimeoutSeconds * 1000,
+		ExcludeCredentials: existing.descriptors(),
+		Attestation:        "none",
+	}
+	opts.RP.Id, opts.RP.Name = rp.RPID, rp.RPName
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = rp.UserVerification
+	return opts, session, nil
+}
+
+// FinishRegistration verifies the authenticator response and returns the
+// new credential for storage (eg on UserVault.WebAuthn). Attestation is not
+// checked against a trust store; "none" and "packed" statements are accepted.
+func (rp *WebAuthnRP) FinishRegistration(session *WebAuthnSession, resp *WebAuthnRegistrationResponse, existing WebAuthnCredentials, nickname string) (*WebAuthnCredential, error) {
+	if err := rp.checkSession(session, WEBAUTHN_CEREMONY_REGISTRATION); err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Type != WEBAUTHN_CREDTYPE_PUBLICKEY {
+		return nil, fmt.Errorf("%w; invalid credential type", ErrWebAuthnVerify)
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.create", session.Challenge); err != nil {
+		return nil, err
+	}
+
+	attObj, _, err := cborMap(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid attestation object; %v", ErrWebAuthnVerify, err)
+	}
+	rawAuthData, _ := attObj["authData"].([]byte)
+	format, _ := attObj["fmt"].(string)
+	attStmt, _ := attObj["attStmt"].(map[interface{}]interface{})
+	authData, err := rp.parseAuthData(rawAuthData, session.UserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&webauthnFlagAT == 0 || len(authData.credentialId) == 0 {
+		return nil, fmt.Errorf("%w; missing attested credential data", ErrWebAuthnVerify)
+	}
+	if !bytes.Equal(authData.credentialId, resp.RawId) {
+		return nil, fmt.Errorf("%w; credential id mismatch", ErrWebAuthnVerify)
+	}
+	if len(authData.credentialId) > 1023 {
+		return nil, fmt.Errorf("%w; credential id too long", ErrWebAuthnVerify)
+	}
+	if existing.Find(authData.credentialId) != nil {
+		return nil, ErrWebAuthnCredentialExists
+	}
+	pub, alg, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w; %v", ErrWebAuthnVerify, err)
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	switch format {
+	case "none":
+		if len(attStmt) != 0 {
+			return nil, fmt.Errorf("%w; unexpected attestation statement", ErrWebAuthnVerify)
+		}
+	case "packed":
+		stmtAlg, _ := attStmt["alg"].(int64)
+		sig, _ := attStmt["sig"].([]byte)
+		if x5c, ok := attStmt["x5c"].([]interface{}); ok && len(x5c) > 0 {
+			der, _ := x5c[0].([]byte)
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("%w; invalid attestation certificate; %v", ErrWebAuthnVerify, err)
+			}
+			if !coseVerify(cert.PublicKey, stmtAlg, signed, sig) {
+				return nil, fmt.Errorf("%w; invalid attestation signature", ErrWebAuthnVerify)
+			}
+		} else if stmtAlg != alg || !coseVerify(pub, alg, signed, sig) {
+			return nil, fmt.Errorf("%w; invalid self attestation signature", ErrWebAuthnVerify)
+		}
+	default:
+		return nil, fmt.Errorf("%w; unsupported attestation format '%s'", ErrWebAuthnVerify, format)
+	}
+
+	return &WebAuthnCredential{
+		Id:             authData.credentialId,
+		PublicKey:      authData.publicKey,
+		Alg:            alg,
+		SignCount:      authData.signCount,
+		Transports:     resp.Response.Transports,
+		Nickname:       strings.TrimSpace(nickname),
+		AAGUID:         authData.aaguid,
+		BackupEligible: authData.flags&webauthnFlagBE != 0,
+		BackedUp:       authData.flags&webauthnFlagBS != 0,
+		Created:        rp.now(),
+	}, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get() and the
+// session to keep until FinishLogin. When user is nil the login is
+// passkey-only: the browser offers any discoverable credential for the RPID
+// and user verification is required. Otherwise the login is limited to the
+// user's credentials, eg as a second factor after a password.
+func (rp *WebAuthnRP) BeginLogin(user *WebAuthnUser, credentials WebAuthnCredentials) (*WebAuthnRequestOptions, *WebAuthnSession, error) {
+	var userId []byte
+	uv := WEBAUTHN_UV_REQUIRED
+	if user != nil {
+		if len(credentials) == 0 {
+			return nil, nil, ErrWebAuthnCredentialUnknown
+		}
+		userId, uv = user.Id, rp.UserVerification
+	}
+	session, err := rp.newWebAuthnSession(WEBAUTHN_CEREMONY_LOGIN, userId, uv)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &WebAuthnRequestOptions{
+		Challenge:        session.Challenge,
+		Timeout:          rp.TimeoutSeconds * 1000,
+		RPId:             rp.RPID,
+		AllowCredentials: []WebAuthnCredentialDescriptor{},
+		UserVerification: uv,
+	}
+	if user != nil {
+		opts.AllowCredentials = credentials.descriptors()
+		for _, d := range opts.AllowCredentials {
+			session.AllowIds = append(session.AllowIds, d.Id)
+		}
+	}
+	return opts, session, nil
+}
+
+// FinishLogin verifies an assertion made with credential, which the caller
+// looks up by resp.RawId. For passkey logins the caller must also check that
+// credential belongs to the account named by resp.Response.UserHandle. On
+// success the credential's sign count and last use are updated and should
+// be saved.
+func (rp *WebAuthnRP) FinishLogin(session *WebAuthnSession, resp *WebAuthnLoginResponse, credential *WebAuthnCredential) error {
+	if err := rp.checkSession(session, WEBAUTHN_CEREMONY_LOGIN); err != nil {
+		return err
+	}
+	if resp == nil || resp.Type != WEBAUTHN_CREDTYPE_PUBLICKEY {
+		return fmt.Errorf("%w; invalid credential type", ErrWebAuthnVerify)
+	}
+	if credential == nil || !bytes.Equal(credential.Id, resp.RawId) {
+		return ErrWebAuthnCredentialUnknown
+	}
+	if len(session.AllowIds) > 0 {
+		allowed := false
+		for _, id := range session.AllowIds {
+			allowed = allowed || bytes.Equal(id, resp.RawId)
+		}
+		if !allowed {
+			return ErrWebAuthnCredentialUnknown
+		}
+	}
+	userHandle := resp.Response.UserHandle
+	if session.IsPasskeyLogin() && len(userHandle) == 0 {
+		return fmt.Errorf("%w; passkey login requires a user handle", ErrWebAuthnVerify)
+	}
+	if len(session.UserId) > 0 && len(userHandle) > 0 && !bytes.Equal(session.UserId, userHandle) {
+		return fmt.Errorf("%w; user handle mismatch", ErrWebAuthnVerify)
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.get", session.Challenge); err != nil {
+		return err
+	}
+	authData, err := rp.parseAuthData(resp.Response.AuthenticatorData, session.UserVerification)
+	if err != nil {
+		return err
+	}
+
+	pub, alg, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w; %v", ErrWebAuthnVerify, err)
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !coseVerify(pub, alg, signed, resp.Response.Signature) {
+		return fmt.Errorf("%w; invalid signature", ErrWebAuthnVerify)
+	}
+
+	// Authenticators that keep a counter must increase it; synced passkeys report zero.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return ErrWebAuthnSignCount
+	}
+	now := rp.now()
+	credential.SignCount = authData.signCount
+	credential.BackedUp = authData.flags&webauthnFlagBS != 0
+	credential.LastUsed = &now
+	return nil
+}
+
+// checkSession verifies the session is for ceremony and has not expired.
+func (rp *WebAuthnRP) checkSession(session *WebAuthnSession, ceremony string) error {
+	if session == nil || session.Ceremony != ceremony || len(session.Challenge) == 0 {
+		return fmt.Errorf("%w; no %s session", ErrWebAuthnVerify, ceremony)
+	}
+	if !rp.now().Before(session.ExpiresAt) {
+		return ErrWebAuthnSessionExpired
+	}
+	return nil
+}
+
+// checkClientData verifies the type, challenge and origin of clientDataJSON.
+func (rp *WebAuthnRP) checkClientData(raw []byte, typ string, challenge []byte) error {
+	var cd struct {
+		Type        string        `json:"type"`
+		Challenge   WebAuthnBytes `json:"challenge"`
+		Origin      string        `json:"origin"`
+		CrossOrigin bool          `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w; invalid client data; %v", ErrWebAuthnVerify, err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w; unexpected client data type '%s'", ErrWebAuthnVerify, cd.Type)
+	}
+	if subtle.ConstantTimeCompare(cd.Challenge, challenge) != 1 {
+		return fmt.Errorf("%w; challenge mismatch", ErrWebAuthnVerify)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w; cross-origin ceremony", ErrWebAuthnVerify)
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w; unexpected origin '%s'", ErrWebAuthnVerify, cd.Origin)
+}
+
+type webauthnAuthData struct {
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialId []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses authenticator data and checks the RPID hash and the
+// user presence and verification flags.
+func (rp *WebAuthnRP) parseAuthData(b []byte, userVerification string) (*webauthnAuthData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w; authenticator data too short", ErrWebAuthnVerify)
+	}
+	rpIdHash := sha256.Sum256([]byte(rp.RPID))
+	if subtle.ConstantTimeCompare(b[:32], rpIdHash[:]) != 1 {
+		return nil, fmt.Errorf("%w; rpId hash mismatch", ErrWebAuthnVerify)
+	}
+	ad := &webauthnAuthData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.flags&webauthnFlagUP == 0 {
+		return nil, fmt.Errorf("%w; user not present", ErrWebAuthnVerify)
+	}
+	if userVerification == WEBAUTHN_UV_REQUIRED && ad.flags&webauthnFlagUV == 0 {
+		return nil, fmt.Errorf("%w; user not verified", ErrWebAuthnVerify)
+	}
+	if ad.flags&webauthnFlagAT != 0 {
+		rest := b[37:]
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w; attested credential data too short", ErrWebAuthnVerify)
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLen {
+			return nil, fmt.Errorf("%w; attested credential data too short", ErrWebAuthnVerify)
+		}
+		ad.aaguid = append([]byte(nil), rest[:16]...)
+		ad.credentialId = append([]byte(nil), rest[18:18+idLen]...)
+		_, n, err := cborDecode(rest[18+idLen:])
+		if err != nil {
+			return nil, fmt.Errorf("%w; invalid credential public key; %v", ErrWebAuthnVerify, err)
+		}
+		ad.publicKey = append([]byte(nil), rest[18+idLen:18+idLen+n]...)
+	}
+	return ad, nil
+}
@@ -0,0 +1,319 @@
+package anode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/auser"
+)
+
+// cborTestEncode is a minimal CBOR encoder for building authenticator output.
+func cborTestEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 65536:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+	switch t := v.(type) {
+	case int:
+		if t < 0 {
+			return head(1, uint64(-1-t))
+		}
+		return head(0, uint64(t))
+	case []byte:
+		return append(head(2, uint64(len(t))), t...)
+	case string:
+		return append(head(3, uint64(len(t))), t...)
+	case []interface{}:
+		out := head(4, uint64(len(t)))
+		for _, item := range t {
+			out = append(out, cborTestEncode(item)...)
+		}
+		return out
+	case [][2]interface{}: // Ordered map entries.
+		out := head(5, uint64(len(t)))
+		for _, kv := range t {
+			out = append(out, cborTestEncode(kv[0])...)
+			out = append(out, cborTestEncode(kv[1])...)
+		}
+		return out
+	}
+	panic("unsupported cbor test type")
+}
+
+// softAuthenticator is a software WebAuthn authenticator for tests.
+type softAuthenticator struct {
+	signer     crypto.Signer
+	alg        int
+	credId     []byte
+	userHandle []byte
+	signCount  uint32
+	flags      byte
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	sa := &softAuthenticator{alg: alg, credId: make([]byte, 16), flags: webauthnFlagUP | webauthnFlagUV}
+	_, _ = rand.Read(sa.credId)
+	var err error
+	if alg == COSEALG_EDDSA {
+		_, sa.signer, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		sa.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sa
+}
+
+func (sa *softAuthenticator) coseKey() []byte {
+	if sa.alg == COSEALG_EDDSA {
+		return cborTestEncode([][2]interface{}{{1, coseKtyOKP}, {3, COSEALG_EDDSA}, {-1, coseCrvEd255}, {-2, []byte(sa.signer.Public().(ed25519.PublicKey))}})
+	}
+	pub, _ := sa.signer.Public().(*ecdsa.PublicKey).Bytes()
+	return cborTestEncode([][2]interface{}{{1, coseKtyEC2}, {3, COSEALG_ES256}, {-1, coseCrvP256}, {-2, pub[1:33]}, {-3, pub[33:]}})
+}
+
+func (sa *softAuthenticator) authData(rpId string, attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	out := append([]byte{}, rpIdHash[:]...)
+	flags := sa.flags
+	if attested {
+		flags |= webauthnFlagAT
+	}
+	out = append(out, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[33:], sa.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...)
+		out = append(out, byte(len(sa.credId)>>8), byte(len(sa.credId)))
+		out = append(out, sa.credId...)
+		out = append(out, sa.coseKey()...)
+	}
+	return out
+}
+
+func (sa *softAuthenticator) sign(data []byte) []byte {
+	var sig []byte
+	if sa.alg == COSEALG_EDDSA {
+		sig, _ = sa.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(data)
+		sig, _ = sa.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	return sig
+}
+
+func softClientData(typ string, challenge []byte, origin string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"type": typ, "challenge": WebAuthnBytes(challenge).String(), "origin": origin})
+	return b
+}
+
+func (sa *softAuthenticator) create(opts *WebAuthnCreationOptions, origin string, format string) *WebAuthnRegistrationResponse {
+	sa.userHandle = opts.User.Id
+	authData := sa.authData(opts.RP.Id, true)
+	clientData := softClientData("webauthn.create", opts.Challenge, origin)
+	stmt := [][2]interface{}{}
+	if format == "packed" {
+		hash := sha256.Sum256(clientData)
+		stmt = [][2]interface{}{{"alg", sa.alg}, {"sig", sa.sign(append(append([]byte{}, authData...), hash[:]...))}}
+	}
+	resp := &WebAuthnRegistrationResponse{Id: WebAuthnBytes(sa.credId).String(), RawId: sa.credId, Type: WEBAUTHN_CREDTYPE_PUBLICKEY}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AttestationObject = cborTestEncode([][2]interface{}{{"fmt", format}, {"attStmt", stmt}, {"authData", authData}})
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+func (sa *softAuthenticator) get(opts *WebAuthnRequestOptions, origin string) *WebAuthnLoginResponse {
+	sa.signCount++
+	authData := sa.authData(opts.RPId, false)
+	clientData := softClientData("webauthn.get", opts.Challenge, origin)
+	hash := sha256.Sum256(clientData)
+	resp := &WebAuthnLoginResponse{Id: WebAuthnBytes(sa.credId).String(), RawId: sa.credId, Type: WEBAUTHN_CREDTYPE_PUBLICKEY}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sa.sign(append(append([]byte{}, authData...), hash[:]...))
+	resp.Response.UserHandle = sa.userHandle
+	return resp
+}
+
+// roundTrip sends v through JSON as a browser would.
+func roundTrip[T any](t *testing.T, v *T) *T {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(T)
+	if err = json.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	const origin = "https://login.example.com"
+	rp, err := NewWebAuthnRP("example.com", "Example", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := auser.NewUID()
+	user := NewWebAuthnUser(uid, "alice@example.com", "")
+
+	for _, tc := range []struct {
+		alg    int
+		format string
+	}{{COSEALG_ES256, "none"}, {COSEALG_EDDSA, "packed"}} {
+		vault := &UserVault{}
+		sa := newSoftAuthenticator(t, tc.alg)
+
+		opts, session, err := rp.BeginRegistration(user, vault.WebAuthn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = roundTrip(t, opts)
+		resp := roundTrip(t, sa.create(opts, origin, tc.format))
+		cred, err := rp.FinishRegistration(session, resp, vault.WebAuthn, " Laptop ")
+		if err != nil {
+			t.Fatalf("alg %d: %v", tc.alg, err)
+		}
+		if cred.Alg != int64(tc.alg) || cred.Nickname != "Laptop" || len(cred.Transports) != 1 {
+			t.Errorf("unexpected credential %+v", cred)
+		}
+		vault.WebAuthn = append(vault.WebAuthn, cred)
+		if !vault.HasWebAuthn() {
+			t.Errorf("expected HasWebAuthn")
+		}
+
+		// The same authenticator cannot register twice.
+		opts, session, _ = rp.BeginRegistration(user, vault.WebAuthn)
+		if len(opts.ExcludeCredentials) != 1 {
+			t.Errorf("expected the credential to be excluded")
+		}
+		if _, err = rp.FinishRegistration(session, sa.create(opts, origin, tc.format), vault.WebAuthn, ""); !errors.Is(err, ErrWebAuthnCredentialExists) {
+			t.Errorf("expected duplicate credential, got %v", err)
+		}
+
+		// Second-factor login limited to the user's credentials.
+		reqOpts, session, err := rp.BeginLogin(user, vault.WebAuthn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqOpts.AllowCredentials) != 1 || reqOpts.UserVerification != WEBAUTHN_UV_PREFERRED {
+			t.Errorf("unexpected request options %+v", reqOpts)
+		}
+		login := roundTrip(t, sa.get(roundTrip(t, reqOpts), origin))
+		if err = rp.FinishLogin(session, login, vault.WebAuthn.Find(login.RawId)); err != nil {
+			t.Fatalf("alg %d: %v", tc.alg, err)
+		}
+		if cred.SignCount != 1 || cred.LastUsed == nil {
+			t.Errorf("expected sign count and last use to update, got %d", cred.SignCount)
+		}
+
+		// Passkey-only login finds the user by the returned user handle.
+		reqOpts, session, _ = rp.BeginLogin(nil, nil)
+		if len(reqOpts.AllowCredentials) != 0 || reqOpts.UserVerification != WEBAUTHN_UV_REQUIRED || !session.IsPasskeyLogin() {
+			t.Errorf("unexpected passkey options %+v", reqOpts)
+		}
+		login = sa.get(reqOpts, origin)
+		if string(login.Response.UserHandle) != uid.String() {
+			t.Errorf("expected user handle %s", uid)
+		}
+		if err = rp.FinishLogin(session, login, vault.WebAuthn.Find(login.RawId)); err != nil {
+			t.Fatal(err)
+		}
+
+		// A replayed assertion fails the challenge and sign count checks.
+		if err = rp.FinishLogin(session, login, cred); !errors.Is(err, ErrWebAuthnSignCount) {
+			t.Errorf("expected sign count error, got %v", err)
+		}
+	}
+}
+
+func TestWebAuthnLoginRejects(t *testing.T) {
+	const origin = "https://example.com"
+	now := time.Now()
+	rp, _ := NewWebAuthnRP("example.com", "")
+	rp.fnNow = func() time.Time { return now }
+	user := NewWebAuthnUser(auser.NewUID(), "bob", "Bob")
+	sa := newSoftAuthenticator(t, COSEALG_ES256)
+	opts, session, _ := rp.BeginRegistration(user, nil)
+	cred, err := rp.FinishRegistration(session, sa.create(opts, origin, "none"), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := WebAuthnCredentials{cred}
+
+	tests := []struct {
+		name      string
+		mutate    func(resp *WebAuthnLoginResponse)
+		origin    string
+		signCount uint32 // Stored sign count before the login.
+		want      error
+	}{
+		{"wrong origin", nil, "https://evil.example.net", 0, ErrWebAuthnVerify},
+		{"bad signature", func(resp *WebAuthnLoginResponse) { resp.Response.Signature[8] ^= 0xff }, origin, 0, ErrWebAuthnVerify},
+		{"wrong user handle", func(resp *WebAuthnLoginResponse) { resp.Response.UserHandle = []byte("someone-else") }, origin, 0, ErrWebAuthnVerify},
+		{"cloned counter", nil, origin, 5, ErrWebAuthnSignCount},
+	}
+	for _, tt := range tests {
+		reqOpts, session, _ := rp.BeginLogin(user, creds)
+		sa.signCount, cred.SignCount = 0, tt.signCount
+		resp := sa.get(reqOpts, tt.origin)
+		if tt.mutate != nil {
+			tt.mutate(resp)
+		}
+		if err := rp.FinishLogin(session, resp, cred); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	// Passkey login without user verification is rejected.
+	sa.flags = webauthnFlagUP
+	reqOpts, session, _ := rp.BeginLogin(nil, nil)
+	cred.SignCount = 0
+	sa.signCount = 0
+	if err := rp.FinishLogin(session, sa.get(reqOpts, origin), cred); !errors.Is(err, ErrWebAuthnVerify) {
+		t.Errorf("expected uv error, got %v", err)
+	}
+
+	// Sessions expire.
+	sa.flags = webauthnFlagUP | webauthnFlagUV
+	reqOpts, session, _ = rp.BeginLogin(user, creds)
+	now = now.Add(time.Duration(rp.TimeoutSeconds+1) * time.Second)
+	if err := rp.FinishLogin(session, sa.get(reqOpts, origin), cred); !errors.Is(err, ErrWebAuthnSessionExpired) {
+		t.Errorf("expected expired session, got %v", err)
+	}
+}
+
+func TestUserAccount_IsOnMFA(t *testing.T) {
+	ua := &UserAccount{}
+	if ua.IsOnMFA() {
+		t.Errorf("expected MFA off")
+	}
+	now := time.Now()
+	ua.MFA.WebAuthn = UserAccountIsOnMFA{IsOn: true, Created: &now}
+	if ua.IsOnMFA() {
+		t.Errorf("expected unverified WebAuthn to not count")
+	}
+	ua.MFA.WebAuthn.Verified = &now
+	if !ua.IsOnMFA() {
+		t.Errorf("expected MFA on with verified WebAuthn")
+	}
+}
@@ -0,0 +1,287 @@
+package anode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// WebAuthn only needs to read a small subset of CBOR (RFC 8949): the
+// attestation object, attestation statements and COSE public keys. All of
+// them use definite lengths, so indefinite-length items are rejected.
+
+const cborMaxDepth = 16
+
+type cborDecoder struct {
+	b   []byte
+	pos int
+}
+
+// cborDecode decodes the first CBOR item in b and returns it with the number
+// of bytes it used. Maps decode to map[interface{}]interface{} with int64 or
+// string keys, integers to int64, byte strings to []byte and text to string.
+func cborDecode(b []byte) (interface{}, int, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.b) {
+		return nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	out := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return out, nil
+}
+
+func (d *cborDecoder) head() (major byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		b, err = d.next(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(b[0]), nil
+	case info == 25:
+		b, err = d.next(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err = d.next(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err = d.next(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(b), nil
+	}
+	return 0, 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+}
+
+func (d *cborDecoder) length(arg uint64) (int, error) {
+	if arg > uint64(len(d.b)-d.pos) {
+		return 0, fmt.Errorf("cbor: length %d exceeds data", arg)
+	}
+	return int(arg), nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("cbor: nesting too deep")
+	}
+	start := d.pos
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := d.next(n)
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case 5:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m[k]; dup {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", k)
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6:
+		return d.decode(depth + 1) // Tags are not meaningful here; return the tagged item.
+	case 7:
+		switch d.b[start] & 0x1f {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25, 26, 27:
+			return float64(0), nil // Floats are not used by WebAuthn; consume and ignore.
+		}
+	}
+	return nil, fmt.Errorf("cbor: unsupported item 0x%02x", d.b[start])
+}
+
+// cborMap decodes b as a CBOR map and returns any trailing bytes.
+func cborMap(b []byte) (map[interface{}]interface{}, []byte, error) {
+	v, n, err := cborDecode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("cbor: expected map, got %T", v)
+	}
+	return m, b[n:], nil
+}
+
+// COSE algorithm identifiers (RFC 9053) supported for WebAuthn credentials.
+const (
+	COSEALG_ES256 = -7
+	COSEALG_EDDSA = -8
+	COSEALG_RS256 = -257
+)
+
+const (
+	coseKeyKty   = 1
+	coseKeyAlg   = 3
+	coseKeyCrv   = -1 // Also "n" for RSA.
+	coseKeyX     = -2 // Also "e" for RSA.
+	coseKeyY     = -3
+	coseKtyOKP   = 1
+	coseKtyEC2   = 2
+	coseKtyRSA   = 3
+	coseCrvP256  = 1
+	coseCrvEd255 = 6
+)
+
+// coseInt returns an integer value of a COSE map.
+func coseInt(m map[interface{}]interface{}, key int64) (int64, bool) {
+	v, ok := m[key].(int64)
+	return v, ok
+}
+
+// coseBytes returns a byte string value of a COSE map.
+func coseBytes(m map[interface{}]interface{}, key int64) []byte {
+	v, _ := m[key].([]byte)
+	return v
+}
+
+// parseCOSEKey parses a CBOR-encoded COSE_Key into a public key and its alg.
+func parseCOSEKey(b []byte) (crypto.PublicKey, int64, error) {
+	m, _, err := cborMap(b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode cose key; %v", err)
+	}
+	kty, _ := coseInt(m, coseKeyKty)
+	alg, ok := coseInt(m, coseKeyAlg)
+	if !ok {
+		return nil, 0, fmt.Errorf("cose key has no alg")
+	}
+	switch {
+	case kty == coseKtyEC2 && alg == COSEALG_ES256:
+		crv, _ := coseInt(m, coseKeyCrv)
+		x, y := coseBytes(m, coseKeyX), coseBytes(m, coseKeyY)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("invalid cose ec2 key")
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid cose ec2 key; %v", err)
+		}
+		return pub, alg, nil
+	case kty == coseKtyOKP && alg == COSEALG_EDDSA:
+		crv, _ := coseInt(m, coseKeyCrv)
+		x := coseBytes(m, coseKeyX)
+		if crv != coseCrvEd255 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("invalid cose okp key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == coseKtyRSA && alg == COSEALG_RS256:
+		n, e := coseBytes(m, coseKeyCrv), coseBytes(m, coseKeyX)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("invalid cose rsa key")
+		}
+		eInt := 0
+		for _, c := range e {
+			eInt = eInt<<8 | int(c)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: eInt}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported cose key kty %d alg %d", kty, alg)
+}
+
+// coseVerify verifies sig over data with pub using the COSE alg.
+func coseVerify(pub crypto.PublicKey, alg int64, data, sig []byte) bool {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if alg != COSEALG_ES256 {
+			return false
+		}
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return alg == COSEALG_EDDSA && ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		if alg != COSEALG_RS256 {
+			return false
+		}
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package anode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jpfluger/alibs-slim/auser"
+)
+
+// WebAuthnBytes is a byte slice that marshals to unpadded base64url, the
+// encoding WebAuthn JSON uses for ids, challenges and authenticator data.
+type WebAuthnBytes []byte
+
+// String returns the base64url encoding.
+func (wb WebAuthnBytes) String() string {
+	return base64.RawURLEncoding.EncodeToString(wb)
+}
+
+// MarshalJSON encodes the bytes as a base64url string.
+func (wb WebAuthnBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(wb.String())
+}
+
+// UnmarshalJSON decodes a base64url string, with or without padding.
+func (wb *WebAuthnBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*wb = b
+	return nil
+}
+
+// WebAuthnUser identifies the account a credential is registered to.
+// Id is the WebAuthn user handle: it is stored on the authenticator and
+// returned by passkey logins, so it must not contain personal data.
+type WebAuthnUser struct {
+	Id          WebAuthnBytes `json:"id"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"displayName"`
+}
+
+// NewWebAuthnUser creates a WebAuthnUser whose user handle is the UID.
+func NewWebAuthnUser(uid auser.UID, name string, displayName string) *WebAuthnUser {
+	name = strings.TrimSpace(name)
+	displayName = strings.TrimSpace(displayName)
+	if displayName == "" {
+		displayName = name
+	}
+	return &WebAuthnUser{Id: WebAuthnBytes(uid.String()), Name: name, DisplayName: displayName}
+}
+
+// WebAuthnCredential is a registered authenticator (eg a passkey or security key).
+type WebAuthnCredential struct {
+	// Id is the credential id chosen by the authenticator.
+	Id WebAuthnBytes `json:"id"`
+
+	// PublicKey is the COSE-encoded credential public key.
+	PublicKey WebAuthnBytes `json:"publicKey"`
+
+	// Alg is the COSE algorithm of PublicKey.
+	Alg int64 `json:"alg"`
+
+	// SignCount is the last signature counter reported by the authenticator.
+	SignCount uint32 `json:"signCount"`
+
+	// Transports are hints (eg "usb", "internal", "hybrid") passed back to the browser at login.
+	Transports []string `json:"transports,omitempty"`
+
+	// Nickname is a user-chosen label, eg "Work laptop".
+	Nickname string `json:"nickname,omitempty"`
+
+	// AAGUID identifies the authenticator model, when disclosed.
+	AAGUID WebAuthnBytes `json:"aaguid,omitempty"`
+
+	// BackupEligible and BackedUp report whether the credential is a synced passkey.
+	BackupEligible bool `json:"backupEligible,omitempty"`
+	BackedUp       bool `json:"backedUp,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+// WebAuthnCredentials is a list of registered credentials.
+type WebAuthnCredentials []*WebAuthnCredential
+
+// Find returns the credential with id or nil.
+func (wcs WebAuthnCredentials) Find(id []byte) *WebAuthnCredential {
+	for _, wc := range wcs {
+		if wc != nil && bytes.Equal(wc.Id, id) {
+			return wc
+		}
+	}
+	return nil
+}
+
+// Remove returns the list without the credential with id.
+func (wcs WebAuthnCredentials) Remove(id []byte) WebAuthnCredentials {
+	out := WebAuthnCredentials{}
+	for _, wc := range wcs {
+		if wc != nil && !bytes.Equal(wc.Id, id) {
+			out = append(out, wc)
+		}
+	}
+	return out
+}
+
+// descriptors returns the credentials as allow/exclude list entries.
+func (wcs WebAuthnCredentials) descriptors() []WebAuthnCredentialDescriptor {
+	out := []WebAuthnCredentialDescriptor{}
+	for _, wc := range wcs {
+		if wc != nil {
+			out = append(out, WebAuthnCredentialDescriptor{Type: WEBAUTHN_CREDTYPE_PUBLICKEY, Id: wc.Id, Transports: wc.Transports})
+		}
+	}
+	return out
+}
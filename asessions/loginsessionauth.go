@@ -0,0 +1,151 @@
+package asessions
+
+import (
+	"strings"
+	"time"
+)
+
+// AuthMethod is how the user last proved their identity.
+type AuthMethod string
+
+const (
+	AUTHMETHOD_PASSWORD   AuthMethod = "password"
+	AUTHMETHOD_MAGICLINK  AuthMethod = "magic-link"
+	AUTHMETHOD_EMAILOTP   AuthMethod = "email-otp"
+	AUTHMETHOD_SSO        AuthMethod = "sso"
+	AUTHMETHOD_TOTP       AuthMethod = "totp"        // Second factor after a password.
+	AUTHMETHOD_BACKUPCODE AuthMethod = "backup-code" // Second factor after a password.
+	AUTHMETHOD_WEBAUTHN   AuthMethod = "webauthn"    // Passkey with user verification.
+)
+
+// IsEmpty checks if the method is empty after trimming whitespace.
+func (m AuthMethod) IsEmpty() bool {
+	return strings.TrimSpace(string(m)) == ""
+}
+
+// String returns the method as a string.
+func (m AuthMethod) String() string {
+	return string(m)
+}
+
+// Level returns the assurance level of the method. Unknown methods are
+// single-factor.
+func (m AuthMethod) Level() AuthLevel {
+	switch m {
+	case "":
+		return AUTHLEVEL_NONE
+	case AUTHMETHOD_TOTP, AUTHMETHOD_BACKUPCODE, AUTHMETHOD_WEBAUTHN:
+		return AUTHLEVEL_MFA
+	}
+	return AUTHLEVEL_SINGLE
+}
+
+// AuthLevel is the assurance level of an authentication.
+type AuthLevel int
+
+const (
+	AUTHLEVEL_NONE   AuthLevel = iota
+	AUTHLEVEL_SINGLE           // One factor, eg a password.
+	AUTHLEVEL_MFA              // Multiple factors or a verified passkey.
+)
+
+// StepUpRequirement is what a sensitive route requires of the session's
+// authentication. Zero values are not checked.
+type StepUpRequirement struct {
+	// MaxAuthAge is the longest time since the user last authenticated.
+	MaxAuthAge time.Duration `json:"maxAuthAge,omitempty"`
+
+	// MinLevel is the lowest accepted assurance level.
+	MinLevel AuthLevel `json:"minLevel,omitempty"`
+}
+
+// IsEmpty returns true if nothing is required.
+func (req StepUpRequirement) IsEmpty() bool {
+	return req.MaxAuthAge <= 0 && req.MinLevel <= AUTHLEVEL_NONE
+}
+
+// LoginSessionAuth records the session's authentication and any step-up
+// elevation. While elevated, the auth age is measured from the later of the
+// login and the step-up, and the level is the higher of the two.
+type LoginSessionAuth struct {
+	// Method and Time are of the login.
+	Method AuthMethod `json:"method,omitempty"`
+	Time   time.Time  `json:"time"`
+
+	// ElevatedMethod and ElevatedAt are of the last step-up, which expires
+	// at ElevatedUntil.
+	ElevatedMethod AuthMethod `json:"elevatedMethod,omitempty"`
+	ElevatedAt     *time.Time `json:"elevatedAt,omitempty"`
+	ElevatedUntil  *time.Time `json:"elevatedUntil,omitempty"`
+}
+
+// SetAuthenticated records a login and clears any elevation. For a login
+// with a second factor, pass the method of the last factor.
+func (a *LoginSessionAuth) SetAuthenticated(method AuthMethod, at time.Time) {
+	a.Method = method
+	a.Time = at
+	a.ElevatedMethod = ""
+	a.ElevatedAt = nil
+	a.ElevatedUntil = nil
+}
+
+// Elevate records a step-up by method that lasts for window.
+func (a *LoginSessionAuth) Elevate(method AuthMethod, at time.Time, window time.Duration) {
+	until := at.Add(window)
+	a.ElevatedMethod = method
+	a.ElevatedAt = &at
+	a.ElevatedUntil = &until
+}
+
+// IsElevated returns true if a step-up is in effect at now.
+func (a *LoginSessionAuth) IsElevated(now time.Time) bool {
+	return a != nil && a.ElevatedUntil != nil && now.Before(*a.ElevatedUntil)
+}
+
+// GetLevel returns the assurance level in effect at now.
+func (a *LoginSessionAuth) GetLevel(now time.Time) AuthLevel {
+	if a == nil {
+		return AUTHLEVEL_NONE
+	}
+	level := a.Method.Level()
+	if a.IsElevated(now) && a.ElevatedMethod.Level() > level {
+		level = a.ElevatedMethod.Level()
+	}
+	return level
+}
+
+// GetAuthTime returns when the user last authenticated at now: the later of
+// the login and a step-up in effect.
+func (a *LoginSessionAuth) GetAuthTime(now time.Time) time.Time {
+	if a == nil {
+		return time.Time{}
+	}
+	if a.IsElevated(now) && a.ElevatedAt != nil && a.ElevatedAt.After(a.Time) {
+		return *a.ElevatedAt
+	}
+	return a.Time
+}
+
+// IsFresh returns true if the last authentication is no older than maxAge
+// at now.
+func (a *LoginSessionAuth) IsFresh(maxAge time.Duration, now time.Time) bool {
+	if a == nil {
+		return false
+	}
+	if maxAge <= 0 {
+		return true
+	}
+	at := a.GetAuthTime(now)
+	return !at.IsZero() && now.Sub(at) <= maxAge
+}
+
+// Satisfies returns true if the authentication meets req at now.
+func (a *LoginSessionAuth) Satisfies(req StepUpRequirement, now time.Time) bool {
+	return a.GetLevel(now) >= req.MinLevel && a.IsFresh(req.MaxAuthAge, now)
+}
+
+// ILoginSessionAuth is implemented by sessions that track authentication for step-up checks.
+type ILoginSessionAuth interface {
+	// GetAuth returns the authentication record of the session.
+	GetAuth() *LoginSessionAuth
+}
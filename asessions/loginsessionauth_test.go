@@ -0,0 +1,57 @@
+package asessions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMethod_Level(t *testing.T) {
+	assert.Equal(t, AUTHLEVEL_NONE, AuthMethod("").Level())
+	assert.Equal(t, AUTHLEVEL_SINGLE, AUTHMETHOD_PASSWORD.Level())
+	assert.Equal(t, AUTHLEVEL_SINGLE, AuthMethod("custom").Level())
+	assert.Equal(t, AUTHLEVEL_MFA, AUTHMETHOD_TOTP.Level())
+	assert.Equal(t, AUTHLEVEL_MFA, AUTHMETHOD_WEBAUTHN.Level())
+}
+
+func TestLoginSessionAuth_Satisfies(t *testing.T) {
+	login := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	maxAge := StepUpRequirement{MaxAuthAge: 10 * time.Minute}
+	mfa := StepUpRequirement{MinLevel: AUTHLEVEL_MFA}
+	mfaMaxAge := StepUpRequirement{MaxAuthAge: 10 * time.Minute, MinLevel: AUTHLEVEL_MFA}
+
+	a := &LoginSessionAuth{}
+	a.SetAuthenticated(AUTHMETHOD_PASSWORD, login)
+
+	assert.True(t, a.Satisfies(maxAge, login.Add(5*time.Minute)))
+	assert.False(t, a.Satisfies(maxAge, login.Add(11*time.Minute)))
+	assert.False(t, a.Satisfies(mfa, login))
+
+	// A TOTP step-up raises the level until the window ends, and the auth age
+	// is measured from the step-up.
+	stepUp := login.Add(time.Hour)
+	a.Elevate(AUTHMETHOD_TOTP, stepUp, 15*time.Minute)
+	assert.True(t, a.IsElevated(stepUp.Add(time.Minute)))
+	assert.Equal(t, stepUp, a.GetAuthTime(stepUp.Add(time.Minute)))
+	assert.True(t, a.Satisfies(mfaMaxAge, stepUp.Add(9*time.Minute)))
+	assert.True(t, a.Satisfies(mfa, stepUp.Add(14*time.Minute)))
+	assert.False(t, a.Satisfies(maxAge, stepUp.Add(11*time.Minute)), "a window longer than the max age does not keep the step-up fresh")
+	assert.False(t, a.Satisfies(maxAge, stepUp.Add(15*time.Minute)))
+	assert.False(t, a.Satisfies(mfa, stepUp.Add(15*time.Minute)))
+	assert.Equal(t, login, a.GetAuthTime(stepUp.Add(15*time.Minute)))
+
+	// A password re-auth does not raise the level.
+	a.Elevate(AUTHMETHOD_PASSWORD, stepUp, 15*time.Minute)
+	assert.True(t, a.Satisfies(maxAge, stepUp))
+	assert.False(t, a.Satisfies(mfa, stepUp))
+
+	// A new login clears the elevation.
+	a.SetAuthenticated(AUTHMETHOD_WEBAUTHN, stepUp)
+	assert.False(t, a.IsElevated(stepUp))
+	assert.True(t, a.Satisfies(mfaMaxAge, stepUp))
+
+	var empty *LoginSessionAuth
+	assert.False(t, empty.Satisfies(maxAge, login))
+	assert.False(t, (&LoginSessionAuth{Method: AUTHMETHOD_PASSWORD}).Satisfies(maxAge, login))
+}
@@ -29,6 +29,9 @@ type UserSessionBase struct {
 	// LastLogin is the timestamp of the user's last login, displayable on the client.
 	LastLogin time.Time `json:"lastLogin"`
 
+	// Auth records how and when the user authenticated, for step-up checks.
+	Auth LoginSessionAuth `json:"auth"`
+
 	// LanguageType specifies the preferred language of the user.
 	LanguageType autils.LanguageType `json:"langType"`
 
@@ -96,6 +99,11 @@ func (us *UserSessionBase) GetLastLogin() time.Time {
 	return us.LastLogin
 }
 
+// GetAuth returns the authentication record of the session.
+func (us *UserSessionBase) GetAuth() *LoginSessionAuth {
+	return &us.Auth
+}
+
 // IsLoggedIn checks if the user is currently logged in.
 func (us *UserSessionBase) IsLoggedIn() bool {
 	return us != nil && us.Status > LOGIN_SESSION_STATUS_NONE && !us.GetUsername().IsEmpty()
@@ -8,6 +8,12 @@ type IDINPaginate interface {
 	NewPaginate(totalItems int, cursor string) (*Paginate, error)
 }
 
+// IDINKeysetPaginate is implemented by data input nodes that page by keyset.
+type IDINKeysetPaginate interface {
+	IDINPaginate
+	NewKeysetPaginate(k *Keyset) (*Paginate, *KeysetClause, error)
+}
+
 // DIN integrates ZAction for data input, with pagination.
 // It implements IDINPaginate.
 type DIN struct {
@@ -54,3 +60,27 @@ func (din *DIN) NewPaginateNoAll(totalItems int, cursor string) (*Paginate, erro
 	din.Paginate = p
 	return p, nil
 }
+
+// NewKeysetPaginate builds a cursor-mode Paginate from ZAction.Cursor and
+// the clause for querying the page. Pass both to KeysetFinish with the rows.
+func (din *DIN) NewKeysetPaginate(k *Keyset) (*Paginate, *KeysetClause, error) {
+	if k == nil {
+		return nil, nil, errors.New("keyset is nil")
+	}
+	if err := din.Validate(); err != nil {
+		return nil, nil, err
+	}
+	clause, err := k.Clause(din.ZAction.Cursor, din.ZAction.PageLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := NewPaginate(1, 0, din.ZAction.PageLimit, din.ZAction.Cursor)
+	din.Paginate = p
+	return p, clause, nil
+}
+
+// NewFilterQuery parses ZAction.Filter and ZAction.Sort against the fields
+// the endpoint allows.
+func (din *DIN) NewFilterQuery(ff FilterFields) (*FilterQuery, error) {
+	return ff.Parse(din.ZAction.Filter, din.ZAction.Sort)
+}
@@ -0,0 +1,374 @@
+package azb
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FilterClause holds the bun-style WHERE (with ? placeholders) and ORDER of
+// a FilterQuery.
+type FilterClause struct {
+	Where string
+	Args  []interface{}
+	Order []string
+}
+
+// Compile validates fq and converts it to SQL using the whitelisted columns.
+func (ff FilterFields) Compile(fq *FilterQuery) (*FilterClause, error) {
+	clause := &FilterClause{}
+	if fq == nil {
+		return clause, nil
+	}
+	fq, err := ff.Normalize(fq)
+	if err != nil {
+		return nil, err
+	}
+	if fq.Where != nil {
+		clause.Where = ff.compileNode(fq.Where, &clause.Args)
+	}
+	for _, fs := range fq.Sort {
+		dir := "ASC"
+		if fs.Desc {
+			dir = "DESC"
+		}
+		clause.Order = append(clause.Order, ff.column(fs.Field)+" "+dir)
+	}
+	return clause, nil
+}
+
+func (ff FilterFields) compileNode(node *FilterNode, args *[]interface{}) string {
+	if node.IsGroup() {
+		parts := make([]string, 0, len(node.Children))
+		for _, child := range node.Children {
+			parts = append(parts, ff.compileNode(child, args))
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(string(node.Logic))+" ") + ")"
+	}
+
+	col := ff.column(node.Field)
+	switch node.Op {
+	case FILTEROP_IN:
+		marks := make([]string, len(node.Values))
+		for ii, val := range node.Values {
+			marks[ii] = "?"
+			*args = append(*args, val)
+		}
+		return fmt.Sprintf("(%s IN (%s))", col, strings.Join(marks, ", "))
+	case FILTEROP_EQ, FILTEROP_NE:
+		if node.Values[0] == nil {
+			if node.Op == FILTEROP_EQ {
+				return fmt.Sprintf("(%s IS NULL)", col)
+			}
+			return fmt.Sprintf("(%s IS NOT NULL)", col)
+		}
+	}
+	sqlOp := map[FilterOp]string{
+		FILTEROP_EQ:   "=",
+		FILTEROP_NE:   "<>",
+		FILTEROP_LT:   "<",
+		FILTEROP_GT:   ">",
+		FILTEROP_LIKE: "LIKE",
+	}[node.Op]
+	*args = append(*args, node.Values[0])
+	return fmt.Sprintf("(%s %s ?)", col, sqlOp)
+}
+
+// FilterPredicate validates node and returns a function that applies it to
+// in-memory items, with the same results the compiled SQL would give
+// (comparisons against null values are false and like is case-sensitive).
+// T must be a struct or a pointer to one.
+func FilterPredicate[T any](ff FilterFields, node *FilterNode) (func(item T) bool, error) {
+	if node == nil {
+		return func(item T) bool { return true }, nil
+	}
+	node, err := ff.normalizeNode(node)
+	if err != nil {
+		return nil, err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	fn, err := ff.buildPredicate(typ, node)
+	if err != nil {
+		return nil, err
+	}
+	return func(item T) bool {
+		return fn(reflect.ValueOf(&item).Elem())
+	}, nil
+}
+
+type filterPredicateFn func(item reflect.Value) bool
+
+func (ff FilterFields) buildPredicate(typ reflect.Type, node *FilterNode) (filterPredicateFn, error) {
+	if node.IsGroup() {
+		fns := make([]filterPredicateFn, 0, len(node.Children))
+		for _, child := range node.Children {
+			fn, err := ff.buildPredicate(typ, child)
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, fn)
+		}
+		isAnd := node.Logic == FILTERLOGIC_AND
+		return func(item reflect.Value) bool {
+			for _, fn := range fns {
+				if fn(item) != isAnd {
+					return !isAnd
+				}
+			}
+			return isAnd
+		}, nil
+	}
+
+	getter, err := ff.fieldGetter(typ, node.Field)
+	if err != nil {
+		return nil, err
+	}
+	var re *regexp.Regexp
+	if node.Op == FILTEROP_LIKE {
+		re = likeToRegexp(node.Values[0].(string))
+	}
+	op, values := node.Op, node.Values
+
+	return func(item reflect.Value) bool {
+		val := getter(item)
+		if (op == FILTEROP_EQ || op == FILTEROP_NE) && values[0] == nil {
+			return (val == nil) == (op == FILTEROP_EQ)
+		}
+		if val == nil {
+			return false
+		}
+		switch op {
+		case FILTEROP_LIKE:
+			s, ok := val.(string)
+			return ok && re.MatchString(s)
+		case FILTEROP_IN:
+			for _, want := range values {
+				if c, ok := compareFilterValues(val, want); ok && c == 0 {
+					return true
+				}
+			}
+			return false
+		}
+		c, ok := compareFilterValues(val, values[0])
+		if !ok {
+			return false
+		}
+		switch op {
+		case FILTEROP_EQ:
+			return c == 0
+		case FILTEROP_NE:
+			return c != 0
+		case FILTEROP_LT:
+			return c < 0
+		case FILTEROP_GT:
+			return c > 0
+		}
+		return false
+	}, nil
+}
+
+// FilterSortSlice sorts items in place by the whitelisted sort fields. Like
+// Postgres, nulls sort after all values ascending and before them descending.
+func FilterSortSlice[T any](ff FilterFields, items []T, sorts []FilterSort) error {
+	if len(sorts) == 0 {
+		return nil
+	}
+	if err := ff.Validate(&FilterQuery{Sort: sorts}); err != nil {
+		return err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	getters := make([]func(reflect.Value) interface{}, len(sorts))
+	for ii, fs := range sorts {
+		getter, err := ff.fieldGetter(typ, fs.Field)
+		if err != nil {
+			return err
+		}
+		getters[ii] = getter
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := reflect.ValueOf(&items[i]).Elem(), reflect.ValueOf(&items[j]).Elem()
+		for ii, fs := range sorts {
+			va, vb := getters[ii](a), getters[ii](b)
+			var c int
+			switch {
+			case va == nil && vb == nil:
+				c = 0
+			case va == nil:
+				c = 1
+			case vb == nil:
+				c = -1
+			default:
+				c, _ = compareFilterValues(va, vb)
+			}
+			if c == 0 {
+				continue
+			}
+			if fs.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// fieldGetter returns a function reading the normalized value of field from
+// an item of typ: string, int64, float64, bool, time.Time or nil.
+func (ff FilterFields) fieldGetter(typ reflect.Type, name string) (func(item reflect.Value) interface{}, error) {
+	structType := typ
+	for structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("filter items must be structs, got %s", typ)
+	}
+
+	goField := ff[name].GoField
+	var index []int
+	for _, sf := range reflect.VisibleFields(structType) {
+		if !sf.IsExported() {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if (goField != "" && sf.Name == goField) || (goField == "" && (jsonName == name || strings.EqualFold(sf.Name, name))) {
+			index = sf.Index
+			break
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("%w; no struct field for '%s'", ErrFilterInvalid, name)
+	}
+
+	return func(item reflect.Value) interface{} {
+		for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+			if item.IsNil() {
+				return nil
+			}
+			item = item.Elem()
+		}
+		fv, err := item.FieldByIndexErr(index)
+		if err != nil {
+			return nil
+		}
+		return normalizeFilterValue(fv)
+	}, nil
+}
+
+var filterTimeType = reflect.TypeOf(time.Time{})
+
+func normalizeFilterValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == filterTimeType {
+		return v.Interface().(time.Time)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			return int64(u)
+		}
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return nil
+}
+
+// compareFilterValues compares two normalized values of the same type.
+func compareFilterValues(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		return strings.Compare(av, bv), ok
+	case int64, float64:
+		return compareFilterNumbers(a, b)
+	case bool:
+		bv, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case av == bv:
+			return 0, true
+		case !av:
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return av.Compare(bv), ok
+	}
+	return 0, false
+}
+
+// compareFilterNumbers compares int64 and float64 values. Two integers
+// compare exactly; otherwise both are compared as float64.
+func compareFilterNumbers(a, b interface{}) (int, bool) {
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			return cmp.Compare(ai, bi), true
+		}
+	}
+	af, ok := filterFloat(a)
+	if !ok {
+		return 0, false
+	}
+	bf, ok := filterFloat(b)
+	if !ok {
+		return 0, false
+	}
+	return cmp.Compare(af, bf), true
+}
+
+func filterFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// likeToRegexp converts a SQL LIKE pattern (% and _, backslash escapes).
+func likeToRegexp(pattern string) *regexp.Regexp {
+	sb := strings.Builder{}
+	sb.WriteString("(?s)^")
+	runes := []rune(pattern)
+	for ii := 0; ii < len(runes); ii++ {
+		switch r := runes[ii]; r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		case '\\':
+			if ii+1 < len(runes) {
+				ii++
+				sb.WriteString(regexp.QuoteMeta(string(runes[ii])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
@@ -0,0 +1,554 @@
+package azb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrFilterInvalid = errors.New("filter is invalid")
+
+const (
+	FILTER_MAX_LENGTH = 2000
+	FILTER_MAX_DEPTH  = 16
+	FILTER_MAX_IN     = 100
+)
+
+// FilterOp is a field comparison in a filter expression.
+type FilterOp string
+
+const (
+	FILTEROP_EQ   FilterOp = "eq"
+	FILTEROP_NE   FilterOp = "ne"
+	FILTEROP_LT   FilterOp = "lt"
+	FILTEROP_GT   FilterOp = "gt"
+	FILTEROP_IN   FilterOp = "in"
+	FILTEROP_LIKE FilterOp = "like"
+)
+
+// IsValid checks if the op is known.
+func (op FilterOp) IsValid() bool {
+	switch op {
+	case FILTEROP_EQ, FILTEROP_NE, FILTEROP_LT, FILTEROP_GT, FILTEROP_IN, FILTEROP_LIKE:
+		return true
+	}
+	return false
+}
+
+// FilterLogic joins the children of a FilterNode.
+type FilterLogic string
+
+const (
+	FILTERLOGIC_AND FilterLogic = "and"
+	FILTERLOGIC_OR  FilterLogic = "or"
+)
+
+// FilterNode is either a group (Logic with Children) or a comparison
+// (Field, Op and Values). Values holds one value except for "in".
+type FilterNode struct {
+	Logic    FilterLogic   `json:"logic,omitempty"`
+	Children []*FilterNode `json:"children,omitempty"`
+
+	Field  string        `json:"field,omitempty"`
+	Op     FilterOp      `json:"op,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// IsGroup is true for AND/OR nodes.
+func (fn *FilterNode) IsGroup() bool {
+	return fn.Logic != ""
+}
+
+// FilterSort is one sort field.
+type FilterSort struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc,omitempty"`
+}
+
+// FilterQuery is a parsed and validated filter with its sort.
+type FilterQuery struct {
+	Where *FilterNode  `json:"where,omitempty"`
+	Sort  []FilterSort `json:"sort,omitempty"`
+}
+
+// ParseFilter parses an expression such as
+//
+//	status eq 'active' and (age gt 30 or name like 'bo%') and id in (1, 2, 3)
+//
+// Strings use single quotes, doubled to escape; numbers, true, false and
+// null are bare. AND binds tighter than OR. An empty expression returns nil.
+func ParseFilter(expr string) (*FilterNode, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, nil
+	}
+	if len(expr) > FILTER_MAX_LENGTH {
+		return nil, fmt.Errorf("%w; longer than %d characters", ErrFilterInvalid, FILTER_MAX_LENGTH)
+	}
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	node, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected '%s'", p.peek().text)
+	}
+	return node, nil
+}
+
+// ParseFilterSort parses a comma-separated list of fields; a leading '-'
+// sorts descending (eg "-created,name").
+func ParseFilterSort(s string) ([]FilterSort, error) {
+	sorts := []FilterSort{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fs := FilterSort{}
+		if strings.HasPrefix(part, "-") {
+			fs.Desc = true
+			part = strings.TrimSpace(part[1:])
+		} else if strings.HasPrefix(part, "+") {
+			part = strings.TrimSpace(part[1:])
+		}
+		if !isFilterIdent(part) {
+			return nil, fmt.Errorf("%w; invalid sort field '%s'", ErrFilterInvalid, part)
+		}
+		fs.Field = part
+		sorts = append(sorts, fs)
+	}
+	return sorts, nil
+}
+
+type filterTokenType int
+
+const (
+	filterTokenIdent filterTokenType = iota
+	filterTokenString
+	filterTokenNumber
+	filterTokenLParen
+	filterTokenRParen
+	filterTokenComma
+)
+
+type filterToken struct {
+	typ  filterTokenType
+	text string
+	pos  int
+}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	runes := []rune(expr)
+	for ii := 0; ii < len(runes); {
+		r := runes[ii]
+		switch {
+		case unicode.IsSpace(r):
+			ii++
+		case r == '(':
+			tokens = append(tokens, filterToken{typ: filterTokenLParen, text: "(", pos: ii})
+			ii++
+		case r == ')':
+			tokens = append(tokens, filterToken{typ: filterTokenRParen, text: ")", pos: ii})
+			ii++
+		case r == ',':
+			tokens = append(tokens, filterToken{typ: filterTokenComma, text: ",", pos: ii})
+			ii++
+		case r == '\'':
+			start := ii
+			sb := strings.Builder{}
+			ii++
+			closed := false
+			for ii < len(runes) {
+				if runes[ii] == '\'' {
+					if ii+1 < len(runes) && runes[ii+1] == '\'' {
+						sb.WriteRune('\'')
+						ii += 2
+						continue
+					}
+					ii++
+					closed = true
+					break
+				}
+				sb.WriteRune(runes[ii])
+				ii++
+			}
+			if !closed {
+				return nil, fmt.Errorf("%w; unterminated string at %d", ErrFilterInvalid, start)
+			}
+			tokens = append(tokens, filterToken{typ: filterTokenString, text: sb.String(), pos: start})
+		case r == '-' || unicode.IsDigit(r):
+			start := ii
+			ii++
+			for ii < len(runes) && (unicode.IsDigit(runes[ii]) || runes[ii] == '.') {
+				ii++
+			}
+			text := string(runes[start:ii])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("%w; invalid number '%s' at %d", ErrFilterInvalid, text, start)
+			}
+			tokens = append(tokens, filterToken{typ: filterTokenNumber, text: text, pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			start := ii
+			for ii < len(runes) && (runes[ii] == '_' || runes[ii] == '.' || unicode.IsLetter(runes[ii]) || unicode.IsDigit(runes[ii])) {
+				ii++
+			}
+			tokens = append(tokens, filterToken{typ: filterTokenIdent, text: string(runes[start:ii]), pos: start})
+		default:
+			return nil, fmt.Errorf("%w; unexpected '%c' at %d", ErrFilterInvalid, r, ii)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (filterToken, bool) {
+	if p.done() {
+		return filterToken{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *filterParser) isKeyword(word string) bool {
+	t := p.peek()
+	return !p.done() && t.typ == filterTokenIdent && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	at := "end"
+	if !p.done() {
+		at = strconv.Itoa(p.peek().pos)
+	}
+	return fmt.Errorf("%w; %s at %s", ErrFilterInvalid, fmt.Sprintf(format, args...), at)
+}
+
+func (p *filterParser) parseOr(depth int) (*FilterNode, error) {
+	return p.parseGroup(depth, FILTERLOGIC_OR, func() (*FilterNode, error) {
+		return p.parseGroup(depth, FILTERLOGIC_AND, func() (*FilterNode, error) {
+			return p.parseTerm(depth)
+		})
+	})
+}
+
+// parseGroup parses one or more operands joined by logic and flattens a
+// single operand to itself.
+func (p *filterParser) parseGroup(depth int, logic FilterLogic, operand func() (*FilterNode, error)) (*FilterNode, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	children := []*FilterNode{first}
+	for p.isKeyword(string(logic)) {
+		p.pos++
+		child, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &FilterNode{Logic: logic, Children: children}, nil
+}
+
+func (p *filterParser) parseTerm(depth int) (*FilterNode, error) {
+	if depth > FILTER_MAX_DEPTH {
+		return nil, p.errorf("nested deeper than %d", FILTER_MAX_DEPTH)
+	}
+	t, ok := p.next()
+	if !ok {
+		return nil, p.errorf("expected a field or '('")
+	}
+	if t.typ == filterTokenLParen {
+		node, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok = p.next(); !ok || t.typ != filterTokenRParen {
+			return nil, p.errorf("expected ')'")
+		}
+		return node, nil
+	}
+	if t.typ != filterTokenIdent || !isFilterIdent(t.text) {
+		return nil, fmt.Errorf("%w; expected a field at %d", ErrFilterInvalid, t.pos)
+	}
+
+	node := &FilterNode{Field: t.text}
+	opTok, ok := p.next()
+	if !ok || opTok.typ != filterTokenIdent || !FilterOp(strings.ToLower(opTok.text)).IsValid() {
+		return nil, fmt.Errorf("%w; expected an operator after '%s'", ErrFilterInvalid, t.text)
+	}
+	node.Op = FilterOp(strings.ToLower(opTok.text))
+
+	if node.Op != FILTEROP_IN {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.Values = []interface{}{val}
+		return node, nil
+	}
+
+	if t, ok = p.next(); !ok || t.typ != filterTokenLParen {
+		return nil, p.errorf("expected '(' after in")
+	}
+	for {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.Values = append(node.Values, val)
+		if len(node.Values) > FILTER_MAX_IN {
+			return nil, p.errorf("more than %d values in list", FILTER_MAX_IN)
+		}
+		t, ok = p.next()
+		if !ok {
+			return nil, p.errorf("expected ')'")
+		}
+		if t.typ == filterTokenRParen {
+			return node, nil
+		}
+		if t.typ != filterTokenComma {
+			return nil, fmt.Errorf("%w; expected ',' or ')' at %d", ErrFilterInvalid, t.pos)
+		}
+	}
+}
+
+// parseValue returns a string, json.Number, bool or nil. Numbers keep their
+// text so integers beyond float64 precision stay exact.
+func (p *filterParser) parseValue() (interface{}, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, p.errorf("expected a value")
+	}
+	switch t.typ {
+	case filterTokenString:
+		return t.text, nil
+	case filterTokenNumber:
+		return json.Number(t.text), nil
+	case filterTokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%w; expected a value at %d", ErrFilterInvalid, t.pos)
+}
+
+func isFilterIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for ii, r := range s {
+		if r == '_' || unicode.IsLetter(r) || (ii > 0 && (unicode.IsDigit(r) || r == '.')) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// FilterFieldType is the value type of a filterable field.
+type FilterFieldType string
+
+const (
+	FILTERFIELDTYPE_STRING FilterFieldType = "string"
+	FILTERFIELDTYPE_NUMBER FilterFieldType = "number"
+	FILTERFIELDTYPE_BOOL   FilterFieldType = "bool"
+	FILTERFIELDTYPE_TIME   FilterFieldType = "time"
+)
+
+// FilterField whitelists a field for filtering and sorting.
+type FilterField struct {
+	// Column is the SQL column, optionally alias-qualified; defaults to the field name.
+	Column string
+	Type   FilterFieldType
+
+	// GoField is the struct field read by FilterPredicate; defaults to the
+	// field whose json name or Go name matches the filter field.
+	GoField string
+
+	NoFilter bool
+	NoSort   bool
+}
+
+// FilterFields maps the public field names of a model to their definitions.
+type FilterFields map[string]FilterField
+
+// Parse parses and validates a filter expression and sort list. Values are
+// converted to the field types (see FilterFields.Normalize).
+func (ff FilterFields) Parse(expr string, sort string) (*FilterQuery, error) {
+	where, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	sorts, err := ParseFilterSort(sort)
+	if err != nil {
+		return nil, err
+	}
+	return ff.Normalize(&FilterQuery{Where: where, Sort: sorts})
+}
+
+// Validate checks fields against the whitelist and that values convert to
+// the field types. It does not change fq.
+func (ff FilterFields) Validate(fq *FilterQuery) error {
+	_, err := ff.Normalize(fq)
+	return err
+}
+
+// Normalize validates fq and returns a copy with values converted to the
+// field types: int64 for integers and float64 for other numbers, time.Time
+// for times (RFC 3339 strings). fq is not changed.
+func (ff FilterFields) Normalize(fq *FilterQuery) (*FilterQuery, error) {
+	if fq == nil {
+		return nil, nil
+	}
+	where, err := ff.normalizeNode(fq.Where)
+	if err != nil {
+		return nil, err
+	}
+	for _, fs := range fq.Sort {
+		field, ok := ff[fs.Field]
+		if !ok || field.NoSort {
+			return nil, fmt.Errorf("%w; cannot sort by '%s'", ErrFilterInvalid, fs.Field)
+		}
+	}
+	return &FilterQuery{Where: where, Sort: append([]FilterSort(nil), fq.Sort...)}, nil
+}
+
+// normalizeNode returns a validated copy of node with converted values.
+func (ff FilterFields) normalizeNode(node *FilterNode) (*FilterNode, error) {
+	if node == nil {
+		return nil, nil
+	}
+	if node.IsGroup() {
+		if node.Logic != FILTERLOGIC_AND && node.Logic != FILTERLOGIC_OR {
+			return nil, fmt.Errorf("%w; unknown logic '%s'", ErrFilterInvalid, node.Logic)
+		}
+		group := &FilterNode{Logic: node.Logic, Children: make([]*FilterNode, 0, len(node.Children))}
+		for _, child := range node.Children {
+			if child == nil {
+				return nil, fmt.Errorf("%w; empty %s group member", ErrFilterInvalid, node.Logic)
+			}
+			normalized, err := ff.normalizeNode(child)
+			if err != nil {
+				return nil, err
+			}
+			group.Children = append(group.Children, normalized)
+		}
+		if len(group.Children) == 0 {
+			return nil, fmt.Errorf("%w; empty %s group", ErrFilterInvalid, node.Logic)
+		}
+		return group, nil
+	}
+
+	field, ok := ff[node.Field]
+	if !ok || field.NoFilter {
+		return nil, fmt.Errorf("%w; cannot filter by '%s'", ErrFilterInvalid, node.Field)
+	}
+	if !node.Op.IsValid() {
+		return nil, fmt.Errorf("%w; unknown operator '%s'", ErrFilterInvalid, node.Op)
+	}
+	if len(node.Values) == 0 || (node.Op != FILTEROP_IN && len(node.Values) != 1) {
+		return nil, fmt.Errorf("%w; wrong number of values for '%s'", ErrFilterInvalid, node.Field)
+	}
+	if node.Op == FILTEROP_LIKE && field.Type != FILTERFIELDTYPE_STRING {
+		return nil, fmt.Errorf("%w; like needs a string field, '%s' is %s", ErrFilterInvalid, node.Field, field.Type)
+	}
+	values := make([]interface{}, len(node.Values))
+	for ii, val := range node.Values {
+		if val == nil {
+			if node.Op != FILTEROP_EQ && node.Op != FILTEROP_NE {
+				return nil, fmt.Errorf("%w; null only works with eq and ne", ErrFilterInvalid)
+			}
+			continue
+		}
+		converted, err := convertFilterValue(field.Type, val)
+		if err != nil {
+			return nil, fmt.Errorf("%w; field '%s': %v", ErrFilterInvalid, node.Field, err)
+		}
+		values[ii] = converted
+	}
+	return &FilterNode{Field: node.Field, Op: node.Op, Values: values}, nil
+}
+
+func convertFilterValue(typ FilterFieldType, val interface{}) (interface{}, error) {
+	switch typ {
+	case FILTERFIELDTYPE_STRING:
+		if s, ok := val.(string); ok {
+			return s, nil
+		}
+	case FILTERFIELDTYPE_NUMBER:
+		switch v := val.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return i, nil
+			}
+			if f, err := v.Float64(); err == nil {
+				return f, nil
+			}
+		case float64:
+			return v, nil
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		}
+	case FILTERFIELDTYPE_BOOL:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+	case FILTERFIELDTYPE_TIME:
+		switch v := val.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			tm, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("expected an RFC 3339 time")
+			}
+			return tm, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", typ)
+	}
+	return nil, fmt.Errorf("expected a %s value", typ)
+}
+
+func (ff FilterFields) column(name string) string {
+	if col := strings.TrimSpace(ff[name].Column); col != "" {
+		return quoteColumn(col)
+	}
+	return quoteColumn(name)
+}
@@ -0,0 +1,205 @@
+package azb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type filterUser struct {
+	Id      int        `json:"id"`
+	Name    string     `json:"name"`
+	Status  string     `json:"status"`
+	Age     *int       `json:"age"`
+	Created time.Time  `json:"created"`
+	Secret  string     `json:"secret"`
+	Manager *time.Time `json:"-"`
+}
+
+var testFilterFields = FilterFields{
+	"id":      {Type: FILTERFIELDTYPE_NUMBER},
+	"name":    {Column: "u.name", Type: FILTERFIELDTYPE_STRING},
+	"status":  {Type: FILTERFIELDTYPE_STRING, NoSort: true},
+	"age":     {Type: FILTERFIELDTYPE_NUMBER},
+	"created": {Column: "u.created_at", Type: FILTERFIELDTYPE_TIME},
+}
+
+// TestParseFilter verifies precedence, grouping and values.
+func TestParseFilter(t *testing.T) {
+	node, err := ParseFilter(`status eq 'it''s' or name like 'bo%' and (age gt 30 or id in (1, 2))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Logic != FILTERLOGIC_OR || len(node.Children) != 2 {
+		t.Fatalf("unexpected root %+v", node)
+	}
+	if node.Children[0].Values[0] != "it's" {
+		t.Errorf("unexpected string %v", node.Children[0].Values[0])
+	}
+	and := node.Children[1]
+	if and.Logic != FILTERLOGIC_AND || and.Children[1].Logic != FILTERLOGIC_OR {
+		t.Errorf("unexpected and %+v", and)
+	}
+	if in := and.Children[1].Children[1]; in.Op != FILTEROP_IN || !reflect.DeepEqual(in.Values, []interface{}{json.Number("1"), json.Number("2")}) {
+		t.Errorf("unexpected in %+v", in)
+	}
+
+	for _, bad := range []string{"name", "name eq", "name foo 1", "(name eq 'x'", "name eq 'x' extra", "id in 1", "name eq 'x", "a eq ;"} {
+		if _, err = ParseFilter(bad); !errors.Is(err, ErrFilterInvalid) {
+			t.Errorf("expected %q to fail, got %v", bad, err)
+		}
+	}
+}
+
+// TestFilterFieldsCompile verifies the whitelist and generated SQL.
+func TestFilterFieldsCompile(t *testing.T) {
+	din := &DIN{}
+	body := `{"zaction":{"filter":"(name like 'A%' or age eq null) and created gt '2024-01-01T00:00:00Z' and id in (1,2)","sort":"-name,id"}}`
+	if err := json.Unmarshal([]byte(body), din); err != nil {
+		t.Fatal(err)
+	}
+	fq, err := din.NewFilterQuery(testFilterFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clause, err := testFilterFields.Compile(fq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `((("u"."name" LIKE ?) OR ("age" IS NULL)) AND ("u"."created_at" > ?) AND ("id" IN (?, ?)))`
+	if clause.Where != want {
+		t.Errorf("where = %s", clause.Where)
+	}
+	if len(clause.Args) != 4 || !reflect.DeepEqual(clause.Order, []string{`"u"."name" DESC`, `"id" ASC`}) {
+		t.Errorf("unexpected clause %+v", clause)
+	}
+	if _, ok := clause.Args[1].(time.Time); !ok {
+		t.Errorf("expected time arg, got %T", clause.Args[1])
+	}
+	if clause.Args[2] != int64(1) {
+		t.Errorf("expected int64 arg, got %T", clause.Args[2])
+	}
+
+	for _, tt := range []struct{ filter, sort string }{
+		{"secret eq 'x'", ""},
+		{"age like '1%'", ""},
+		{"age eq 'x'", ""},
+		{"age gt null", ""},
+		{"created gt 'yesterday'", ""},
+		{"", "status"},
+		{"", "secret"},
+	} {
+		if _, err = testFilterFields.Parse(tt.filter, tt.sort); !errors.Is(err, ErrFilterInvalid) {
+			t.Errorf("expected %q/%q to fail, got %v", tt.filter, tt.sort, err)
+		}
+	}
+}
+
+// TestFilterFieldsNormalize verifies large integers stay exact and that
+// Validate and Compile leave their argument unchanged.
+func TestFilterFieldsNormalize(t *testing.T) {
+	node, err := ParseFilter("id eq 9007199254740993 or age gt 30.5 or created lt '2024-01-01T00:00:00Z'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fq := &FilterQuery{Where: node}
+	if err = testFilterFields.Validate(fq); err != nil {
+		t.Fatal(err)
+	}
+	clause, err := testFilterFields.Compile(fq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clause.Args[0] != int64(9007199254740993) || clause.Args[1] != 30.5 {
+		t.Errorf("unexpected args %#v", clause.Args)
+	}
+	if _, ok := clause.Args[2].(time.Time); !ok {
+		t.Errorf("expected time arg, got %T", clause.Args[2])
+	}
+	if got := node.Children[0].Values[0]; got != json.Number("9007199254740993") {
+		t.Errorf("Validate changed its argument: %#v", got)
+	}
+	if got := node.Children[2].Values[0]; got != "2024-01-01T00:00:00Z" {
+		t.Errorf("Compile changed its argument: %#v", got)
+	}
+
+	fn, err := FilterPredicate[*filterUser](testFilterFields, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if fn(&filterUser{Id: 9007199254740992, Created: later}) || !fn(&filterUser{Id: 9007199254740993, Created: later}) {
+		t.Error("expected an exact match on a large id")
+	}
+}
+
+// TestFilterPredicate verifies in-memory filtering and sorting match SQL semantics.
+func TestFilterPredicate(t *testing.T) {
+	age := func(n int) *int { return &n }
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []*filterUser{
+		{Id: 1, Name: "Alice", Status: "active", Age: age(31), Created: day},
+		{Id: 2, Name: "bob", Status: "active", Age: nil, Created: day.Add(48 * time.Hour)},
+		{Id: 3, Name: "Anna", Status: "locked", Age: age(25), Created: day.Add(24 * time.Hour)},
+		{Id: 4, Name: "Carl", Status: "active", Age: age(40), Created: day.Add(72 * time.Hour)},
+	}
+	run := func(expr string) []int {
+		node, err := ParseFilter(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := FilterPredicate[*filterUser](testFilterFields, node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, u := range users {
+			if fn(u) {
+				ids = append(ids, u.Id)
+			}
+		}
+		return ids
+	}
+
+	tests := []struct {
+		expr string
+		want []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"status eq 'active' and age gt 30", []int{1, 4}},
+		{"name like 'A%' or age eq null", []int{1, 2, 3}},
+		{"name like 'a%'", []int{}},
+		{"age ne 31", []int{3, 4}},
+		{"age ne null", []int{1, 3, 4}},
+		{"id in (2, 4) and not_a eq 1", nil},
+		{"created lt '2024-01-02T12:00:00Z'", []int{1, 3}},
+		{"name like '_ob'", []int{2}},
+	}
+	for _, tt := range tests {
+		if tt.want == nil {
+			node, _ := ParseFilter(tt.expr)
+			if _, err := FilterPredicate[*filterUser](testFilterFields, node); !errors.Is(err, ErrFilterInvalid) {
+				t.Errorf("%q: expected whitelist error, got %v", tt.expr, err)
+			}
+			continue
+		}
+		if got := run(tt.expr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	sorts, _ := ParseFilterSort("-age,name")
+	if err := FilterSortSlice(testFilterFields, users, sorts); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, u := range users {
+		ids = append(ids, u.Id)
+	}
+	// Nulls come first when descending, as in Postgres.
+	if !reflect.DeepEqual(ids, []int{2, 4, 1, 3}) {
+		t.Errorf("sorted = %v", ids)
+	}
+}
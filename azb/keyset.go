@@ -0,0 +1,278 @@
+package azb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrKeysetCursorInvalid = errors.New("keyset cursor is invalid")
+
+// KeysetDirection is the paging direction stored in a cursor.
+type KeysetDirection string
+
+const (
+	KEYSETDIRECTION_NEXT KeysetDirection = "next"
+	KEYSETDIRECTION_PREV KeysetDirection = "prev"
+)
+
+// KeysetColumn is one sort key. Columns must be NOT NULL and the last
+// column must be unique (eg the primary key) so every row has one position;
+// a Keyset whose last column is not marked Unique is rejected.
+type KeysetColumn struct {
+	Name   string `json:"name"` // Column name, optionally alias-qualified (eg "u.created_at").
+	Desc   bool   `json:"desc,omitempty"`
+	Unique bool   `json:"unique,omitempty"` // The column alone identifies a row.
+}
+
+// Keyset pages through a result set by its sort keys instead of an offset.
+// The boundary row's keys are stored in a cursor signed with Secret, so
+// clients cannot edit it and a cursor built for one sort cannot be replayed
+// against another.
+type Keyset struct {
+	Columns []KeysetColumn
+	Secret  []byte
+}
+
+// NewKeyset creates a Keyset.
+func NewKeyset(secret []byte, columns ...KeysetColumn) *Keyset {
+	return &Keyset{Columns: columns, Secret: secret}
+}
+
+// KeysetCursor is the decoded content of a cursor.
+type KeysetCursor struct {
+	Dir    KeysetDirection `json:"d"`
+	Sort   string          `json:"s"`
+	Values []interface{}   `json:"v"`
+}
+
+// KeysetClause holds the bun-style WHERE (with ? placeholders), ORDER and
+// LIMIT for one page. Limit is one more than the page size so the query
+// reveals whether another page exists; see KeysetFinish.
+type KeysetClause struct {
+	Where string
+	Args  []interface{}
+	Order []string
+	Limit int
+	Dir   KeysetDirection
+
+	cursor string
+}
+
+// IsPrev is true when the clause fetches the page before the cursor.
+func (kc *KeysetClause) IsPrev() bool {
+	return kc.Dir == KEYSETDIRECTION_PREV
+}
+
+func (k *Keyset) validate() error {
+	if len(k.Columns) == 0 {
+		return fmt.Errorf("keyset has no columns")
+	}
+	if len(k.Secret) == 0 {
+		return fmt.Errorf("keyset secret is empty")
+	}
+	for _, col := range k.Columns {
+		if strings.TrimSpace(col.Name) == "" || strings.ContainsAny(col.Name, `?"`) {
+			return fmt.Errorf("invalid keyset column '%s'", col.Name)
+		}
+	}
+	if last := k.Columns[len(k.Columns)-1]; !last.Unique {
+		return fmt.Errorf("last keyset column '%s' must be unique (eg the primary key) to break ties", last.Name)
+	}
+	return nil
+}
+
+// sortSignature identifies the column order and directions.
+func (k *Keyset) sortSignature() string {
+	parts := make([]string, len(k.Columns))
+	for ii, col := range k.Columns {
+		dir := "asc"
+		if col.Desc {
+			dir = "desc"
+		}
+		parts[ii] = strings.TrimSpace(col.Name) + ":" + dir
+	}
+	return strings.Join(parts, ",")
+}
+
+func (k *Keyset) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// EncodeCursor returns a signed cursor for the row with the given sort key
+// values, one per column.
+func (k *Keyset) EncodeCursor(dir KeysetDirection, values ...interface{}) (string, error) {
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+	if len(values) != len(k.Columns) {
+		return "", fmt.Errorf("keyset expects %d values, got %d", len(k.Columns), len(values))
+	}
+	if dir != KEYSETDIRECTION_PREV {
+		dir = KEYSETDIRECTION_NEXT
+	}
+	payload, err := json.Marshal(&KeysetCursor{Dir: dir, Sort: k.sortSignature(), Values: values})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode keyset cursor; %v", err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(k.sign(payload)), nil
+}
+
+// DecodeCursor verifies the signature and sort of cursor and returns its content.
+// Integers are returned as int64, so large ids stay exact and bind as
+// numbers, and other numbers as float64.
+func (k *Keyset) DecodeCursor(cursor string) (*KeysetCursor, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(strings.TrimSpace(cursor), ".")
+	if !ok {
+		return nil, ErrKeysetCursorInvalid
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return nil, ErrKeysetCursorInvalid
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, k.sign(payload)) {
+		return nil, ErrKeysetCursorInvalid
+	}
+
+	kc := &KeysetCursor{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err = dec.Decode(kc); err != nil {
+		return nil, ErrKeysetCursorInvalid
+	}
+	if kc.Sort != k.sortSignature() || len(kc.Values) != len(k.Columns) {
+		return nil, fmt.Errorf("%w; cursor does not match the sort", ErrKeysetCursorInvalid)
+	}
+	if kc.Dir != KEYSETDIRECTION_PREV {
+		kc.Dir = KEYSETDIRECTION_NEXT
+	}
+	for ii, val := range kc.Values {
+		if num, ok := val.(json.Number); ok {
+			if kc.Values[ii], err = num.Int64(); err != nil {
+				if kc.Values[ii], err = num.Float64(); err != nil {
+					return nil, ErrKeysetCursorInvalid
+				}
+			}
+		}
+	}
+	return kc, nil
+}
+
+// Clause builds the query parts for the page after (or before) cursor. An
+// empty cursor returns the first page. For previous pages the ORDER is
+// reversed; KeysetFinish restores display order.
+func (k *Keyset) Clause(cursor string, pageSize int) (*KeysetClause, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		pageSize = 25
+	}
+	clause := &KeysetClause{Limit: pageSize + 1, Dir: KEYSETDIRECTION_NEXT, cursor: cursor}
+	var values []interface{}
+	if strings.TrimSpace(cursor) != "" {
+		kc, err := k.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		clause.Dir = kc.Dir
+		values = kc.Values
+	}
+
+	// Walking backwards flips every comparison and sort direction.
+	isPrev := clause.IsPrev()
+	for _, col := range k.Columns {
+		desc := col.Desc != isPrev
+		dir := "ASC"
+		if desc {
+			dir = "DESC"
+		}
+		clause.Order = append(clause.Order, quoteColumn(col.Name)+" "+dir)
+	}
+	if values == nil {
+		return clause, nil
+	}
+
+	// (a > ?) OR (a = ? AND b > ?) OR ... handles mixed directions, which a
+	// row-value comparison cannot.
+	ors := make([]string, 0, len(k.Columns))
+	for ii, col := range k.Columns {
+		ands := make([]string, 0, ii+1)
+		for jj := 0; jj < ii; jj++ {
+			ands = append(ands, quoteColumn(k.Columns[jj].Name)+" = ?")
+			clause.Args = append(clause.Args, values[jj])
+		}
+		op := ">"
+		if col.Desc != isPrev {
+			op = "<"
+		}
+		ands = append(ands, quoteColumn(col.Name)+" "+op+" ?")
+		clause.Args = append(clause.Args, values[ii])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	clause.Where = "(" + strings.Join(ors, " OR ") + ")"
+	return clause, nil
+}
+
+// KeysetFinish trims the extra row fetched by the clause, restores display
+// order for previous pages and sets NextCursor and PrevCursor on p.
+// fnKeys returns the sort key values of an item in column order.
+func KeysetFinish[T any](k *Keyset, p *Paginate, clause *KeysetClause, items []T, fnKeys func(item T) []interface{}) ([]T, error) {
+	if clause == nil || p == nil {
+		return items, fmt.Errorf("clause or paginate is nil")
+	}
+	pageSize := clause.Limit - 1
+	hasMore := len(items) > pageSize
+	if hasMore {
+		items = items[:pageSize]
+	}
+	if clause.IsPrev() {
+		for ii, jj := 0, len(items)-1; ii < jj; ii, jj = ii+1, jj-1 {
+			items[ii], items[jj] = items[jj], items[ii]
+		}
+	}
+
+	hasNext, hasPrev := hasMore, clause.cursor != ""
+	if clause.IsPrev() {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	p.NextCursor, p.PrevCursor = "", ""
+	if len(items) == 0 {
+		return items, nil
+	}
+	var err error
+	if hasNext {
+		if p.NextCursor, err = k.EncodeCursor(KEYSETDIRECTION_NEXT, fnKeys(items[len(items)-1])...); err != nil {
+			return items, err
+		}
+	}
+	if hasPrev {
+		if p.PrevCursor, err = k.EncodeCursor(KEYSETDIRECTION_PREV, fnKeys(items[0])...); err != nil {
+			return items, err
+		}
+	}
+	return items, nil
+}
+
+// quoteColumn quotes each part of an optionally alias-qualified name.
+func quoteColumn(name string) string {
+	parts := strings.Split(strings.TrimSpace(name), ".")
+	for ii, part := range parts {
+		parts[ii] = `"` + strings.TrimSpace(part) + `"`
+	}
+	return strings.Join(parts, ".")
+}
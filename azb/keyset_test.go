@@ -0,0 +1,178 @@
+package azb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type keysetRow struct {
+	Score int
+	Id    int
+}
+
+func newTestKeyset() *Keyset {
+	return NewKeyset([]byte("secret"), KeysetColumn{Name: "r.score", Desc: true}, KeysetColumn{Name: "id", Unique: true})
+}
+
+// TestKeysetCursorTamper verifies cursors round-trip and reject edits or another sort.
+func TestKeysetCursorTamper(t *testing.T) {
+	k := newTestKeyset()
+	cursor, err := k.EncodeCursor(KEYSETDIRECTION_NEXT, 90, 9007199254740993)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc, err := k.DecodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.Dir != KEYSETDIRECTION_NEXT || kc.Values[1] != int64(9007199254740993) {
+		t.Errorf("unexpected cursor %+v", kc)
+	}
+	cursor2, _ := k.EncodeCursor(KEYSETDIRECTION_NEXT, 1.5, "x")
+	if kc, err = k.DecodeCursor(cursor2); err != nil || kc.Values[0] != 1.5 || kc.Values[1] != "x" {
+		t.Errorf("unexpected cursor %+v, %v", kc, err)
+	}
+
+	payload, sig, _ := strings.Cut(cursor, ".")
+	if _, err = k.DecodeCursor(payload + "x." + sig); !errors.Is(err, ErrKeysetCursorInvalid) {
+		t.Errorf("expected tampered cursor to fail, got %v", err)
+	}
+	other := NewKeyset([]byte("secret"), KeysetColumn{Name: "r.score"}, KeysetColumn{Name: "id", Unique: true})
+	if _, err = other.DecodeCursor(cursor); !errors.Is(err, ErrKeysetCursorInvalid) {
+		t.Errorf("expected other sort to fail, got %v", err)
+	}
+	if _, err = NewKeyset([]byte("other"), k.Columns...).DecodeCursor(cursor); !errors.Is(err, ErrKeysetCursorInvalid) {
+		t.Errorf("expected other secret to fail, got %v", err)
+	}
+}
+
+// TestKeysetClause verifies the generated WHERE and ORDER.
+func TestKeysetClause(t *testing.T) {
+	k := newTestKeyset()
+	first, err := k.Clause("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Where != "" || first.Limit != 11 || !reflect.DeepEqual(first.Order, []string{`"r"."score" DESC`, `"id" ASC`}) {
+		t.Errorf("unexpected first clause %+v", first)
+	}
+
+	cursor, _ := k.EncodeCursor(KEYSETDIRECTION_PREV, 50, 7)
+	prev, err := k.Clause(cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWhere := `(("r"."score" > ?) OR ("r"."score" = ? AND "id" < ?))`
+	if prev.Where != wantWhere || len(prev.Args) != 3 || !prev.IsPrev() {
+		t.Errorf("unexpected prev clause %+v", prev)
+	}
+	if !reflect.DeepEqual(prev.Order, []string{`"r"."score" ASC`, `"id" DESC`}) {
+		t.Errorf("unexpected prev order %v", prev.Order)
+	}
+
+	// Without a unique last column, rows with equal keys could be skipped.
+	for _, bad := range []*Keyset{
+		NewKeyset([]byte("secret"), KeysetColumn{Name: "r.score", Desc: true}),
+		NewKeyset([]byte("secret"), KeysetColumn{Name: "id", Unique: true}, KeysetColumn{Name: "r.score"}),
+	} {
+		if _, err = bad.Clause("", 10); err == nil {
+			t.Errorf("expected %v to be rejected", bad.Columns)
+		}
+	}
+}
+
+// runKeysetQuery evaluates a clause over rows the way the database would.
+func runKeysetQuery(rows []keysetRow, clause *KeysetClause) []keysetRow {
+	less := func(a, b keysetRow) bool { // Display order: score desc, id asc.
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Id < b.Id
+	}
+	out := []keysetRow{}
+	for _, row := range rows {
+		if clause.Where != "" {
+			bound := keysetRow{Score: int(clause.Args[0].(int64)), Id: int(clause.Args[2].(int64))}
+			if clause.IsPrev() && !less(row, bound) || !clause.IsPrev() && !less(bound, row) {
+				continue
+			}
+		}
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if clause.IsPrev() {
+			return less(out[j], out[i])
+		}
+		return less(out[i], out[j])
+	})
+	if len(out) > clause.Limit {
+		out = out[:clause.Limit]
+	}
+	return out
+}
+
+// TestKeysetWalk pages forward to the end and back again through DIN.
+func TestKeysetWalk(t *testing.T) {
+	k := newTestKeyset()
+	rows := []keysetRow{}
+	for ii := 1; ii <= 7; ii++ {
+		rows = append(rows, keysetRow{Score: ii % 3, Id: ii})
+	}
+	keys := func(r keysetRow) []interface{} { return []interface{}{r.Score, r.Id} }
+
+	fetch := func(cursor string) ([]keysetRow, *Paginate) {
+		din := &DIN{}
+		if err := json.Unmarshal([]byte(`{"zaction":{"pageLimit":3,"cursor":"`+cursor+`"}}`), din); err != nil {
+			t.Fatal(err)
+		}
+		var idin IDINKeysetPaginate = din
+		p, clause, err := idin.NewKeysetPaginate(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := KeysetFinish(k, p, clause, runKeysetQuery(rows, clause), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return items, p
+	}
+	ids := func(items []keysetRow) []int {
+		out := []int{}
+		for _, r := range items {
+			out = append(out, r.Id)
+		}
+		return out
+	}
+
+	page1, p := fetch("")
+	if !reflect.DeepEqual(ids(page1), []int{2, 5, 1}) || p.PrevCursor != "" || p.NextCursor == "" {
+		t.Fatalf("page1 = %v, %+v", ids(page1), p)
+	}
+	p.NavNext()
+	page2, p := fetch(p.Cursor)
+	if !reflect.DeepEqual(ids(page2), []int{4, 7, 3}) || p.PrevCursor == "" || p.NextCursor == "" {
+		t.Fatalf("page2 = %v", ids(page2))
+	}
+	page3, p3 := fetch(p.NextCursor)
+	if !reflect.DeepEqual(ids(page3), []int{6}) || p3.NextCursor != "" {
+		t.Fatalf("page3 = %v", ids(page3))
+	}
+	p3.NavPrev()
+	back2, p := fetch(p3.Cursor)
+	if !reflect.DeepEqual(ids(back2), []int{4, 7, 3}) || p.NextCursor == "" {
+		t.Fatalf("back2 = %v", ids(back2))
+	}
+	back1, p := fetch(p.PrevCursor)
+	if !reflect.DeepEqual(ids(back1), []int{2, 5, 1}) || p.PrevCursor != "" {
+		t.Fatalf("back1 = %v, prev=%q", ids(back1), p.PrevCursor)
+	}
+
+	din := &DIN{ZAction: ZAction{Cursor: "bogus"}}
+	if _, _, err := din.NewKeysetPaginate(k); !errors.Is(err, ErrKeysetCursorInvalid) {
+		t.Errorf("expected invalid cursor, got %v", err)
+	}
+}
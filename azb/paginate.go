@@ -24,6 +24,10 @@ type Paginate struct {
 	// Optional for UUIDv7/cursor-based queries.
 	Cursor string `json:"cursor,omitempty" query:"cursor"`
 
+	// Keyset cursors for the adjacent pages, set by KeysetFinish.
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+
 	// UI fields (from PaginateNav).
 	AddZClick bool   `json:"addZClick,omitempty"`
 	ZUrl      string `json:"zurl,omitempty"`
@@ -129,19 +133,28 @@ func (p *Paginate) Limit() int {
 	return p.ItemsPerPage
 }
 
+// isCursorMode is true when paging by cursor rather than page number.
+func (p *Paginate) isCursorMode() bool {
+	return p.Cursor != "" || p.NextCursor != "" || p.PrevCursor != ""
+}
+
 // NavNext advances page or cursor.
 func (p *Paginate) NavNext() {
-	if p.Cursor != "" {
-		// App-specific: Update cursor based on last item.
+	if p.isCursorMode() {
+		if p.NextCursor != "" {
+			p.Cursor = p.NextCursor
+		}
 		return
 	}
 	p.SetCurrentPage(p.CurrentPage + 1)
 }
 
-// NavPrev decrements the current page.
+// NavPrev decrements the current page or moves to the previous cursor.
 func (p *Paginate) NavPrev() {
-	if p.Cursor != "" {
-		// App-specific logic.
+	if p.isCursorMode() {
+		if p.PrevCursor != "" {
+			p.Cursor = p.PrevCursor
+		}
 		return
 	}
 	p.SetCurrentPage(p.CurrentPage - 1)
@@ -149,7 +162,7 @@ func (p *Paginate) NavPrev() {
 
 // NavFirst sets to first page.
 func (p *Paginate) NavFirst() {
-	if p.Cursor != "" {
+	if p.isCursorMode() {
 		// Reset cursor.
 		p.Cursor = ""
 	}
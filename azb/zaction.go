@@ -39,6 +39,9 @@ type ZAction struct {
 	LoopType  ZBType   `json:"loopType"`  // Type of loop, if applicable.
 	PageOn    int      `json:"pageOn"`    // Current page number for pagination.
 	PageLimit int      `json:"pageLimit"` // Number of items per page for pagination.
+	Cursor    string   `json:"cursor"`    // Keyset cursor for pagination.
+	Filter    string   `json:"filter"`    // Filter expression, see ParseFilter.
+	Sort      string   `json:"sort"`      // Sort fields, see ParseFilterSort.
 	ViewPort  ViewPort `json:"viewPort"`
 }
 